@@ -0,0 +1,142 @@
+package backend
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrWriteStalled is returned by WritableLow of an AdmissionDB when the
+// database stalls writes for longer than the low-priority writer may
+// wait.
+const ErrWriteStalled Error = Error("low-priority write rejected during write stall")
+
+// AdmissionOption configures an AdmissionDB.
+type AdmissionOption func(*AdmissionDB) error
+
+// MaxStallDelay sets how long a low-priority writer waits for a write
+// stall to end before it is rejected. The default is 0, rejecting
+// low-priority writers as long as writes are stalled.
+func MaxStallDelay(d time.Duration) AdmissionOption {
+	return func(a *AdmissionDB) error {
+		if d < 0 {
+			return errors.New("negative max stall delay")
+		}
+		a.maxDelay = d
+		return nil
+	}
+}
+
+// AdmissionDB wraps a DB and holds back low-priority write transactions
+// while the database stalls writes, leaving the remaining write capacity
+// to high-priority traffic. Writable starts high-priority transactions
+// and is never held back, WritableLow starts low-priority ones.
+//
+// The stall state is reported through SetStalled, e.g. by the LevelDB
+// OnWriteStall callback. The callback may run before NewAdmissionDB
+// returned, so the AdmissionDB is published through an atomic pointer;
+// a stall reported before it is stored is not observed:
+//
+//	var adm atomic.Pointer[AdmissionDB]
+//	db, err := OpenLevelDB(path, OnWriteStall(func(ev WriteStall) {
+//		if a := adm.Load(); a != nil {
+//			a.SetStalled(ev.Stalled)
+//		}
+//	}))
+//	...
+//	a, err := NewAdmissionDB(db, MaxStallDelay(50*time.Millisecond))
+//	...
+//	adm.Store(a)
+type AdmissionDB struct {
+	DB
+
+	maxDelay time.Duration
+
+	mu       sync.Mutex
+	stalled  bool
+	resumed  chan struct{} // closed when the current stall ends
+	delayed  uint64
+	rejected uint64
+}
+
+// NewAdmissionDB returns an AdmissionDB admitting writes to db.
+func NewAdmissionDB(db DB, opts ...AdmissionOption) (*AdmissionDB, error) {
+	a := &AdmissionDB{DB: db}
+	for _, opt := range opts {
+		if err := opt(a); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// SetStalled sets whether the database currently stalls writes. Ending a
+// stall admits all waiting low-priority writers.
+func (a *AdmissionDB) SetStalled(stalled bool) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if stalled == a.stalled {
+		return
+	}
+	a.stalled = stalled
+	if stalled {
+		a.resumed = make(chan struct{})
+	} else {
+		close(a.resumed)
+	}
+}
+
+// Stalled reports whether low-priority writes are held back.
+func (a *AdmissionDB) Stalled() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.stalled
+}
+
+// Shed returns the number of low-priority write transactions that were
+// delayed by a stall and the number that were rejected.
+func (a *AdmissionDB) Shed() (delayed, rejected uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.delayed, a.rejected
+}
+
+// WritableLow starts a low-priority write transaction. During a write
+// stall it waits up to the maximum stall delay for the stall to end and
+// returns ErrWriteStalled if it does not.
+func (a *AdmissionDB) WritableLow() (RWTxn, error) {
+	if err := a.admit(); err != nil {
+		return nil, err
+	}
+	return a.DB.Writable()
+}
+
+func (a *AdmissionDB) admit() error {
+	a.mu.Lock()
+	if !a.stalled {
+		a.mu.Unlock()
+		return nil
+	}
+	resumed := a.resumed
+	a.mu.Unlock()
+
+	if a.maxDelay > 0 {
+		t := time.NewTimer(a.maxDelay)
+		defer t.Stop()
+		select {
+		case <-resumed:
+			a.mu.Lock()
+			a.delayed++
+			a.mu.Unlock()
+			return nil
+		case <-t.C:
+		}
+	}
+	a.mu.Lock()
+	a.rejected++
+	a.mu.Unlock()
+	return ErrWriteStalled
+}
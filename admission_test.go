@@ -0,0 +1,53 @@
+package backend
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAdmission(t *testing.T) {
+	db, err := NewAdmissionDB(NewMemDB(), MaxStallDelay(time.Minute))
+	if err != nil {
+		t.Fatalf("new admission: %v", err)
+	}
+	defer db.Close()
+
+	txn, err := db.WritableLow()
+	if err != nil {
+		t.Fatalf("low-priority writable without stall: %v", err)
+	}
+	txn.Rollback()
+
+	db.SetStalled(true)
+	admitted := make(chan error)
+	go func() {
+		txn, err := db.WritableLow()
+		if err == nil {
+			err = txn.Commit()
+		}
+		admitted <- err
+	}()
+
+	// high-priority writes pass the stall
+	if err = Update(db, func(txn RWTxn) error { return txn.Put([]byte("a"), []byte("1")) }); err != nil {
+		t.Fatalf("high-priority update during stall: %v", err)
+	}
+	select {
+	case err = <-admitted:
+		t.Fatalf("low-priority writer admitted during stall: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	db.SetStalled(false)
+	if err = <-admitted; err != nil {
+		t.Fatalf("low-priority writer after stall: %v", err)
+	}
+
+	db.maxDelay = 0
+	db.SetStalled(true)
+	if _, err = db.WritableLow(); err != ErrWriteStalled {
+		t.Fatalf("low-priority writable during stall: expected ErrWriteStalled, got %v", err)
+	}
+	if delayed, rejected := db.Shed(); delayed != 1 || rejected != 1 {
+		t.Fatalf("shed: expected 1 delayed and 1 rejected, got %d and %d", delayed, rejected)
+	}
+}
@@ -39,6 +39,28 @@ type Iterator interface {
 	// and value are only valid for the life of the transaction.
 	Prev() ([]byte, []byte)
 
+	// SeekToIndex moves the iterator to the n-th key in key order,
+	// counting from zero, and returns it. If n is negative or not less
+	// than the number of keys, a nil key is returned. Backends keeping
+	// counted trees (MemDB, Bitcask) position exactly in logarithmic
+	// time. LevelDB and GoLevelDB estimate the position of large indexes
+	// from the storage used by the key range and may land on a nearby
+	// key; all other backends step from the first key. The returned key
+	// and value are only valid for the life of the transaction.
+	SeekToIndex(n int64) ([]byte, []byte)
+
+	// Reset moves the iterator to a fresh view of the database and
+	// releases the previous one, so a long-lived iterator can observe
+	// recent commits without being closed and recreated. After Reset the
+	// iterator is unpositioned; call Seek, First or Last before Next or
+	// Prev. Keys and values returned before Reset are no longer valid.
+	//
+	// A BoltDB or BBoltDB commit that grows the file waits until all
+	// iterators and read transactions are released, so an iterator must
+	// not be held, even across Reset, while committing in the same
+	// goroutine.
+	Reset() error
+
 	// Close closes the iterator and returns any accumulated error.
 	// Exhausting all the key/value pairs in a table is not considered to
 	// be an error. It is valid to call Close multiple times. Other methods
@@ -96,7 +118,8 @@ type DB interface {
 	// Transactions should not be dependent on one another.
 	Writable() (RWTxn, error)
 
-	// WriteTo writes the entire database to a writer.
+	// WriteTo writes the entire database to a writer using the export
+	// format, which Import reads into any backend.
 	WriteTo(w io.Writer) (int64, error)
 
 	// Name returns the unique database name.
@@ -105,9 +128,10 @@ type DB interface {
 	// Close closes the DB. It may or may not close any underlying io.Reader
 	// or io.Writer, depending on how the DB was created.
 	//
-	// It is not safe to close a DB until all outstanding iterators and
-	// transactions are closed. It is valid to call Close multiple times.
-	// Other methods should not be called after the DB has been closed.
+	// Close blocks until all outstanding iterators and transactions are
+	// closed; a goroutine must therefore not close a DB while it still
+	// holds one. Other methods, and calling Close again, return
+	// ErrDBClosed once Close was called.
 	Close() error
 }
 
@@ -119,3 +143,13 @@ func (e Error) Error() string { return string(e) }
 // ErrNotFound means that a get or delete call did not find the requested
 // key.
 const ErrNotFound Error = Error("key not found")
+
+// ErrDBClosed is returned by all methods of a closed DB.
+const ErrDBClosed Error = Error("database closed")
+
+// ErrReadOnlyTxn is returned when writing to a read-only transaction.
+const ErrReadOnlyTxn Error = Error("write in read-only transaction")
+
+// ErrReadOnly is returned when starting a write transaction on a
+// read-only database view.
+const ErrReadOnly Error = Error("read-only database")
@@ -0,0 +1,222 @@
+// Package backendkv provides a minimal database/sql driver on top of a
+// backend.DB. Databases are registered by name and opened with
+//
+//	backendkv.Register("users", db)
+//	conn, err := sql.Open("backendkv", "users")
+//
+// The driver understands the following statements, where each operand is
+// either a ? placeholder, a bare word or a single quoted string in which
+// a doubled quote stands for a literal quote:
+//
+//	GET key                 returns the columns key and value
+//	PUT key value           stores value under key
+//	DELETE key              deletes key
+//	SCAN [prefix] [LIMIT n] returns all pairs with the given key prefix
+//
+// Statements executed inside a sql.Tx share a single writable
+// transaction. SCAN always reads from a snapshot of the database and does
+// not observe uncommitted writes of the surrounding transaction.
+package backendkv
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/mars9/backend"
+)
+
+func init() {
+	sql.Register("backendkv", &Driver{})
+}
+
+var registry struct {
+	sync.Mutex
+	dbs map[string]backend.DB
+}
+
+// Register makes db available to sql.Open under the given name. If
+// Register is called twice with the same name it panics.
+func Register(name string, db backend.DB) {
+	registry.Lock()
+	defer registry.Unlock()
+	if db == nil {
+		panic("backendkv: Register db is nil")
+	}
+	if _, dup := registry.dbs[name]; dup {
+		panic("backendkv: Register called twice for " + name)
+	}
+	if registry.dbs == nil {
+		registry.dbs = make(map[string]backend.DB)
+	}
+	registry.dbs[name] = db
+}
+
+// Unregister removes the database registered under name.
+func Unregister(name string) {
+	registry.Lock()
+	delete(registry.dbs, name)
+	registry.Unlock()
+}
+
+// Driver implements driver.Driver.
+type Driver struct{}
+
+// Open returns a connection to the database registered under name.
+func (d *Driver) Open(name string) (driver.Conn, error) {
+	registry.Lock()
+	db, found := registry.dbs[name]
+	registry.Unlock()
+	if !found {
+		return nil, errors.New("backendkv: unknown database " + strconv.Quote(name))
+	}
+	return &conn{db: db}, nil
+}
+
+type conn struct {
+	db  backend.DB
+	txn backend.RWTxn // current transaction, if any
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	s, err := parse(query)
+	if err != nil {
+		return nil, err
+	}
+	s.c = c
+	return s, nil
+}
+
+func (c *conn) Close() error {
+	if c.txn != nil {
+		err := c.txn.Rollback()
+		c.txn = nil
+		return err
+	}
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	if c.txn != nil {
+		return nil, errors.New("backendkv: transaction already in progress")
+	}
+	txn, err := c.db.Writable()
+	if err != nil {
+		return nil, err
+	}
+	c.txn = txn
+	return &tx{c: c}, nil
+}
+
+type tx struct {
+	c *conn
+}
+
+func (t *tx) Commit() error {
+	if t.c.txn == nil {
+		return driver.ErrBadConn
+	}
+	err := t.c.txn.Commit()
+	t.c.txn = nil
+	return err
+}
+
+func (t *tx) Rollback() error {
+	if t.c.txn == nil {
+		return driver.ErrBadConn
+	}
+	err := t.c.txn.Rollback()
+	t.c.txn = nil
+	return err
+}
+
+// update runs fn inside the connection transaction, or inside a new
+// writable transaction that is committed if fn succeeds.
+func (c *conn) update(fn func(backend.RWTxn) error) error {
+	if c.txn != nil {
+		return fn(c.txn)
+	}
+	txn, err := c.db.Writable()
+	if err != nil {
+		return err
+	}
+	if err = fn(txn); err != nil {
+		txn.Rollback()
+		return err
+	}
+	return txn.Commit()
+}
+
+// view runs fn inside the connection transaction, or inside a new
+// read-only transaction.
+func (c *conn) view(fn func(backend.Txn) error) error {
+	if c.txn != nil {
+		return fn(c.txn)
+	}
+	txn, err := c.db.Readonly()
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+	return fn(txn)
+}
+
+type result int64
+
+func (r result) LastInsertId() (int64, error) {
+	return 0, errors.New("backendkv: LastInsertId is not supported")
+}
+
+func (r result) RowsAffected() (int64, error) { return int64(r), nil }
+
+type rows struct {
+	pairs [][2][]byte
+}
+
+func (r *rows) Columns() []string { return []string{"key", "value"} }
+
+func (r *rows) Close() error {
+	r.pairs = nil
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.pairs) == 0 {
+		return io.EOF
+	}
+	dest[0], dest[1] = r.pairs[0][0], r.pairs[0][1]
+	r.pairs = r.pairs[1:]
+	return nil
+}
+
+func clone(b []byte) []byte {
+	return append([]byte{}, b...)
+}
+
+func toBytes(v driver.Value) ([]byte, error) {
+	switch v := v.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	case int64:
+		return []byte(strconv.FormatInt(v, 10)), nil
+	case nil:
+		return nil, errors.New("backendkv: NULL argument")
+	}
+	return nil, errors.New("backendkv: unsupported argument type")
+}
+
+func toInt(v driver.Value) (int, error) {
+	switch v := v.(type) {
+	case int64:
+		return int(v), nil
+	case []byte, string:
+		b, _ := toBytes(v)
+		return strconv.Atoi(string(b))
+	}
+	return 0, errors.New("backendkv: LIMIT expects an integer")
+}
@@ -0,0 +1,88 @@
+package backendkv
+
+import (
+	"database/sql"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/mars9/backend"
+)
+
+func TestDriver(t *testing.T) {
+	const path = "backendkv_test.db"
+	db, err := backend.OpenBoltDB(path, 0)
+	if err != nil {
+		t.Fatalf("opening BoltDB %q: %v", path, err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(path)
+	}()
+	Register("test", db)
+	defer Unregister("test")
+
+	conn, err := sql.Open("backendkv", "test")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer conn.Close()
+
+	for _, kv := range [][2]string{{"user/1", "alice"}, {"user/2", "bob"}, {"group/1", "admin"}} {
+		if _, err = conn.Exec("PUT ? ?", kv[0], kv[1]); err != nil {
+			t.Fatalf("put %q: %v", kv[0], err)
+		}
+	}
+	if _, err = conn.Exec("PUT 'user/3' 'o''hara'"); err != nil {
+		t.Fatalf("put literal: %v", err)
+	}
+
+	var value string
+	if err = conn.QueryRow("GET ?", "user/3").Scan(new(string), &value); err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if value != "o'hara" {
+		t.Fatalf("get: expected value %q, got %q", "o'hara", value)
+	}
+	if err = conn.QueryRow("GET missing").Scan(new(string), &value); err != sql.ErrNoRows {
+		t.Fatalf("get: expected sql.ErrNoRows, got %v", err)
+	}
+
+	tx, err := conn.Begin()
+	if err != nil {
+		t.Fatalf("begin: %v", err)
+	}
+	res, err := tx.Exec("DELETE ?", "user/2")
+	if err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		t.Fatalf("delete: expected 1 row affected, got %d", n)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	rows, err := conn.Query("SCAN user/ LIMIT ?", 5)
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	var keys []string
+	for rows.Next() {
+		var k, v string
+		if err = rows.Scan(&k, &v); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		keys = append(keys, k)
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if want := []string{"user/1", "user/3"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("scan: expected keys %q, got %q", want, keys)
+	}
+
+	if _, err = conn.Exec("FETCH x"); err == nil {
+		t.Fatalf("expected error for unknown statement")
+	}
+}
@@ -0,0 +1,241 @@
+package backendkv
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"errors"
+	"strings"
+
+	"github.com/mars9/backend"
+)
+
+// operand is a statement argument. It is either a literal or, if
+// placeholder is true, refers to the next positional argument.
+type operand struct {
+	literal     []byte
+	placeholder bool
+}
+
+type stmt struct {
+	c     *conn
+	verb  string
+	ops   []operand // GET/PUT/DELETE: keys and values, SCAN: prefix
+	limit *operand  // SCAN only
+	nargs int
+}
+
+func (s *stmt) Close() error  { return nil }
+func (s *stmt) NumInput() int { return s.nargs }
+
+// resolve returns the operand values in statement order.
+func (s *stmt) resolve(args []driver.Value) ([][]byte, error) {
+	vals := make([][]byte, 0, len(s.ops))
+	for _, op := range s.ops {
+		if !op.placeholder {
+			vals = append(vals, op.literal)
+			continue
+		}
+		v, err := toBytes(args[0])
+		if err != nil {
+			return nil, err
+		}
+		vals = append(vals, v)
+		args = args[1:]
+	}
+	return vals, nil
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	vals, err := s.resolve(args)
+	if err != nil {
+		return nil, err
+	}
+
+	switch s.verb {
+	case "PUT":
+		err = s.c.update(func(txn backend.RWTxn) error {
+			return txn.Put(clone(vals[0]), clone(vals[1]))
+		})
+		if err != nil {
+			return nil, err
+		}
+		return result(1), nil
+	case "DELETE":
+		var n result
+		err = s.c.update(func(txn backend.RWTxn) error {
+			if _, err := txn.Get(vals[0]); err == nil {
+				n = 1
+			} else if err != backend.ErrNotFound {
+				return err
+			}
+			return txn.Delete(vals[0])
+		})
+		if err != nil {
+			return nil, err
+		}
+		return n, nil
+	}
+	return nil, errors.New("backendkv: " + s.verb + " must be used with Query")
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	vals, err := s.resolve(args)
+	if err != nil {
+		return nil, err
+	}
+
+	switch s.verb {
+	case "GET":
+		r := &rows{}
+		err = s.c.view(func(txn backend.Txn) error {
+			v, err := txn.Get(vals[0])
+			if err == backend.ErrNotFound {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			r.pairs = append(r.pairs, [2][]byte{clone(vals[0]), clone(v)})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return r, nil
+	case "SCAN":
+		limit := -1
+		if s.limit != nil {
+			v := driver.Value(s.limit.literal)
+			if s.limit.placeholder {
+				v = args[len(args)-1]
+			}
+			if limit, err = toInt(v); err != nil || limit < 0 {
+				return nil, errors.New("backendkv: LIMIT expects a non-negative integer")
+			}
+		}
+		var prefix []byte
+		if len(vals) > 0 {
+			prefix = vals[0]
+		}
+		return s.scan(prefix, limit)
+	}
+	return nil, errors.New("backendkv: " + s.verb + " must be used with Exec")
+}
+
+func (s *stmt) scan(prefix []byte, limit int) (driver.Rows, error) {
+	iter, err := s.c.db.Iterator()
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	r := &rows{}
+	var k, v []byte
+	if len(prefix) == 0 {
+		k, v = iter.First()
+	} else {
+		k, v = iter.Seek(prefix)
+	}
+	for ; k != nil && bytes.HasPrefix(k, prefix); k, v = iter.Next() {
+		if limit >= 0 && len(r.pairs) == limit {
+			break
+		}
+		r.pairs = append(r.pairs, [2][]byte{clone(k), clone(v)})
+	}
+	return r, iter.Close()
+}
+
+// parse parses a statement. See the package documentation for the
+// supported syntax.
+func parse(query string) (*stmt, error) {
+	tokens, err := tokenize(query)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("backendkv: empty statement")
+	}
+
+	s := &stmt{verb: strings.ToUpper(string(tokens[0].literal))}
+	if tokens[0].placeholder {
+		return nil, errors.New("backendkv: statement must start with a verb")
+	}
+	tokens = tokens[1:]
+
+	want := 0
+	switch s.verb {
+	case "GET", "DELETE":
+		want = 1
+	case "PUT":
+		want = 2
+	case "SCAN":
+		if n := len(tokens); n >= 2 && !tokens[n-2].placeholder &&
+			strings.EqualFold(string(tokens[n-2].literal), "LIMIT") {
+			s.limit = &tokens[n-1]
+			tokens = tokens[:n-2]
+		}
+		if len(tokens) > 1 {
+			return nil, errors.New("backendkv: SCAN takes at most one prefix")
+		}
+		want = len(tokens)
+	default:
+		return nil, errors.New("backendkv: unknown statement " + s.verb)
+	}
+	if len(tokens) != want {
+		return nil, errors.New("backendkv: wrong number of operands for " + s.verb)
+	}
+
+	s.ops = tokens
+	for _, op := range s.ops {
+		if op.placeholder {
+			s.nargs++
+		}
+	}
+	if s.limit != nil && s.limit.placeholder {
+		s.nargs++
+	}
+	return s, nil
+}
+
+func tokenize(query string) ([]operand, error) {
+	var tokens []operand
+	for i := 0; i < len(query); {
+		switch c := query[i]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == ';':
+			if strings.TrimSpace(query[i+1:]) != "" {
+				return nil, errors.New("backendkv: multiple statements are not supported")
+			}
+			i = len(query)
+		case c == '?':
+			tokens = append(tokens, operand{placeholder: true})
+			i++
+		case c == '\'':
+			var lit []byte
+			for i++; ; i++ {
+				if i >= len(query) {
+					return nil, errors.New("backendkv: unterminated string")
+				}
+				if query[i] == '\'' {
+					if i+1 < len(query) && query[i+1] == '\'' {
+						lit = append(lit, '\'')
+						i++
+						continue
+					}
+					i++
+					break
+				}
+				lit = append(lit, query[i])
+			}
+			tokens = append(tokens, operand{literal: lit})
+		default:
+			j := i
+			for j < len(query) && !strings.ContainsRune(" \t\n\r'?;", rune(query[j])) {
+				j++
+			}
+			tokens = append(tokens, operand{literal: []byte(query[i:j])})
+			i = j
+		}
+	}
+	return tokens, nil
+}
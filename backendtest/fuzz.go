@@ -0,0 +1,305 @@
+// Package backendtest provides fuzz targets checking the transaction,
+// iterator and restore semantics of a backend.DB against an in-memory
+// model. Third-party backends can run them from their own tests:
+//
+//	func FuzzTxnOps(f *testing.F) {
+//		backendtest.FuzzTxnOps(f, openMyDB)
+//	}
+package backendtest
+
+import (
+	"bytes"
+	"sort"
+	"testing"
+
+	"github.com/mars9/backend"
+)
+
+// OpenFunc opens a new empty database. The database is closed by the
+// fuzz target; OpenFunc should register the removal of any files with
+// t.Cleanup.
+type OpenFunc func(t testing.TB) backend.DB
+
+// input decodes fuzz data into operations. Reading past the end yields
+// zero bytes, so every input is valid.
+type input struct {
+	data []byte
+}
+
+func (in *input) done() bool { return len(in.data) == 0 }
+
+func (in *input) byte() byte {
+	if len(in.data) == 0 {
+		return 0
+	}
+	b := in.data[0]
+	in.data = in.data[1:]
+	return b
+}
+
+func (in *input) bytes(n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = in.byte()
+	}
+	return b
+}
+
+// key returns a non-empty key of up to 3 bytes from a small alphabet, so
+// operations frequently hit the same keys.
+func (in *input) key() []byte {
+	key := in.bytes(1 + int(in.byte()%3))
+	for i := range key {
+		key[i] = 'a' + key[i]%4
+	}
+	return key
+}
+
+func (in *input) value() []byte {
+	return in.bytes(int(in.byte() % 8))
+}
+
+const (
+	opPut = iota
+	opDelete
+	opGet
+	opCommit
+	opRollback
+	numOps
+)
+
+// FuzzTxnOps runs random sequences of Put, Delete, Get, Commit and
+// Rollback and checks that reads observe the transaction's own writes,
+// that only committed writes persist and that iteration returns exactly
+// the committed pairs.
+func FuzzTxnOps(f *testing.F, open OpenFunc) {
+	f.Add([]byte{opPut, 0, 1, 1, 'x', opGet, 0, 1, opCommit, opGet, 0, 1})
+	f.Add([]byte{opPut, 1, 2, 3, 2, 'y', 'z', opRollback, opDelete, 1, 2, 3, opCommit})
+	f.Add([]byte{opPut, 0, 0, 0, opDelete, 0, 0, opGet, 0, 0, opCommit, opPut, 2, 1, 1, 2, 0, 'v'})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		db := open(t)
+		defer db.Close()
+
+		committed := map[string][]byte{}
+		var pending map[string][]byte // nil value marks a deletion
+		var txn backend.RWTxn
+		in := &input{data: data}
+		for !in.done() {
+			if txn == nil {
+				var err error
+				if txn, err = db.Writable(); err != nil {
+					t.Fatalf("begin writable transaction: %v", err)
+				}
+				pending = map[string][]byte{}
+			}
+
+			switch in.byte() % numOps {
+			case opPut:
+				key, value := in.key(), in.value()
+				if err := txn.Put(key, value); err != nil {
+					t.Fatalf("put %q: %v", key, err)
+				}
+				pending[string(key)] = append([]byte{}, value...)
+			case opDelete:
+				key := in.key()
+				if err := txn.Delete(key); err != nil {
+					t.Fatalf("delete %q: %v", key, err)
+				}
+				pending[string(key)] = nil
+			case opGet:
+				key := in.key()
+				expected, found := pending[string(key)]
+				if !found {
+					expected, found = committed[string(key)]
+				} else {
+					found = expected != nil
+				}
+				v, err := txn.Get(key)
+				if !found && err != backend.ErrNotFound {
+					t.Fatalf("get %q: expected ErrNotFound, got %q, %v", key, v, err)
+				} else if found && (err != nil || !bytes.Equal(v, expected)) {
+					t.Fatalf("get %q: expected %q, got %q, %v", key, expected, v, err)
+				}
+			case opCommit:
+				if err := txn.Commit(); err != nil {
+					t.Fatalf("commit: %v", err)
+				}
+				for k, v := range pending {
+					if v == nil {
+						delete(committed, k)
+					} else {
+						committed[k] = v
+					}
+				}
+				txn = nil
+			case opRollback:
+				if err := txn.Rollback(); err != nil {
+					t.Fatalf("rollback: %v", err)
+				}
+				txn = nil
+			}
+		}
+		if txn != nil {
+			if err := txn.Rollback(); err != nil {
+				t.Fatalf("rollback: %v", err)
+			}
+		}
+
+		checkContents(t, db, committed)
+	})
+}
+
+// checkContents checks that iterating db yields exactly the pairs in
+// expected, in key order.
+func checkContents(t *testing.T, db backend.DB, expected map[string][]byte) {
+	iter, err := db.Iterator()
+	if err != nil {
+		t.Fatalf("iterator: %v", err)
+	}
+	defer iter.Close()
+
+	keys := sortedKeys(expected)
+	i := 0
+	for k, v := iter.First(); k != nil; k, v = iter.Next() {
+		if i == len(keys) || string(k) != keys[i] {
+			t.Fatalf("iterate: unexpected key %q", k)
+		}
+		if !bytes.Equal(v, expected[keys[i]]) {
+			t.Fatalf("iterate: key %q: expected %q, got %q", k, expected[keys[i]], v)
+		}
+		i++
+	}
+	if i != len(keys) {
+		t.Fatalf("iterate: expected %d keys, got %d", len(keys), i)
+	}
+	if err = iter.Close(); err != nil {
+		t.Fatalf("close iterator: %v", err)
+	}
+}
+
+func sortedKeys(m map[string][]byte) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// FuzzIteratorSeeks stores random keys and checks Seek, Next and Prev
+// against the sorted key set. The first byte of the input selects the
+// number of keys, the remaining input after the keys are seek targets.
+func FuzzIteratorSeeks(f *testing.F, open OpenFunc) {
+	f.Add([]byte{3, 0, 0, 1, 1, 2, 2, 2, 0, 3, 1, 0})
+	f.Add([]byte{8, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 1, 3, 2, 0, 0})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		db := open(t)
+		defer db.Close()
+
+		in := &input{data: data}
+		pairs := map[string][]byte{}
+		txn, err := db.Writable()
+		if err != nil {
+			t.Fatalf("begin writable transaction: %v", err)
+		}
+		for n := int(in.byte() % 32); n > 0; n-- {
+			key := in.key()
+			if err = txn.Put(key, key); err != nil {
+				t.Fatalf("put %q: %v", key, err)
+			}
+			pairs[string(key)] = key
+		}
+		if err = txn.Commit(); err != nil {
+			t.Fatalf("commit: %v", err)
+		}
+		keys := sortedKeys(pairs)
+
+		iter, err := db.Iterator()
+		if err != nil {
+			t.Fatalf("iterator: %v", err)
+		}
+		defer iter.Close()
+
+		for !in.done() {
+			target := in.bytes(int(in.byte() % 4))
+			for i := range target {
+				target[i] = 'a' + target[i]%5
+			}
+			i := sort.SearchStrings(keys, string(target))
+
+			k, _ := iter.Seek(target)
+			if i == len(keys) {
+				if k != nil {
+					t.Fatalf("seek %q: expected end, got %q", target, k)
+				}
+				continue
+			}
+			if string(k) != keys[i] {
+				t.Fatalf("seek %q: expected %q, got %q", target, keys[i], k)
+			}
+
+			if in.byte()%2 == 0 {
+				k, _ = iter.Next()
+				if i+1 == len(keys) && k != nil {
+					t.Fatalf("next after %q: expected end, got %q", keys[i], k)
+				} else if i+1 < len(keys) && string(k) != keys[i+1] {
+					t.Fatalf("next after %q: expected %q, got %q", keys[i], keys[i+1], k)
+				}
+			} else {
+				k, _ = iter.Prev()
+				if i == 0 && k != nil {
+					t.Fatalf("prev before %q: expected end, got %q", keys[i], k)
+				} else if i > 0 && string(k) != keys[i-1] {
+					t.Fatalf("prev before %q: expected %q, got %q", keys[i], keys[i-1], k)
+				}
+			}
+		}
+		if err = iter.Close(); err != nil {
+			t.Fatalf("close iterator: %v", err)
+		}
+	})
+}
+
+// FuzzRestore feeds random input to Import and LoadText, which must fail
+// cleanly on malformed input. Input that imports successfully must
+// survive an Export and Import round trip unchanged.
+func FuzzRestore(f *testing.F, open OpenFunc) {
+	f.Add([]byte("BKX\x01\x00\x00"))
+	f.Add([]byte("0x6B6579 ==> 0x76616C\n"))
+	f.Add([]byte("BKX\x01\x00\x07\x00\x01\x01ab\x00"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		db := open(t)
+		defer db.Close()
+
+		errText := backend.LoadText(db, bytes.NewReader(data))
+		errImport := backend.Import(db, bytes.NewReader(data))
+		if errText != nil && errImport != nil {
+			return
+		}
+
+		var buf bytes.Buffer
+		if _, err := backend.Export(&buf, db); err != nil {
+			t.Fatalf("export: %v", err)
+		}
+		restored := open(t)
+		defer restored.Close()
+		if err := backend.Import(restored, &buf); err != nil {
+			t.Fatalf("import exported data: %v", err)
+		}
+
+		sum, err := backend.Checksum(db, nil)
+		if err != nil {
+			t.Fatalf("checksum: %v", err)
+		}
+		restoredSum, err := backend.Checksum(restored, nil)
+		if err != nil {
+			t.Fatalf("checksum: %v", err)
+		}
+		if sum != restoredSum {
+			t.Fatalf("round trip: checksum mismatch")
+		}
+	})
+}
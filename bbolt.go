@@ -0,0 +1,340 @@
+package backend
+
+import (
+	"errors"
+	"io"
+
+	"go.etcd.io/bbolt"
+)
+
+var _ DB = (*BBoltDB)(nil)
+
+// BBoltOption configures a BBoltDB.
+type BBoltOption func(*bbolt.Options) error
+
+// BBoltOptions replaces the bbolt options the database is opened with.
+// Without options bbolt.DefaultOptions are used.
+func BBoltOptions(o bbolt.Options) BBoltOption {
+	return func(opts *bbolt.Options) error {
+		*opts = o
+		return nil
+	}
+}
+
+// BBoltFreelist selects the freelist implementation. The hashmap
+// freelist allocates pages in constant time, which pays off for large
+// databases with many free pages. Unless sync is true the freelist is
+// not written on commit but rebuilt by scanning the file on open, which
+// speeds up write-heavy workloads.
+func BBoltFreelist(typ bbolt.FreelistType, sync bool) BBoltOption {
+	return func(opts *bbolt.Options) error {
+		opts.FreelistType = typ
+		opts.NoFreelistSync = !sync
+		return nil
+	}
+}
+
+// BBoltDB is a key/value store built on go.etcd.io/bbolt, the maintained
+// fork of the archived BoltDB. Both keep keys in the same bucket of the
+// same file format, so a file written by BoltDB opens as BBoltDB and vice
+// versa.
+type BBoltDB struct {
+	tree   *bbolt.DB
+	opts   bbolt.Options
+	writer fifoMutex // grants write transactions in FIFO order
+	refs   refCount  // open iterators and transactions
+}
+
+// OpenBBoltDB creates and opens a database at the given path. If the
+// file does not exist then it will be created automatically.
+func OpenBBoltDB(path string, opts ...BBoltOption) (*BBoltDB, error) {
+	o := *bbolt.DefaultOptions
+	for _, opt := range opts {
+		if err := opt(&o); err != nil {
+			return nil, err
+		}
+	}
+
+	tree, err := bbolt.Open(path, defaultOpenMode, &o)
+	if err != nil {
+		return nil, err
+	}
+	if err = tree.Update(func(tx *bbolt.Tx) (err error) {
+		_, err = tx.CreateBucketIfNotExists(rootBucket)
+		return err
+	}); err != nil {
+		tree.Close()
+		return nil, errors.New("create root: " + err.Error())
+	}
+	return &BBoltDB{tree: tree, opts: o}, nil
+}
+
+// BBoltStores returns a StoreOpener opening each store as a BBoltDB file
+// with the given options.
+func BBoltStores(opts ...BBoltOption) StoreOpener {
+	return func(path string) (DB, error) {
+		return OpenBBoltDB(path, opts...)
+	}
+}
+
+// begin starts a bbolt transaction holding a reference on the handle,
+// which must be released when the transaction ends.
+func (db *BBoltDB) begin(writable bool) (*bbolt.Tx, error) {
+	if err := db.refs.acquire(); err != nil {
+		return nil, err
+	}
+	tx, err := db.tree.Begin(writable)
+	if err != nil {
+		db.refs.release()
+		return nil, err
+	}
+	return tx, nil
+}
+
+func (db *BBoltDB) Iterator() (Iterator, error) {
+	tx, err := db.begin(false)
+	if err != nil {
+		return nil, err
+	}
+	return &bboltIterator{c: tx.Bucket(rootBucket).Cursor(), tx: tx, tree: db.tree, refs: &db.refs}, nil
+}
+
+func (db *BBoltDB) Readonly() (Txn, error) {
+	tx, err := db.begin(false)
+	if err != nil {
+		return nil, err
+	}
+	return &bboltReadTxn{b: tx.Bucket(rootBucket), tx: tx, refs: &db.refs}, nil
+}
+
+// Writable starts a new write transaction. Blocked callers are granted
+// the transaction in the order they called Writable.
+func (db *BBoltDB) Writable() (RWTxn, error) {
+	db.writer.Lock()
+	tx, err := db.begin(true)
+	if err != nil {
+		db.writer.Unlock()
+		return nil, err
+	}
+	return &bboltTxn{
+		bboltReadTxn: bboltReadTxn{b: tx.Bucket(rootBucket), tx: tx, refs: &db.refs},
+		writer:       &db.writer,
+	}, nil
+}
+
+// WriteQueueDepth returns the number of callers blocked in Writable.
+func (db *BBoltDB) WriteQueueDepth() int {
+	return db.writer.waiting()
+}
+
+// WriteTo writes the entire database to w using the export format. See
+// Export for details.
+func (db *BBoltDB) WriteTo(w io.Writer) (int64, error) {
+	return Export(w, db)
+}
+
+// WriteFileTo writes a consistent copy of the database file to w, which
+// can be opened with OpenBBoltDB.
+func (db *BBoltDB) WriteFileTo(w io.Writer) (n int64, err error) {
+	if err = db.refs.acquire(); err != nil {
+		return 0, err
+	}
+	defer db.refs.release()
+	err = db.tree.View(func(tx *bbolt.Tx) (err error) {
+		n, err = tx.WriteTo(w)
+		return err
+	})
+	return n, err
+}
+
+func (db *BBoltDB) Name() string { return "BBoltDB" }
+
+// diagnostics adds the options, the freelist statistics and the page
+// usage of the root bucket.
+func (db *BBoltDB) diagnostics(b *bundle) error {
+	if err := db.refs.acquire(); err != nil {
+		return err
+	}
+	defer db.refs.release()
+	var root bbolt.BucketStats
+	if err := db.tree.View(func(tx *bbolt.Tx) error {
+		root = tx.Bucket(rootBucket).Stats()
+		return nil
+	}); err != nil {
+		return err
+	}
+	o := db.opts
+	return b.writeJSON("stats.json", struct {
+		Path            string
+		FreelistType    bbolt.FreelistType
+		NoFreelistSync  bool
+		NoGrowSync      bool
+		NoSync          bool
+		InitialMmapSize int
+		PageSize        int
+		DB              bbolt.Stats
+		Root            bbolt.BucketStats
+	}{db.tree.Path(), o.FreelistType, o.NoFreelistSync, o.NoGrowSync, o.NoSync,
+		o.InitialMmapSize, o.PageSize, db.tree.Stats(), root})
+}
+
+func (db *BBoltDB) Close() error {
+	if db == nil {
+		return errors.New("closing unopened BBoltDB instance")
+	}
+	if err := db.refs.close(); err != nil {
+		return err
+	}
+	err := db.tree.Close()
+	db.tree = nil
+	return err
+}
+
+type bboltIterator struct {
+	c    *bbolt.Cursor
+	tx   *bbolt.Tx
+	tree *bbolt.DB
+	refs *refCount
+}
+
+func (i *bboltIterator) Seek(key []byte) ([]byte, []byte) {
+	if i == nil || i.tx == nil {
+		return nil, nil
+	}
+	return i.c.Seek(key)
+}
+
+func (i *bboltIterator) First() ([]byte, []byte) {
+	if i == nil || i.tx == nil {
+		return nil, nil
+	}
+	return i.c.First()
+}
+
+func (i *bboltIterator) Last() ([]byte, []byte) {
+	if i == nil || i.tx == nil {
+		return nil, nil
+	}
+	return i.c.Last()
+}
+
+func (i *bboltIterator) Next() ([]byte, []byte) {
+	if i == nil || i.tx == nil {
+		return nil, nil
+	}
+	return i.c.Next()
+}
+
+func (i *bboltIterator) Prev() ([]byte, []byte) {
+	if i == nil || i.tx == nil {
+		return nil, nil
+	}
+	return i.c.Prev()
+}
+
+func (i *bboltIterator) SeekToIndex(n int64) ([]byte, []byte) {
+	if i == nil || i.tx == nil {
+		return nil, nil
+	}
+	return walkToIndex(i, n)
+}
+
+func (i *bboltIterator) Reset() error {
+	if i == nil || i.tx == nil {
+		return errors.New("reset closed iterator")
+	}
+	// The old view is released first: a commit growing the file waits
+	// for all read transactions, and Begin waits for that commit.
+	err := i.tx.Rollback()
+	tx, berr := i.tree.Begin(false)
+	if berr != nil {
+		i.tx = nil
+		i.refs.release()
+		return berr
+	}
+	i.c, i.tx = tx.Bucket(rootBucket).Cursor(), tx
+	return err
+}
+
+func (i *bboltIterator) Close() error {
+	if i == nil || i.tx == nil {
+		return nil
+	}
+	err := i.tx.Rollback()
+	i.tx = nil
+	i.refs.release()
+	return err
+}
+
+// bboltReadTxn is a read-only transaction. Its write methods only exist
+// to reject writes after a type assertion to RWTxn.
+type bboltReadTxn struct {
+	b    *bbolt.Bucket
+	tx   *bbolt.Tx
+	refs *refCount
+}
+
+func (t *bboltReadTxn) Get(key []byte) ([]byte, error) {
+	if t == nil || t.tx == nil {
+		return nil, errors.New("get from unopened transaction")
+	}
+	value := t.b.Get(key)
+	if value == nil {
+		return nil, ErrNotFound
+	}
+	return value, nil
+}
+
+func (t *bboltReadTxn) Rollback() error {
+	if t == nil || t.tx == nil {
+		return nil
+	}
+	err := t.tx.Rollback()
+	t.tx = nil
+	t.refs.release()
+	return err
+}
+
+func (t *bboltReadTxn) Put(key, value []byte) error { return ErrReadOnlyTxn }
+func (t *bboltReadTxn) Delete(key []byte) error     { return ErrReadOnlyTxn }
+func (t *bboltReadTxn) Commit() error               { return ErrReadOnlyTxn }
+
+// bboltTxn is a write transaction. It holds the writer lock until it is
+// committed or rolled back.
+type bboltTxn struct {
+	bboltReadTxn
+	writer *fifoMutex
+}
+
+func (t *bboltTxn) Put(key, value []byte) error {
+	if t == nil || t.tx == nil {
+		return errors.New("put in unopened transaction")
+	}
+	return t.b.Put(key, value)
+}
+
+func (t *bboltTxn) Delete(key []byte) error {
+	if t == nil || t.tx == nil {
+		return errors.New("delete in unopened transaction")
+	}
+	return t.b.Delete(key)
+}
+
+func (t *bboltTxn) Rollback() error {
+	if t == nil || t.tx == nil {
+		return nil
+	}
+	defer t.writer.Unlock()
+	return t.bboltReadTxn.Rollback()
+}
+
+func (t *bboltTxn) Commit() error {
+	if t == nil || t.tx == nil {
+		return nil
+	}
+	defer t.writer.Unlock()
+	err := t.tx.Commit()
+	t.tx = nil
+	t.refs.release()
+	return err
+}
@@ -0,0 +1,30 @@
+package backend
+
+import (
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+func TestBBoltOpensBoltFile(t *testing.T) {
+	const path = "bolt_to_bbolt.db"
+	src := openBoltDB(t, path)
+	if err := Update(src, func(txn RWTxn) error { return txn.Put([]byte("a"), []byte("1")) }); err != nil {
+		t.Fatalf("update BoltDB: %v", err)
+	}
+	if err := src.Close(); err != nil {
+		t.Fatalf("close BoltDB: %v", err)
+	}
+
+	db := openBBoltDB(t, path, BBoltFreelist(bbolt.FreelistMapType, false))
+	defer closeBBoltDB(t, path, db)
+	if err := View(db, func(txn Txn) error {
+		v, err := txn.Get([]byte("a"))
+		if err == nil && string(v) != "1" {
+			t.Fatalf("get: expected %q, got %q", "1", v)
+		}
+		return err
+	}); err != nil {
+		t.Fatalf("view BBoltDB: %v", err)
+	}
+}
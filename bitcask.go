@@ -0,0 +1,752 @@
+package backend
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	bitcaskExt             = ".data"
+	bitcaskHeaderSize      = 8 // crc32 and payload length of a batch
+	bitcaskLocationSize    = 16
+	defaultBitcaskFileSize = 64 << 20
+
+	bitcaskPut    = 0
+	bitcaskDelete = 1
+)
+
+var _ DB = (*Bitcask)(nil)
+
+type BitcaskOption func(*Bitcask) error
+
+// MaxFileSize sets the size after which a new data file is started. The
+// default is 64 MiB.
+func MaxFileSize(n int64) BitcaskOption {
+	return func(db *Bitcask) error {
+		if n <= 0 {
+			return errors.New("max file size must be positive")
+		}
+		db.maxFileSize = n
+		return nil
+	}
+}
+
+// SyncWrites flushes the data file to stable storage on every commit.
+func SyncWrites() BitcaskOption {
+	return func(db *Bitcask) error {
+		db.sync = true
+		return nil
+	}
+}
+
+// Bitcask is a log-structured key/value store. Committed transactions
+// are appended to data files as a single checksummed batch, and an
+// in-memory keydir maps every key to the location of its latest value,
+// so a read costs one seek and writes never rewrite data in place. All
+// keys must fit into memory; Merge reclaims the space of overwritten
+// and deleted values.
+//
+// The keydir is a persistent tree like MemDB's, iterators and read-only
+// transactions read a snapshot of it. Write transactions are granted in
+// FIFO order. A directory must only be opened by one Bitcask at a time.
+type Bitcask struct {
+	dir         string
+	maxFileSize int64
+	sync        bool
+
+	mu      sync.Mutex // guards root, files and retired
+	root    *memNode   // keydir, values are encoded locations
+	files   *bitcaskFiles
+	retired []*bitcaskFiles // replaced file sets still in use, oldest first
+
+	// The active file is only used with the writer lock held.
+	active     *os.File
+	activeID   uint32
+	activeSize int64
+
+	writer fifoMutex
+	refs   refCount
+}
+
+// bitcaskFiles is the set of data files a keydir refers to. A snapshot
+// holds a reference on the set so Merge does not remove files it reads.
+type bitcaskFiles struct {
+	files  map[uint32]*os.File
+	refs   int
+	remove []uint32 // files to remove once the set is no longer used
+}
+
+// OpenBitcask opens the Bitcask database in the directory dir, creating
+// it if it does not exist. The keydir is rebuilt by reading all data
+// files. A batch torn by a crash at the end of the last file is
+// discarded.
+func OpenBitcask(dir string, opts ...BitcaskOption) (*Bitcask, error) {
+	db := &Bitcask{dir: dir, maxFileSize: defaultBitcaskFileSize}
+	for _, opt := range opts {
+		if err := opt(db); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	ids, err := bitcaskFileIDs(dir)
+	if err != nil {
+		return nil, err
+	}
+	db.files = &bitcaskFiles{files: make(map[uint32]*os.File)}
+	for i, id := range ids {
+		f, err := os.OpenFile(db.path(id), os.O_RDWR, defaultOpenMode)
+		if err != nil {
+			db.closeFiles()
+			return nil, err
+		}
+		db.files.files[id] = f
+		size, err := db.replay(f, id, i == len(ids)-1)
+		if err != nil {
+			db.closeFiles()
+			return nil, err
+		}
+		db.activeID, db.activeSize = id, size
+	}
+	if len(ids) == 0 {
+		if err = db.createActive(1); err != nil {
+			return nil, err
+		}
+	}
+	db.active = db.files.files[db.activeID]
+	return db, nil
+}
+
+// bitcaskFileIDs returns the ids of the data files in dir in ascending
+// order.
+func bitcaskFileIDs(dir string) ([]uint32, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*"+bitcaskExt))
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint32
+	for _, name := range names {
+		id, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), bitcaskExt), 10, 32)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, uint32(id))
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+func (db *Bitcask) path(id uint32) string {
+	return filepath.Join(db.dir, fmt.Sprintf("%09d%s", id, bitcaskExt))
+}
+
+// replay applies the batches of data file id to the keydir and returns
+// the size of the valid part of the file. A torn batch at the end of the
+// last file is truncated, anywhere else it is an error.
+func (db *Bitcask) replay(f *os.File, id uint32, last bool) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	r := bufio.NewReader(f)
+	var off int64
+	var header [bitcaskHeaderSize]byte
+	var payload []byte
+	for {
+		_, err := io.ReadFull(r, header[:])
+		if err == io.EOF {
+			return off, nil
+		}
+		if err == nil {
+			n := int64(binary.BigEndian.Uint32(header[4:]))
+			if off+bitcaskHeaderSize+n > info.Size() {
+				err = io.ErrUnexpectedEOF
+			} else {
+				if int64(cap(payload)) < n {
+					payload = make([]byte, n)
+				}
+				payload = payload[:n]
+				if _, err = io.ReadFull(r, payload); err == nil &&
+					crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[:4]) {
+					err = errors.New("checksum mismatch")
+				}
+			}
+		}
+		var root *memNode
+		if err == nil {
+			root, err = applyBitcaskBatch(db.root, payload, id, off+bitcaskHeaderSize)
+		}
+		if err != nil {
+			if !last {
+				return 0, fmt.Errorf("corrupt bitcask data file %s at offset %d: %v", f.Name(), off, err)
+			}
+			return off, f.Truncate(off)
+		}
+		db.root = root
+		off += bitcaskHeaderSize + int64(len(payload))
+	}
+}
+
+// applyBitcaskBatch applies the operations of a batch payload starting
+// at offset base of data file id to the keydir root.
+func applyBitcaskBatch(root *memNode, payload []byte, id uint32, base int64) (*memNode, error) {
+	for p := 0; p < len(payload); {
+		kind := payload[p]
+		klen, n1 := binary.Uvarint(payload[p+1:])
+		if n1 <= 0 {
+			return nil, errors.New("invalid key length")
+		}
+		vlen, n2 := binary.Uvarint(payload[p+1+n1:])
+		if n2 <= 0 {
+			return nil, errors.New("invalid value length")
+		}
+		start := p + 1 + n1 + n2
+		if uint64(len(payload)-start) < klen+vlen {
+			return nil, errors.New("batch too short")
+		}
+		key := append([]byte{}, payload[start:start+int(klen)]...)
+		switch kind {
+		case bitcaskPut:
+			root = root.put(key, bitcaskLocation(id, base+int64(start)+int64(klen), uint32(vlen)))
+		case bitcaskDelete:
+			root = root.delete(key)
+		default:
+			return nil, errors.New("invalid operation")
+		}
+		p = start + int(klen+vlen)
+	}
+	return root, nil
+}
+
+func bitcaskLocation(id uint32, off int64, size uint32) []byte {
+	loc := make([]byte, bitcaskLocationSize)
+	binary.BigEndian.PutUint32(loc, id)
+	binary.BigEndian.PutUint64(loc[4:], uint64(off))
+	binary.BigEndian.PutUint32(loc[12:], size)
+	return loc
+}
+
+// createActive creates the data file id and makes it the active file.
+// The caller must hold the writer lock or own db exclusively.
+func (db *Bitcask) createActive(id uint32) error {
+	f, err := os.OpenFile(db.path(id), os.O_RDWR|os.O_CREATE|os.O_EXCL, defaultOpenMode)
+	if err != nil {
+		return err
+	}
+	db.mu.Lock()
+	files := db.files.clone()
+	files.files[id] = f
+	db.replaceFiles(files, nil)
+	db.mu.Unlock()
+	db.active, db.activeID, db.activeSize = f, id, 0
+	return nil
+}
+
+func (s *bitcaskFiles) clone() *bitcaskFiles {
+	c := &bitcaskFiles{files: make(map[uint32]*os.File, len(s.files)+1)}
+	for id, f := range s.files {
+		c.files[id] = f
+	}
+	return c
+}
+
+// replaceFiles makes files the current file set. The files in remove
+// are closed and removed once no snapshot uses the old set or any older
+// one. The caller must hold db.mu.
+func (db *Bitcask) replaceFiles(files *bitcaskFiles, remove []uint32) {
+	old := db.files
+	old.remove = remove
+	db.files = files
+	db.retired = append(db.retired, old)
+	db.collect()
+}
+
+// collect removes the files of retired sets no longer in use. The caller
+// must hold db.mu.
+func (db *Bitcask) collect() {
+	for len(db.retired) > 0 && db.retired[0].refs == 0 {
+		s := db.retired[0]
+		// ascending order keeps a crash from resurrecting deleted keys
+		for _, id := range s.remove {
+			s.files[id].Close()
+			os.Remove(db.path(id))
+		}
+		db.retired = db.retired[1:]
+	}
+}
+
+// closeFiles closes all data files while opening the database.
+func (db *Bitcask) closeFiles() {
+	for _, f := range db.files.files {
+		f.Close()
+	}
+}
+
+// snapshot returns the current keydir and file set holding a reference
+// on the handle, released by bitcaskSnapshot.release.
+func (db *Bitcask) snapshot() (bitcaskSnapshot, error) {
+	if err := db.refs.acquire(); err != nil {
+		return bitcaskSnapshot{}, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.files.refs++
+	return bitcaskSnapshot{root: db.root, files: db.files, db: db}, nil
+}
+
+type bitcaskSnapshot struct {
+	root  *memNode
+	files *bitcaskFiles
+	db    *Bitcask // nil after release
+}
+
+func (s *bitcaskSnapshot) release() {
+	s.db.mu.Lock()
+	s.files.refs--
+	s.db.collect()
+	s.db.mu.Unlock()
+	s.db.refs.release()
+	s.db, s.root, s.files = nil, nil, nil
+}
+
+// read returns the value stored at loc.
+func (s *bitcaskSnapshot) read(loc []byte) ([]byte, error) {
+	f := s.files.files[binary.BigEndian.Uint32(loc)]
+	if f == nil {
+		return nil, errors.New("bitcask data file missing")
+	}
+	value := make([]byte, binary.BigEndian.Uint32(loc[12:]))
+	_, err := f.ReadAt(value, int64(binary.BigEndian.Uint64(loc[4:])))
+	return value, err
+}
+
+func (s *bitcaskSnapshot) get(key []byte) ([]byte, error) {
+	n := s.root.get(key)
+	if n == nil {
+		return nil, ErrNotFound
+	}
+	return s.read(n.value)
+}
+
+func (db *Bitcask) Iterator() (Iterator, error) {
+	snap, err := db.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &bitcaskIterator{memCursor: memCursor{root: snap.root}, snap: snap}, nil
+}
+
+func (db *Bitcask) Readonly() (Txn, error) {
+	snap, err := db.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &bitcaskReadTxn{snap: snap}, nil
+}
+
+// Writable starts a new write transaction. Blocked callers are granted
+// the transaction in the order they called Writable. Writes are
+// buffered and appended to the active data file on Commit.
+func (db *Bitcask) Writable() (RWTxn, error) {
+	db.writer.Lock()
+	snap, err := db.snapshot()
+	if err != nil {
+		db.writer.Unlock()
+		return nil, err
+	}
+	return &bitcaskTxn{bitcaskReadTxn: bitcaskReadTxn{snap: snap}}, nil
+}
+
+// WriteQueueDepth returns the number of callers blocked in Writable.
+func (db *Bitcask) WriteQueueDepth() int {
+	return db.writer.waiting()
+}
+
+// WriteTo writes the entire database to w using the export format. See
+// Export for details.
+func (db *Bitcask) WriteTo(w io.Writer) (int64, error) {
+	return Export(w, db)
+}
+
+func (db *Bitcask) Name() string { return "Bitcask" }
+
+func (db *Bitcask) Close() error {
+	if db == nil {
+		return errors.New("closing unopened Bitcask instance")
+	}
+	if err := db.refs.close(); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var err error
+	for _, f := range db.files.files {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	db.root, db.files, db.active = nil, nil, nil
+	return err
+}
+
+// append writes a batch payload to the active data file and returns the
+// offset of the payload. It starts a new data file if the active one is
+// full. The caller must hold the writer lock.
+func (db *Bitcask) append(payload []byte) (uint32, int64, error) {
+	if db.activeSize > 0 && db.activeSize+bitcaskHeaderSize+int64(len(payload)) > db.maxFileSize {
+		if err := db.active.Sync(); err != nil {
+			return 0, 0, err
+		}
+		if err := db.createActive(db.activeID + 1); err != nil {
+			return 0, 0, err
+		}
+	}
+	buf := make([]byte, bitcaskHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(buf, crc32.ChecksumIEEE(payload))
+	binary.BigEndian.PutUint32(buf[4:], uint32(len(payload)))
+	copy(buf[bitcaskHeaderSize:], payload)
+	if _, err := db.active.WriteAt(buf, db.activeSize); err != nil {
+		return 0, 0, err
+	}
+	if db.sync {
+		if err := db.active.Sync(); err != nil {
+			return 0, 0, err
+		}
+	}
+	off := db.activeSize + bitcaskHeaderSize
+	db.activeSize += int64(len(buf))
+	return db.activeID, off, nil
+}
+
+// Merge rewrites all live values into new data files and removes the old
+// files, reclaiming the space of overwritten and deleted values. Old
+// files are removed once the iterators and transactions reading them are
+// closed. Merge blocks writers while it runs.
+func (db *Bitcask) Merge() error {
+	db.writer.Lock()
+	defer db.writer.Unlock()
+	snap, err := db.snapshot()
+	if err != nil {
+		return err
+	}
+	defer snap.release()
+
+	// the merged data goes into new files after the active one, followed
+	// by a new active file, so replaying the files in order applies later
+	// writes last
+	old := make([]uint32, 0, len(snap.files.files))
+	for id := range snap.files.files {
+		old = append(old, id)
+	}
+	sort.Slice(old, func(i, j int) bool { return old[i] < old[j] })
+	if err = db.active.Sync(); err != nil {
+		return err
+	}
+
+	var root *memNode
+	var payload []byte
+	var keys [][]byte
+	var values [][2]int // offset and length of the values in payload
+	files := &bitcaskFiles{files: make(map[uint32]*os.File)}
+	id := db.activeID + 1
+	var f *os.File
+	var size int64
+	flush := func() error {
+		if len(payload) == 0 {
+			return nil
+		}
+		if f == nil || size > 0 && size+bitcaskHeaderSize+int64(len(payload)) > db.maxFileSize {
+			if f != nil {
+				if err := f.Sync(); err != nil {
+					return err
+				}
+				id++
+			}
+			var err error
+			if f, err = os.OpenFile(db.path(id), os.O_RDWR|os.O_CREATE|os.O_EXCL, defaultOpenMode); err != nil {
+				return err
+			}
+			files.files[id] = f
+			size = 0
+		}
+		buf := make([]byte, bitcaskHeaderSize, bitcaskHeaderSize+len(payload))
+		binary.BigEndian.PutUint32(buf, crc32.ChecksumIEEE(payload))
+		binary.BigEndian.PutUint32(buf[4:], uint32(len(payload)))
+		if _, err := f.WriteAt(append(buf, payload...), size); err != nil {
+			return err
+		}
+		for i, key := range keys {
+			off := size + bitcaskHeaderSize + int64(values[i][0])
+			root = root.put(key, bitcaskLocation(id, off, uint32(values[i][1])))
+		}
+		size += bitcaskHeaderSize + int64(len(payload))
+		payload, keys, values = payload[:0], keys[:0], values[:0]
+		return nil
+	}
+
+	c := memCursor{root: snap.root}
+	for n := c.first(); n != nil; n = c.next() {
+		value, err := snap.read(n.value)
+		if err != nil {
+			closeBitcaskFiles(files)
+			return err
+		}
+		payload = appendBitcaskOp(payload, bitcaskPut, n.key, value)
+		keys = append(keys, n.key)
+		values = append(values, [2]int{len(payload) - len(value), len(value)})
+		if int64(len(payload)) >= db.maxFileSize/4 {
+			if err = flush(); err != nil {
+				closeBitcaskFiles(files)
+				return err
+			}
+		}
+	}
+	if err = flush(); err == nil && f != nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		closeBitcaskFiles(files)
+		return err
+	}
+
+	active, err := os.OpenFile(db.path(id+1), os.O_RDWR|os.O_CREATE|os.O_EXCL, defaultOpenMode)
+	if err != nil {
+		closeBitcaskFiles(files)
+		return err
+	}
+	files.files[id+1] = active
+
+	db.mu.Lock()
+	db.root = root
+	db.replaceFiles(files, old)
+	db.mu.Unlock()
+	db.active, db.activeID, db.activeSize = active, id+1, 0
+	return nil
+}
+
+func closeBitcaskFiles(s *bitcaskFiles) {
+	for id, f := range s.files {
+		f.Close()
+		os.Remove(f.Name())
+		delete(s.files, id)
+	}
+}
+
+func appendBitcaskOp(payload []byte, kind byte, key, value []byte) []byte {
+	payload = append(payload, kind)
+	payload = binary.AppendUvarint(payload, uint64(len(key)))
+	payload = binary.AppendUvarint(payload, uint64(len(value)))
+	payload = append(payload, key...)
+	return append(payload, value...)
+}
+
+type bitcaskIterator struct {
+	memCursor
+	snap bitcaskSnapshot
+	err  error // first read error, returned by Close
+}
+
+// pair returns the key and value of n, reading the value from its data
+// file.
+func (i *bitcaskIterator) pair(n *memNode) ([]byte, []byte) {
+	if n == nil {
+		return nil, nil
+	}
+	value, err := i.snap.read(n.value)
+	if err != nil {
+		if i.err == nil {
+			i.err = err
+		}
+		i.path = i.path[:0]
+		return nil, nil
+	}
+	return n.key, value
+}
+
+func (i *bitcaskIterator) Seek(key []byte) ([]byte, []byte) {
+	if i == nil || i.snap.db == nil {
+		return nil, nil
+	}
+	return i.pair(i.seek(key))
+}
+
+func (i *bitcaskIterator) First() ([]byte, []byte) {
+	if i == nil || i.snap.db == nil {
+		return nil, nil
+	}
+	return i.pair(i.first())
+}
+
+func (i *bitcaskIterator) Last() ([]byte, []byte) {
+	if i == nil || i.snap.db == nil {
+		return nil, nil
+	}
+	return i.pair(i.last())
+}
+
+func (i *bitcaskIterator) Next() ([]byte, []byte) {
+	if i == nil || i.snap.db == nil {
+		return nil, nil
+	}
+	return i.pair(i.next())
+}
+
+func (i *bitcaskIterator) Prev() ([]byte, []byte) {
+	if i == nil || i.snap.db == nil {
+		return nil, nil
+	}
+	return i.pair(i.prev())
+}
+
+func (i *bitcaskIterator) SeekToIndex(n int64) ([]byte, []byte) {
+	if i == nil || i.snap.db == nil {
+		return nil, nil
+	}
+	return i.pair(i.index(n))
+}
+
+// Reset moves the iterator to a new snapshot of the database. The
+// iterator is unpositioned afterwards.
+func (i *bitcaskIterator) Reset() error {
+	if i == nil || i.snap.db == nil {
+		return errors.New("reset closed iterator")
+	}
+	db := i.snap.db
+	snap, err := db.snapshot()
+	if err != nil {
+		return err
+	}
+	i.snap.release()
+	i.snap = snap
+	i.reset(snap.root)
+	return nil
+}
+
+func (i *bitcaskIterator) Close() error {
+	if i == nil || i.snap.db == nil {
+		return nil
+	}
+	i.snap.release()
+	i.reset(nil)
+	return i.err
+}
+
+// bitcaskReadTxn is a read-only transaction on a snapshot. Its write
+// methods only exist to reject writes after a type assertion to RWTxn.
+type bitcaskReadTxn struct {
+	snap bitcaskSnapshot
+}
+
+func (t *bitcaskReadTxn) Get(key []byte) ([]byte, error) {
+	if t == nil || t.snap.db == nil {
+		return nil, errors.New("get from unopened transaction")
+	}
+	return t.snap.get(key)
+}
+
+func (t *bitcaskReadTxn) Rollback() error {
+	if t == nil || t.snap.db == nil {
+		return nil
+	}
+	t.snap.release()
+	return nil
+}
+
+func (t *bitcaskReadTxn) Put(key, value []byte) error { return ErrReadOnlyTxn }
+func (t *bitcaskReadTxn) Delete(key []byte) error     { return ErrReadOnlyTxn }
+func (t *bitcaskReadTxn) Commit() error               { return ErrReadOnlyTxn }
+
+// bitcaskTxn is a write transaction. Pending writes are kept in a tree
+// holding the operation kind followed by the value for each key. It
+// holds the writer lock until it is committed or rolled back.
+type bitcaskTxn struct {
+	bitcaskReadTxn
+	pending *memNode
+}
+
+func (t *bitcaskTxn) Get(key []byte) ([]byte, error) {
+	if t == nil || t.snap.db == nil {
+		return nil, errors.New("get from unopened transaction")
+	}
+	if n := t.pending.get(key); n != nil {
+		if n.value[0] == bitcaskDelete {
+			return nil, ErrNotFound
+		}
+		return n.value[1:], nil
+	}
+	return t.snap.get(key)
+}
+
+func (t *bitcaskTxn) Put(key, value []byte) error {
+	if t == nil || t.snap.db == nil {
+		return errors.New("put in unopened transaction")
+	}
+	buf := make([]byte, len(key)+1+len(value))
+	n := copy(buf, key)
+	buf[n] = bitcaskPut
+	copy(buf[n+1:], value)
+	t.pending = t.pending.put(buf[:n:n], buf[n:])
+	return nil
+}
+
+func (t *bitcaskTxn) Delete(key []byte) error {
+	if t == nil || t.snap.db == nil {
+		return errors.New("delete in unopened transaction")
+	}
+	buf := append(append([]byte{}, key...), bitcaskDelete)
+	t.pending = t.pending.put(buf[:len(key):len(key)], buf[len(key):])
+	return nil
+}
+
+func (t *bitcaskTxn) Rollback() error {
+	if t == nil || t.snap.db == nil {
+		return nil
+	}
+	defer t.snap.db.writer.Unlock()
+	t.pending = nil
+	return t.bitcaskReadTxn.Rollback()
+}
+
+func (t *bitcaskTxn) Commit() error {
+	if t == nil || t.snap.db == nil {
+		return nil
+	}
+	if t.pending == nil {
+		return t.Rollback()
+	}
+	var payload []byte
+	c := memCursor{root: t.pending}
+	for n := c.first(); n != nil; n = c.next() {
+		payload = appendBitcaskOp(payload, n.value[0], n.key, n.value[1:])
+	}
+
+	db := t.snap.db
+	id, off, err := db.append(payload)
+	var root *memNode
+	if err == nil {
+		// the writer lock guarantees that the keydir has not changed
+		// since the transaction started
+		root, err = applyBitcaskBatch(t.snap.root, payload, id, off)
+	}
+	if err == nil {
+		db.mu.Lock()
+		db.root = root
+		db.mu.Unlock()
+	}
+	if rerr := t.Rollback(); err == nil {
+		err = rerr
+	}
+	return err
+}
@@ -0,0 +1,140 @@
+package backend
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func bitcaskDataFiles(t *testing.T, path string) []string {
+	names, err := filepath.Glob(filepath.Join(path, "*"+bitcaskExt))
+	if err != nil {
+		t.Fatalf("glob: %v", err)
+	}
+	return names
+}
+
+func TestBitcaskReopen(t *testing.T) {
+	const path = "reopen_bitcask"
+	db := openBitcask(t, path, MaxFileSize(256))
+	defer os.RemoveAll(path)
+
+	for i := 0; i < 3; i++ {
+		if err := Update(db, func(txn RWTxn) error {
+			for j := 0; j < 20; j++ {
+				k := []byte(fmt.Sprintf("key%02d", j))
+				if j%5 == i {
+					if err := txn.Delete(k); err != nil {
+						return err
+					}
+					continue
+				}
+				if err := txn.Put(k, []byte(fmt.Sprintf("val%d", i))); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("update %d: %v", i, err)
+		}
+	}
+	if n := len(bitcaskDataFiles(t, path)); n < 2 {
+		t.Fatalf("expected rotated data files, got %d", n)
+	}
+	want, err := Scan(db, nil)
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// a torn batch at the end of the last file is discarded
+	files := bitcaskDataFiles(t, path)
+	f, err := os.OpenFile(files[len(files)-1], os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("open data file: %v", err)
+	}
+	f.Write([]byte{1, 2, 3, 4, 0, 0, 1, 0, 9})
+	f.Close()
+
+	if db, err = OpenBitcask(path); err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer db.Close()
+	got, err := Scan(db, nil)
+	if err != nil {
+		t.Fatalf("scan after reopen: %v", err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("reopen: expected %d pairs %q, got %d pairs %q", len(want), want, len(got), got)
+	}
+	if err = Update(db, func(txn RWTxn) error { return txn.Put([]byte("new"), []byte("1")) }); err != nil {
+		t.Fatalf("put after reopen: %v", err)
+	}
+}
+
+func TestBitcaskMerge(t *testing.T) {
+	const path = "merge_bitcask"
+	db := openBitcask(t, path, MaxFileSize(512))
+	defer func() { closeBitcask(t, path, db) }()
+
+	for i := 0; i < 10; i++ {
+		if err := Update(db, func(txn RWTxn) error {
+			for j := 0; j < 10; j++ {
+				if err := txn.Put([]byte(fmt.Sprintf("key%d", j)), []byte(fmt.Sprintf("val%d-%d", j, i))); err != nil {
+					return err
+				}
+			}
+			return txn.Delete([]byte("key0"))
+		}); err != nil {
+			t.Fatalf("update %d: %v", i, err)
+		}
+	}
+	before := bitcaskDataFiles(t, path)
+
+	iter, err := db.Iterator()
+	if err != nil {
+		t.Fatalf("iterator: %v", err)
+	}
+	if err = db.Merge(); err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	// the open iterator keeps the old files alive
+	if _, err = os.Stat(before[0]); err != nil {
+		t.Fatalf("old data file removed while in use: %v", err)
+	}
+	n := 0
+	for k, v := iter.First(); k != nil; k, v = iter.Next() {
+		if want := fmt.Sprintf("val%s-9", k[3:]); string(v) != want {
+			t.Fatalf("iterator after merge: expected %q, got %q", want, v)
+		}
+		n++
+	}
+	if err = iter.Close(); err != nil {
+		t.Fatalf("close iterator: %v", err)
+	}
+	if n != 9 {
+		t.Fatalf("iterator after merge: expected 9 keys, got %d", n)
+	}
+	for _, name := range before {
+		if _, err = os.Stat(name); !os.IsNotExist(err) {
+			t.Fatalf("old data file %s not removed: %v", name, err)
+		}
+	}
+
+	pairs, err := Scan(db, nil)
+	if err != nil || len(pairs) != 9 || string(pairs[8].Value) != "val9-9" {
+		t.Fatalf("scan after merge: got %q, %v", pairs, err)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if db, err = OpenBitcask(path); err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if pairs, err = Scan(db, nil); err != nil || len(pairs) != 9 {
+		t.Fatalf("scan after reopen: got %d pairs, %v", len(pairs), err)
+	}
+}
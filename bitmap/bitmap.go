@@ -0,0 +1,471 @@
+// Package bitmap stores compressed sets of uint32 values, e.g. the ids
+// of the members of a group, as database values.
+//
+// Sets are roaring bitmaps: values are partitioned by their high 16
+// bits into containers, which hold their low 16 bits either as a sorted
+// array while sparse or as a 65536 bit bitmap while dense. The helpers
+// Add, Remove, Contains, Union and Intersect read and write sets inside
+// a transaction:
+//
+//	err := bitmap.Add(txn, []byte("group/admins"), 7, 42)
+//	...
+//	users, err := bitmap.Union(txn, []byte("group/admins"), []byte("group/ops"))
+//
+// The binary format is specific to this package and not compatible with
+// other roaring implementations.
+package bitmap
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"sort"
+
+	"github.com/mars9/backend"
+)
+
+var errInvalidBitmap = errors.New("bitmap: invalid encoding")
+
+const (
+	// arrayMax is the cardinality above which a container is stored as a
+	// bitmap, the size of both representations is equal at this point.
+	arrayMax   = 4096
+	bitmapSize = 1 << 16 / 64
+
+	typeArray  = 0
+	typeBitmap = 1
+)
+
+// container holds the low 16 bits of the values sharing the same high
+// bits. Exactly one of array and bitmap is used.
+type container struct {
+	array  []uint16 // sorted
+	bitmap []uint64
+	n      int
+}
+
+func (c *container) contains(x uint16) bool {
+	if c.bitmap != nil {
+		return c.bitmap[x/64]&(1<<(x%64)) != 0
+	}
+	i := sort.Search(len(c.array), func(i int) bool { return c.array[i] >= x })
+	return i < len(c.array) && c.array[i] == x
+}
+
+func (c *container) add(x uint16) {
+	if c.bitmap != nil {
+		if c.bitmap[x/64]&(1<<(x%64)) == 0 {
+			c.bitmap[x/64] |= 1 << (x % 64)
+			c.n++
+		}
+		return
+	}
+	i := sort.Search(len(c.array), func(i int) bool { return c.array[i] >= x })
+	if i < len(c.array) && c.array[i] == x {
+		return
+	}
+	c.array = append(c.array, 0)
+	copy(c.array[i+1:], c.array[i:])
+	c.array[i] = x
+	c.n++
+	if c.n > arrayMax {
+		c.toBitmap()
+	}
+}
+
+func (c *container) remove(x uint16) {
+	if c.bitmap != nil {
+		if c.bitmap[x/64]&(1<<(x%64)) != 0 {
+			c.bitmap[x/64] &^= 1 << (x % 64)
+			c.n--
+		}
+		if c.n <= arrayMax {
+			c.toArray()
+		}
+		return
+	}
+	i := sort.Search(len(c.array), func(i int) bool { return c.array[i] >= x })
+	if i < len(c.array) && c.array[i] == x {
+		c.array = append(c.array[:i], c.array[i+1:]...)
+		c.n--
+	}
+}
+
+func (c *container) toBitmap() {
+	c.bitmap = make([]uint64, bitmapSize)
+	for _, x := range c.array {
+		c.bitmap[x/64] |= 1 << (x % 64)
+	}
+	c.array = nil
+}
+
+func (c *container) toArray() {
+	c.array = make([]uint16, 0, c.n)
+	c.each(func(x uint16) { c.array = append(c.array, x) })
+	c.bitmap = nil
+}
+
+// each calls fn for all values in ascending order.
+func (c *container) each(fn func(x uint16)) {
+	if c.bitmap == nil {
+		for _, x := range c.array {
+			fn(x)
+		}
+		return
+	}
+	for i, w := range c.bitmap {
+		for w != 0 {
+			fn(uint16(i*64 + bits.TrailingZeros64(w)))
+			w &= w - 1
+		}
+	}
+}
+
+// or adds all values of o to c.
+func (c *container) or(o *container) {
+	if c.bitmap != nil && o.bitmap != nil {
+		c.n = 0
+		for i := range c.bitmap {
+			c.bitmap[i] |= o.bitmap[i]
+			c.n += bits.OnesCount64(c.bitmap[i])
+		}
+		return
+	}
+	o.each(c.add)
+}
+
+// and removes all values of c not in o.
+func (c *container) and(o *container) {
+	if c.bitmap != nil && o.bitmap != nil {
+		c.n = 0
+		for i := range c.bitmap {
+			c.bitmap[i] &= o.bitmap[i]
+			c.n += bits.OnesCount64(c.bitmap[i])
+		}
+		if c.n <= arrayMax {
+			c.toArray()
+		}
+		return
+	}
+	var keep []uint16
+	c.each(func(x uint16) {
+		if o.contains(x) {
+			keep = append(keep, x)
+		}
+	})
+	c.array, c.bitmap, c.n = keep, nil, len(keep)
+}
+
+func (c *container) clone() *container {
+	return &container{
+		array:  append([]uint16(nil), c.array...),
+		bitmap: append([]uint64(nil), c.bitmap...),
+		n:      c.n,
+	}
+}
+
+// Bitmap is a set of uint32 values. The zero value is an empty set.
+type Bitmap struct {
+	keys       []uint16 // sorted high bits
+	containers []*container
+}
+
+// New returns a bitmap containing values.
+func New(values ...uint32) *Bitmap {
+	b := &Bitmap{}
+	for _, x := range values {
+		b.Add(x)
+	}
+	return b
+}
+
+func (b *Bitmap) find(hi uint16) (int, bool) {
+	i := sort.Search(len(b.keys), func(i int) bool { return b.keys[i] >= hi })
+	return i, i < len(b.keys) && b.keys[i] == hi
+}
+
+// Add adds x to the set.
+func (b *Bitmap) Add(x uint32) {
+	hi := uint16(x >> 16)
+	i, ok := b.find(hi)
+	if !ok {
+		b.keys = append(b.keys, 0)
+		copy(b.keys[i+1:], b.keys[i:])
+		b.keys[i] = hi
+		b.containers = append(b.containers, nil)
+		copy(b.containers[i+1:], b.containers[i:])
+		b.containers[i] = &container{}
+	}
+	b.containers[i].add(uint16(x))
+}
+
+// Remove removes x from the set.
+func (b *Bitmap) Remove(x uint32) {
+	if i, ok := b.find(uint16(x >> 16)); ok {
+		b.containers[i].remove(uint16(x))
+		if b.containers[i].n == 0 {
+			b.keys = append(b.keys[:i], b.keys[i+1:]...)
+			b.containers = append(b.containers[:i], b.containers[i+1:]...)
+		}
+	}
+}
+
+// Contains reports whether x is in the set.
+func (b *Bitmap) Contains(x uint32) bool {
+	i, ok := b.find(uint16(x >> 16))
+	return ok && b.containers[i].contains(uint16(x))
+}
+
+// Len returns the number of values in the set.
+func (b *Bitmap) Len() int {
+	n := 0
+	for _, c := range b.containers {
+		n += c.n
+	}
+	return n
+}
+
+// Each calls fn for all values in ascending order until fn returns
+// false.
+func (b *Bitmap) Each(fn func(x uint32) bool) {
+	for i, c := range b.containers {
+		hi, stop := uint32(b.keys[i])<<16, false
+		c.each(func(x uint16) {
+			if !stop && !fn(hi|uint32(x)) {
+				stop = true
+			}
+		})
+		if stop {
+			return
+		}
+	}
+}
+
+// Values returns all values in ascending order.
+func (b *Bitmap) Values() []uint32 {
+	values := make([]uint32, 0, b.Len())
+	b.Each(func(x uint32) bool {
+		values = append(values, x)
+		return true
+	})
+	return values
+}
+
+// Or adds all values of o to b.
+func (b *Bitmap) Or(o *Bitmap) {
+	for j, hi := range o.keys {
+		i, ok := b.find(hi)
+		if ok {
+			b.containers[i].or(o.containers[j])
+			continue
+		}
+		b.keys = append(b.keys, 0)
+		copy(b.keys[i+1:], b.keys[i:])
+		b.keys[i] = hi
+		b.containers = append(b.containers, nil)
+		copy(b.containers[i+1:], b.containers[i:])
+		b.containers[i] = o.containers[j].clone()
+	}
+}
+
+// And removes all values from b that are not in o.
+func (b *Bitmap) And(o *Bitmap) {
+	keys, containers := b.keys[:0], b.containers[:0]
+	for i, hi := range b.keys {
+		j, ok := o.find(hi)
+		if !ok {
+			continue
+		}
+		c := b.containers[i]
+		if c.and(o.containers[j]); c.n > 0 {
+			keys, containers = append(keys, hi), append(containers, c)
+		}
+	}
+	b.keys, b.containers = keys, containers
+}
+
+// MarshalBinary encodes the set. Each container is stored as its high
+// bits, type and cardinality followed by its values or bitmap words in
+// little endian byte order.
+func (b *Bitmap) MarshalBinary() ([]byte, error) {
+	size := 4
+	for _, c := range b.containers {
+		size += 8 + 2*len(c.array) + 8*len(c.bitmap)
+	}
+	buf := make([]byte, 4, size)
+	binary.LittleEndian.PutUint32(buf, uint32(len(b.keys)))
+	for i, c := range b.containers {
+		typ := uint16(typeArray)
+		if c.bitmap != nil {
+			typ = typeBitmap
+		}
+		buf = binary.LittleEndian.AppendUint16(buf, b.keys[i])
+		buf = binary.LittleEndian.AppendUint16(buf, typ)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(c.n))
+		for _, x := range c.array {
+			buf = binary.LittleEndian.AppendUint16(buf, x)
+		}
+		for _, w := range c.bitmap {
+			buf = binary.LittleEndian.AppendUint64(buf, w)
+		}
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a set encoded by MarshalBinary.
+func (b *Bitmap) UnmarshalBinary(data []byte) error {
+	if len(data) < 4 {
+		return errInvalidBitmap
+	}
+	count := binary.LittleEndian.Uint32(data)
+	data = data[4:]
+	if uint64(count)*8 > uint64(len(data)) {
+		return errInvalidBitmap
+	}
+	keys := make([]uint16, 0, count)
+	containers := make([]*container, 0, count)
+	for i := uint32(0); i < count; i++ {
+		if len(data) < 8 {
+			return errInvalidBitmap
+		}
+		hi := binary.LittleEndian.Uint16(data)
+		typ := binary.LittleEndian.Uint16(data[2:])
+		n := int(binary.LittleEndian.Uint32(data[4:]))
+		data = data[8:]
+		if (i > 0 && hi <= keys[i-1]) || n == 0 || n > 1<<16 {
+			return errInvalidBitmap
+		}
+
+		c := &container{n: n}
+		switch typ {
+		case typeArray:
+			if n > arrayMax || len(data) < 2*n {
+				return errInvalidBitmap
+			}
+			c.array = make([]uint16, n)
+			for j := range c.array {
+				c.array[j] = binary.LittleEndian.Uint16(data[2*j:])
+				if j > 0 && c.array[j] <= c.array[j-1] {
+					return errInvalidBitmap
+				}
+			}
+			data = data[2*n:]
+		case typeBitmap:
+			if len(data) < 8*bitmapSize {
+				return errInvalidBitmap
+			}
+			c.bitmap = make([]uint64, bitmapSize)
+			ones := 0
+			for j := range c.bitmap {
+				c.bitmap[j] = binary.LittleEndian.Uint64(data[8*j:])
+				ones += bits.OnesCount64(c.bitmap[j])
+			}
+			if ones != n {
+				return errInvalidBitmap
+			}
+			data = data[8*bitmapSize:]
+		default:
+			return errInvalidBitmap
+		}
+		keys, containers = append(keys, hi), append(containers, c)
+	}
+	if len(data) != 0 {
+		return errInvalidBitmap
+	}
+	b.keys, b.containers = keys, containers
+	return nil
+}
+
+// Get returns the set stored under key, or an empty set if the key does
+// not exist.
+func Get(txn backend.Txn, key []byte) (*Bitmap, error) {
+	b := &Bitmap{}
+	value, err := txn.Get(key)
+	if err == backend.ErrNotFound {
+		return b, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err = b.UnmarshalBinary(value); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Put stores b under key. An empty set deletes the key.
+func Put(txn backend.RWTxn, key []byte, b *Bitmap) error {
+	if b.Len() == 0 {
+		return txn.Delete(key)
+	}
+	value, err := b.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return txn.Put(key, value)
+}
+
+// Add adds values to the set stored under key.
+func Add(txn backend.RWTxn, key []byte, values ...uint32) error {
+	b, err := Get(txn, key)
+	if err != nil {
+		return err
+	}
+	for _, x := range values {
+		b.Add(x)
+	}
+	return Put(txn, key, b)
+}
+
+// Remove removes values from the set stored under key.
+func Remove(txn backend.RWTxn, key []byte, values ...uint32) error {
+	b, err := Get(txn, key)
+	if err != nil {
+		return err
+	}
+	for _, x := range values {
+		b.Remove(x)
+	}
+	return Put(txn, key, b)
+}
+
+// Contains reports whether x is in the set stored under key.
+func Contains(txn backend.Txn, key []byte, x uint32) (bool, error) {
+	b, err := Get(txn, key)
+	if err != nil {
+		return false, err
+	}
+	return b.Contains(x), nil
+}
+
+// Union returns the union of the sets stored under keys.
+func Union(txn backend.Txn, keys ...[]byte) (*Bitmap, error) {
+	result := &Bitmap{}
+	for _, key := range keys {
+		b, err := Get(txn, key)
+		if err != nil {
+			return nil, err
+		}
+		result.Or(b)
+	}
+	return result, nil
+}
+
+// Intersect returns the intersection of the sets stored under keys.
+func Intersect(txn backend.Txn, keys ...[]byte) (*Bitmap, error) {
+	var result *Bitmap
+	for _, key := range keys {
+		b, err := Get(txn, key)
+		if err != nil {
+			return nil, err
+		}
+		if result == nil {
+			result = b
+		} else {
+			result.And(b)
+		}
+	}
+	if result == nil {
+		result = &Bitmap{}
+	}
+	return result, nil
+}
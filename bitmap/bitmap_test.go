@@ -0,0 +1,133 @@
+package bitmap
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/mars9/backend"
+)
+
+func TestBitmap(t *testing.T) {
+	b := New(1, 70000, 5, 1<<31)
+	if got := b.Values(); !reflect.DeepEqual(got, []uint32{1, 5, 70000, 1 << 31}) {
+		t.Fatalf("values: got %v", got)
+	}
+	if !b.Contains(70000) || b.Contains(70001) {
+		t.Fatal("contains: wrong membership")
+	}
+	b.Remove(70000)
+	b.Remove(3)
+	if b.Len() != 3 || len(b.keys) != 2 {
+		t.Fatalf("remove: expected 3 values in 2 containers, got %d in %d", b.Len(), len(b.keys))
+	}
+
+	// dense containers switch to bitmaps and back
+	d := &Bitmap{}
+	for x := uint32(0); x < 10000; x += 2 {
+		d.Add(x)
+	}
+	if d.containers[0].bitmap == nil || d.Len() != 5000 {
+		t.Fatalf("dense: expected bitmap container with 5000 values, got %d", d.Len())
+	}
+	for x := uint32(0); x < 4000; x += 2 {
+		d.Remove(x)
+	}
+	if d.containers[0].bitmap != nil || d.Len() != 3000 {
+		t.Fatalf("sparse: expected array container with 3000 values, got %d", d.Len())
+	}
+
+	for _, s := range []*Bitmap{{}, b, d} {
+		data, err := s.MarshalBinary()
+		if err != nil {
+			t.Fatalf("marshal: %v", err)
+		}
+		r := &Bitmap{}
+		if err = r.UnmarshalBinary(data); err != nil {
+			t.Fatalf("unmarshal: %v", err)
+		}
+		if !reflect.DeepEqual(r.Values(), s.Values()) {
+			t.Fatal("unmarshal: values differ")
+		}
+		if len(data) > 4 {
+			if err = r.UnmarshalBinary(data[:len(data)-1]); err != errInvalidBitmap {
+				t.Fatalf("unmarshal truncated: expected errInvalidBitmap, got %v", err)
+			}
+		}
+	}
+
+	u := New(1, 2, 3)
+	u.Or(New(3, 4, 1<<20))
+	if got := u.Values(); !reflect.DeepEqual(got, []uint32{1, 2, 3, 4, 1 << 20}) {
+		t.Fatalf("or: got %v", got)
+	}
+	u.And(New(2, 4, 9, 1<<21))
+	if got := u.Values(); !reflect.DeepEqual(got, []uint32{2, 4}) {
+		t.Fatalf("and: got %v", got)
+	}
+}
+
+func TestTxn(t *testing.T) {
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("bitmap-%d.db", os.Getpid()))
+	db, err := backend.OpenBoltDB(path, 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer os.Remove(path)
+	defer db.Close()
+
+	a, c := []byte("a"), []byte("c")
+	err = backend.Update(db, func(txn backend.RWTxn) error {
+		if err := Add(txn, a, 1, 2, 3); err != nil {
+			return err
+		}
+		if err := Add(txn, c, 3, 4); err != nil {
+			return err
+		}
+		return Remove(txn, a, 1)
+	})
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	err = backend.View(db, func(txn backend.Txn) error {
+		if ok, err := Contains(txn, a, 2); err != nil || !ok {
+			return fmt.Errorf("contains 2: %v %v", ok, err)
+		}
+		if ok, err := Contains(txn, a, 1); err != nil || ok {
+			return fmt.Errorf("contains 1: %v %v", ok, err)
+		}
+		u, err := Union(txn, a, c, []byte("missing"))
+		if err != nil {
+			return err
+		}
+		if got := u.Values(); !reflect.DeepEqual(got, []uint32{2, 3, 4}) {
+			return fmt.Errorf("union: got %v", got)
+		}
+		i, err := Intersect(txn, a, c)
+		if err != nil {
+			return err
+		}
+		if got := i.Values(); !reflect.DeepEqual(got, []uint32{3}) {
+			return fmt.Errorf("intersect: got %v", got)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// removing the last value deletes the key
+	if err = backend.Update(db, func(txn backend.RWTxn) error { return Remove(txn, c, 3, 4) }); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	err = backend.View(db, func(txn backend.Txn) error {
+		_, err := txn.Get(c)
+		return err
+	})
+	if err != backend.ErrNotFound {
+		t.Fatalf("get: expected ErrNotFound, got %v", err)
+	}
+}
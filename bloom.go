@@ -0,0 +1,100 @@
+package backend
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+const minBloomCapacity = 1024
+
+// bloomFilter is an in-memory bloom filter over database keys. Keys are
+// only ever added, deletions leave stale bits behind and merely increase
+// the false positive rate until the filter is rebuilt.
+type bloomFilter struct {
+	mu         sync.RWMutex
+	bits       []uint64
+	k          uint32 // number of probes
+	bitsPerKey int
+	capacity   int // number of keys the filter was sized for
+	n          int // number of distinct keys added
+}
+
+func newBloomFilter(bitsPerKey, capacity int) *bloomFilter {
+	if capacity < minBloomCapacity {
+		capacity = minBloomCapacity
+	}
+	// k = ln(2) * bits per key minimizes the false positive rate
+	k := uint32(float64(bitsPerKey) * 0.69)
+	if k < 1 {
+		k = 1
+	} else if k > 30 {
+		k = 30
+	}
+	return &bloomFilter{
+		bits:       make([]uint64, (bitsPerKey*capacity+63)/64),
+		k:          k,
+		bitsPerKey: bitsPerKey,
+		capacity:   capacity,
+	}
+}
+
+// bloomHash returns the two hashes used for double hashing.
+func bloomHash(key []byte) (uint32, uint32) {
+	h := fnv.New64a()
+	h.Write(key)
+	sum := h.Sum64()
+	return uint32(sum), uint32(sum>>32) | 1
+}
+
+// add adds key to the filter. Keys whose bits are all set already, like
+// overwritten ones, are not counted.
+func (f *bloomFilter) add(key []byte) {
+	h, delta := bloomHash(key)
+	nbits := uint32(len(f.bits) * 64)
+	f.mu.Lock()
+	added := false
+	for i := uint32(0); i < f.k; i++ {
+		bit := h % nbits
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			f.bits[bit/64] |= 1 << (bit % 64)
+			added = true
+		}
+		h += delta
+	}
+	if added {
+		f.n++
+	}
+	f.mu.Unlock()
+}
+
+// mayContain reports whether key may have been added. A false result
+// means the key was definitely never added.
+func (f *bloomFilter) mayContain(key []byte) bool {
+	h, delta := bloomHash(key)
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	nbits := uint32(len(f.bits) * 64)
+	for i := uint32(0); i < f.k; i++ {
+		bit := h % nbits
+		if f.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+		h += delta
+	}
+	return true
+}
+
+// full reports whether the filter holds considerably more keys than it
+// was sized for and should be rebuilt.
+func (f *bloomFilter) full() bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.n > 2*f.capacity
+}
+
+// replace atomically replaces the filter contents with nf.
+func (f *bloomFilter) replace(nf *bloomFilter) {
+	f.mu.Lock()
+	f.bits, f.k, f.capacity, f.n = nf.bits, nf.k, nf.capacity, nf.n
+	f.mu.Unlock()
+}
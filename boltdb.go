@@ -14,9 +14,47 @@ var _ DB = (*BoltDB)(nil)
 
 const defaultOpenMode = 0600
 
+// BoltOption configures a BoltDB.
+type BoltOption func(*BoltDB) error
+
+// BloomFilter maintains an in-memory bloom filter with the given number
+// of bits per key, which lets Get return ErrNotFound for most missing
+// keys without walking the B-tree. The filter is built by scanning the
+// database on open and is rebuilt by a commit once it has grown well
+// beyond its initial size and no reader is open. Ten bits per key yield
+// a false positive rate of about one percent.
+func BloomFilter(bitsPerKey int) BoltOption {
+	return func(db *BoltDB) error {
+		if bitsPerKey <= 0 {
+			return errors.New("bloom filter bits per key must be positive")
+		}
+		db.bloomBitsPerKey = bitsPerKey
+		return nil
+	}
+}
+
+// ReadPool recycles the memory of values returned by GetValue. Values up
+// to maxSize bytes are copied into buffers taken from power of two size
+// classes and returned to them by Value.Release.
+func ReadPool(maxSize int) BoltOption {
+	return func(db *BoltDB) error {
+		if maxSize < minPooledSize {
+			return errors.New("read pool max size too small")
+		}
+		db.pool = newValuePool(maxSize)
+		return nil
+	}
+}
+
 // BoltDB represents a key/value store.
 type BoltDB struct {
-	tree *bolt.DB
+	tree   *bolt.DB
+	filter *bloomFilter // optional negative lookup filter
+	pool   *valuePool   // optional GetValue buffer pool
+	writer fifoMutex    // grants write transactions in FIFO order
+	refs   refCount     // open iterators and transactions
+
+	bloomBitsPerKey int
 }
 
 // OpenBoltDB creates and opens a database at the given path. If the file
@@ -25,7 +63,14 @@ type BoltDB struct {
 // Timeout is the amount of time to wait to obtain a file lock. When set
 // to zero it will wait indefinitely. This option is only available on
 // Darwin and Linux.
-func OpenBoltDB(path string, timeout time.Duration) (*BoltDB, error) {
+func OpenBoltDB(path string, timeout time.Duration, opts ...BoltOption) (*BoltDB, error) {
+	db := &BoltDB{}
+	for _, opt := range opts {
+		if err := opt(db); err != nil {
+			return nil, err
+		}
+	}
+
 	tree, err := bolt.Open(path, defaultOpenMode, &bolt.Options{
 		Timeout: timeout,
 	})
@@ -37,37 +82,108 @@ func OpenBoltDB(path string, timeout time.Duration) (*BoltDB, error) {
 		_, err = tx.CreateBucketIfNotExists(rootBucket)
 		return err
 	}); err != nil {
+		tree.Close()
 		return nil, errors.New("create root: " + err.Error())
 	}
 
-	return &BoltDB{tree: tree}, nil
+	if db.bloomBitsPerKey > 0 {
+		if err = tree.View(func(tx *bolt.Tx) error {
+			db.filter = buildBloomFilter(tx.Bucket(rootBucket), db.bloomBitsPerKey, 0)
+			return nil
+		}); err != nil {
+			tree.Close()
+			return nil, err
+		}
+	}
+
+	db.tree = tree
+	return db, nil
+}
+
+// buildBloomFilter returns a filter containing all keys of b.
+func buildBloomFilter(b *bolt.Bucket, bitsPerKey, capacity int) *bloomFilter {
+	if capacity == 0 {
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			capacity++
+		}
+	}
+	f := newBloomFilter(bitsPerKey, capacity)
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil; k, _ = c.Next() {
+		f.add(k)
+	}
+	return f
 }
 
 func (db *BoltDB) Iterator() (Iterator, error) {
-	tx, err := db.tree.Begin(false)
+	tx, err := db.begin(false)
 	if err != nil {
 		return nil, err
 	}
-	return &boltIterator{c: tx.Bucket(rootBucket).Cursor(), tx: tx}, nil
+	return &boltIterator{c: tx.Bucket(rootBucket).Cursor(), tx: tx, tree: db.tree, refs: &db.refs}, nil
 }
 
 func (db *BoltDB) Readonly() (Txn, error) {
-	tx, err := db.tree.Begin(false)
+	return db.readonly()
+}
+
+func (db *BoltDB) readonly() (*boltReadTxn, error) {
+	tx, err := db.begin(false)
+	if err != nil {
+		return nil, err
+	}
+	return &boltReadTxn{b: tx.Bucket(rootBucket), tx: tx, filter: db.filter, refs: &db.refs}, nil
+}
+
+// begin starts a bolt transaction holding a reference on the handle,
+// which must be released when the transaction ends.
+func (db *BoltDB) begin(writable bool) (*bolt.Tx, error) {
+	if err := db.refs.acquire(); err != nil {
+		return nil, err
+	}
+	tx, err := db.tree.Begin(writable)
 	if err != nil {
+		db.refs.release()
 		return nil, err
 	}
-	return &boltTxn{b: tx.Bucket(rootBucket), tx: tx}, nil
+	return tx, nil
 }
 
+// Writable starts a new write transaction. Blocked callers are granted
+// the transaction in the order they called Writable.
 func (db *BoltDB) Writable() (RWTxn, error) {
-	tx, err := db.tree.Begin(true)
+	db.writer.Lock()
+	tx, err := db.begin(true)
 	if err != nil {
+		db.writer.Unlock()
 		return nil, err
 	}
-	return &boltTxn{b: tx.Bucket(rootBucket), tx: tx}, nil
+	return &boltTxn{
+		boltReadTxn: boltReadTxn{b: tx.Bucket(rootBucket), tx: tx, filter: db.filter, refs: &db.refs},
+		writer:      &db.writer,
+		tree:        db.tree,
+	}, nil
+}
+
+// WriteQueueDepth returns the number of callers blocked in Writable.
+func (db *BoltDB) WriteQueueDepth() int {
+	return db.writer.waiting()
 }
 
-func (db *BoltDB) WriteTo(w io.Writer) (n int64, err error) {
+// WriteTo writes the entire database to w using the export format. See
+// Export for details.
+func (db *BoltDB) WriteTo(w io.Writer) (int64, error) {
+	return Export(w, db)
+}
+
+// WriteFileTo writes a consistent copy of the database file to w, which
+// can be opened with OpenBoltDB.
+func (db *BoltDB) WriteFileTo(w io.Writer) (n int64, err error) {
+	if err = db.refs.acquire(); err != nil {
+		return 0, err
+	}
+	defer db.refs.release()
 	err = db.tree.View(func(tx *bolt.Tx) (err error) {
 		n, err = tx.WriteTo(w)
 		return err
@@ -75,20 +191,97 @@ func (db *BoltDB) WriteTo(w io.Writer) (n int64, err error) {
 	return n, err
 }
 
+// GetRef returns the value stored for key without copying it. The value
+// references Bolt's memory map and is only valid until release is
+// called, which ends the underlying read transaction. Until then the
+// transaction keeps the referenced pages from being reused, so release
+// should be called as soon as the value is no longer needed. GetRef
+// returns ErrNotFound if the key does not exist.
+func (db *BoltDB) GetRef(key []byte) (value []byte, release func() error, err error) {
+	txn, err := db.readonly()
+	if err != nil {
+		return nil, nil, err
+	}
+	if value, err = txn.Get(key); err != nil {
+		txn.Rollback()
+		return nil, nil, err
+	}
+	return value, txn.Rollback, nil
+}
+
+// WriteValueTo writes the value stored for key to w directly from Bolt's
+// memory map and returns the number of bytes written. It returns
+// ErrNotFound if the key does not exist.
+func (db *BoltDB) WriteValueTo(w io.Writer, key []byte) (int64, error) {
+	value, release, err := db.GetRef(key)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	n, err := w.Write(value)
+	return int64(n), err
+}
+
+// GetValue returns a copy of the value stored for key that stays valid
+// after the read transaction ends. If the database was opened with
+// ReadPool the copy is taken from the pool and should be handed back with
+// Value.Release. GetValue returns ErrNotFound if the key does not exist.
+func (db *BoltDB) GetValue(key []byte) (*Value, error) {
+	value, release, err := db.GetRef(key)
+	if err != nil {
+		return nil, err
+	}
+	v := db.pool.clone(value)
+	if err = release(); err != nil {
+		v.Release()
+		return nil, err
+	}
+	return v, nil
+}
+
 func (db *BoltDB) Name() string { return "BoltDB" }
 
+// diagnostics adds the options, the freelist statistics and the page
+// usage of the root bucket.
+func (db *BoltDB) diagnostics(b *bundle) error {
+	if err := db.refs.acquire(); err != nil {
+		return err
+	}
+	defer db.refs.release()
+	var root bolt.BucketStats
+	if err := db.tree.View(func(tx *bolt.Tx) error {
+		root = tx.Bucket(rootBucket).Stats()
+		return nil
+	}); err != nil {
+		return err
+	}
+	return b.writeJSON("stats.json", struct {
+		Path            string
+		BloomBitsPerKey int
+		ReadPool        bool
+		DB              bolt.Stats
+		Root            bolt.BucketStats
+	}{db.tree.Path(), db.bloomBitsPerKey, db.pool != nil, db.tree.Stats(), root})
+}
+
 func (db *BoltDB) Close() error {
-	if db == nil || db.tree == nil {
+	if db == nil {
 		return errors.New("closing unopened BoltDB instance")
 	}
+	if err := db.refs.close(); err != nil {
+		return err
+	}
 	err := db.tree.Close()
 	db.tree = nil
 	return err
 }
 
 type boltIterator struct {
-	c  *bolt.Cursor
-	tx *bolt.Tx
+	c    *bolt.Cursor
+	tx   *bolt.Tx
+	tree *bolt.DB
+	refs *refCount
 }
 
 func (i *boltIterator) Seek(key []byte) ([]byte, []byte) {
@@ -123,7 +316,33 @@ func (i *boltIterator) Prev() ([]byte, []byte) {
 	if i == nil || i.tx == nil {
 		return nil, nil
 	}
-	return i.c.Next()
+	return i.c.Prev()
+}
+
+// SeekToIndex steps from the first key, BoltDB pages do not count the
+// keys below them.
+func (i *boltIterator) SeekToIndex(n int64) ([]byte, []byte) {
+	if i == nil || i.tx == nil {
+		return nil, nil
+	}
+	return walkToIndex(i, n)
+}
+
+func (i *boltIterator) Reset() error {
+	if i == nil || i.tx == nil {
+		return errors.New("reset closed iterator")
+	}
+	// The old view is released first: a commit growing the file waits
+	// for all read transactions, and Begin waits for that commit.
+	err := i.tx.Rollback()
+	tx, berr := i.tree.Begin(false)
+	if berr != nil {
+		i.tx = nil
+		i.refs.release()
+		return berr
+	}
+	i.c, i.tx = tx.Bucket(rootBucket).Cursor(), tx
+	return err
 }
 
 func (i *boltIterator) Close() error {
@@ -132,19 +351,52 @@ func (i *boltIterator) Close() error {
 	}
 	err := i.tx.Rollback()
 	i.tx = nil
+	i.refs.release()
 	return err
 }
 
+// boltReadTxn is a read-only transaction. Its write methods only exist
+// to reject writes after a type assertion to RWTxn.
+type boltReadTxn struct {
+	b      *bolt.Bucket
+	tx     *bolt.Tx
+	filter *bloomFilter
+	refs   *refCount
+}
+
+func (t *boltReadTxn) Put(key, value []byte) error { return ErrReadOnlyTxn }
+func (t *boltReadTxn) Delete(key []byte) error     { return ErrReadOnlyTxn }
+func (t *boltReadTxn) Commit() error               { return ErrReadOnlyTxn }
+
+// boltTxn is a write transaction. It holds the writer lock until it is
+// committed or rolled back.
 type boltTxn struct {
-	b  *bolt.Bucket
-	tx *bolt.Tx
+	boltReadTxn
+	writer *fifoMutex
+	tree   *bolt.DB
+}
+
+func (t *boltTxn) Rollback() error {
+	if t == nil || t.tx == nil {
+		return nil
+	}
+	defer t.writer.Unlock()
+	return t.boltReadTxn.Rollback()
 }
 
 func (t *boltTxn) Put(key, value []byte) error {
 	if t == nil || t.tx == nil {
 		return nil
 	}
-	return t.b.Put(key, value)
+	if err := t.b.Put(key, value); err != nil {
+		return err
+	}
+	// Keys are added before commit, a rollback only leaves a false
+	// positive behind.
+	if t.filter != nil {
+		t.filter.add(key)
+	}
+	return nil
 }
 
 func (t *boltTxn) Delete(key []byte) error {
@@ -154,10 +406,13 @@ func (t *boltTxn) Delete(key []byte) error {
 	return t.b.Delete(key)
 }
 
-func (t *boltTxn) Get(key []byte) ([]byte, error) {
+func (t *boltReadTxn) Get(key []byte) ([]byte, error) {
 	if t == nil || t.tx == nil {
 		return nil, nil
 	}
+	if t.filter != nil && !t.filter.mayContain(key) {
+		return nil, ErrNotFound
+	}
 	value := t.b.Get(key)
 	if value == nil {
 		return nil, ErrNotFound
@@ -165,12 +420,13 @@ func (t *boltTxn) Get(key []byte) ([]byte, error) {
 	return value, nil
 }
 
-func (t *boltTxn) Rollback() error {
+func (t *boltReadTxn) Rollback() error {
 	if t == nil || t.tx == nil {
 		return nil
 	}
 	err := t.tx.Rollback()
 	t.tx = nil
+	t.refs.release()
 	return err
 }
 
@@ -178,7 +434,27 @@ func (t *boltTxn) Commit() error {
 	if t == nil || t.tx == nil {
 		return nil
 	}
+	defer t.writer.Unlock()
 	err := t.tx.Commit()
 	t.tx = nil
+	t.refs.release()
+	if err == nil && t.filter != nil && t.filter.full() {
+		t.rebuildFilter()
+	}
 	return err
 }
+
+// rebuildFilter replaces the filter with one holding the committed keys.
+// It runs with the write lock held, so no concurrent Put can be lost, and
+// only if no iterator or transaction is open: an older snapshot may still
+// hold keys deleted since. Otherwise a later commit rebuilds the filter.
+func (t *boltTxn) rebuildFilter() {
+	if !t.refs.acquireIdle() {
+		return
+	}
+	defer t.refs.release()
+	t.tree.View(func(tx *bolt.Tx) error {
+		t.filter.replace(buildBloomFilter(tx.Bucket(rootBucket), t.filter.bitsPerKey, 0))
+		return nil
+	})
+}
@@ -0,0 +1,134 @@
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestBoltGetRef(t *testing.T) {
+	const path = "getref_boltdb.db"
+	db := openBoltDB(t, path)
+	defer closeBoltDB(t, path, db)
+
+	txn, err := db.Writable()
+	if err != nil {
+		t.Fatalf("begin writable transaction: %v", err)
+	}
+	value := bytes.Repeat([]byte("x"), 1<<16)
+	if err = txn.Put([]byte("large"), value); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if err = txn.Commit(); err != nil {
+		t.Fatalf("commit writable transaction: %v", err)
+	}
+
+	v, release, err := db.GetRef([]byte("large"))
+	if err != nil {
+		t.Fatalf("get ref: %v", err)
+	}
+	if !bytes.Equal(v, value) {
+		t.Fatalf("get ref: value mismatch")
+	}
+	if err = release(); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+
+	if _, _, err = db.GetRef([]byte("missing")); err != ErrNotFound {
+		t.Fatalf("get ref: expected ErrNotFound, got %v", err)
+	}
+
+	var buf bytes.Buffer
+	n, err := db.WriteValueTo(&buf, []byte("large"))
+	if err != nil {
+		t.Fatalf("write value: %v", err)
+	}
+	if n != int64(len(value)) || !bytes.Equal(buf.Bytes(), value) {
+		t.Fatalf("write value: expected %d bytes, got %d", len(value), n)
+	}
+}
+
+func TestBoltGetValue(t *testing.T) {
+	const path = "getvalue_boltdb.db"
+	db := openBoltDB(t, path, ReadPool(1024))
+	defer closeBoltDB(t, path, db)
+
+	txn, err := db.Writable()
+	if err != nil {
+		t.Fatalf("begin writable transaction: %v", err)
+	}
+	small, large := []byte("small"), bytes.Repeat([]byte("x"), 4096)
+	txn.Put([]byte("small"), small)
+	txn.Put([]byte("large"), large)
+	if err = txn.Commit(); err != nil {
+		t.Fatalf("commit writable transaction: %v", err)
+	}
+
+	for key, want := range map[string][]byte{"small": small, "large": large} {
+		for i := 0; i < 3; i++ {
+			v, err := db.GetValue([]byte(key))
+			if err != nil {
+				t.Fatalf("get value: %v", err)
+			}
+			if !bytes.Equal(v.Bytes(), want) {
+				t.Fatalf("get value: expected %d bytes, got %d", len(want), len(v.Bytes()))
+			}
+			v.Release()
+			v.Release()
+		}
+	}
+
+	if _, err = db.GetValue([]byte("missing")); err != ErrNotFound {
+		t.Fatalf("get value: expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestBoltBloomRebuild(t *testing.T) {
+	const path = "bloom_rebuild_boltdb.db"
+	db := openBoltDB(t, path, BloomFilter(10))
+	defer closeBoltDB(t, path, db)
+
+	put := func(keys ...string) {
+		t.Helper()
+		if err := Update(db, func(txn RWTxn) error {
+			for _, key := range keys {
+				if err := txn.Put([]byte(key), []byte("v")); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("update: %v", err)
+		}
+	}
+
+	for i := 0; i < 3*minBloomCapacity; i++ {
+		put("overwritten")
+	}
+	if db.filter.n != 1 || db.filter.capacity != minBloomCapacity {
+		t.Fatalf("overwrites: expected 1 key in unchanged filter, got %d of %d", db.filter.n, db.filter.capacity)
+	}
+
+	// a held reference stands in for a reader of an older snapshot
+	if err := db.refs.acquire(); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	keys := make([]string, 3*minBloomCapacity)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%05d", i)
+	}
+	put(keys...)
+	if db.filter.capacity != minBloomCapacity {
+		t.Fatalf("rebuilt filter while a reader was open")
+	}
+	db.refs.release()
+	put("last")
+	if db.filter.capacity != len(keys)+2 {
+		t.Fatalf("rebuild: expected filter sized for %d keys, got %d", len(keys)+2, db.filter.capacity)
+	}
+	for _, key := range append(keys, "overwritten", "last") {
+		if !db.filter.mayContain([]byte(key)) {
+			t.Fatalf("rebuilt filter misses %q", key)
+		}
+	}
+}
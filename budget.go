@@ -0,0 +1,192 @@
+package backend
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ErrDiskBudget is returned by writes of a BudgetDB whose disk usage
+// exceeds its budget.
+const ErrDiskBudget Error = Error("disk budget exceeded")
+
+// BudgetOption configures a BudgetDB.
+type BudgetOption func(*BudgetDB) error
+
+// BudgetCheckInterval sets how long a measured disk usage is reused
+// before the database files are measured again. The default is one
+// second, 0 measures on every write.
+func BudgetCheckInterval(d time.Duration) BudgetOption {
+	return func(b *BudgetDB) error {
+		if d < 0 {
+			return errors.New("negative budget check interval")
+		}
+		b.interval = d
+		return nil
+	}
+}
+
+// OnBudgetExceeded registers fn to be called when a write is refused
+// because usage exceeds limit. fn is called once per excess, and again
+// only after usage dropped below the limit in between.
+func OnBudgetExceeded(fn func(usage, limit int64)) BudgetOption {
+	return func(b *BudgetDB) error {
+		b.exceeded = fn
+		return nil
+	}
+}
+
+// BudgetDB wraps a DB and refuses writes once the database files would
+// grow beyond a limit. Usage is the size of the files below a path plus
+// the keys and values put by open transactions and by transactions
+// committed since the files were last measured; space that compactions
+// will reclaim or need is not estimated. Deletes are always allowed so
+// space can be freed.
+type BudgetDB struct {
+	DB
+
+	path     string
+	limit    int64
+	interval time.Duration
+	exceeded func(usage, limit int64)
+
+	mu       sync.Mutex
+	usage    int64 // measured plus committed bytes
+	pending  int64 // bytes put by open transactions
+	measured time.Time
+	over     bool // usage exceeded the limit on the last refused write
+}
+
+// NewBudgetDB returns a BudgetDB limiting the database files below path,
+// the database file or directory, to limit bytes.
+func NewBudgetDB(db DB, path string, limit int64, opts ...BudgetOption) (*BudgetDB, error) {
+	if limit <= 0 {
+		return nil, errors.New("disk budget must be positive")
+	}
+	b := &BudgetDB{DB: db, path: path, limit: limit, interval: time.Second}
+	for _, opt := range opts {
+		if err := opt(b); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// Usage returns the size of the database files, including bytes committed
+// since they were last measured.
+func (b *BudgetDB) Usage() (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.measure()
+}
+
+func (b *BudgetDB) measure() (int64, error) {
+	if !b.measured.IsZero() && time.Since(b.measured) < b.interval {
+		return b.usage, nil
+	}
+	usage, err := diskUsage(b.path)
+	if err != nil {
+		return 0, err
+	}
+	b.usage, b.measured = usage, time.Now()
+	return usage, nil
+}
+
+// reserve adds n bytes to the pending bytes if the database files plus
+// all pending bytes fit into the budget.
+func (b *BudgetDB) reserve(n int64) error {
+	b.mu.Lock()
+	usage, err := b.measure()
+	if err != nil {
+		b.mu.Unlock()
+		return err
+	}
+	usage += b.pending + n
+	if usage <= b.limit {
+		b.pending += n
+		b.over = false
+		b.mu.Unlock()
+		return nil
+	}
+	notify := !b.over && b.exceeded != nil
+	b.over = true
+	b.mu.Unlock()
+
+	if notify {
+		b.exceeded(usage, b.limit)
+	}
+	return ErrDiskBudget
+}
+
+// release removes n pending bytes. If they were committed they count as
+// usage until the database files are measured again.
+func (b *BudgetDB) release(n int64, committed bool) {
+	b.mu.Lock()
+	b.pending -= n
+	if committed {
+		b.usage += n
+	}
+	b.mu.Unlock()
+}
+
+// Writable starts a new write transaction whose puts fail with
+// ErrDiskBudget if they would exceed the budget.
+func (b *BudgetDB) Writable() (RWTxn, error) {
+	txn, err := b.DB.Writable()
+	if err != nil {
+		return nil, err
+	}
+	return &budgetTxn{RWTxn: txn, b: b}, nil
+}
+
+type budgetTxn struct {
+	RWTxn
+	b       *BudgetDB
+	pending int64 // bytes put by the transaction
+}
+
+func (t *budgetTxn) Put(key, value []byte) error {
+	n := int64(len(key) + len(value))
+	if err := t.b.reserve(n); err != nil {
+		return err
+	}
+	if err := t.RWTxn.Put(key, value); err != nil {
+		t.b.release(n, false)
+		return err
+	}
+	t.pending += n
+	return nil
+}
+
+func (t *budgetTxn) Commit() error {
+	err := t.RWTxn.Commit()
+	t.b.release(t.pending, err == nil)
+	t.pending = 0
+	return err
+}
+
+func (t *budgetTxn) Rollback() error {
+	t.b.release(t.pending, false)
+	t.pending = 0
+	return t.RWTxn.Rollback()
+}
+
+// diskUsage returns the size of the file at path or of all files below
+// it.
+func diskUsage(path string) (int64, error) {
+	usage := int64(0)
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		usage += info.Size()
+		return nil
+	})
+	return usage, err
+}
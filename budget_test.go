@@ -0,0 +1,142 @@
+package backend
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBudgetDB(t *testing.T) {
+	const path = "budget_boltdb.db"
+	db := openBoltDB(t, path)
+	defer closeBoltDB(t, path, db)
+
+	// measure a directory of known size instead of the database file
+	dir := t.TempDir()
+	resize := func(n int) {
+		if err := os.WriteFile(filepath.Join(dir, "data"), make([]byte, n), 0644); err != nil {
+			t.Fatalf("write data file: %v", err)
+		}
+	}
+	resize(50)
+
+	if _, err := NewBudgetDB(db, dir, 0); err == nil {
+		t.Fatalf("expected error for zero budget")
+	}
+	events := 0
+	b, err := NewBudgetDB(db, dir, 100, BudgetCheckInterval(0),
+		OnBudgetExceeded(func(usage, limit int64) { events++ }))
+	if err != nil {
+		t.Fatalf("new budget db: %v", err)
+	}
+	if n, err := b.Usage(); err != nil || n != 50 {
+		t.Fatalf("usage: expected 50, got %d, %v", n, err)
+	}
+
+	txn, err := b.Writable()
+	if err != nil {
+		t.Fatalf("begin writable transaction: %v", err)
+	}
+	if err = txn.Put([]byte("k1"), make([]byte, 28)); err != nil {
+		t.Fatalf("put within budget: %v", err)
+	}
+	if err = txn.Put([]byte("k2"), make([]byte, 28)); err != ErrDiskBudget {
+		t.Fatalf("put beyond budget: expected ErrDiskBudget, got %v", err)
+	}
+	if err = txn.Delete([]byte("k1")); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err = txn.Commit(); err != nil {
+		t.Fatalf("commit writable transaction: %v", err)
+	}
+
+	resize(200)
+	for i := 0; i < 2; i++ {
+		txn, err = b.Writable()
+		if err != nil {
+			t.Fatalf("begin writable transaction: %v", err)
+		}
+		if err = txn.Put([]byte("k"), nil); err != ErrDiskBudget {
+			t.Fatalf("put beyond budget: expected ErrDiskBudget, got %v", err)
+		}
+		txn.Rollback()
+	}
+	if events != 1 {
+		t.Fatalf("expected 1 budget event, got %d", events)
+	}
+
+	resize(10)
+	txn, err = b.Writable()
+	if err != nil {
+		t.Fatalf("begin writable transaction: %v", err)
+	}
+	if err = txn.Put([]byte("k"), nil); err != nil {
+		t.Fatalf("put within budget: %v", err)
+	}
+	if err = txn.Rollback(); err != nil {
+		t.Fatalf("rollback writable transaction: %v", err)
+	}
+
+	// exceeding the budget again fires a new event
+	resize(200)
+	if txn, err = b.Writable(); err != nil {
+		t.Fatalf("begin writable transaction: %v", err)
+	}
+	if err = txn.Put([]byte("k"), nil); err != ErrDiskBudget {
+		t.Fatalf("put beyond budget: expected ErrDiskBudget, got %v", err)
+	}
+	txn.Rollback()
+	if events != 2 {
+		t.Fatalf("expected 2 budget events, got %d", events)
+	}
+}
+
+func TestBudgetDBCommitted(t *testing.T) {
+	db := NewMemDB()
+	defer db.Close()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "data"), make([]byte, 50), 0644); err != nil {
+		t.Fatalf("write data file: %v", err)
+	}
+
+	// the files are measured once, committed bytes count until the next
+	// measurement
+	b, err := NewBudgetDB(db, dir, 100, BudgetCheckInterval(time.Hour))
+	if err != nil {
+		t.Fatalf("new budget db: %v", err)
+	}
+	for i, expected := range []error{nil, nil, ErrDiskBudget} {
+		txn, err := b.Writable()
+		if err != nil {
+			t.Fatalf("begin writable transaction: %v", err)
+		}
+		if err = txn.Put([]byte{byte(i)}, make([]byte, 19)); err != expected {
+			txn.Rollback()
+			t.Fatalf("put %d: expected %v, got %v", i, expected, err)
+		}
+		if err = txn.Commit(); err != nil {
+			t.Fatalf("commit writable transaction: %v", err)
+		}
+	}
+	if n, err := b.Usage(); err != nil || n != 90 {
+		t.Fatalf("usage: expected 90, got %d, %v", n, err)
+	}
+
+	// rolled back bytes are released
+	txn, err := b.Writable()
+	if err != nil {
+		t.Fatalf("begin writable transaction: %v", err)
+	}
+	if err = txn.Put([]byte("k"), make([]byte, 9)); err != nil {
+		t.Fatalf("put within budget: %v", err)
+	}
+	if err = txn.Rollback(); err != nil {
+		t.Fatalf("rollback writable transaction: %v", err)
+	}
+	if err = Update(b, func(txn RWTxn) error {
+		return txn.Put([]byte("k"), make([]byte, 9))
+	}); err != nil {
+		t.Fatalf("put within budget after rollback: %v", err)
+	}
+}
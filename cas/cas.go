@@ -0,0 +1,216 @@
+// Package cas implements a content-addressable blob store on top of a
+// backend.DB. Blobs are keyed by their SHA-256 hash and reference
+// counted, so identical content is stored once:
+//
+//	s := cas.New(db, []byte("blobs/"))
+//	hash, err := s.PutContent(data)
+//	...
+//	err = s.ReleaseContent(hash)
+//
+// Blobs whose count dropped to zero are kept until GC removes them, so
+// content that is released and added again, e.g. when a record is
+// rewritten, is not deleted and stored anew.
+package cas
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/mars9/backend"
+)
+
+// HashSize is the length of content hashes.
+const HashSize = sha256.Size
+
+// ErrNotReferenced is returned when releasing a hash whose reference
+// count is zero.
+var ErrNotReferenced = errors.New("cas: content not referenced")
+
+var (
+	errInvalidHash  = errors.New("cas: invalid hash")
+	errInvalidCount = errors.New("cas: invalid reference count")
+)
+
+// Store keeps blobs below prefix+"b", reference counts below prefix+"r"
+// and the time unreferenced blobs were released below prefix+"z", each
+// followed by the content hash.
+type Store struct {
+	db     backend.DB
+	blobs  []byte
+	counts []byte
+	zero   []byte
+}
+
+// New returns a Store keeping its data below prefix in db.
+func New(db backend.DB, prefix []byte) *Store {
+	key := func(c byte) []byte { return append(append([]byte{}, prefix...), c) }
+	return &Store{db: db, blobs: key('b'), counts: key('r'), zero: key('z')}
+}
+
+func (s *Store) key(prefix, hash []byte) []byte {
+	k := make([]byte, 0, len(prefix)+len(hash))
+	return append(append(k, prefix...), hash...)
+}
+
+func (s *Store) count(txn backend.Txn, hash []byte) (uint64, error) {
+	v, err := txn.Get(s.key(s.counts, hash))
+	if err == backend.ErrNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if len(v) != 8 {
+		return 0, errInvalidCount
+	}
+	return binary.BigEndian.Uint64(v), nil
+}
+
+func (s *Store) setCount(txn backend.RWTxn, hash []byte, n uint64) error {
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, n)
+	return txn.Put(s.key(s.counts, hash), v)
+}
+
+// Put stores value in txn, or adds a reference to it if it is stored
+// already, and returns its hash.
+func (s *Store) Put(txn backend.RWTxn, value []byte) ([]byte, error) {
+	sum := sha256.Sum256(value)
+	hash := sum[:]
+	n, err := s.count(txn, hash)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		if _, err = txn.Get(s.key(s.blobs, hash)); err == backend.ErrNotFound {
+			err = txn.Put(s.key(s.blobs, hash), value)
+		} else if err == nil {
+			// released but not collected yet
+			err = txn.Delete(s.key(s.zero, hash))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err = s.setCount(txn, hash, n+1); err != nil {
+		return nil, err
+	}
+	return hash, nil
+}
+
+// Get returns the content stored under hash in txn, or
+// backend.ErrNotFound.
+func (s *Store) Get(txn backend.Txn, hash []byte) ([]byte, error) {
+	if len(hash) != HashSize {
+		return nil, errInvalidHash
+	}
+	if n, err := s.count(txn, hash); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, backend.ErrNotFound
+	}
+	return txn.Get(s.key(s.blobs, hash))
+}
+
+// Release removes a reference to hash in txn. Once the last reference is
+// released the content can no longer be read and is deleted by the next
+// GC.
+func (s *Store) Release(txn backend.RWTxn, hash []byte) error {
+	if len(hash) != HashSize {
+		return errInvalidHash
+	}
+	n, err := s.count(txn, hash)
+	if err != nil {
+		return err
+	}
+	switch n {
+	case 0:
+		return ErrNotReferenced
+	case 1:
+		if err = txn.Delete(s.key(s.counts, hash)); err != nil {
+			return err
+		}
+		v := make([]byte, 8)
+		binary.BigEndian.PutUint64(v, uint64(time.Now().UnixNano()))
+		return txn.Put(s.key(s.zero, hash), v)
+	}
+	return s.setCount(txn, hash, n-1)
+}
+
+// PutContent stores value in its own transaction and returns its hash.
+func (s *Store) PutContent(value []byte) (hash []byte, err error) {
+	err = backend.Update(s.db, func(txn backend.RWTxn) error {
+		hash, err = s.Put(txn, value)
+		return err
+	})
+	return hash, err
+}
+
+// GetContent returns a copy of the content stored under hash, or
+// backend.ErrNotFound.
+func (s *Store) GetContent(hash []byte) (value []byte, err error) {
+	err = backend.View(s.db, func(txn backend.Txn) error {
+		v, err := s.Get(txn, hash)
+		value = append([]byte{}, v...)
+		return err
+	})
+	return value, err
+}
+
+// ReleaseContent removes a reference to hash in its own transaction.
+func (s *Store) ReleaseContent(hash []byte) error {
+	return backend.Update(s.db, func(txn backend.RWTxn) error {
+		return s.Release(txn, hash)
+	})
+}
+
+// Refs returns the number of references to hash.
+func (s *Store) Refs(hash []byte) (n uint64, err error) {
+	err = backend.View(s.db, func(txn backend.Txn) error {
+		n, err = s.count(txn, hash)
+		return err
+	})
+	return n, err
+}
+
+// GC deletes the content released for longer than grace and returns the
+// number of deleted blobs.
+func (s *Store) GC(grace time.Duration) (int, error) {
+	deadline := uint64(time.Now().Add(-grace).UnixNano())
+	pairs, err := backend.Scan(s.db, s.zero)
+	if err != nil {
+		return 0, err
+	}
+	var hashes [][]byte
+	for _, p := range pairs {
+		if len(p.Value) == 8 && binary.BigEndian.Uint64(p.Value) <= deadline {
+			hashes = append(hashes, p.Key[len(s.zero):])
+		}
+	}
+	if len(hashes) == 0 {
+		return 0, nil
+	}
+
+	n := 0
+	err = backend.Update(s.db, func(txn backend.RWTxn) error {
+		n = 0
+		for _, hash := range hashes {
+			// the content may have been added again meanwhile
+			if _, err := txn.Get(s.key(s.zero, hash)); err == backend.ErrNotFound {
+				continue
+			} else if err != nil {
+				return err
+			}
+			if err := txn.Delete(s.key(s.zero, hash)); err != nil {
+				return err
+			}
+			if err := txn.Delete(s.key(s.blobs, hash)); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,72 @@
+package cas
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mars9/backend"
+)
+
+func TestStore(t *testing.T) {
+	const path = "cas_test.db"
+	db, err := backend.OpenBoltDB(path, 0)
+	if err != nil {
+		t.Fatalf("opening BoltDB %q: %v", path, err)
+	}
+	defer os.Remove(path)
+	defer db.Close()
+
+	s := New(db, []byte("cas/"))
+	blob := bytes.Repeat([]byte("blob"), 100)
+	hash, err := s.PutContent(blob)
+	if err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if hash2, err := s.PutContent(blob); err != nil || !bytes.Equal(hash, hash2) {
+		t.Fatalf("put duplicate: expected hash %x, got %x, %v", hash, hash2, err)
+	}
+	if n, err := s.Refs(hash); err != nil || n != 2 {
+		t.Fatalf("refs: expected 2, got %d, %v", n, err)
+	}
+	if v, err := s.GetContent(hash); err != nil || !bytes.Equal(v, blob) {
+		t.Fatalf("get: expected blob, got %q, %v", v, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err = s.ReleaseContent(hash); err != nil {
+			t.Fatalf("release: %v", err)
+		}
+	}
+	if err = s.ReleaseContent(hash); err != ErrNotReferenced {
+		t.Fatalf("release unreferenced: expected ErrNotReferenced, got %v", err)
+	}
+	if _, err = s.GetContent(hash); err != backend.ErrNotFound {
+		t.Fatalf("get released: expected ErrNotFound, got %v", err)
+	}
+
+	// released content within the grace period is revived by a put
+	if n, err := s.GC(time.Hour); err != nil || n != 0 {
+		t.Fatalf("gc: expected 0 blobs, got %d, %v", n, err)
+	}
+	if _, err = s.PutContent(blob); err != nil {
+		t.Fatalf("put released: %v", err)
+	}
+	if n, err := s.GC(0); err != nil || n != 0 {
+		t.Fatalf("gc: expected 0 blobs, got %d, %v", n, err)
+	}
+	if err = s.ReleaseContent(hash); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	if n, err := s.GC(0); err != nil || n != 1 {
+		t.Fatalf("gc: expected 1 blob, got %d, %v", n, err)
+	}
+	if pairs, err := backend.Scan(db, []byte("cas/")); err != nil || len(pairs) != 0 {
+		t.Fatalf("gc: expected no keys left, got %d, %v", len(pairs), err)
+	}
+
+	if _, err = s.GetContent([]byte("short")); err != errInvalidHash {
+		t.Fatalf("get invalid hash: expected errInvalidHash, got %v", err)
+	}
+}
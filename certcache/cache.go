@@ -0,0 +1,125 @@
+// Package certcache implements an acme/autocert.Cache that keeps
+// certificates and account keys encrypted in a backend.DB.
+package certcache
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+
+	"github.com/mars9/backend"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+var _ autocert.Cache = (*Cache)(nil)
+
+// ErrDecrypt is returned when a stored entry cannot be decrypted, either
+// because it was encrypted with a different key or it was tampered with.
+var ErrDecrypt = errors.New("certcache: decrypt entry")
+
+// Cache stores autocert data below a key prefix. Entries are encrypted
+// with AES-GCM; the entry name is authenticated as additional data so
+// ciphertexts cannot be swapped between names.
+type Cache struct {
+	db     backend.DB
+	prefix []byte
+	aead   cipher.AEAD
+}
+
+// New returns a Cache storing entries below prefix in db. The key must be
+// 16, 24 or 32 bytes long to select AES-128, AES-192 or AES-256.
+// Encryption is mandatory, there is no way to store entries in plain
+// text.
+func New(db backend.DB, prefix []byte, key []byte) (*Cache, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cache{db: db, prefix: append([]byte{}, prefix...), aead: aead}, nil
+}
+
+func (c *Cache) key(name string) []byte {
+	key := make([]byte, 0, len(c.prefix)+len(name))
+	return append(append(key, c.prefix...), name...)
+}
+
+// Get returns the decrypted data stored for name. It returns
+// autocert.ErrCacheMiss if no entry exists.
+func (c *Cache) Get(ctx context.Context, name string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	txn, err := c.db.Readonly()
+	if err != nil {
+		return nil, err
+	}
+	defer txn.Rollback()
+
+	key := c.key(name)
+	v, err := txn.Get(key)
+	if err == backend.ErrNotFound {
+		return nil, autocert.ErrCacheMiss
+	} else if err != nil {
+		return nil, err
+	}
+
+	n := c.aead.NonceSize()
+	if len(v) < n {
+		return nil, ErrDecrypt
+	}
+	data, err := c.aead.Open(nil, v[:n], v[n:], key)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return data, nil
+}
+
+// Put encrypts data and stores it under name.
+func (c *Cache) Put(ctx context.Context, name string, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	key := c.key(name)
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(data)+c.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	value := c.aead.Seal(nonce, nonce, data, key)
+
+	txn, err := c.db.Writable()
+	if err != nil {
+		return err
+	}
+	if err = txn.Put(key, value); err != nil {
+		txn.Rollback()
+		return err
+	}
+	return txn.Commit()
+}
+
+// Delete removes the entry stored under name. Deleting a missing entry
+// is not an error.
+func (c *Cache) Delete(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	txn, err := c.db.Writable()
+	if err != nil {
+		return err
+	}
+	if err = txn.Delete(c.key(name)); err != nil {
+		txn.Rollback()
+		return err
+	}
+	return txn.Commit()
+}
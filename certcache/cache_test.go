@@ -0,0 +1,74 @@
+package certcache
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/mars9/backend"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+func TestCache(t *testing.T) {
+	const path = "certcache_test.db"
+	db, err := backend.OpenBoltDB(path, 0)
+	if err != nil {
+		t.Fatalf("opening BoltDB %q: %v", path, err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(path)
+	}()
+
+	ctx := context.Background()
+	cache, err := New(db, []byte("certs/"), bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatalf("new cache: %v", err)
+	}
+
+	if _, err = cache.Get(ctx, "example.com"); err != autocert.ErrCacheMiss {
+		t.Fatalf("get: expected ErrCacheMiss, got %v", err)
+	}
+
+	cert := []byte("-----BEGIN CERTIFICATE-----")
+	if err = cache.Put(ctx, "example.com", cert); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	data, err := cache.Get(ctx, "example.com")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if !bytes.Equal(data, cert) {
+		t.Fatalf("get: expected %q, got %q", cert, data)
+	}
+
+	// stored value must not contain the plain text
+	txn, err := db.Readonly()
+	if err != nil {
+		t.Fatalf("begin readonly transaction: %v", err)
+	}
+	raw, err := txn.Get([]byte("certs/example.com"))
+	if err != nil {
+		t.Fatalf("get raw: %v", err)
+	}
+	if bytes.Contains(raw, cert) {
+		t.Fatalf("stored value is not encrypted")
+	}
+	txn.Rollback()
+
+	other, err := New(db, []byte("certs/"), bytes.Repeat([]byte{2}, 32))
+	if err != nil {
+		t.Fatalf("new cache: %v", err)
+	}
+	if _, err = other.Get(ctx, "example.com"); err != ErrDecrypt {
+		t.Fatalf("get with wrong key: expected ErrDecrypt, got %v", err)
+	}
+
+	if err = cache.Delete(ctx, "example.com"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err = cache.Get(ctx, "example.com"); err != autocert.ErrCacheMiss {
+		t.Fatalf("get after delete: expected ErrCacheMiss, got %v", err)
+	}
+}
@@ -0,0 +1,35 @@
+package backend
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// Checksum returns a SHA-256 hash over all key/value pairs whose key
+// starts with prefix, read from a single iterator view of db. Pairs are
+// hashed in key order with their lengths, so two ranges have the same
+// checksum only if they hold the same pairs, regardless of backend.
+func Checksum(db DB, prefix []byte) ([32]byte, error) {
+	var sum [32]byte
+	iter, err := db.Iterator()
+	if err != nil {
+		return sum, err
+	}
+	defer iter.Close()
+
+	h := sha256.New()
+	var buf [2 * binary.MaxVarintLen64]byte
+	for k, v := iter.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = iter.Next() {
+		n := binary.PutUvarint(buf[:], uint64(len(k)))
+		n += binary.PutUvarint(buf[n:], uint64(len(v)))
+		h.Write(buf[:n])
+		h.Write(k)
+		h.Write(v)
+	}
+	if err = iter.Close(); err != nil {
+		return sum, err
+	}
+	h.Sum(sum[:0])
+	return sum, nil
+}
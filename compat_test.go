@@ -2,10 +2,16 @@ package backend
 
 import (
 	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/ed25519"
 	"fmt"
+	"io"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 var (
@@ -65,7 +71,7 @@ func testBasic(t *testing.T, backend ...DB) {
 			t.Fatalf("%s: get: expected ErrNotFound, got %v", db.Name(), err)
 		}
 		if val != nil {
-			t.Fatalf("%s: get: expected <nil> value, got %q", db.Name, val)
+			t.Fatalf("%s: get: expected <nil> value, got %q", db.Name(), val)
 		}
 		if err = rtxn.Rollback(); err != nil {
 			t.Fatalf("%s: rollback readonly transaction: %v", db.Name(), err)
@@ -158,6 +164,9 @@ func testBasicIterator(t *testing.T, backend ...DB) {
 			}
 			i--
 		}
+		if i != -1 {
+			t.Fatalf("%s: descending iterator: %d keys not visited", db.Name(), i+1)
+		}
 
 		i = compatPairLength / 2
 		k, v := iter.Seek(compatKeys[i])
@@ -169,6 +178,9 @@ func testBasicIterator(t *testing.T, backend ...DB) {
 		if bytes.Compare(k, key) != 0 {
 			t.Fatalf("%s: seek: expected key %q, got %q", db.Name(), key, k)
 		}
+		if k, _ = iter.Prev(); !bytes.Equal(k, compatKeys[i-1]) {
+			t.Fatalf("%s: prev after seek: expected key %q, got %q", db.Name(), compatKeys[i-1], k)
+		}
 
 		k, v = iter.Seek([]byte("xxx"))
 		if v != nil {
@@ -184,21 +196,805 @@ func testBasicIterator(t *testing.T, backend ...DB) {
 	}
 }
 
+func testExport(t *testing.T, backend ...DB) {
+	for _, db := range backend {
+		for _, opts := range [][]ExportOption{
+			nil,
+			{ExportBlockSize(64)},
+			{ExportBlockSize(256), Compress(flate.BestSpeed)},
+		} {
+			var buf bytes.Buffer
+			n, err := Export(&buf, db, opts...)
+			if err != nil {
+				t.Fatalf("%s: export: %v", db.Name(), err)
+			}
+			if n != int64(buf.Len()) {
+				t.Fatalf("%s: export: expected %d bytes written, got %d", db.Name(), buf.Len(), n)
+			}
+
+			path := "compatibility_export.db"
+			dst := openBoltDB(t, path)
+			if err = Import(dst, &buf); err != nil {
+				closeBoltDB(t, path, dst)
+				t.Fatalf("%s: import: %v", db.Name(), err)
+			}
+			testBasicIterator(t, dst)
+			closeBoltDB(t, path, dst)
+		}
+
+		// every backend writes the export format
+		var buf bytes.Buffer
+		if _, err := db.WriteTo(&buf); err != nil {
+			t.Fatalf("%s: write to: %v", db.Name(), err)
+		}
+		path := "compatibility_export.db"
+		dst := openBoltDB(t, path)
+		if err := Import(dst, &buf); err != nil {
+			closeBoltDB(t, path, dst)
+			t.Fatalf("%s: import written database: %v", db.Name(), err)
+		}
+		testBasicIterator(t, dst)
+		closeBoltDB(t, path, dst)
+
+		buf.Reset()
+		if _, err := ExportFunc(&buf, db, func(k, v []byte) bool {
+			return bytes.HasSuffix(k, []byte("7"))
+		}, ExportBlockSize(32)); err != nil {
+			t.Fatalf("%s: export func: %v", db.Name(), err)
+		}
+		dst = openBoltDB(t, path)
+		if err := Import(dst, &buf); err != nil {
+			closeBoltDB(t, path, dst)
+			t.Fatalf("%s: import: %v", db.Name(), err)
+		}
+		pairs, err := Scan(dst, nil)
+		closeBoltDB(t, path, dst)
+		if err != nil {
+			t.Fatalf("%s: scan: %v", db.Name(), err)
+		}
+		if len(pairs) != 10 || string(pairs[0].Key) != "key007" || string(pairs[9].Value) != "val097" {
+			t.Fatalf("%s: export func: expected key007..key097, got %d pairs", db.Name(), len(pairs))
+		}
+
+		pub, priv, err := ed25519.GenerateKey(nil)
+		if err != nil {
+			t.Fatalf("generate key: %v", err)
+		}
+		buf.Reset()
+		if _, err = Export(&buf, db, SignExport(priv), ExportBlockSize(128)); err != nil {
+			t.Fatalf("%s: signed export: %v", db.Name(), err)
+		}
+		signed := buf.Bytes()
+		tampered := append([]byte{}, signed...)
+		tampered[len(tampered)/2] ^= 1
+		other, _, _ := ed25519.GenerateKey(nil)
+		var unsigned bytes.Buffer
+		if _, err = Export(&unsigned, db); err != nil {
+			t.Fatalf("%s: export: %v", db.Name(), err)
+		}
+		for _, tc := range []struct {
+			data []byte
+			pub  ed25519.PublicKey
+		}{{tampered, pub}, {signed, other}, {unsigned.Bytes(), pub}, {signed[:10], pub}} {
+			dst := openBoltDB(t, path)
+			err = ImportVerified(dst, bytes.NewReader(tc.data), tc.pub)
+			closeBoltDB(t, path, dst)
+			if err != ErrInvalidSignature {
+				t.Fatalf("%s: import verified: expected ErrInvalidSignature, got %v", db.Name(), err)
+			}
+		}
+		for _, verify := range []bool{true, false} {
+			dst := openBoltDB(t, path)
+			if verify {
+				err = ImportVerified(dst, bytes.NewReader(signed), pub)
+			} else {
+				err = Import(dst, bytes.NewReader(signed))
+			}
+			if err != nil {
+				closeBoltDB(t, path, dst)
+				t.Fatalf("%s: import signed export: %v", db.Name(), err)
+			}
+			testBasicIterator(t, dst)
+			closeBoltDB(t, path, dst)
+		}
+
+		buf.Reset()
+		_, err = PublishSnapshot(&buf, db, priv, func(k, v []byte) bool {
+			return bytes.HasSuffix(k, []byte("5"))
+		}, func(k []byte) []byte {
+			return bytes.TrimPrefix(k, []byte("key"))
+		})
+		if err != nil {
+			t.Fatalf("%s: publish snapshot: %v", db.Name(), err)
+		}
+		dst = openBoltDB(t, path)
+		if err = ImportVerified(dst, &buf, pub); err != nil {
+			closeBoltDB(t, path, dst)
+			t.Fatalf("%s: import snapshot: %v", db.Name(), err)
+		}
+		pairs, err = Scan(dst, nil)
+		closeBoltDB(t, path, dst)
+		if err != nil {
+			t.Fatalf("%s: scan: %v", db.Name(), err)
+		}
+		if len(pairs) != 10 || string(pairs[0].Key) != "005" || string(pairs[9].Value) != "val095" {
+			t.Fatalf("%s: publish snapshot: expected 005..095, got %d pairs", db.Name(), len(pairs))
+		}
+		_, err = PublishSnapshot(io.Discard, db, priv, nil, func(k []byte) []byte {
+			return []byte{byte(len(k) % 2)}
+		})
+		if err != errRekeyOrder {
+			t.Fatalf("%s: publish snapshot: expected errRekeyOrder, got %v", db.Name(), err)
+		}
+
+		if err := Import(db, bytes.NewReader([]byte("BKX\x01"))); err != ErrInvalidExport {
+			t.Fatalf("%s: import: expected ErrInvalidExport, got %v", db.Name(), err)
+		}
+	}
+}
+
+func testTextDump(t *testing.T, backend ...DB) {
+	for _, db := range backend {
+		var buf bytes.Buffer
+		if _, err := DumpText(&buf, db); err != nil {
+			t.Fatalf("%s: dump text: %v", db.Name(), err)
+		}
+		line, err := buf.ReadString('\n')
+		if err != nil {
+			t.Fatalf("%s: dump text: %v", db.Name(), err)
+		}
+		if want := "0x6B6579303030 ==> 0x76616C303030\n"; line != want {
+			t.Fatalf("%s: dump text: expected line %q, got %q", db.Name(), want, line)
+		}
+
+		path := "compatibility_textdump.db"
+		dst := openBoltDB(t, path)
+		if err = LoadText(dst, io.MultiReader(strings.NewReader(line), &buf)); err != nil {
+			closeBoltDB(t, path, dst)
+			t.Fatalf("%s: load text: %v", db.Name(), err)
+		}
+		testBasicIterator(t, dst)
+		closeBoltDB(t, path, dst)
+	}
+}
+
+func testIteratorReset(t *testing.T, backend ...DB) {
+	for _, db := range backend {
+		iter, err := db.Iterator()
+		if err != nil {
+			t.Fatalf("%s: next iterator: %v", db.Name(), err)
+		}
+
+		key := []byte("key100")
+		for _, put := range []bool{true, false} {
+			// Commit in another goroutine: a Bolt commit growing the file
+			// waits until the iterator releases its view.
+			done := make(chan error, 1)
+			go func(put bool) {
+				done <- Update(db, func(txn RWTxn) error {
+					if put {
+						return txn.Put(key, []byte("val100"))
+					}
+					return txn.Delete(key)
+				})
+			}(put)
+
+			if k, _ := iter.Last(); bytes.Equal(k, key) == put {
+				t.Fatalf("%s: iterator observed commit before reset", db.Name())
+			}
+			for committed := false; ; {
+				if err = iter.Reset(); err != nil {
+					t.Fatalf("%s: reset iterator: %v", db.Name(), err)
+				}
+				if committed {
+					break
+				}
+				select {
+				case err = <-done:
+					if err != nil {
+						t.Fatalf("%s: modify key %q: %v", db.Name(), key, err)
+					}
+					committed = true
+				case <-time.After(time.Millisecond):
+				}
+			}
+			if k, _ := iter.Last(); bytes.Equal(k, key) != put {
+				t.Fatalf("%s: reset iterator: expected last key %q present %v, got %q", db.Name(), key, put, k)
+			}
+		}
+
+		if err = iter.Close(); err != nil {
+			t.Fatalf("%s: closing iterator: %v", db.Name(), err)
+		}
+		if err = iter.Reset(); err == nil {
+			t.Fatalf("%s: reset closed iterator: expected error", db.Name())
+		}
+	}
+}
+
+func testScan(t *testing.T, backend ...DB) {
+	tests := []struct {
+		prefix []byte
+		opts   []ScanOption
+		first  int
+		n      int
+	}{
+		{[]byte("key09"), nil, 90, 10},
+		{[]byte("key"), []ScanOption{Limit(5)}, 0, 5},
+		{[]byte("key05"), []ScanOption{StopAfterBytes(30)}, 50, 2},
+		{[]byte("key05"), []ScanOption{StopAfterBytes(1)}, 50, 1},
+		{[]byte("key"), []ScanOption{Limit(5), StopAfterBytes(36)}, 0, 3},
+		{[]byte("nokey"), []ScanOption{Limit(5)}, 0, 0},
+	}
+
+	for _, db := range backend {
+		for _, test := range tests {
+			pairs, err := Scan(db, test.prefix, test.opts...)
+			if err != nil {
+				t.Fatalf("%s: scan %q: %v", db.Name(), test.prefix, err)
+			}
+			if len(pairs) != test.n {
+				t.Fatalf("%s: scan %q: expected %d pairs, got %d", db.Name(), test.prefix, test.n, len(pairs))
+			}
+			for i, kv := range pairs {
+				if !bytes.Equal(kv.Key, compatKeys[test.first+i]) || !bytes.Equal(kv.Value, compatValues[test.first+i]) {
+					t.Fatalf("%s: scan %q: unexpected pair %q/%q", db.Name(), test.prefix, kv.Key, kv.Value)
+				}
+			}
+		}
+
+		// project "valNNN" to "NNN" and skip odd values
+		project := func(key, value []byte) ([]byte, error) {
+			if (value[len(value)-1]-'0')%2 == 1 {
+				return nil, nil
+			}
+			return value[3:], nil
+		}
+		pairs, err := Scan(db, []byte("key01"), Project(project), StopAfterBytes(27))
+		if err != nil {
+			t.Fatalf("%s: projected scan: %v", db.Name(), err)
+		}
+		if len(pairs) != 3 {
+			t.Fatalf("%s: projected scan: expected 3 pairs, got %d", db.Name(), len(pairs))
+		}
+		for i, kv := range pairs {
+			if !bytes.Equal(kv.Key, compatKeys[10+2*i]) || !bytes.Equal(kv.Value, compatValues[10+2*i][3:]) {
+				t.Fatalf("%s: projected scan: unexpected pair %q/%q", db.Name(), kv.Key, kv.Value)
+			}
+		}
+
+		plan, err := ExplainScan(db, []byte("key09"), Limit(5), Project(project))
+		if err != nil {
+			t.Fatalf("%s: explain scan: %v", db.Name(), err)
+		}
+		if string(plan.Limit) != "key0:" || plan.MaxPairs != 5 || !plan.Projected {
+			t.Fatalf("%s: explain scan: unexpected plan %+v", db.Name(), plan)
+		}
+		if _, ok := db.(sizeEstimator); ok != (plan.EstimatedBytes >= 0) {
+			t.Fatalf("%s: explain scan: unexpected size estimate %d", db.Name(), plan.EstimatedBytes)
+		}
+		if prefixed, err := Scan(db, []byte("key09")); err != nil || plan.EstimatedKeys != int64(len(prefixed)) {
+			t.Fatalf("%s: explain scan: expected %d keys, got %d, %v", db.Name(), len(prefixed), plan.EstimatedKeys, err)
+		}
+		if plan, err = ExplainScan(db, []byte{0xff}); err != nil || plan.Limit != nil {
+			t.Fatalf("%s: explain unbounded scan: unexpected plan %+v, %v", db.Name(), plan, err)
+		}
+
+		if _, err := Scan(db, nil, Limit(0)); err == nil {
+			t.Fatalf("%s: scan with zero limit: expected error", db.Name())
+		}
+	}
+}
+
+func testPatchJSON(t *testing.T, backend ...DB) {
+	tests := []struct {
+		patch    string
+		expected string
+	}{
+		{`{"a":"b","c":{"d":"e","f":"g"}}`, `{"a":"b","c":{"d":"e","f":"g"}}`},
+		{`{"a":"z","c":{"f":null},"n":null}`, `{"a":"z","c":{"d":"e"}}`},
+		{`{"a":[1, 2],"c":{"d":{"x":1}}}`, `{"a":[1,2],"c":{"d":{"x":1}}}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"c"},"c":{"d":{"x":1}}}`},
+		{`["a"]`, `["a"]`},
+		{`{"a":"b"}`, `{"a":"b"}`},
+	}
+
+	key := []byte("json")
+	for _, db := range backend {
+		for _, test := range tests {
+			txn, err := db.Writable()
+			if err != nil {
+				t.Fatalf("%s: begin writable transaction: %v", db.Name(), err)
+			}
+			if err = PatchJSON(txn, key, []byte(test.patch)); err != nil {
+				t.Fatalf("%s: patch %s: %v", db.Name(), test.patch, err)
+			}
+			if err = txn.Commit(); err != nil {
+				t.Fatalf("%s: commit writable transaction: %v", db.Name(), err)
+			}
+
+			txn1, err := db.Readonly()
+			if err != nil {
+				t.Fatalf("%s: begin read-only transaction: %v", db.Name(), err)
+			}
+			v, err := txn1.Get(key)
+			if err != nil {
+				t.Fatalf("%s: get key %q: %v", db.Name(), key, err)
+			}
+			if string(v) != test.expected {
+				t.Fatalf("%s: patch %s: expected %s, got %s", db.Name(), test.patch, test.expected, v)
+			}
+			if err = txn1.Rollback(); err != nil {
+				t.Fatalf("%s: rollback read-only transaction: %v", db.Name(), err)
+			}
+		}
+
+		txn, err := db.Writable()
+		if err != nil {
+			t.Fatalf("%s: begin writable transaction: %v", db.Name(), err)
+		}
+		if err = PatchJSON(txn, key, []byte(`{"a":`)); err != ErrInvalidJSON {
+			t.Fatalf("%s: invalid patch: expected ErrInvalidJSON, got %v", db.Name(), err)
+		}
+		if err = txn.Delete(key); err != nil {
+			t.Fatalf("%s: delete key %q: %v", db.Name(), key, err)
+		}
+		if err = txn.Commit(); err != nil {
+			t.Fatalf("%s: commit writable transaction: %v", db.Name(), err)
+		}
+	}
+}
+
+func testChecksum(t *testing.T, backend ...DB) {
+	update := func(db DB, put bool) {
+		txn, err := db.Writable()
+		if err != nil {
+			t.Fatalf("%s: begin writable transaction: %v", db.Name(), err)
+		}
+		for i, key := range compatKeys {
+			key = append([]byte("sum/"), key...)
+			if put {
+				err = txn.Put(key, compatValues[i])
+			} else {
+				err = txn.Delete(key)
+			}
+			if err != nil {
+				t.Fatalf("%s: modify key %q: %v", db.Name(), key, err)
+			}
+		}
+		if err = txn.Commit(); err != nil {
+			t.Fatalf("%s: commit writable transaction: %v", db.Name(), err)
+		}
+	}
+
+	var sums [][32]byte
+	for _, db := range backend {
+		update(db, true)
+		sum, err := Checksum(db, []byte("sum/"))
+		if err != nil {
+			t.Fatalf("%s: checksum: %v", db.Name(), err)
+		}
+		sums = append(sums, sum)
+
+		part, err := Checksum(db, []byte("sum/key09"))
+		if err != nil {
+			t.Fatalf("%s: checksum: %v", db.Name(), err)
+		}
+		if part == sum {
+			t.Fatalf("%s: checksum: expected different sums for different ranges", db.Name())
+		}
+		update(db, false)
+	}
+	for i := 1; i < len(sums); i++ {
+		if sums[i] != sums[0] {
+			t.Fatalf("%s: checksum: mismatch with %s", backend[i].Name(), backend[0].Name())
+		}
+	}
+}
+
+func testReadOnlyTxn(t *testing.T, backend ...DB) {
+	for _, db := range backend {
+		txn, err := db.Readonly()
+		if err != nil {
+			t.Fatalf("%s: begin read-only transaction: %v", db.Name(), err)
+		}
+		if rw, ok := txn.(RWTxn); ok {
+			if err = rw.Put([]byte("key"), []byte("value")); err != ErrReadOnlyTxn {
+				t.Fatalf("%s: put in read-only transaction: expected ErrReadOnlyTxn, got %v", db.Name(), err)
+			}
+			if err = rw.Delete(compatKeys[0]); err != ErrReadOnlyTxn {
+				t.Fatalf("%s: delete in read-only transaction: expected ErrReadOnlyTxn, got %v", db.Name(), err)
+			}
+			if err = rw.Commit(); err != ErrReadOnlyTxn {
+				t.Fatalf("%s: commit read-only transaction: expected ErrReadOnlyTxn, got %v", db.Name(), err)
+			}
+		}
+		if err = txn.Rollback(); err != nil {
+			t.Fatalf("%s: rollback read-only transaction: %v", db.Name(), err)
+		}
+	}
+}
+
+func testReadOnlyView(t *testing.T, backend ...DB) {
+	for _, db := range backend {
+		view := NewReadOnlyView(db)
+		if _, err := view.Writable(); err != ErrReadOnly {
+			t.Fatalf("%s: writable on read-only view: expected ErrReadOnly, got %v", db.Name(), err)
+		}
+		if err := Update(view, func(RWTxn) error { return nil }); err != ErrReadOnly {
+			t.Fatalf("%s: update on read-only view: expected ErrReadOnly, got %v", db.Name(), err)
+		}
+		testReadOnlyTxn(t, view)
+		if err := View(view, func(txn Txn) error {
+			_, err := txn.Get(compatKeys[0])
+			return err
+		}); err != nil {
+			t.Fatalf("%s: get on read-only view: %v", db.Name(), err)
+		}
+		if err := view.Close(); err != nil {
+			t.Fatalf("%s: close read-only view: %v", db.Name(), err)
+		}
+		if _, err := NewReadonly(db).Writable(); err != ErrReadOnly {
+			t.Fatalf("%s: writable on NewReadonly: expected ErrReadOnly, got %v", db.Name(), err)
+		}
+	}
+}
+
+func testGetMulti(t *testing.T, backend ...DB) {
+	keys := []NamespacedKey{
+		{[]byte("key"), []byte("042")},
+		{[]byte("none/"), []byte("042")},
+		{[]byte("key0"), []byte("07")},
+		{nil, []byte("key001")},
+	}
+	for _, db := range backend {
+		results, err := GetMulti(db, keys)
+		if err != nil {
+			t.Fatalf("%s: get multi: %v", db.Name(), err)
+		}
+		if len(results) != len(keys) {
+			t.Fatalf("%s: get multi: expected %d results, got %d", db.Name(), len(keys), len(results))
+		}
+		for i, expected := range []string{"val042", "", "val007", "val001"} {
+			r := results[i]
+			if expected == "" && r.Err != ErrNotFound {
+				t.Fatalf("%s: get multi: expected ErrNotFound, got %q, %v", db.Name(), r.Value, r.Err)
+			} else if expected != "" && (r.Err != nil || string(r.Value) != expected) {
+				t.Fatalf("%s: get multi: expected %q, got %q, %v", db.Name(), expected, r.Value, r.Err)
+			}
+		}
+	}
+}
+
+func testMove(t *testing.T, backend ...DB) {
+	for _, db := range backend {
+		txn, err := db.Writable()
+		if err != nil {
+			t.Fatalf("%s: begin writable transaction: %v", db.Name(), err)
+		}
+		for _, k := range []string{"tenant/a/1", "tenant/a/2", "tenant/b/2"} {
+			if err = txn.Put([]byte(k), []byte(k)); err != nil {
+				t.Fatalf("%s: put key %q: %v", db.Name(), k, err)
+			}
+		}
+		if err = Move(txn, []byte("tenant/a/1"), []byte("tenant/a/3"), false); err != nil {
+			t.Fatalf("%s: move: %v", db.Name(), err)
+		}
+		if err = Move(txn, []byte("tenant/a/3"), []byte("tenant/a/2"), false); err != ErrKeyExists {
+			t.Fatalf("%s: move to existing key: expected ErrKeyExists, got %v", db.Name(), err)
+		}
+		if err = Move(txn, []byte("tenant/a/1"), []byte("tenant/a/4"), false); err != ErrNotFound {
+			t.Fatalf("%s: move missing key: expected ErrNotFound, got %v", db.Name(), err)
+		}
+		if err = txn.Commit(); err != nil {
+			t.Fatalf("%s: commit writable transaction: %v", db.Name(), err)
+		}
+
+		if _, err = MovePrefix(db, []byte("tenant/a/"), []byte("tenant/b/"), false); err != ErrKeyExists {
+			t.Fatalf("%s: move prefix: expected ErrKeyExists, got %v", db.Name(), err)
+		}
+		n, err := MovePrefix(db, []byte("tenant/a/"), []byte("tenant/b/"), true)
+		if err != nil || n != 2 {
+			t.Fatalf("%s: move prefix: expected 2 keys, got %d, %v", db.Name(), n, err)
+		}
+
+		pairs, err := Scan(db, []byte("tenant/"))
+		if err != nil {
+			t.Fatalf("%s: scan: %v", db.Name(), err)
+		}
+		expected := []string{"tenant/b/2", "tenant/a/2", "tenant/b/3", "tenant/a/1"}
+		if len(pairs) != len(expected)/2 {
+			t.Fatalf("%s: move prefix: expected %d keys, got %d", db.Name(), len(expected)/2, len(pairs))
+		}
+		for i, p := range pairs {
+			if string(p.Key) != expected[2*i] || string(p.Value) != expected[2*i+1] {
+				t.Fatalf("%s: move prefix: expected %s => %s, got %s => %s", db.Name(),
+					expected[2*i], expected[2*i+1], p.Key, p.Value)
+			}
+		}
+
+		txn, err = db.Writable()
+		if err != nil {
+			t.Fatalf("%s: begin writable transaction: %v", db.Name(), err)
+		}
+		for _, p := range pairs {
+			if err = txn.Delete(p.Key); err != nil {
+				t.Fatalf("%s: delete key %q: %v", db.Name(), p.Key, err)
+			}
+		}
+		if err = txn.Commit(); err != nil {
+			t.Fatalf("%s: commit writable transaction: %v", db.Name(), err)
+		}
+	}
+}
+
+func testDeleteFunc(t *testing.T, backend ...DB) {
+	even := func(k, v []byte) bool { return (k[len(k)-1]-'0')%2 == 0 }
+	for _, db := range backend {
+		txn, err := db.Writable()
+		if err != nil {
+			t.Fatalf("%s: begin writable transaction: %v", db.Name(), err)
+		}
+		for i := 0; i < 10; i++ {
+			k := []byte(fmt.Sprintf("retain/%03d", i))
+			if err = txn.Put(k, k); err != nil {
+				t.Fatalf("%s: put key %q: %v", db.Name(), k, err)
+			}
+		}
+		if err = txn.Commit(); err != nil {
+			t.Fatalf("%s: commit writable transaction: %v", db.Name(), err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err = DeleteFunc(ctx, db, []byte("retain/"), even); err != context.Canceled {
+			t.Fatalf("%s: delete func: expected context.Canceled, got %v", db.Name(), err)
+		}
+		n, err := DeleteFunc(context.Background(), db, []byte("retain/"), even,
+			DeleteBatch(2), DeleteThrottle(time.Millisecond))
+		if err != nil || n != 5 {
+			t.Fatalf("%s: delete func: expected 5 keys, got %d, %v", db.Name(), n, err)
+		}
+		n, err = DeleteFunc(context.Background(), db, []byte("retain/"), func(k, v []byte) bool { return true })
+		if err != nil || n != 5 {
+			t.Fatalf("%s: delete func: expected 5 keys, got %d, %v", db.Name(), n, err)
+		}
+	}
+}
+
+func testSeekToIndex(t *testing.T, backend ...DB) {
+	for _, db := range backend {
+		pairs, err := Scan(db, nil)
+		if err != nil {
+			t.Fatalf("%s: scan: %v", db.Name(), err)
+		}
+		if len(pairs) < 2 {
+			t.Fatalf("%s: expected keys left by previous tests, got %d", db.Name(), len(pairs))
+		}
+
+		iter, err := db.Iterator()
+		if err != nil {
+			t.Fatalf("%s: iterator: %v", db.Name(), err)
+		}
+		for n := len(pairs) - 1; n >= 0; n-- {
+			k, v := iter.SeekToIndex(int64(n))
+			if !bytes.Equal(k, pairs[n].Key) || !bytes.Equal(v, pairs[n].Value) {
+				t.Fatalf("%s: seek to index %d: expected %q, got %q", db.Name(), n, pairs[n].Key, k)
+			}
+		}
+		if k, _ := iter.Next(); !bytes.Equal(k, pairs[1].Key) {
+			t.Fatalf("%s: next after seek to index 0: expected %q, got %q", db.Name(), pairs[1].Key, k)
+		}
+		for _, n := range []int64{-1, int64(len(pairs))} {
+			if k, _ := iter.SeekToIndex(n); k != nil {
+				t.Fatalf("%s: seek to index %d: expected nil, got %q", db.Name(), n, k)
+			}
+		}
+		if err = iter.Close(); err != nil {
+			t.Fatalf("%s: close iterator: %v", db.Name(), err)
+		}
+	}
+}
+
+func testScope(t *testing.T, backend ...DB) {
+	for _, db := range backend {
+		txn, err := db.Writable()
+		if err != nil {
+			t.Fatalf("%s: begin writable transaction: %v", db.Name(), err)
+		}
+		for _, k := range []string{"tenant/a", "tenant/b/x", "tenant/b/y", "tenant/c", "tenantz"} {
+			if err = txn.Put([]byte(k), []byte(k)); err != nil {
+				t.Fatalf("%s: put key %q: %v", db.Name(), k, err)
+			}
+		}
+		if err = txn.Commit(); err != nil {
+			t.Fatalf("%s: commit writable transaction: %v", db.Name(), err)
+		}
+
+		scope := Scope(db, []byte("tenant/b/"))
+		if err = Update(scope, func(txn RWTxn) error {
+			if _, err := txn.Get([]byte("x")); err != nil {
+				return err
+			}
+			if _, err := txn.Get([]byte("../a")); err != ErrNotFound {
+				t.Fatalf("%s: scope get outside prefix: expected ErrNotFound, got %v", db.Name(), err)
+			}
+			return txn.Put([]byte("z"), []byte("tenant/b/z"))
+		}); err != nil {
+			t.Fatalf("%s: scope update: %v", db.Name(), err)
+		}
+
+		iter, err := scope.Iterator()
+		if err != nil {
+			t.Fatalf("%s: scope iterator: %v", db.Name(), err)
+		}
+		var keys []string
+		for k, v := iter.First(); k != nil; k, v = iter.Next() {
+			if string(v) != "tenant/b/"+string(k) {
+				t.Fatalf("%s: scope iterator: key %q has value %q", db.Name(), k, v)
+			}
+			keys = append(keys, string(k))
+		}
+		if got := strings.Join(keys, ","); got != "x,y,z" {
+			t.Fatalf("%s: scope ascending: expected x,y,z, got %s", db.Name(), got)
+		}
+		keys = keys[:0]
+		for k, _ := iter.Last(); k != nil; k, _ = iter.Prev() {
+			keys = append(keys, string(k))
+		}
+		if got := strings.Join(keys, ","); got != "z,y,x" {
+			t.Fatalf("%s: scope descending: expected z,y,x, got %s", db.Name(), got)
+		}
+		if k, _ := iter.Seek([]byte("zz")); k != nil {
+			t.Fatalf("%s: scope seek past end: expected nil, got %q", db.Name(), k)
+		}
+		if err = iter.Close(); err != nil {
+			t.Fatalf("%s: close scope iterator: %v", db.Name(), err)
+		}
+
+		if err = scope.Close(); err != nil {
+			t.Fatalf("%s: close scope: %v", db.Name(), err)
+		}
+		if err = View(db, func(txn Txn) error {
+			_, err := txn.Get([]byte("tenant/b/z"))
+			return err
+		}); err != nil {
+			t.Fatalf("%s: get scoped key after closing scope: %v", db.Name(), err)
+		}
+	}
+}
+
 func TestCompatibility(t *testing.T) {
 	boltDB := openBoltDB(t, "compatibility_boltdb.db")
+	bloomDB := openBoltDB(t, "compatibility_bloom.db", BloomFilter(10))
 	levelDB := openLevelDB(t, "compatibility_leveldb")
+	goLevelDB := openGoLevelDB(t, "compatibility_goleveldb")
+	memDB := NewMemDB()
+	bitcask := openBitcask(t, "compatibility_bitcask", MaxFileSize(512))
+	bboltDB := openBBoltDB(t, "compatibility_bbolt.db")
+	sharded := openSharded(t, 3)
 	defer func() {
 		closeBoltDB(t, "compatibility_boltdb.db", boltDB)
+		closeBoltDB(t, "compatibility_bloom.db", bloomDB)
 		closeLevelDB(t, "compatibility_leveldb", levelDB)
+		closeGoLevelDB(t, "compatibility_goleveldb", goLevelDB)
+		if err := memDB.Close(); err != nil {
+			t.Errorf("closing MemDB: %v", err)
+		}
+		closeBitcask(t, "compatibility_bitcask", bitcask)
+		closeBBoltDB(t, "compatibility_bbolt.db", bboltDB)
+		if err := sharded.Close(); err != nil {
+			t.Errorf("closing Sharded: %v", err)
+		}
 	}()
 
-	testBasic(t, boltDB, levelDB)
-	testBasicTransaction(t, boltDB, levelDB)
-	testBasicIterator(t, boltDB, levelDB)
+	testBasic(t, boltDB, bloomDB, levelDB, goLevelDB, memDB, bitcask, bboltDB, sharded)
+	testBasicTransaction(t, boltDB, bloomDB, levelDB, goLevelDB, memDB, bitcask, bboltDB, sharded)
+	testBasicIterator(t, boltDB, bloomDB, levelDB, goLevelDB, memDB, bitcask, bboltDB, sharded)
+	testExport(t, boltDB, bloomDB, levelDB, goLevelDB, memDB, bitcask, bboltDB, sharded)
+	testTextDump(t, boltDB, bloomDB, levelDB, goLevelDB, memDB, bitcask, bboltDB, sharded)
+	testIteratorReset(t, boltDB, bloomDB, levelDB, goLevelDB, memDB, bitcask, bboltDB, sharded)
+	testScan(t, boltDB, bloomDB, levelDB, goLevelDB, memDB, bitcask, bboltDB, sharded)
+	testPatchJSON(t, boltDB, bloomDB, levelDB, goLevelDB, memDB, bitcask, bboltDB, sharded)
+	testChecksum(t, boltDB, bloomDB, levelDB, goLevelDB, memDB, bitcask, bboltDB, sharded)
+	testReadOnlyTxn(t, boltDB, bloomDB, levelDB, goLevelDB, memDB, bitcask, bboltDB, sharded)
+	testReadOnlyView(t, boltDB, bloomDB, levelDB, goLevelDB, memDB, bitcask, bboltDB, sharded)
+	testGetMulti(t, boltDB, bloomDB, levelDB, goLevelDB, memDB, bitcask, bboltDB, sharded)
+	testMove(t, boltDB, bloomDB, levelDB, goLevelDB, memDB, bitcask, bboltDB, sharded)
+	testDeleteFunc(t, boltDB, bloomDB, levelDB, goLevelDB, memDB, bitcask, bboltDB, sharded)
+	testScope(t, boltDB, bloomDB, levelDB, goLevelDB, memDB, bitcask, bboltDB, sharded)
+	testSeekToIndex(t, boltDB, bloomDB, levelDB, goLevelDB, memDB, bitcask, bboltDB, sharded)
 }
 
-func openBoltDB(t *testing.T, path string) *BoltDB {
-	db, err := OpenBoltDB(path, 0)
+func TestClose(t *testing.T) {
+	boltDB := openBoltDB(t, "close_boltdb.db")
+	levelDB := openLevelDB(t, "close_leveldb")
+	goLevelDB := openGoLevelDB(t, "close_goleveldb")
+	bitcask := openBitcask(t, "close_bitcask")
+	bboltDB := openBBoltDB(t, "close_bbolt.db")
+	defer os.RemoveAll("close_boltdb.db")
+	defer os.RemoveAll("close_leveldb")
+	defer os.RemoveAll("close_goleveldb")
+	defer os.RemoveAll("close_bitcask")
+	defer os.RemoveAll("close_bbolt.db")
+
+	for _, db := range []DB{boltDB, levelDB, goLevelDB, NewMemDB(), bitcask, bboltDB, openSharded(t, 2)} {
+		iter, err := db.Iterator()
+		if err != nil {
+			t.Fatalf("%s: iterator: %v", db.Name(), err)
+		}
+		closed := make(chan error)
+		go func() { closed <- db.Close() }()
+
+		// Close waits for the open iterator but rejects new users
+		for {
+			txn, err := db.Readonly()
+			if err == ErrDBClosed {
+				break
+			} else if err != nil {
+				t.Fatalf("%s: begin read-only transaction: %v", db.Name(), err)
+			}
+			txn.Rollback()
+		}
+		select {
+		case err = <-closed:
+			t.Fatalf("%s: close returned with open iterator: %v", db.Name(), err)
+		default:
+		}
+		if err = iter.Close(); err != nil {
+			t.Fatalf("%s: closing iterator: %v", db.Name(), err)
+		}
+		if err = <-closed; err != nil {
+			t.Fatalf("%s: close: %v", db.Name(), err)
+		}
+
+		if _, err = db.Writable(); err != ErrDBClosed {
+			t.Fatalf("%s: begin writable transaction: expected ErrDBClosed, got %v", db.Name(), err)
+		}
+		if _, err = db.Iterator(); err != ErrDBClosed {
+			t.Fatalf("%s: iterator: expected ErrDBClosed, got %v", db.Name(), err)
+		}
+		if err = db.Close(); err != ErrDBClosed {
+			t.Fatalf("%s: close again: expected ErrDBClosed, got %v", db.Name(), err)
+		}
+	}
+}
+
+// TestExportEmptyKey round-trips an empty key, which sorts first and is
+// written as a record without key bytes.
+func TestExportEmptyKey(t *testing.T) {
+	src := NewMemDB()
+	defer src.Close()
+	if err := Update(src, func(txn RWTxn) error {
+		if err := txn.Put([]byte{}, []byte("empty")); err != nil {
+			return err
+		}
+		return txn.Put([]byte("key"), []byte("value"))
+	}); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := Export(&buf, src); err != nil {
+		t.Fatalf("export: %v", err)
+	}
+	dst := NewMemDB()
+	defer dst.Close()
+	if err := Import(dst, &buf); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	pairs, err := Scan(dst, nil)
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if len(pairs) != 2 || len(pairs[0].Key) != 0 || string(pairs[0].Value) != "empty" ||
+		string(pairs[1].Key) != "key" || string(pairs[1].Value) != "value" {
+		t.Fatalf("expected the empty key and key, got %q", pairs)
+	}
+}
+
+func openBoltDB(t *testing.T, path string, opts ...BoltOption) *BoltDB {
+	db, err := OpenBoltDB(path, 0, opts...)
 	if err != nil {
 		t.Errorf("opening BoltDB %q: %v", path, err)
 	}
@@ -212,8 +1008,35 @@ func closeBoltDB(t *testing.T, path string, db *BoltDB) {
 	os.RemoveAll(path)
 }
 
-func openLevelDB(t *testing.T, path string) *LevelDB {
-	db, err := OpenLevelDB(path)
+func openSharded(t *testing.T, n int, opts ...ShardOption) *Sharded {
+	shards := make([]DB, n)
+	for i := range shards {
+		shards[i] = NewMemDB()
+	}
+	db, err := NewSharded(shards, opts...)
+	if err != nil {
+		t.Fatalf("new Sharded: %v", err)
+	}
+	return db
+}
+
+func openBBoltDB(t *testing.T, path string, opts ...BBoltOption) *BBoltDB {
+	db, err := OpenBBoltDB(path, opts...)
+	if err != nil {
+		t.Errorf("opening BBoltDB %q: %v", path, err)
+	}
+	return db
+}
+
+func closeBBoltDB(t *testing.T, path string, db *BBoltDB) {
+	if err := db.Close(); err != nil {
+		t.Errorf("closing BBoltDB %q: %v", path, err)
+	}
+	os.RemoveAll(path)
+}
+
+func openLevelDB(t *testing.T, path string, opts ...LevelOption) *LevelDB {
+	db, err := OpenLevelDB(path, opts...)
 	if err != nil {
 		t.Errorf("opening LevelDB %q: %v", path, err)
 	}
@@ -226,3 +1049,33 @@ func closeLevelDB(t *testing.T, path string, db *LevelDB) {
 	}
 	os.RemoveAll(path)
 }
+
+func openGoLevelDB(t *testing.T, path string) *GoLevelDB {
+	db, err := OpenGoLevelDB(path)
+	if err != nil {
+		t.Errorf("opening GoLevelDB %q: %v", path, err)
+	}
+	return db
+}
+
+func closeGoLevelDB(t *testing.T, path string, db *GoLevelDB) {
+	if err := db.Close(); err != nil {
+		t.Errorf("closing GoLevelDB %q: %v", path, err)
+	}
+	os.RemoveAll(path)
+}
+
+func openBitcask(t *testing.T, path string, opts ...BitcaskOption) *Bitcask {
+	db, err := OpenBitcask(path, opts...)
+	if err != nil {
+		t.Errorf("opening Bitcask %q: %v", path, err)
+	}
+	return db
+}
+
+func closeBitcask(t *testing.T, path string, db *Bitcask) {
+	if err := db.Close(); err != nil {
+		t.Errorf("closing Bitcask %q: %v", path, err)
+	}
+	os.RemoveAll(path)
+}
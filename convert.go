@@ -0,0 +1,82 @@
+package backend
+
+import (
+	"errors"
+	"os"
+
+	"github.com/boltdb/bolt"
+)
+
+// convertBatchBytes is the amount of key and value data ConvertToBolt
+// writes per Bolt transaction.
+const convertBatchBytes = 32 << 20
+
+// ConvertToBolt copies all pairs of src into a new BoltDB file at path,
+// which can then be opened with OpenBoltDB, and returns the number of
+// copied pairs. It is meant for one-off migrations, e.g. from LevelDB to
+// a pure Go deployment, and is much faster than copying through
+// transactions of a BoltDB: keys arrive in order, so pages are filled
+// completely instead of being split in half, and only the last of the
+// large write transactions is synced.
+//
+// ConvertToBolt fails if path exists and removes the file again if the
+// conversion fails.
+func ConvertToBolt(src DB, path string) (n int64, err error) {
+	if _, err = os.Stat(path); err == nil {
+		return 0, errors.New("convert: " + path + " exists")
+	} else if !os.IsNotExist(err) {
+		return 0, err
+	}
+	tree, err := bolt.Open(path, defaultOpenMode, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if cerr := tree.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(path)
+		}
+	}()
+
+	iter, err := src.Iterator()
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+
+	tree.NoSync = true
+	k, v := iter.First()
+	for {
+		err = tree.Update(func(tx *bolt.Tx) error {
+			b, err := tx.CreateBucketIfNotExists(rootBucket)
+			if err != nil {
+				return err
+			}
+			// keys are appended, full pages are never split again
+			b.FillPercent = 1.0
+			for size := 0; k != nil && size < convertBatchBytes; k, v = iter.Next() {
+				// Put values must stay valid for the transaction, the
+				// iterator's only until it moves on
+				if err = b.Put(append([]byte{}, k...), append([]byte{}, v...)); err != nil {
+					return err
+				}
+				size += len(k) + len(v)
+				n++
+			}
+			if k == nil {
+				// make the last commit durable, which syncs all
+				tree.NoSync = false
+			}
+			return nil
+		})
+		if err != nil || k == nil {
+			break
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+	return n, iter.Close()
+}
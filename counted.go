@@ -0,0 +1,254 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// ErrNotCounted is returned by Count for prefixes that were not
+// registered with the CountedDB.
+const ErrNotCounted Error = Error("prefix not counted")
+
+var errInvalidCounter = errors.New("invalid counter value")
+
+// CountedDB wraps a DB and maintains the exact number of keys below each
+// registered prefix. Counters are stored below a dedicated key prefix and
+// updated in the same transaction as the keys they count, so a count
+// never disagrees with the committed data. Writes that bypass the
+// CountedDB are not counted.
+type CountedDB struct {
+	DB
+
+	counters []byte
+	prefixes [][]byte
+}
+
+// NewCountedDB returns a CountedDB counting the keys below each of
+// prefixes, storing the counters below the counters prefix. Counters that
+// do not exist yet are initialized by scanning the database; counter keys
+// themselves are never counted.
+func NewCountedDB(db DB, counters []byte, prefixes ...[]byte) (*CountedDB, error) {
+	c := &CountedDB{DB: db, counters: append([]byte{}, counters...)}
+	for _, prefix := range prefixes {
+		c.prefixes = append(c.prefixes, append([]byte{}, prefix...))
+	}
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// init writes the initial value of all missing counters.
+func (c *CountedDB) init() error {
+	txn, err := c.DB.Writable()
+	if err != nil {
+		return err
+	}
+	for _, prefix := range c.prefixes {
+		if _, err = txn.Get(c.counterKey(prefix)); err == nil {
+			continue
+		} else if err != ErrNotFound {
+			txn.Rollback()
+			return err
+		}
+
+		n, err := c.scan(prefix)
+		if err != nil {
+			txn.Rollback()
+			return err
+		}
+		if err = txn.Put(c.counterKey(prefix), encodeCount(n)); err != nil {
+			txn.Rollback()
+			return err
+		}
+	}
+	return txn.Commit()
+}
+
+// scan counts the keys below prefix.
+func (c *CountedDB) scan(prefix []byte) (uint64, error) {
+	iter, err := c.DB.Iterator()
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+
+	n := uint64(0)
+	for k, _ := iter.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = iter.Next() {
+		if !bytes.HasPrefix(k, c.counters) {
+			n++
+		}
+	}
+	return n, iter.Close()
+}
+
+func (c *CountedDB) counterKey(prefix []byte) []byte {
+	key := make([]byte, 0, len(c.counters)+len(prefix))
+	return append(append(key, c.counters...), prefix...)
+}
+
+func encodeCount(n uint64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, n)
+	return buf
+}
+
+// Count returns the number of keys below prefix. It returns
+// ErrNotCounted if prefix was not registered.
+func (c *CountedDB) Count(prefix []byte) (uint64, error) {
+	if !c.registered(prefix) {
+		return 0, ErrNotCounted
+	}
+
+	txn, err := c.DB.Readonly()
+	if err != nil {
+		return 0, err
+	}
+	defer txn.Rollback()
+
+	v, err := txn.Get(c.counterKey(prefix))
+	if err != nil {
+		return 0, err
+	}
+	if len(v) != 8 {
+		return 0, errInvalidCounter
+	}
+	return binary.BigEndian.Uint64(v), nil
+}
+
+func (c *CountedDB) registered(prefix []byte) bool {
+	for _, p := range c.prefixes {
+		if bytes.Equal(p, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Writable starts a new write transaction that updates the counters of
+// all prefixes containing an inserted or deleted key on commit.
+func (c *CountedDB) Writable() (RWTxn, error) {
+	txn, err := c.DB.Writable()
+	if err != nil {
+		return nil, err
+	}
+	return &countedTxn{
+		RWTxn:  txn,
+		c:      c,
+		exists: make(map[string]bool),
+		deltas: make([]int64, len(c.prefixes)),
+	}, nil
+}
+
+type countedTxn struct {
+	RWTxn
+	c      *CountedDB
+	exists map[string]bool // existence of keys modified in this transaction
+	deltas []int64         // count changes by prefix index
+	done   bool            // committed or rolled back
+}
+
+// counted reports whether key is below any registered prefix.
+func (t *countedTxn) counted(key []byte) bool {
+	if bytes.HasPrefix(key, t.c.counters) {
+		return false
+	}
+	for _, prefix := range t.c.prefixes {
+		if bytes.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// keyExists reports whether key exists. Writes of the transaction are
+// tracked separately since not every backend reads its own writes.
+func (t *countedTxn) keyExists(key []byte) (bool, error) {
+	if ok, found := t.exists[string(key)]; found {
+		return ok, nil
+	}
+	_, err := t.RWTxn.Get(key)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (t *countedTxn) add(key []byte, delta int64) {
+	for i, prefix := range t.c.prefixes {
+		if bytes.HasPrefix(key, prefix) {
+			t.deltas[i] += delta
+		}
+	}
+}
+
+func (t *countedTxn) Put(key, value []byte) error {
+	if !t.counted(key) {
+		return t.RWTxn.Put(key, value)
+	}
+	ok, err := t.keyExists(key)
+	if err != nil {
+		return err
+	}
+	if err = t.RWTxn.Put(key, value); err != nil {
+		return err
+	}
+	if !ok {
+		t.add(key, 1)
+	}
+	t.exists[string(key)] = true
+	return nil
+}
+
+func (t *countedTxn) Delete(key []byte) error {
+	if !t.counted(key) {
+		return t.RWTxn.Delete(key)
+	}
+	ok, err := t.keyExists(key)
+	if err != nil {
+		return err
+	}
+	if err = t.RWTxn.Delete(key); err != nil {
+		return err
+	}
+	if ok {
+		t.add(key, -1)
+	}
+	t.exists[string(key)] = false
+	return nil
+}
+
+func (t *countedTxn) Commit() error {
+	if t.done {
+		return t.RWTxn.Commit()
+	}
+	t.done = true
+
+	for i, delta := range t.deltas {
+		if delta == 0 {
+			continue
+		}
+		key := t.c.counterKey(t.c.prefixes[i])
+		v, err := t.RWTxn.Get(key)
+		if err != nil {
+			t.RWTxn.Rollback()
+			return err
+		}
+		if len(v) != 8 {
+			t.RWTxn.Rollback()
+			return errInvalidCounter
+		}
+		n := int64(binary.BigEndian.Uint64(v)) + delta
+		if err = t.RWTxn.Put(key, encodeCount(uint64(n))); err != nil {
+			t.RWTxn.Rollback()
+			return err
+		}
+	}
+	return t.RWTxn.Commit()
+}
+
+func (t *countedTxn) Rollback() error {
+	t.done = true
+	return t.RWTxn.Rollback()
+}
@@ -0,0 +1,87 @@
+package backend
+
+import (
+	"testing"
+)
+
+func TestCountedDB(t *testing.T) {
+	const path = "counted_boltdb.db"
+	db := openBoltDB(t, path)
+	defer closeBoltDB(t, path, db)
+
+	update := func(c *CountedDB, fn func(txn RWTxn) error) {
+		txn, err := c.Writable()
+		if err != nil {
+			t.Fatalf("begin writable transaction: %v", err)
+		}
+		if err = fn(txn); err != nil {
+			t.Fatalf("update: %v", err)
+		}
+		if err = txn.Commit(); err != nil {
+			t.Fatalf("commit writable transaction: %v", err)
+		}
+	}
+	count := func(c *CountedDB, prefix string, expected uint64) {
+		n, err := c.Count([]byte(prefix))
+		if err != nil {
+			t.Fatalf("count %q: %v", prefix, err)
+		}
+		if n != expected {
+			t.Fatalf("count %q: expected %d, got %d", prefix, expected, n)
+		}
+	}
+
+	c, err := NewCountedDB(db, []byte("#"), []byte("a/"), []byte("a/x/"), []byte(""))
+	if err != nil {
+		t.Fatalf("new counted db: %v", err)
+	}
+	update(c, func(txn RWTxn) error {
+		for _, key := range []string{"a/1", "a/x/1", "a/1", "b/1"} {
+			if err := txn.Put([]byte(key), []byte("v")); err != nil {
+				return err
+			}
+		}
+		return txn.Delete([]byte("b/2"))
+	})
+	count(c, "a/", 2)
+	count(c, "a/x/", 1)
+	count(c, "", 3)
+
+	update(c, func(txn RWTxn) error {
+		if err := txn.Delete([]byte("a/1")); err != nil {
+			return err
+		}
+		if err := txn.Delete([]byte("a/1")); err != nil {
+			return err
+		}
+		return txn.Put([]byte("a/x/1"), []byte("w"))
+	})
+	count(c, "a/", 1)
+	count(c, "a/x/", 1)
+	count(c, "", 2)
+
+	// rolled back changes are not counted
+	txn, err := c.Writable()
+	if err != nil {
+		t.Fatalf("begin writable transaction: %v", err)
+	}
+	if err = txn.Put([]byte("a/2"), []byte("v")); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if err = txn.Rollback(); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	count(c, "a/", 1)
+
+	if _, err = c.Count([]byte("b/")); err != ErrNotCounted {
+		t.Fatalf("count unregistered prefix: expected ErrNotCounted, got %v", err)
+	}
+
+	// new prefixes are initialized from existing keys
+	c, err = NewCountedDB(db, []byte("#"), []byte("a/"), []byte("b/"))
+	if err != nil {
+		t.Fatalf("new counted db: %v", err)
+	}
+	count(c, "a/", 1)
+	count(c, "b/", 1)
+}
@@ -0,0 +1,597 @@
+// Package crypt encrypts the values of a backend.DB at rest.
+//
+// Values are sealed with AES-256-GCM under a data key of their
+// namespace, a registered key prefix, and the key they are stored under
+// is authenticated as additional data. Data keys are random, wrapped by
+// the master key and stored below a metadata prefix. Keys themselves are
+// stored in plain text to preserve their order.
+//
+// Destroying the data key of a namespace with ShredNamespace makes all
+// values stored in it unrecoverable, even from old backups that do not
+// contain the data key records.
+package crypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/mars9/backend"
+	"github.com/mars9/backend/keys"
+)
+
+var (
+	// ErrDecrypt is returned when a stored value or data key cannot be
+	// decrypted, either because it was encrypted with a different key or
+	// it was tampered with.
+	ErrDecrypt = errors.New("crypt: decrypt value")
+
+	// ErrShredded is returned when reading or writing a key of a
+	// shredded namespace.
+	ErrShredded = errors.New("crypt: namespace shredded")
+
+	errMetadata  = errors.New("crypt: write to metadata key")
+	errNamespace = errors.New("crypt: invalid namespace")
+	errMasterKey = errors.New("crypt: unknown master key")
+)
+
+// Metadata records below the metadata prefix.
+const (
+	recordKey      = 'k' // + namespace + generation: wrapped data key
+	recordCurrent  = 'c' // + namespace: current generation
+	recordShredded = 's' // + namespace: shred marker
+	recordMaster   = 'm' // + namespace + generation: id of the wrapping master key
+	recordActive   = 'a' // id of the master key wrapping new data keys
+)
+
+const (
+	dataKeySize = 32
+	genSize     = 4
+)
+
+// Option configures a DB.
+type Option func(*DB) error
+
+// MasterKey registers the additional 32 byte master key id, e.g. to
+// rotate to it with RotateEncryptionKey. The master key passed to New
+// has the empty id.
+func MasterKey(id string, key []byte) Option {
+	return func(db *DB) error {
+		if len(key) != 32 {
+			return errors.New("crypt: master key must be 32 bytes")
+		}
+		if _, ok := db.masters[id]; ok {
+			return errors.New("crypt: duplicate master key")
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return err
+		}
+		db.masters[id] = aead
+		return nil
+	}
+}
+
+// Namespace assigns the keys starting with prefix to the namespace
+// name. A key belongs to the namespace with the longest matching prefix;
+// keys matching none belong to the default namespace "".
+func Namespace(name string, prefix []byte) Option {
+	return func(db *DB) error {
+		if name == "" {
+			return errNamespace
+		}
+		for _, ns := range db.namespaces {
+			if ns.name == name || bytes.Equal(ns.prefix, prefix) {
+				return errNamespace
+			}
+		}
+		db.namespaces = append(db.namespaces, namespace{name, append([]byte{}, prefix...)})
+		return nil
+	}
+}
+
+type namespace struct {
+	name   string
+	prefix []byte
+}
+
+// dataKey is a generation of the data key of a namespace.
+type dataKey struct {
+	gen  uint32
+	aead cipher.AEAD
+}
+
+// DB wraps a backend.DB and encrypts the values of all keys outside the
+// metadata prefix. Values written bypassing the DB are not readable
+// through it. The data keys are cached, so a namespace must not be
+// shredded through another DB wrapping the same database.
+type DB struct {
+	backend.DB
+
+	meta       []byte
+	masters    map[string]cipher.AEAD // by id
+	provider   KeyProvider            // optional source of other master keys
+	namespaces []namespace
+
+	mu       sync.Mutex
+	fetched  map[string]providerKey // by id
+	dataKeys map[string]*dataKey    // by record key
+	shredded map[string]bool        // by namespace
+}
+
+// New returns a DB storing its metadata below meta in db. The master key
+// must be 32 bytes long, or nil if it is fetched from a KeyProvider.
+func New(db backend.DB, meta, master []byte, opts ...Option) (*DB, error) {
+	c := &DB{
+		DB:       db,
+		meta:     append([]byte{}, meta...),
+		masters:  make(map[string]cipher.AEAD),
+		fetched:  make(map[string]providerKey),
+		dataKeys: make(map[string]*dataKey),
+		shredded: make(map[string]bool),
+	}
+	if master != nil {
+		if err := MasterKey("", master)(c); err != nil {
+			return nil, err
+		}
+	}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	if master == nil && c.provider == nil {
+		return nil, errors.New("crypt: master key must be 32 bytes")
+	}
+	return c, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// namespace returns the namespace of key.
+func (db *DB) namespace(key []byte) string {
+	name, n := "", -1
+	for _, ns := range db.namespaces {
+		if len(ns.prefix) > n && bytes.HasPrefix(key, ns.prefix) {
+			name, n = ns.name, len(ns.prefix)
+		}
+	}
+	return name
+}
+
+func (db *DB) record(typ byte, ns string) []byte {
+	return keys.AppendString(append(append([]byte{}, db.meta...), typ), ns)
+}
+
+func (db *DB) keyRecord(ns string, gen uint32) []byte {
+	return binary.BigEndian.AppendUint32(db.record(recordKey, ns), gen)
+}
+
+func (db *DB) masterRecord(ns string, gen uint32) []byte {
+	return binary.BigEndian.AppendUint32(db.record(recordMaster, ns), gen)
+}
+
+// masterKey returns the master key id.
+func (db *DB) masterKey(id string) (cipher.AEAD, error) {
+	if aead, ok := db.masters[id]; ok {
+		return aead, nil
+	}
+	if db.provider == nil {
+		return nil, errMasterKey
+	}
+	return db.fetchKey(id)
+}
+
+// activeMaster returns the id of the master key wrapping new data keys.
+func (db *DB) activeMaster(txn backend.Txn) (string, error) {
+	id, err := txn.Get(append(append([]byte{}, db.meta...), recordActive))
+	if err == backend.ErrNotFound {
+		return "", nil
+	}
+	return string(id), err
+}
+
+// seal encrypts value with aead, binding it to key, and prepends a
+// random nonce.
+func seal(aead cipher.AEAD, key, value, prefix []byte) ([]byte, error) {
+	out := make([]byte, len(prefix)+aead.NonceSize(), len(prefix)+aead.NonceSize()+len(value)+aead.Overhead())
+	copy(out, prefix)
+	nonce := out[len(prefix):]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(out, nonce, value, key), nil
+}
+
+func open(aead cipher.AEAD, key, sealed []byte) ([]byte, error) {
+	n := aead.NonceSize()
+	if len(sealed) < n {
+		return nil, ErrDecrypt
+	}
+	value, err := aead.Open(nil, sealed[:n], sealed[n:], key)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return value, nil
+}
+
+// loadKey returns generation gen of the data key of ns read through txn.
+func (db *DB) loadKey(txn backend.Txn, ns string, gen uint32) (*dataKey, error) {
+	rec := db.keyRecord(ns, gen)
+	db.mu.Lock()
+	k, ok := db.dataKeys[string(rec)]
+	shredded := db.shredded[ns]
+	db.mu.Unlock()
+	if shredded {
+		return nil, ErrShredded
+	}
+	if ok {
+		return k, nil
+	}
+
+	wrapped, err := txn.Get(rec)
+	if err == backend.ErrNotFound {
+		if _, err = txn.Get(db.record(recordShredded, ns)); err == nil {
+			db.mu.Lock()
+			db.shredded[ns] = true
+			db.mu.Unlock()
+			return nil, ErrShredded
+		} else if err != backend.ErrNotFound {
+			return nil, err
+		}
+		return nil, ErrDecrypt
+	} else if err != nil {
+		return nil, err
+	}
+	id, err := txn.Get(db.masterRecord(ns, gen))
+	if err == backend.ErrNotFound {
+		id = nil
+	} else if err != nil {
+		return nil, err
+	}
+	master, err := db.masterKey(string(id))
+	if err != nil {
+		return nil, err
+	}
+	raw, err := open(master, rec, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(raw)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	k = &dataKey{gen: gen, aead: aead}
+	db.mu.Lock()
+	db.dataKeys[string(rec)] = k
+	db.mu.Unlock()
+	return k, nil
+}
+
+// currentKey returns the current data key of ns, creating it in txn if
+// the namespace has none. created reports whether the key is new and
+// only valid once txn commits. The current generation is read inside
+// the write transaction, never from the cache, so no write can use a
+// generation replaced by a concurrent rotation.
+func (db *DB) currentKey(txn backend.RWTxn, ns string) (k *dataKey, created bool, err error) {
+	v, err := txn.Get(db.record(recordCurrent, ns))
+	if err == nil {
+		if len(v) != genSize {
+			return nil, false, ErrDecrypt
+		}
+		k, err = db.loadKey(txn, ns, binary.BigEndian.Uint32(v))
+		return k, false, err
+	} else if err != backend.ErrNotFound {
+		return nil, false, err
+	}
+	if _, err = txn.Get(db.record(recordShredded, ns)); err == nil {
+		return nil, false, ErrShredded
+	} else if err != backend.ErrNotFound {
+		return nil, false, err
+	}
+
+	id, err := db.activeMaster(txn)
+	if err != nil {
+		return nil, false, err
+	}
+	k, err = db.createKey(txn, ns, 1, id)
+	return k, true, err
+}
+
+// createKey writes a new random data key generation of ns wrapped by
+// the master key id and makes it the current generation.
+func (db *DB) createKey(txn backend.RWTxn, ns string, gen uint32, id string) (*dataKey, error) {
+	master, err := db.masterKey(id)
+	if err != nil {
+		return nil, err
+	}
+	raw := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, raw); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(raw)
+	if err != nil {
+		return nil, err
+	}
+	rec := db.keyRecord(ns, gen)
+	wrapped, err := seal(master, rec, raw, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = txn.Put(rec, wrapped); err != nil {
+		return nil, err
+	}
+	if id != "" {
+		if err = txn.Put(db.masterRecord(ns, gen), []byte(id)); err != nil {
+			return nil, err
+		}
+	}
+	if err = txn.Put(db.record(recordCurrent, ns), binary.BigEndian.AppendUint32(nil, gen)); err != nil {
+		return nil, err
+	}
+	return &dataKey{gen: gen, aead: aead}, nil
+}
+
+// decrypt returns the plain text of the value stored under key.
+func (db *DB) decrypt(txn backend.Txn, key, value []byte) ([]byte, error) {
+	if len(value) < genSize {
+		return nil, ErrDecrypt
+	}
+	k, err := db.loadKey(txn, db.namespace(key), binary.BigEndian.Uint32(value))
+	if err != nil {
+		return nil, err
+	}
+	return open(k.aead, key, value[genSize:])
+}
+
+// ShredNamespace destroys all data keys of the namespace name. Its
+// values become unrecoverable: reads fail with ErrShredded and
+// iterators skip them. New writes to the namespace fail with
+// ErrShredded as well. The encrypted values are left in place and may be
+// deleted separately.
+func (db *DB) ShredNamespace(name string) error {
+	found := name == ""
+	for _, ns := range db.namespaces {
+		found = found || ns.name == name
+	}
+	if !found {
+		return errNamespace
+	}
+
+	err := backend.Update(db.DB, func(txn backend.RWTxn) error {
+		v, err := txn.Get(db.record(recordCurrent, name))
+		if err == nil && len(v) == genSize {
+			for gen := binary.BigEndian.Uint32(v); gen > 0; gen-- {
+				if err = txn.Delete(db.keyRecord(name, gen)); err != nil {
+					return err
+				}
+				if err = txn.Delete(db.masterRecord(name, gen)); err != nil {
+					return err
+				}
+			}
+		} else if err != nil && err != backend.ErrNotFound {
+			return err
+		}
+		if err = txn.Delete(db.record(recordCurrent, name)); err != nil {
+			return err
+		}
+		return txn.Put(db.record(recordShredded, name), []byte{})
+	})
+	if err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.shredded[name] = true
+	prefix := string(db.record(recordKey, name))
+	for rec := range db.dataKeys {
+		if len(rec) == len(prefix)+genSize && rec[:len(prefix)] == prefix {
+			delete(db.dataKeys, rec)
+		}
+	}
+	return nil
+}
+
+func (db *DB) Iterator() (backend.Iterator, error) {
+	iter, err := db.DB.Iterator()
+	if err != nil {
+		return nil, err
+	}
+	txn, err := db.DB.Readonly()
+	if err != nil {
+		iter.Close()
+		return nil, err
+	}
+	return &iterator{Iterator: iter, db: db, txn: txn}, nil
+}
+
+func (db *DB) Readonly() (backend.Txn, error) {
+	txn, err := db.DB.Readonly()
+	if err != nil {
+		return nil, err
+	}
+	return &readTxn{Txn: txn, db: db}, nil
+}
+
+func (db *DB) Writable() (backend.RWTxn, error) {
+	txn, err := db.DB.Writable()
+	if err != nil {
+		return nil, err
+	}
+	return &rwTxn{RWTxn: txn, db: db}, nil
+}
+
+// iterator decrypts values, skipping metadata and shredded keys. It
+// stops at the first value that cannot be decrypted and reports the
+// error on Close. Data keys are read through a separate transaction.
+type iterator struct {
+	backend.Iterator
+	db  *DB
+	txn backend.Txn
+	err error
+}
+
+// next decrypts the pair k, v, moving on with step past keys that are
+// skipped.
+func (i *iterator) next(k, v []byte, step func() ([]byte, []byte)) ([]byte, []byte) {
+	for ; k != nil && i.err == nil; k, v = step() {
+		if bytes.HasPrefix(k, i.db.meta) {
+			continue
+		}
+		plain, err := i.db.decrypt(i.txn, k, v)
+		if err == ErrShredded {
+			continue
+		} else if err != nil {
+			i.err = err
+			break
+		}
+		return k, plain
+	}
+	return nil, nil
+}
+
+func (i *iterator) Seek(key []byte) ([]byte, []byte) {
+	k, v := i.Iterator.Seek(key)
+	return i.next(k, v, i.Iterator.Next)
+}
+
+func (i *iterator) First() ([]byte, []byte) {
+	k, v := i.Iterator.First()
+	return i.next(k, v, i.Iterator.Next)
+}
+
+func (i *iterator) Last() ([]byte, []byte) {
+	k, v := i.Iterator.Last()
+	return i.next(k, v, i.Iterator.Prev)
+}
+
+func (i *iterator) Next() ([]byte, []byte) {
+	k, v := i.Iterator.Next()
+	return i.next(k, v, i.Iterator.Next)
+}
+
+func (i *iterator) Prev() ([]byte, []byte) {
+	k, v := i.Iterator.Prev()
+	return i.next(k, v, i.Iterator.Prev)
+}
+
+// SeekToIndex steps from the first key, the underlying index counts
+// metadata and shredded keys.
+func (i *iterator) SeekToIndex(n int64) ([]byte, []byte) {
+	if n < 0 {
+		return nil, nil
+	}
+	k, v := i.First()
+	for ; k != nil && n > 0; n-- {
+		k, v = i.Next()
+	}
+	return k, v
+}
+
+func (i *iterator) Close() error {
+	err := i.Iterator.Close()
+	i.txn.Rollback()
+	if i.err != nil {
+		return i.err
+	}
+	return err
+}
+
+type readTxn struct {
+	backend.Txn
+	db *DB
+}
+
+func (t *readTxn) Get(key []byte) ([]byte, error) {
+	if bytes.HasPrefix(key, t.db.meta) {
+		return nil, backend.ErrNotFound
+	}
+	v, err := t.Txn.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return t.db.decrypt(t.Txn, key, v)
+}
+
+type rwTxn struct {
+	backend.RWTxn
+	db      *DB
+	keys    map[string]*dataKey // current data keys by namespace
+	created map[string]*dataKey // data keys created by this transaction
+}
+
+// Get decrypts values written with a data key created by the
+// transaction itself without caching the key, which is not stored
+// before commit.
+func (t *rwTxn) Get(key []byte) ([]byte, error) {
+	if bytes.HasPrefix(key, t.db.meta) {
+		return nil, backend.ErrNotFound
+	}
+	v, err := t.RWTxn.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if k := t.created[t.db.namespace(key)]; k != nil && len(v) >= genSize && binary.BigEndian.Uint32(v) == k.gen {
+		return open(k.aead, key, v[genSize:])
+	}
+	return t.db.decrypt(t.RWTxn, key, v)
+}
+
+func (t *rwTxn) Put(key, value []byte) error {
+	if bytes.HasPrefix(key, t.db.meta) {
+		return errMetadata
+	}
+	ns := t.db.namespace(key)
+	k := t.keys[ns]
+	if k == nil {
+		var err error
+		var created bool
+		if k, created, err = t.db.currentKey(t.RWTxn, ns); err != nil {
+			return err
+		}
+		if t.keys == nil {
+			t.keys, t.created = make(map[string]*dataKey), make(map[string]*dataKey)
+		}
+		if t.keys[ns] = k; created {
+			t.created[ns] = k
+		}
+	}
+	sealed, err := seal(k.aead, key, value, binary.BigEndian.AppendUint32(nil, k.gen))
+	if err != nil {
+		return err
+	}
+	return t.RWTxn.Put(key, sealed)
+}
+
+func (t *rwTxn) Delete(key []byte) error {
+	if bytes.HasPrefix(key, t.db.meta) {
+		return errMetadata
+	}
+	return t.RWTxn.Delete(key)
+}
+
+// Commit publishes the data keys created by the transaction to the
+// cache once they are stored.
+func (t *rwTxn) Commit() error {
+	if err := t.RWTxn.Commit(); err != nil {
+		return err
+	}
+	t.db.mu.Lock()
+	defer t.db.mu.Unlock()
+	for ns, k := range t.created {
+		t.db.dataKeys[string(t.db.keyRecord(ns, k.gen))] = k
+	}
+	return nil
+}
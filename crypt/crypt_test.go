@@ -0,0 +1,181 @@
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/mars9/backend"
+)
+
+func TestCrypt(t *testing.T) {
+	const path = "crypt_test.db"
+	bolt, err := backend.OpenBoltDB(path, 0)
+	if err != nil {
+		t.Fatalf("opening BoltDB %q: %v", path, err)
+	}
+	defer func() {
+		bolt.Close()
+		os.RemoveAll(path)
+	}()
+
+	master := bytes.Repeat([]byte{1}, 32)
+	db, err := New(bolt, []byte("#"), master, Namespace("acme", []byte("t/acme/")), Namespace("initech", []byte("t/initech/")))
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	get := func(db backend.DB, key string) ([]byte, error) {
+		var value []byte
+		err := backend.View(db, func(txn backend.Txn) (err error) {
+			value, err = txn.Get([]byte(key))
+			return err
+		})
+		return value, err
+	}
+
+	err = backend.Update(db, func(txn backend.RWTxn) error {
+		for _, key := range []string{"t/acme/1", "t/acme/2", "t/initech/1", "x"} {
+			if err := txn.Put([]byte(key), []byte("secret "+key)); err != nil {
+				return err
+			}
+		}
+		// values written with a new data key are readable before commit
+		v, err := txn.Get([]byte("t/acme/1"))
+		if err != nil || string(v) != "secret t/acme/1" {
+			t.Fatalf("get in transaction: %q %v", v, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if err = backend.Update(db, func(txn backend.RWTxn) error { return txn.Put([]byte("#k"), nil) }); err != errMetadata {
+		t.Fatalf("put metadata: expected errMetadata, got %v", err)
+	}
+
+	raw, err := get(bolt, "t/acme/1")
+	if err != nil || bytes.Contains(raw, []byte("secret")) {
+		t.Fatalf("stored value is not encrypted: %v", err)
+	}
+	if v, err := get(db, "t/acme/1"); err != nil || string(v) != "secret t/acme/1" {
+		t.Fatalf("get: %q %v", v, err)
+	}
+
+	// a fresh wrapper reads the wrapped data keys, a wrong master key
+	// cannot unwrap them
+	reopened, err := New(bolt, []byte("#"), master, Namespace("acme", []byte("t/acme/")))
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	if v, err := get(reopened, "x"); err != nil || string(v) != "secret x" {
+		t.Fatalf("get reopened: %q %v", v, err)
+	}
+	other, _ := New(bolt, []byte("#"), bytes.Repeat([]byte{2}, 32))
+	if _, err = get(other, "x"); err != ErrDecrypt {
+		t.Fatalf("get with other key: expected ErrDecrypt, got %v", err)
+	}
+
+	if err = db.ShredNamespace("acme"); err != nil {
+		t.Fatalf("shred: %v", err)
+	}
+	if err = db.ShredNamespace("unknown"); err != errNamespace {
+		t.Fatalf("shred unknown: expected errNamespace, got %v", err)
+	}
+	for _, d := range []*DB{db, reopened} {
+		if _, err = get(d, "t/acme/2"); err != ErrShredded {
+			t.Fatalf("get shredded: expected ErrShredded, got %v", err)
+		}
+	}
+	if err = backend.Update(db, func(txn backend.RWTxn) error { return txn.Put([]byte("t/acme/3"), nil) }); err != ErrShredded {
+		t.Fatalf("put shredded: expected ErrShredded, got %v", err)
+	}
+
+	iter, err := db.Iterator()
+	if err != nil {
+		t.Fatalf("iterator: %v", err)
+	}
+	var found []string
+	for k, v := iter.First(); k != nil; k, v = iter.Next() {
+		if string(v) != "secret "+string(k) {
+			t.Fatalf("iterator: %q has value %q", k, v)
+		}
+		found = append(found, string(k))
+	}
+	if err = iter.Close(); err != nil {
+		t.Fatalf("close iterator: %v", err)
+	}
+	if len(found) != 2 || found[0] != "t/initech/1" || found[1] != "x" {
+		t.Fatalf("iterator: expected [t/initech/1 x], got %v", found)
+	}
+}
+
+func TestRotateEncryptionKey(t *testing.T) {
+	const path = "crypt_rotate_test.db"
+	bolt, err := backend.OpenBoltDB(path, 0)
+	if err != nil {
+		t.Fatalf("opening BoltDB %q: %v", path, err)
+	}
+	defer func() {
+		bolt.Close()
+		os.RemoveAll(path)
+	}()
+
+	oldKey, newKey := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	db, err := New(bolt, []byte("#"), oldKey, MasterKey("2024", newKey), Namespace("a", []byte("a/")))
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	err = backend.Update(db, func(txn backend.RWTxn) error {
+		for _, key := range []string{"a/1", "a/2", "a/3", "b"} {
+			if err := txn.Put([]byte(key), []byte(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	if _, err = db.RotateEncryptionKey(context.Background(), "unknown"); err != errMasterKey {
+		t.Fatalf("rotate: expected errMasterKey, got %v", err)
+	}
+
+	// an interrupted rotation is resumed, writes in between use the new
+	// generation
+	ctx, cancel := context.WithCancel(context.Background())
+	n, err := db.RotateEncryptionKey(ctx, "2024", RotateBatch(1), RotateProgress(func(int64) { cancel() }))
+	if err != context.Canceled || n != 1 {
+		t.Fatalf("rotate: expected 1 value and context.Canceled, got %d %v", n, err)
+	}
+	if err = backend.Update(db, func(txn backend.RWTxn) error { return txn.Put([]byte("a/4"), []byte("a/4")) }); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if _, err = db.RotateEncryptionKey(context.Background(), ""); err != errRotating {
+		t.Fatalf("rotate: expected errRotating, got %v", err)
+	}
+	if n, err = db.RotateEncryptionKey(context.Background(), "2024"); err != nil || n != 3 {
+		t.Fatalf("rotate: expected 3 values, got %d %v", n, err)
+	}
+
+	// only the new master key is needed to read all values
+	rotated, err := New(bolt, []byte("#"), bytes.Repeat([]byte{9}, 32), MasterKey("2024", newKey), Namespace("a", []byte("a/")))
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	iter, err := rotated.Iterator()
+	if err != nil {
+		t.Fatalf("iterator: %v", err)
+	}
+	count := 0
+	for k, v := iter.First(); k != nil; k, v = iter.Next() {
+		if !bytes.Equal(k, v) {
+			t.Fatalf("iterator: %q has value %q", k, v)
+		}
+		count++
+	}
+	if err = iter.Close(); err != nil || count != 5 {
+		t.Fatalf("iterator: expected 5 values, got %d (%v)", count, err)
+	}
+}
@@ -0,0 +1,154 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeyProvider returns master keys by id, e.g. from the environment, a
+// secrets directory or a key management service.
+type KeyProvider interface {
+	GetKey(id string) ([]byte, error)
+}
+
+// KeyProviderFunc adapts a function, e.g. a call to a cloud KMS, to a
+// KeyProvider.
+type KeyProviderFunc func(id string) ([]byte, error)
+
+func (f KeyProviderFunc) GetKey(id string) ([]byte, error) { return f(id) }
+
+// defaultKeyName names the key with the empty id in environment
+// variables and files.
+const defaultKeyName = "default"
+
+func keyName(id string) string {
+	if id == "" {
+		return defaultKeyName
+	}
+	return id
+}
+
+// EnvKeys returns a provider reading the key id from the environment
+// variable prefix + id, or prefix + "default" for the empty id. Values
+// are base64 encoded.
+func EnvKeys(prefix string) KeyProvider {
+	return KeyProviderFunc(func(id string) ([]byte, error) {
+		v, ok := os.LookupEnv(prefix + keyName(id))
+		if !ok {
+			return nil, errMasterKey
+		}
+		return base64.StdEncoding.DecodeString(strings.TrimSpace(v))
+	})
+}
+
+// FileKeys returns a provider reading the key id from the file id, or
+// "default" for the empty id, in dir. Files hold the base64 encoded key,
+// e.g. as mounted from a secret store. Ids must not contain path
+// separators.
+func FileKeys(dir string) KeyProvider {
+	return KeyProviderFunc(func(id string) ([]byte, error) {
+		name := keyName(id)
+		if strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+			return nil, errMasterKey
+		}
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			return nil, errMasterKey
+		} else if err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+	})
+}
+
+// CachedKeys returns a provider caching the keys of p for ttl. If a
+// refresh fails, the expired key keeps being returned until a refresh
+// succeeds, so an unavailable key service does not stop reads and
+// writes.
+func CachedKeys(p KeyProvider, ttl time.Duration) KeyProvider {
+	return &keyCache{p: p, ttl: ttl, keys: make(map[string]cachedKey)}
+}
+
+type cachedKey struct {
+	key     []byte
+	expires time.Time
+}
+
+type keyCache struct {
+	p   KeyProvider
+	ttl time.Duration
+
+	mu   sync.Mutex
+	keys map[string]cachedKey
+}
+
+func (c *keyCache) GetKey(id string) ([]byte, error) {
+	c.mu.Lock()
+	k, ok := c.keys[id]
+	c.mu.Unlock()
+	if ok && time.Now().Before(k.expires) {
+		return k.key, nil
+	}
+
+	key, err := c.p.GetKey(id)
+	if err != nil {
+		if ok {
+			return k.key, nil
+		}
+		return nil, err
+	}
+	c.mu.Lock()
+	c.keys[id] = cachedKey{key: key, expires: time.Now().Add(c.ttl)}
+	c.mu.Unlock()
+	return key, nil
+}
+
+// Keys fetches master keys not registered with New or MasterKey from p.
+// If the master key passed to New is nil, the key with the empty id is
+// fetched from p as well.
+func Keys(p KeyProvider) Option {
+	return func(db *DB) error {
+		if p == nil {
+			return errors.New("crypt: nil key provider")
+		}
+		db.provider = p
+		return nil
+	}
+}
+
+// providerKey is a master key fetched from the provider.
+type providerKey struct {
+	key  []byte
+	aead cipher.AEAD
+}
+
+// fetchKey returns the master key id from the provider, reusing the
+// cipher while the provider returns the same key.
+func (db *DB) fetchKey(id string) (cipher.AEAD, error) {
+	key, err := db.provider.GetKey(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != 32 {
+		return nil, errors.New("crypt: master key must be 32 bytes")
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if k, ok := db.fetched[id]; ok && bytes.Equal(k.key, key) {
+		return k.aead, nil
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	db.fetched[id] = providerKey{key: append([]byte{}, key...), aead: aead}
+	return aead, nil
+}
@@ -0,0 +1,112 @@
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mars9/backend"
+)
+
+func TestKeyProviders(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	encoded := base64.StdEncoding.EncodeToString(key)
+
+	t.Setenv("TEST_CRYPT_KEY_default", encoded)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "2024"), []byte(encoded+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for name, p := range map[string]struct {
+		p  KeyProvider
+		id string
+	}{
+		"env":  {EnvKeys("TEST_CRYPT_KEY_"), ""},
+		"file": {FileKeys(dir), "2024"},
+	} {
+		got, err := p.p.GetKey(p.id)
+		if err != nil || !bytes.Equal(got, key) {
+			t.Fatalf("%s: get key: %x %v", name, got, err)
+		}
+		if _, err = p.p.GetKey("missing"); err != errMasterKey {
+			t.Fatalf("%s: get missing key: expected errMasterKey, got %v", name, err)
+		}
+	}
+	if _, err := FileKeys(dir).GetKey("../2024"); err != errMasterKey {
+		t.Fatalf("file: get key outside dir: expected errMasterKey, got %v", err)
+	}
+
+	// cached keys outlive a failing provider
+	calls, fail := 0, false
+	cached := CachedKeys(KeyProviderFunc(func(id string) ([]byte, error) {
+		calls++
+		if fail {
+			return nil, errors.New("unavailable")
+		}
+		return key, nil
+	}), time.Nanosecond)
+	for i := 0; i < 2; i++ {
+		if _, err := cached.GetKey("k"); err != nil {
+			t.Fatalf("cached: get key: %v", err)
+		}
+		fail = true
+	}
+	if calls != 2 {
+		t.Fatalf("cached: expected 2 refreshes, got %d", calls)
+	}
+	if _, err := CachedKeys(KeyProviderFunc(func(string) ([]byte, error) { return key, nil }), time.Hour).GetKey("k"); err != nil {
+		t.Fatalf("cached: get key: %v", err)
+	}
+}
+
+func TestProviderMasterKey(t *testing.T) {
+	const path = "crypt_provider_test.db"
+	bolt, err := backend.OpenBoltDB(path, 0)
+	if err != nil {
+		t.Fatalf("opening BoltDB %q: %v", path, err)
+	}
+	defer func() {
+		bolt.Close()
+		os.RemoveAll(path)
+	}()
+
+	if _, err = New(bolt, []byte("#"), nil); err == nil {
+		t.Fatal("new without master key: expected error")
+	}
+	keys := map[string][]byte{"": bytes.Repeat([]byte{1}, 32), "next": bytes.Repeat([]byte{2}, 32)}
+	db, err := New(bolt, []byte("#"), nil, Keys(KeyProviderFunc(func(id string) ([]byte, error) {
+		if k, ok := keys[id]; ok {
+			return k, nil
+		}
+		return nil, errMasterKey
+	})))
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	if err = backend.Update(db, func(txn backend.RWTxn) error { return txn.Put([]byte("k"), []byte("v")) }); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if _, err = db.RotateEncryptionKey(context.Background(), "next"); err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+
+	static, err := New(bolt, []byte("#"), nil, MasterKey("next", keys["next"]), Keys(EnvKeys("TEST_CRYPT_UNSET_")))
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	err = backend.View(static, func(txn backend.Txn) error {
+		v, err := txn.Get([]byte("k"))
+		if err == nil && string(v) != "v" {
+			t.Fatalf("get: expected v, got %q", v)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+}
@@ -0,0 +1,290 @@
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+
+	"github.com/mars9/backend"
+)
+
+const defaultRotateBatch = 1000
+
+var errRotating = errors.New("crypt: rotation to another master key in progress")
+
+// recordRotation holds the master key id and the last key re-encrypted
+// by an unfinished rotation.
+const recordRotation = 'r'
+
+// RotateOption configures RotateEncryptionKey.
+type RotateOption func(*rotation) error
+
+type rotation struct {
+	batch    int
+	progress func(keys int64)
+}
+
+// RotateBatch sets the number of keys re-encrypted per transaction.
+func RotateBatch(n int) RotateOption {
+	return func(r *rotation) error {
+		if n <= 0 {
+			return errors.New("crypt: rotate batch must be positive")
+		}
+		r.batch = n
+		return nil
+	}
+}
+
+// RotateProgress calls fn after every batch with the number of keys
+// checked so far.
+func RotateProgress(fn func(keys int64)) RotateOption {
+	return func(r *rotation) error {
+		r.progress = fn
+		return nil
+	}
+}
+
+func (db *DB) rotationRecord() []byte {
+	return append(append([]byte{}, db.meta...), recordRotation)
+}
+
+// RotateEncryptionKey makes the registered master key newKeyID the
+// active one. Every namespace gets a new data key generation wrapped by
+// it, which encrypts all further writes, and the values are then
+// re-encrypted in batches while the DB stays in use. Values of older
+// generations remain readable until their batch is rewritten; once all
+// values are, the old data keys are destroyed.
+//
+// The position of the sweep is stored with every batch, calling
+// RotateEncryptionKey again with the same id after a failure or
+// cancellation continues where it stopped. It returns the number of
+// values re-encrypted.
+func (db *DB) RotateEncryptionKey(ctx context.Context, newKeyID string, opts ...RotateOption) (int64, error) {
+	r := &rotation{batch: defaultRotateBatch}
+	for _, opt := range opts {
+		if err := opt(r); err != nil {
+			return 0, err
+		}
+	}
+	if _, err := db.masterKey(newKeyID); err != nil {
+		return 0, err
+	}
+
+	// start or resume, rotating all namespaces to new generations
+	var last []byte
+	current := make(map[string]uint32)
+	err := backend.Update(db.DB, func(txn backend.RWTxn) error {
+		v, err := txn.Get(db.rotationRecord())
+		if err == nil {
+			id, pos, ok := decodeRotation(v)
+			if !ok {
+				return ErrDecrypt
+			}
+			if id != newKeyID {
+				return errRotating
+			}
+			last = pos
+		} else if err != backend.ErrNotFound {
+			return err
+		}
+
+		for _, ns := range db.names() {
+			gen, err := db.generation(txn, ns)
+			if err == ErrShredded {
+				continue
+			} else if err != nil {
+				return err
+			}
+			if gen == 0 {
+				continue // no values, new writes use the active key
+			}
+			if v == nil {
+				// resumed rotations created their generations already
+				gen++
+				if _, err = db.createKey(txn, ns, gen, newKeyID); err != nil {
+					return err
+				}
+			}
+			current[ns] = gen
+		}
+		if err = txn.Put(append(append([]byte{}, db.meta...), recordActive), []byte(newKeyID)); err != nil {
+			return err
+		}
+		if v == nil {
+			return txn.Put(db.rotationRecord(), encodeRotation(newKeyID, nil))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var n, checked int64
+	for {
+		if err = ctx.Err(); err != nil {
+			return n, err
+		}
+		batch, err := db.collect(last, r.batch)
+		if err != nil {
+			return n, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+		last = batch[len(batch)-1]
+
+		var rewritten int64
+		err = backend.Update(db.DB, func(txn backend.RWTxn) error {
+			rewritten = 0
+			for _, key := range batch {
+				ok, err := db.reencrypt(txn, key, current)
+				if err != nil {
+					return err
+				}
+				if ok {
+					rewritten++
+				}
+			}
+			return txn.Put(db.rotationRecord(), encodeRotation(newKeyID, last))
+		})
+		if err != nil {
+			return n, err
+		}
+		n += rewritten
+		checked += int64(len(batch))
+		if r.progress != nil {
+			r.progress(checked)
+		}
+	}
+
+	// all values use the current generations, destroy the older ones
+	err = backend.Update(db.DB, func(txn backend.RWTxn) error {
+		for ns, gen := range current {
+			for g := gen - 1; g > 0; g-- {
+				if err := txn.Delete(db.keyRecord(ns, g)); err != nil {
+					return err
+				}
+				if err := txn.Delete(db.masterRecord(ns, g)); err != nil {
+					return err
+				}
+			}
+		}
+		return txn.Delete(db.rotationRecord())
+	})
+	if err != nil {
+		return n, err
+	}
+	db.mu.Lock()
+	for ns, gen := range current {
+		for g := gen - 1; g > 0; g-- {
+			delete(db.dataKeys, string(db.keyRecord(ns, g)))
+		}
+	}
+	db.mu.Unlock()
+	return n, nil
+}
+
+// names returns all namespace names including the default namespace.
+func (db *DB) names() []string {
+	names := []string{""}
+	for _, ns := range db.namespaces {
+		names = append(names, ns.name)
+	}
+	return names
+}
+
+// generation returns the current data key generation of ns, 0 if it has
+// none.
+func (db *DB) generation(txn backend.Txn, ns string) (uint32, error) {
+	if _, err := txn.Get(db.record(recordShredded, ns)); err == nil {
+		return 0, ErrShredded
+	} else if err != backend.ErrNotFound {
+		return 0, err
+	}
+	v, err := txn.Get(db.record(recordCurrent, ns))
+	if err == backend.ErrNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if len(v) != genSize {
+		return 0, ErrDecrypt
+	}
+	return binary.BigEndian.Uint32(v), nil
+}
+
+// collect returns up to n keys outside the metadata prefix following
+// last.
+func (db *DB) collect(last []byte, n int) ([][]byte, error) {
+	iter, err := db.DB.Iterator()
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var start []byte
+	if last != nil {
+		start = append(append([]byte{}, last...), 0x00)
+	}
+	var batch [][]byte
+	for k, _ := iter.Seek(start); k != nil && len(batch) < n; k, _ = iter.Next() {
+		if !bytes.HasPrefix(k, db.meta) {
+			batch = append(batch, append([]byte{}, k...))
+		}
+	}
+	return batch, iter.Close()
+}
+
+// reencrypt rewrites the value of key with the current generation of
+// its namespace, reporting whether it was rewritten. Values of shredded
+// namespaces are left alone.
+func (db *DB) reencrypt(txn backend.RWTxn, key []byte, current map[string]uint32) (bool, error) {
+	ns := db.namespace(key)
+	gen, ok := current[ns]
+	if !ok {
+		return false, nil
+	}
+	v, err := txn.Get(key)
+	if err == backend.ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if len(v) >= genSize && binary.BigEndian.Uint32(v) == gen {
+		return false, nil
+	}
+
+	plain, err := db.decrypt(txn, key, v)
+	if err != nil {
+		return false, err
+	}
+	k, err := db.loadKey(txn, ns, gen)
+	if err != nil {
+		return false, err
+	}
+	sealed, err := seal(k.aead, key, plain, binary.BigEndian.AppendUint32(nil, gen))
+	if err != nil {
+		return false, err
+	}
+	return true, txn.Put(key, sealed)
+}
+
+func encodeRotation(id string, last []byte) []byte {
+	v := binary.AppendUvarint(nil, uint64(len(id)))
+	return append(append(v, id...), last...)
+}
+
+func decodeRotation(v []byte) (id string, last []byte, ok bool) {
+	n, i := binary.Uvarint(v)
+	if i <= 0 || n > uint64(len(v)-i) {
+		return "", nil, false
+	}
+	id, last = string(v[i:i+int(n)]), v[i+int(n):]
+	if len(last) == 0 {
+		last = nil
+	} else {
+		last = append([]byte{}, last...)
+	}
+	return id, last, true
+}
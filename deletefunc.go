@@ -0,0 +1,148 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"time"
+)
+
+// defaultDeleteBatch is the default number of keys deleted per write
+// transaction by DeleteFunc.
+const defaultDeleteBatch = 1000
+
+// DeleteOption configures a DeleteFunc.
+type DeleteOption func(*deleter) error
+
+type deleter struct {
+	batch int           // maximum number of keys per write transaction
+	pause time.Duration // pause between write transactions
+}
+
+// DeleteBatch deletes at most n keys per write transaction.
+func DeleteBatch(n int) DeleteOption {
+	return func(d *deleter) error {
+		if n <= 0 {
+			return errors.New("delete batch size must be positive")
+		}
+		d.batch = n
+		return nil
+	}
+}
+
+// DeleteThrottle pauses for d after every committed batch, leaving room
+// for other writers.
+func DeleteThrottle(d time.Duration) DeleteOption {
+	return func(del *deleter) error {
+		if d < 0 {
+			return errors.New("negative delete throttle")
+		}
+		del.pause = d
+		return nil
+	}
+}
+
+// DeleteFunc deletes all keys starting with prefix for which pred returns
+// true and returns the number of deleted keys. Keys are deleted in
+// batches, each committed in its own write transaction, so a large
+// deletion neither holds the write lock nor buffers all keys at once.
+// Keys are collected from a read snapshot and checked by pred again in
+// the write transaction, so pred may see a key twice. The key and value
+// passed to pred are only valid during the call.
+//
+// DeleteFunc stops between batches and returns ctx.Err() if ctx is done;
+// batches committed so far stay deleted.
+func DeleteFunc(ctx context.Context, db DB, prefix []byte, pred func(k, v []byte) bool, opts ...DeleteOption) (int64, error) {
+	d := &deleter{batch: defaultDeleteBatch}
+	for _, opt := range opts {
+		if err := opt(d); err != nil {
+			return 0, err
+		}
+	}
+
+	deleted := int64(0)
+	start := append([]byte{}, prefix...)
+	for {
+		if err := ctx.Err(); err != nil {
+			return deleted, err
+		}
+
+		keys, next, err := d.collect(db, start, prefix, pred)
+		if err != nil {
+			return deleted, err
+		}
+		if len(keys) > 0 {
+			n, err := deleteMatching(db, keys, pred)
+			if err != nil {
+				return deleted, err
+			}
+			deleted += n
+		}
+		if next == nil {
+			return deleted, nil
+		}
+		start = next
+
+		if d.pause > 0 {
+			t := time.NewTimer(d.pause)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return deleted, ctx.Err()
+			case <-t.C:
+			}
+		}
+	}
+}
+
+// deleteMatching deletes the keys still matching pred in a single write
+// transaction and returns how many it deleted. A key may have been
+// changed or deleted since it was collected.
+func deleteMatching(db DB, keys [][]byte, pred func(k, v []byte) bool) (int64, error) {
+	txn, err := db.Writable()
+	if err != nil {
+		return 0, err
+	}
+	n := int64(0)
+	for _, k := range keys {
+		v, err := txn.Get(k)
+		if err == ErrNotFound {
+			continue
+		} else if err != nil {
+			txn.Rollback()
+			return 0, err
+		}
+		if !pred(k, v) {
+			continue
+		}
+		if err = txn.Delete(k); err != nil {
+			txn.Rollback()
+			return 0, err
+		}
+		n++
+	}
+	return n, txn.Commit()
+}
+
+// collect returns up to d.batch keys matching pred, starting at start,
+// and the key to continue at, or nil if the prefix was exhausted.
+func (d *deleter) collect(db DB, start, prefix []byte, pred func(k, v []byte) bool) ([][]byte, []byte, error) {
+	iter, err := db.Iterator()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer iter.Close()
+
+	var keys [][]byte
+	for k, v := iter.Seek(start); k != nil && bytes.HasPrefix(k, prefix); k, v = iter.Next() {
+		if !pred(k, v) {
+			continue
+		}
+		keys = append(keys, append([]byte{}, k...))
+		if len(keys) == d.batch {
+			// continue right after the last collected key
+			return keys, append(append([]byte{}, k...), 0), iter.Close()
+		}
+	}
+	return keys, nil, iter.Close()
+}
@@ -0,0 +1,41 @@
+package backend
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDeleteFuncRewritten(t *testing.T) {
+	db := NewMemDB()
+	defer db.Close()
+	for _, key := range []string{"k/1", "k/2"} {
+		if err := Update(db, func(txn RWTxn) error { return txn.Put([]byte(key), []byte("stale")) }); err != nil {
+			t.Fatalf("put %s: %v", key, err)
+		}
+	}
+
+	// k/1 is rewritten after it was collected, k/2 is deleted
+	rewritten := false
+	stale := func(k, v []byte) bool {
+		if !rewritten {
+			rewritten = true
+			if err := Update(db, func(txn RWTxn) error {
+				if err := txn.Put([]byte("k/1"), []byte("fresh")); err != nil {
+					return err
+				}
+				return txn.Delete([]byte("k/2"))
+			}); err != nil {
+				t.Fatalf("rewrite: %v", err)
+			}
+		}
+		return string(v) == "stale"
+	}
+	n, err := DeleteFunc(context.Background(), db, []byte("k/"), stale)
+	if err != nil || n != 0 {
+		t.Fatalf("delete func: expected no deleted keys, got %d, %v", n, err)
+	}
+	pairs, err := Scan(db, []byte("k/"))
+	if err != nil || len(pairs) != 1 || string(pairs[0].Value) != "fresh" {
+		t.Fatalf("scan: expected k/1 => fresh, got %q, %v", pairs, err)
+	}
+}
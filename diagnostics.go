@@ -0,0 +1,168 @@
+package backend
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"runtime/pprof"
+	"strings"
+	"time"
+)
+
+// diagnoser is implemented by databases adding their own files, such as
+// statistics and logs, to a diagnostics bundle.
+type diagnoser interface {
+	diagnostics(b *bundle) error
+}
+
+// bundle writes the files of one database layer into a diagnostics
+// archive.
+type bundle struct {
+	z   *zip.Writer
+	dir string
+}
+
+func (b *bundle) create(name string) (io.Writer, error) {
+	return b.z.CreateHeader(&zip.FileHeader{
+		Name:     b.dir + name,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+}
+
+func (b *bundle) writeJSON(name string, v interface{}) error {
+	w, err := b.create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// writeTail adds the last max bytes of the file at path.
+func (b *bundle) writeTail(name, path string, max int64) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err != nil {
+		return err
+	} else if fi.Size() > max {
+		if _, err = f.Seek(-max, io.SeekEnd); err != nil {
+			return err
+		}
+	}
+	w, err := b.create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// unwrapDB returns the database wrapped by db, or nil if db wraps none.
+func unwrapDB(db DB) DB {
+	switch w := db.(type) {
+	case *OpLog:
+		return w.DB
+	case *Recorder:
+		return w.DB
+	case *AdmissionDB:
+		return w.DB
+	case *BudgetDB:
+		return w.DB
+	case *CountedDB:
+		return w.DB
+	case *FencedDB:
+		return w.DB
+	case *FallbackDB:
+		return w.DB
+	case *Tiered:
+		return w.DB
+	case *Mirror:
+		return w.DB
+	}
+	return nil
+}
+
+// DiagnosticsBundle writes a zip archive describing db and the process to
+// w, to be attached to support tickets. It holds the Go runtime version,
+// the goroutine stacks and a directory for each layer of db, outermost
+// first, with the files the layer provides: statistics of LevelDB and
+// BoltDB including Bolt page usage, the tail of the LevelDB LOG and the
+// operations kept by an OpLog. A layer failing to describe itself gets an
+// error.txt instead, the bundle is written anyway.
+func DiagnosticsBundle(w io.Writer, db DB) error {
+	return writeDiagnostics(w, db, nil)
+}
+
+// DiagnosticsOnPanic writes a diagnostics bundle of db, including the
+// panic value and stack, to w if the goroutine panics and continues
+// panicking. It must be deferred directly:
+//
+//	defer backend.DiagnosticsOnPanic(f, db)
+func DiagnosticsOnPanic(w io.Writer, db DB) {
+	if r := recover(); r != nil {
+		writeDiagnostics(w, db, &panicInfo{Value: fmt.Sprint(r), Stack: string(debug.Stack())})
+		panic(r)
+	}
+}
+
+type panicInfo struct {
+	Value string
+	Stack string
+}
+
+func writeDiagnostics(w io.Writer, db DB, p *panicInfo) error {
+	z := zip.NewWriter(w)
+	b := &bundle{z: z}
+
+	var layers []string
+	for l := db; l != nil; l = unwrapDB(l) {
+		layers = append(layers, fmt.Sprintf("%T", l))
+	}
+	if err := b.writeJSON("bundle.json", struct {
+		Time       time.Time
+		GoVersion  string
+		GOOS       string
+		GOARCH     string
+		NumCPU     int
+		Goroutines int
+		Layers     []string
+		Panic      *panicInfo `json:",omitempty"`
+	}{time.Now(), runtime.Version(), runtime.GOOS, runtime.GOARCH,
+		runtime.NumCPU(), runtime.NumGoroutine(), layers, p}); err != nil {
+		return err
+	}
+
+	gw, err := b.create("goroutines.txt")
+	if err != nil {
+		return err
+	}
+	if err = pprof.Lookup("goroutine").WriteTo(gw, 2); err != nil {
+		return err
+	}
+
+	for i, l := 0, db; l != nil; i, l = i+1, unwrapDB(l) {
+		d, ok := l.(diagnoser)
+		if !ok {
+			continue
+		}
+		name := strings.TrimPrefix(layers[i], "*backend.")
+		lb := &bundle{z: z, dir: fmt.Sprintf("%d-%s/", i, name)}
+		if err = d.diagnostics(lb); err != nil {
+			ew, cerr := lb.create("error.txt")
+			if cerr != nil {
+				return cerr
+			}
+			io.WriteString(ew, err.Error()+"\n")
+		}
+	}
+	return z.Close()
+}
@@ -0,0 +1,89 @@
+package backend
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readBundle(t *testing.T, data []byte) map[string]string {
+	z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("read bundle: %v", err)
+	}
+	files := make(map[string]string)
+	for _, f := range z.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("open %s: %v", f.Name, err)
+		}
+		b, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("read %s: %v", f.Name, err)
+		}
+		files[f.Name] = string(b)
+	}
+	return files
+}
+
+func TestDiagnosticsBundle(t *testing.T) {
+	dir, err := os.MkdirTemp("", "diagnostics")
+	if err != nil {
+		t.Fatalf("temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bolt.db")
+	bolt := openBoltDB(t, path)
+	defer closeBoltDB(t, path, bolt)
+	db, err := NewOpLog(bolt, 8)
+	if err != nil {
+		t.Fatalf("new op log: %v", err)
+	}
+	if err = Update(db, func(txn RWTxn) error { return txn.Put([]byte("a"), []byte("1")) }); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err = DiagnosticsBundle(&buf, db); err != nil {
+		t.Fatalf("diagnostics bundle: %v", err)
+	}
+	files := readBundle(t, buf.Bytes())
+	for _, name := range []string{"bundle.json", "goroutines.txt", "0-OpLog/ops.txt", "1-BoltDB/stats.json"} {
+		if _, ok := files[name]; !ok {
+			t.Fatalf("bundle lacks %s, has %v", name, files)
+		}
+	}
+	var info struct{ Layers []string }
+	if err = json.Unmarshal([]byte(files["bundle.json"]), &info); err != nil {
+		t.Fatalf("decode bundle.json: %v", err)
+	}
+	if got := strings.Join(info.Layers, ","); got != "*backend.OpLog,*backend.BoltDB" {
+		t.Fatalf("layers: got %s", got)
+	}
+	if !strings.Contains(files["0-OpLog/ops.txt"], " commit ") {
+		t.Fatalf("ops.txt lacks the commit:\n%s", files["0-OpLog/ops.txt"])
+	}
+	if !strings.Contains(files["1-BoltDB/stats.json"], path) {
+		t.Fatalf("stats.json lacks the path:\n%s", files["1-BoltDB/stats.json"])
+	}
+
+	buf.Reset()
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Fatalf("recover: expected boom, got %v", r)
+			}
+		}()
+		defer DiagnosticsOnPanic(&buf, db)
+		panic("boom")
+	}()
+	if files = readBundle(t, buf.Bytes()); !strings.Contains(files["bundle.json"], `"boom"`) {
+		t.Fatalf("bundle.json lacks the panic:\n%s", files["bundle.json"])
+	}
+}
@@ -0,0 +1,487 @@
+// Package dynamostore implements backend.DB on an Amazon DynamoDB table.
+//
+// Keys are stored as items of a single partition, so DynamoDB keeps them
+// ordered by their sort key and iterators page through a Query. The
+// table needs a string partition key named "pk" and a binary sort key
+// named "sk"; several databases share a table under different
+// namespaces, which become the partition key. Values are stored in the
+// binary attribute "v".
+//
+// All keys of a namespace live in one partition and share its
+// throughput, which DynamoDB limits to about 3000 reads and 1000 writes
+// per second.
+package dynamostore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/mars9/backend"
+)
+
+var _ backend.DB = (*DB)(nil)
+
+// maxTxnItems is the maximum number of items written by one
+// TransactWriteItems request.
+const maxTxnItems = 100
+
+var (
+	errEmptyKey    = errors.New("dynamostore: empty key")
+	errTxnTooLarge = errors.New("dynamostore: transaction writes more than 100 keys")
+	errInvalidItem = errors.New("dynamostore: invalid item")
+)
+
+const (
+	attrPartition = "pk"
+	attrSort      = "sk"
+	attrValue     = "v"
+)
+
+// Client is the subset of the DynamoDB API used by DB, implemented by
+// *dynamodb.Client.
+type Client interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	Query(ctx context.Context, params *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error)
+	TransactWriteItems(ctx context.Context, params *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error)
+}
+
+// Option configures a DB.
+type Option func(*DB) error
+
+// PageSize sets the number of keys an iterator reads per Query. The
+// default is 100.
+func PageSize(n int) Option {
+	return func(db *DB) error {
+		if n <= 0 {
+			return errors.New("dynamostore: page size must be positive")
+		}
+		db.pageSize = int32(n)
+		return nil
+	}
+}
+
+// DB is a database stored in a DynamoDB table. Reads are strongly
+// consistent but not isolated: DynamoDB has no snapshots, so iterators
+// and transactions observe writes committed after they started. Write
+// transactions buffer their writes and commit them atomically with a
+// single TransactWriteItems request, which limits a transaction to 100
+// distinct keys. Write transactions of a DB are serialized; writers of
+// other processes sharing the namespace are not, the last commit of a
+// key wins.
+type DB struct {
+	client    Client
+	table     *string
+	namespace types.AttributeValue
+	pageSize  int32
+
+	writer sync.Mutex   // held for the life of a write transaction
+	mu     sync.RWMutex // guards closed
+	closed bool
+	refs   sync.WaitGroup // open iterators and transactions
+}
+
+// New returns a DB storing its keys in the namespace of table.
+func New(client Client, table, namespace string, opts ...Option) (*DB, error) {
+	if namespace == "" {
+		return nil, errors.New("dynamostore: empty namespace")
+	}
+	db := &DB{
+		client:    client,
+		table:     aws.String(table),
+		namespace: &types.AttributeValueMemberS{Value: namespace},
+		pageSize:  100,
+	}
+	for _, opt := range opts {
+		if err := opt(db); err != nil {
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// acquire registers an iterator or transaction, which must call release
+// when it ends.
+func (db *DB) acquire() error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return backend.ErrDBClosed
+	}
+	db.refs.Add(1)
+	return nil
+}
+
+func (db *DB) release() { db.refs.Done() }
+
+func (db *DB) key(key []byte) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		attrPartition: db.namespace,
+		attrSort:      &types.AttributeValueMemberB{Value: key},
+	}
+}
+
+func (db *DB) get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, backend.ErrNotFound
+	}
+	out, err := db.client.GetItem(context.Background(), &dynamodb.GetItemInput{
+		TableName:      db.table,
+		Key:            db.key(key),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, backend.ErrNotFound
+	}
+	_, value, err := decodeItem(out.Item)
+	return value, err
+}
+
+// decodeItem returns the key and value of a stored item.
+func decodeItem(item map[string]types.AttributeValue) ([]byte, []byte, error) {
+	k, ok := item[attrSort].(*types.AttributeValueMemberB)
+	if !ok {
+		return nil, nil, errInvalidItem
+	}
+	v, ok := item[attrValue].(*types.AttributeValueMemberB)
+	if !ok {
+		return nil, nil, errInvalidItem
+	}
+	value := v.Value
+	if value == nil {
+		value = []byte{}
+	}
+	return k.Value, value, nil
+}
+
+func (db *DB) Iterator() (backend.Iterator, error) {
+	if err := db.acquire(); err != nil {
+		return nil, err
+	}
+	return &iterator{db: db, pos: -1}, nil
+}
+
+func (db *DB) Readonly() (backend.Txn, error) {
+	if err := db.acquire(); err != nil {
+		return nil, err
+	}
+	return &readTxn{db: db}, nil
+}
+
+// Writable starts a new write transaction. It blocks while another
+// write transaction of the DB is open.
+func (db *DB) Writable() (backend.RWTxn, error) {
+	db.writer.Lock()
+	if err := db.acquire(); err != nil {
+		db.writer.Unlock()
+		return nil, err
+	}
+	return &txn{readTxn: readTxn{db: db}, writes: make(map[string]*write)}, nil
+}
+
+// WriteTo writes the entire database to w using the export format. See
+// backend.Export for details.
+func (db *DB) WriteTo(w io.Writer) (int64, error) {
+	return backend.Export(w, db)
+}
+
+func (db *DB) Name() string { return "DynamoDB" }
+
+// Close waits for open iterators and transactions. The table and its
+// items are left untouched.
+func (db *DB) Close() error {
+	if db == nil {
+		return errors.New("closing unopened DynamoDB instance")
+	}
+	db.mu.Lock()
+	if db.closed {
+		db.mu.Unlock()
+		return backend.ErrDBClosed
+	}
+	db.closed = true
+	db.mu.Unlock()
+	db.refs.Wait()
+	return nil
+}
+
+// readTxn is a read-only transaction. Its write methods only exist to
+// reject writes after a type assertion to RWTxn.
+type readTxn struct {
+	db *DB // nil after the transaction ended
+}
+
+func (t *readTxn) Get(key []byte) ([]byte, error) {
+	if t == nil || t.db == nil {
+		return nil, errors.New("get from unopened transaction")
+	}
+	return t.db.get(key)
+}
+
+func (t *readTxn) Rollback() error {
+	if t == nil || t.db == nil {
+		return nil
+	}
+	t.db.release()
+	t.db = nil
+	return nil
+}
+
+func (t *readTxn) Put(key, value []byte) error { return backend.ErrReadOnlyTxn }
+func (t *readTxn) Delete(key []byte) error     { return backend.ErrReadOnlyTxn }
+func (t *readTxn) Commit() error               { return backend.ErrReadOnlyTxn }
+
+// write is a buffered put, or a delete if deleted is set.
+type write struct {
+	value   []byte
+	deleted bool
+}
+
+// txn is a write transaction. DynamoDB rejects transactions writing an
+// item twice, so only the last write of each key is kept.
+type txn struct {
+	readTxn
+	writes map[string]*write
+}
+
+func (t *txn) Get(key []byte) ([]byte, error) {
+	if t == nil || t.db == nil {
+		return nil, errors.New("get from unopened transaction")
+	}
+	if w, ok := t.writes[string(key)]; ok {
+		if w.deleted {
+			return nil, backend.ErrNotFound
+		}
+		return w.value, nil
+	}
+	return t.db.get(key)
+}
+
+func (t *txn) Put(key, value []byte) error {
+	if t == nil || t.db == nil {
+		return errors.New("put in unopened transaction")
+	}
+	if len(key) == 0 {
+		return errEmptyKey
+	}
+	t.writes[string(key)] = &write{value: append([]byte{}, value...)}
+	return nil
+}
+
+func (t *txn) Delete(key []byte) error {
+	if t == nil || t.db == nil {
+		return errors.New("delete in unopened transaction")
+	}
+	if len(key) == 0 {
+		return nil
+	}
+	t.writes[string(key)] = &write{deleted: true}
+	return nil
+}
+
+func (t *txn) Rollback() error {
+	if t == nil || t.db == nil {
+		return nil
+	}
+	defer t.db.writer.Unlock()
+	t.writes = nil
+	return t.readTxn.Rollback()
+}
+
+// Commit writes all buffered writes in a single TransactWriteItems
+// request. The transaction ends even if the commit fails.
+func (t *txn) Commit() error {
+	if t == nil || t.db == nil {
+		return nil
+	}
+	defer t.Rollback()
+	if len(t.writes) == 0 {
+		return nil
+	}
+	if len(t.writes) > maxTxnItems {
+		return errTxnTooLarge
+	}
+
+	db := t.db
+	items := make([]types.TransactWriteItem, 0, len(t.writes))
+	for k, w := range t.writes {
+		item := db.key([]byte(k))
+		if w.deleted {
+			items = append(items, types.TransactWriteItem{
+				Delete: &types.Delete{TableName: db.table, Key: item},
+			})
+			continue
+		}
+		item[attrValue] = &types.AttributeValueMemberB{Value: w.value}
+		items = append(items, types.TransactWriteItem{
+			Put: &types.Put{TableName: db.table, Item: item},
+		})
+	}
+	_, err := db.client.TransactWriteItems(context.Background(), &dynamodb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+	return err
+}
+
+// pair is a key/value pair read by an iterator.
+type pair struct {
+	key, value []byte
+}
+
+// iterator pages through the namespace. It holds the page around the
+// current key in ascending order and queries the next page in the
+// direction of travel when moving past either end. Query errors end the
+// iteration and are returned by Close.
+type iterator struct {
+	db    *DB // nil after the iterator was closed
+	items []pair
+	pos   int // index of the current pair, -1 if unpositioned
+	err   error
+}
+
+// Key conditions of iterator queries.
+const (
+	condAll     = "pk = :p"
+	condAtLeast = "pk = :p AND sk >= :k"
+	condAfter   = "pk = :p AND sk > :k"
+	condBefore  = "pk = :p AND sk < :k"
+)
+
+// load queries a page of pairs matching cond in the given direction and
+// positions the iterator on the pair closest to the start of the query.
+func (i *iterator) load(cond string, key []byte, forward bool) ([]byte, []byte) {
+	i.items, i.pos = i.items[:0], -1
+	if i.err != nil {
+		return nil, nil
+	}
+	values := map[string]types.AttributeValue{":p": i.db.namespace}
+	if cond != condAll {
+		values[":k"] = &types.AttributeValueMemberB{Value: key}
+	}
+	out, err := i.db.client.Query(context.Background(), &dynamodb.QueryInput{
+		TableName:                 i.db.table,
+		KeyConditionExpression:    aws.String(cond),
+		ExpressionAttributeValues: values,
+		ScanIndexForward:          aws.Bool(forward),
+		ConsistentRead:            aws.Bool(true),
+		Limit:                     aws.Int32(i.db.pageSize),
+	})
+	if err != nil {
+		i.err = err
+		return nil, nil
+	}
+	for _, item := range out.Items {
+		k, v, err := decodeItem(item)
+		if err != nil {
+			i.err = err
+			i.items = i.items[:0]
+			return nil, nil
+		}
+		i.items = append(i.items, pair{k, v})
+	}
+	if len(i.items) == 0 {
+		return nil, nil
+	}
+	if forward {
+		i.pos = 0
+	} else {
+		// keep the page ascending
+		for l, r := 0, len(i.items)-1; l < r; l, r = l+1, r-1 {
+			i.items[l], i.items[r] = i.items[r], i.items[l]
+		}
+		i.pos = len(i.items) - 1
+	}
+	return i.current()
+}
+
+func (i *iterator) current() ([]byte, []byte) {
+	if i.pos < 0 {
+		return nil, nil
+	}
+	p := i.items[i.pos]
+	return p.key, p.value
+}
+
+func (i *iterator) Seek(key []byte) ([]byte, []byte) {
+	if i == nil || i.db == nil {
+		return nil, nil
+	}
+	if len(key) == 0 {
+		return i.First()
+	}
+	return i.load(condAtLeast, key, true)
+}
+
+func (i *iterator) First() ([]byte, []byte) {
+	if i == nil || i.db == nil {
+		return nil, nil
+	}
+	return i.load(condAll, nil, true)
+}
+
+func (i *iterator) Last() ([]byte, []byte) {
+	if i == nil || i.db == nil {
+		return nil, nil
+	}
+	return i.load(condAll, nil, false)
+}
+
+func (i *iterator) Next() ([]byte, []byte) {
+	if i == nil || i.db == nil || i.pos < 0 {
+		return nil, nil
+	}
+	if i.pos+1 < len(i.items) {
+		i.pos++
+		return i.current()
+	}
+	return i.load(condAfter, append([]byte{}, i.items[i.pos].key...), true)
+}
+
+func (i *iterator) Prev() ([]byte, []byte) {
+	if i == nil || i.db == nil || i.pos < 0 {
+		return nil, nil
+	}
+	if i.pos > 0 {
+		i.pos--
+		return i.current()
+	}
+	return i.load(condBefore, append([]byte{}, i.items[0].key...), false)
+}
+
+// SeekToIndex steps from the first key, DynamoDB cannot skip items of a
+// Query without reading them.
+func (i *iterator) SeekToIndex(n int64) ([]byte, []byte) {
+	if i == nil || i.db == nil || n < 0 {
+		return nil, nil
+	}
+	k, v := i.First()
+	for ; k != nil && n > 0; n-- {
+		k, v = i.Next()
+	}
+	return k, v
+}
+
+// Reset drops the buffered page, the iterator reads the current state of
+// the table on every query anyway.
+func (i *iterator) Reset() error {
+	if i == nil || i.db == nil {
+		return errors.New("reset closed iterator")
+	}
+	i.items, i.pos = i.items[:0], -1
+	return nil
+}
+
+func (i *iterator) Close() error {
+	if i == nil || i.db == nil {
+		return nil
+	}
+	i.db.release()
+	i.db, i.items, i.pos = nil, nil, -1
+	return i.err
+}
@@ -0,0 +1,228 @@
+package dynamostore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/mars9/backend"
+	"github.com/mars9/backend/backendtest"
+)
+
+// fakeClient is an in-memory table understanding the requests of DB.
+type fakeClient struct {
+	mu    sync.Mutex
+	items map[string]map[string][]byte // values by key by partition
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{items: make(map[string]map[string][]byte)}
+}
+
+func itemKey(key map[string]types.AttributeValue) (string, []byte) {
+	return key[attrPartition].(*types.AttributeValueMemberS).Value,
+		key[attrSort].(*types.AttributeValueMemberB).Value
+}
+
+func (c *fakeClient) GetItem(ctx context.Context, in *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, k := itemKey(in.Key)
+	v, ok := c.items[p][string(k)]
+	if !ok {
+		return &dynamodb.GetItemOutput{}, nil
+	}
+	item := map[string]types.AttributeValue{attrValue: &types.AttributeValueMemberB{Value: v}}
+	for name, attr := range in.Key {
+		item[name] = attr
+	}
+	return &dynamodb.GetItemOutput{Item: item}, nil
+}
+
+func (c *fakeClient) Query(ctx context.Context, in *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := in.ExpressionAttributeValues[":p"].(*types.AttributeValueMemberS).Value
+	var bound []byte
+	if k, ok := in.ExpressionAttributeValues[":k"]; ok {
+		bound = k.(*types.AttributeValueMemberB).Value
+	}
+	cond := aws.ToString(in.KeyConditionExpression)
+
+	var keys []string
+	for k := range c.items[p] {
+		cmp := bytes.Compare([]byte(k), bound)
+		switch {
+		case strings.HasSuffix(cond, "sk >= :k") && cmp < 0,
+			strings.HasSuffix(cond, "sk > :k") && cmp <= 0,
+			strings.HasSuffix(cond, "sk < :k") && cmp >= 0:
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if !aws.ToBool(in.ScanIndexForward) {
+		sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+	}
+	out := &dynamodb.QueryOutput{}
+	for _, k := range keys {
+		if len(out.Items) == int(aws.ToInt32(in.Limit)) {
+			break
+		}
+		out.Items = append(out.Items, map[string]types.AttributeValue{
+			attrPartition: &types.AttributeValueMemberS{Value: p},
+			attrSort:      &types.AttributeValueMemberB{Value: []byte(k)},
+			attrValue:     &types.AttributeValueMemberB{Value: c.items[p][k]},
+		})
+	}
+	out.Count = int32(len(out.Items))
+	return out, nil
+}
+
+func (c *fakeClient) TransactWriteItems(ctx context.Context, in *dynamodb.TransactWriteItemsInput, optFns ...func(*dynamodb.Options)) (*dynamodb.TransactWriteItemsOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(in.TransactItems) > maxTxnItems {
+		return nil, errors.New("ValidationException: too many items")
+	}
+	seen := make(map[string]bool)
+	for _, item := range in.TransactItems {
+		var key map[string]types.AttributeValue
+		if item.Delete != nil {
+			key = item.Delete.Key
+		} else {
+			key = item.Put.Item
+		}
+		p, k := itemKey(key)
+		if seen[p+"/"+string(k)] {
+			return nil, errors.New("ValidationException: multiple operations on one item")
+		}
+		seen[p+"/"+string(k)] = true
+	}
+	for _, item := range in.TransactItems {
+		if item.Delete != nil {
+			p, k := itemKey(item.Delete.Key)
+			delete(c.items[p], string(k))
+			continue
+		}
+		p, k := itemKey(item.Put.Item)
+		if c.items[p] == nil {
+			c.items[p] = make(map[string][]byte)
+		}
+		c.items[p][string(k)] = append([]byte{}, item.Put.Item[attrValue].(*types.AttributeValueMemberB).Value...)
+	}
+	return &dynamodb.TransactWriteItemsOutput{}, nil
+}
+
+func TestStore(t *testing.T) {
+	client := newFakeClient()
+	db, err := New(client, "table", "test", PageSize(2))
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	other, err := New(client, "table", "other")
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	defer other.Close()
+	defer db.Close()
+
+	if err = backend.Update(other, func(txn backend.RWTxn) error { return txn.Put([]byte("k3"), []byte("other")) }); err != nil {
+		t.Fatalf("update other namespace: %v", err)
+	}
+	if err = backend.Update(db, func(txn backend.RWTxn) error {
+		for i := 0; i < 7; i++ {
+			if err := txn.Put([]byte(fmt.Sprintf("k%d", i)), []byte(fmt.Sprint(i))); err != nil {
+				return err
+			}
+		}
+		if err := txn.Put([]byte("k9"), nil); err != nil {
+			return err
+		}
+		if err := txn.Delete([]byte("k9")); err != nil {
+			return err
+		}
+		if _, err := txn.Get([]byte("k9")); err != backend.ErrNotFound {
+			t.Fatalf("get deleted key: expected ErrNotFound, got %v", err)
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	iter, err := db.Iterator()
+	if err != nil {
+		t.Fatalf("iterator: %v", err)
+	}
+	var keys []string
+	for k, v := iter.First(); k != nil; k, v = iter.Next() {
+		if want := "k" + string(v); string(k) != want {
+			t.Fatalf("iterator: key %q has value %q", k, v)
+		}
+		keys = append(keys, string(k))
+	}
+	if got := strings.Join(keys, ","); got != "k0,k1,k2,k3,k4,k5,k6" {
+		t.Fatalf("ascending: got %s", got)
+	}
+	keys = keys[:0]
+	for k, _ := iter.Last(); k != nil; k, _ = iter.Prev() {
+		keys = append(keys, string(k))
+	}
+	if got := strings.Join(keys, ","); got != "k6,k5,k4,k3,k2,k1,k0" {
+		t.Fatalf("descending: got %s", got)
+	}
+
+	// change direction across a page boundary
+	iter.Seek([]byte("k2a"))
+	iter.Next()
+	if k, _ := iter.Next(); string(k) != "k5" {
+		t.Fatalf("next across page: expected k5, got %q", k)
+	}
+	if k, _ := iter.Prev(); string(k) != "k4" {
+		t.Fatalf("prev: expected k4, got %q", k)
+	}
+	if k, _ := iter.Prev(); string(k) != "k3" {
+		t.Fatalf("prev across page: expected k3, got %q", k)
+	}
+	if k, v := iter.SeekToIndex(6); string(k) != "k6" || string(v) != "6" {
+		t.Fatalf("seek to index 6: got %q %q", k, v)
+	}
+	if err = iter.Close(); err != nil {
+		t.Fatalf("close iterator: %v", err)
+	}
+
+	err = backend.Update(db, func(txn backend.RWTxn) error {
+		for i := 0; i <= maxTxnItems; i++ {
+			if err := txn.Put([]byte(fmt.Sprintf("big%03d", i)), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != errTxnTooLarge {
+		t.Fatalf("commit oversized transaction: expected errTxnTooLarge, got %v", err)
+	}
+	if pairs, err := backend.Scan(db, nil); err != nil || len(pairs) != 7 {
+		t.Fatalf("scan after failed commit: got %d pairs, %v", len(pairs), err)
+	}
+}
+
+func open(t testing.TB) backend.DB {
+	db, err := New(newFakeClient(), "table", "fuzz", PageSize(3))
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	return db
+}
+
+func FuzzTxnOps(f *testing.F)        { backendtest.FuzzTxnOps(f, open) }
+func FuzzIteratorSeeks(f *testing.F) { backendtest.FuzzIteratorSeeks(f, open) }
+func FuzzRestore(f *testing.F)       { backendtest.FuzzRestore(f, open) }
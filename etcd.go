@@ -0,0 +1,164 @@
+package backend
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// etcdImportBatch is the number of keys written per transaction by
+// ImportEtcdSnapshot.
+const etcdImportBatch = 1000
+
+var errInvalidEtcdSnapshot = errors.New("invalid etcd snapshot")
+
+// etcd stores its MVCC history in the "key" bucket. Keys are revisions,
+// an 8 byte main revision, '_' and an 8 byte sub revision, followed by
+// 't' for deletions. Values are mvccpb.KeyValue protobuf messages.
+var etcdKeyBucket = []byte("key")
+
+const (
+	etcdRevisionLen   = 17
+	etcdTombstone     = 't'
+	etcdFieldKey      = 1
+	etcdFieldValue    = 5
+	protoVarint       = 0
+	protoFixed64      = 1
+	protoBytes        = 2
+	protoFixed32      = 5
+	protoFieldShift   = 3
+	protoWireTypeMask = 7
+)
+
+// ImportEtcdSnapshot copies the current keys of the etcd v3 bbolt
+// snapshot or data file at path into db, storing each etcd key below
+// prefix. Deleted keys and older revisions are skipped, leases are
+// dropped. It returns the number of imported keys.
+//
+// Keys are written in batches of separate transactions; if an error
+// occurs, the batches written so far stay in db.
+func ImportEtcdSnapshot(db DB, prefix []byte, path string) (int, error) {
+	tree, err := bolt.Open(path, defaultOpenMode, &bolt.Options{
+		Timeout:  time.Second,
+		ReadOnly: true,
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer tree.Close()
+
+	// revisions are in commit order, the last one of a key wins
+	current := make(map[string][]byte)
+	var order []string
+	err = tree.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(etcdKeyBucket)
+		if b == nil {
+			return errInvalidEtcdSnapshot
+		}
+		return b.ForEach(func(rev, kv []byte) error {
+			if len(rev) < etcdRevisionLen || rev[8] != '_' {
+				return errInvalidEtcdSnapshot
+			}
+			key, value, err := decodeEtcdKeyValue(kv)
+			if err != nil {
+				return err
+			}
+			if _, ok := current[string(key)]; !ok {
+				order = append(order, string(key))
+			}
+			if len(rev) > etcdRevisionLen && rev[etcdRevisionLen] == etcdTombstone {
+				current[string(key)] = nil
+			} else {
+				current[string(key)] = append([]byte{}, value...)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	n, pending := 0, 0
+	var txn RWTxn
+	for _, key := range order {
+		value := current[key]
+		if value == nil {
+			continue
+		}
+		if txn == nil {
+			if txn, err = db.Writable(); err != nil {
+				return n, err
+			}
+		}
+		k := append(append(make([]byte, 0, len(prefix)+len(key)), prefix...), key...)
+		if err = txn.Put(k, value); err != nil {
+			txn.Rollback()
+			return n, err
+		}
+		if pending++; pending == etcdImportBatch {
+			if err = txn.Commit(); err != nil {
+				return n, err
+			}
+			n, pending, txn = n+pending, 0, nil
+		}
+	}
+	if txn != nil {
+		if err = txn.Commit(); err != nil {
+			return n, err
+		}
+	}
+	return n + pending, nil
+}
+
+// decodeEtcdKeyValue returns the key and value fields of an encoded
+// mvccpb.KeyValue.
+func decodeEtcdKeyValue(b []byte) (key, value []byte, err error) {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, nil, errInvalidEtcdSnapshot
+		}
+		b = b[n:]
+		switch tag & protoWireTypeMask {
+		case protoVarint:
+			if _, n = binary.Uvarint(b); n <= 0 {
+				return nil, nil, errInvalidEtcdSnapshot
+			}
+			b = b[n:]
+		case protoFixed64, protoFixed32:
+			size := 8
+			if tag&protoWireTypeMask == protoFixed32 {
+				size = 4
+			}
+			if len(b) < size {
+				return nil, nil, errInvalidEtcdSnapshot
+			}
+			b = b[size:]
+		case protoBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return nil, nil, errInvalidEtcdSnapshot
+			}
+			field := b[n : n+int(l)]
+			switch tag >> protoFieldShift {
+			case etcdFieldKey:
+				key = field
+			case etcdFieldValue:
+				value = field
+			}
+			b = b[n+int(l):]
+		default:
+			return nil, nil, errInvalidEtcdSnapshot
+		}
+	}
+	if key == nil {
+		return nil, nil, errInvalidEtcdSnapshot
+	}
+	// etcd stores empty values as absent fields
+	if value == nil {
+		value = []byte{}
+	}
+	return key, value, nil
+}
@@ -0,0 +1,100 @@
+package backend
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"testing"
+
+	"github.com/boltdb/bolt"
+)
+
+// etcdRevision encodes an etcd revision key.
+func etcdRevision(main, sub uint64, tombstone bool) []byte {
+	rev := make([]byte, etcdRevisionLen, etcdRevisionLen+1)
+	binary.BigEndian.PutUint64(rev, main)
+	rev[8] = '_'
+	binary.BigEndian.PutUint64(rev[9:], sub)
+	if tombstone {
+		rev = append(rev, etcdTombstone)
+	}
+	return rev
+}
+
+// etcdKeyValue encodes a mvccpb.KeyValue with a mod revision, which the
+// importer skips.
+func etcdKeyValue(key, value string, modRevision uint64) []byte {
+	b := binary.AppendUvarint(nil, etcdFieldKey<<protoFieldShift|protoBytes)
+	b = binary.AppendUvarint(b, uint64(len(key)))
+	b = append(b, key...)
+	b = binary.AppendUvarint(b, 3<<protoFieldShift|protoVarint)
+	b = binary.AppendUvarint(b, modRevision)
+	if value != "" {
+		b = binary.AppendUvarint(b, etcdFieldValue<<protoFieldShift|protoBytes)
+		b = binary.AppendUvarint(b, uint64(len(value)))
+		b = append(b, value...)
+	}
+	return b
+}
+
+func TestImportEtcdSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "etcd.db")
+	tree, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatalf("open bolt: %v", err)
+	}
+	err = tree.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucket(etcdKeyBucket)
+		if err != nil {
+			return err
+		}
+		for _, r := range []struct {
+			rev       uint64
+			tombstone bool
+			key       string
+			value     string
+		}{
+			{2, false, "/config/a", "1"},
+			{3, false, "/config/b", "2"},
+			{4, false, "/config/a", "3"},
+			{5, true, "/config/b", ""},
+			{6, false, "/config/c", ""},
+		} {
+			if err = b.Put(etcdRevision(r.rev, 0, r.tombstone), etcdKeyValue(r.key, r.value, r.rev)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("fill bolt: %v", err)
+	}
+	if err = tree.Close(); err != nil {
+		t.Fatalf("close bolt: %v", err)
+	}
+
+	const dbPath = "etcd_boltdb.db"
+	db := openBoltDB(t, dbPath)
+	defer closeBoltDB(t, dbPath, db)
+
+	n, err := ImportEtcdSnapshot(db, []byte("etcd"), path)
+	if err != nil || n != 2 {
+		t.Fatalf("import: expected 2 keys, got %d, %v", n, err)
+	}
+	pairs, err := Scan(db, []byte("etcd"))
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	expected := []string{"etcd/config/a", "3", "etcd/config/c", ""}
+	if len(pairs) != len(expected)/2 {
+		t.Fatalf("import: expected %d keys, got %d", len(expected)/2, len(pairs))
+	}
+	for i, p := range pairs {
+		if string(p.Key) != expected[2*i] || string(p.Value) != expected[2*i+1] {
+			t.Fatalf("import: expected %s => %q, got %s => %q", expected[2*i], expected[2*i+1], p.Key, p.Value)
+		}
+	}
+
+	if _, _, err = decodeEtcdKeyValue([]byte{etcdFieldKey<<protoFieldShift | protoBytes, 5, 'a'}); err != errInvalidEtcdSnapshot {
+		t.Fatalf("decode truncated message: expected errInvalidEtcdSnapshot, got %v", err)
+	}
+}
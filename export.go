@@ -0,0 +1,432 @@
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// Export format
+//
+// An export starts with a header consisting of the magic string "BKX",
+// a version byte and a flags byte. It is followed by a sequence of
+// blocks, each prefixed with the uvarint encoded length of its stored
+// payload. A zero length block terminates the export.
+//
+// A block payload holds key/value records in ascending key order:
+//
+//	shared   uvarint  bytes shared with the previous key
+//	unshared uvarint  length of the key suffix
+//	vlen     uvarint  length of the value
+//	suffix   [unshared]byte
+//	value    [vlen]byte
+//
+// Prefix sharing restarts at every block, so each block can be decoded
+// on its own. If the compressed flag is set the payload is deflate
+// compressed. If the signed flag is set the terminating block is
+// followed by an Ed25519 signature of the SHA-256 hash of all preceding
+// bytes.
+const (
+	exportMagic   = "BKX"
+	exportVersion = 1
+
+	flagCompressed = 1 << 0
+	flagSigned     = 1 << 1
+
+	defaultExportBlockSize = 64 << 10
+	maxExportBlockSize     = 1 << 30
+)
+
+// ErrInvalidExport is returned when reading malformed export data.
+const ErrInvalidExport Error = Error("invalid export format")
+
+// ErrInvalidSignature is returned by ImportVerified for exports that are
+// not signed by the expected key.
+const ErrInvalidSignature Error = Error("invalid export signature")
+
+type exporter struct {
+	blockSize int
+	level     int
+	compress  bool
+	signer    ed25519.PrivateKey
+	rekey     func(k []byte) []byte
+}
+
+// ExportOption configures Export.
+type ExportOption func(*exporter) error
+
+// ExportBlockSize sets the approximate uncompressed size of a block.
+func ExportBlockSize(size int) ExportOption {
+	return func(e *exporter) error {
+		if size <= 0 {
+			return errors.New("export block size must be positive")
+		}
+		e.blockSize = size
+		return nil
+	}
+}
+
+// Compress enables deflate compression of blocks using the given
+// compress/flate level.
+func Compress(level int) ExportOption {
+	return func(e *exporter) error {
+		if level < flate.HuffmanOnly || level > flate.BestCompression {
+			return errors.New("invalid compression level")
+		}
+		e.level = level
+		e.compress = true
+		return nil
+	}
+}
+
+// SignExport signs the export with key, see ImportVerified.
+func SignExport(key ed25519.PrivateKey) ExportOption {
+	return func(e *exporter) error {
+		if len(key) != ed25519.PrivateKeySize {
+			return errors.New("invalid export signing key")
+		}
+		e.signer = key
+		return nil
+	}
+}
+
+// Export writes all key/value pairs of db to w using the export format
+// and returns the number of bytes written. Keys are read from a single
+// iterator, so the export reflects a consistent view of the database.
+func Export(w io.Writer, db DB, opts ...ExportOption) (int64, error) {
+	return ExportFunc(w, db, nil, opts...)
+}
+
+// ExportFunc is like Export but only writes the pairs for which pred
+// returns true, e.g. the records of a single customer. The key and value
+// passed to pred are only valid during the call. A nil pred selects all
+// pairs.
+func ExportFunc(w io.Writer, db DB, pred func(k, v []byte) bool, opts ...ExportOption) (int64, error) {
+	e := &exporter{blockSize: defaultExportBlockSize}
+	for _, opt := range opts {
+		if err := opt(e); err != nil {
+			return 0, err
+		}
+	}
+
+	iter, err := db.Iterator()
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+
+	cw := &countWriter{w: w}
+	var out io.Writer = cw
+	hash := sha256.New()
+	var flags byte
+	if e.compress {
+		flags |= flagCompressed
+	}
+	if e.signer != nil {
+		flags |= flagSigned
+		out = io.MultiWriter(cw, hash)
+	}
+	if _, err = out.Write([]byte{exportMagic[0], exportMagic[1], exportMagic[2], exportVersion, flags}); err != nil {
+		return cw.n, err
+	}
+
+	var block, prev, last []byte
+	for k, v := iter.First(); k != nil; k, v = iter.Next() {
+		if pred != nil && !pred(k, v) {
+			continue
+		}
+		if e.rekey != nil {
+			if k = e.rekey(k); k == nil {
+				continue
+			}
+			if last != nil && bytes.Compare(k, last) <= 0 {
+				return cw.n, errRekeyOrder
+			}
+			last = append(last[:0], k...)
+		}
+		block = appendRecord(block, prev, k, v)
+		prev = append(prev[:0], k...)
+		if len(block) >= e.blockSize {
+			if err = e.writeBlock(out, block); err != nil {
+				return cw.n, err
+			}
+			block, prev = block[:0], prev[:0]
+		}
+	}
+	if len(block) > 0 {
+		if err = e.writeBlock(out, block); err != nil {
+			return cw.n, err
+		}
+	}
+	if err = writeUvarint(out, 0); err != nil {
+		return cw.n, err
+	}
+	if e.signer != nil {
+		if _, err = cw.Write(ed25519.Sign(e.signer, hash.Sum(nil))); err != nil {
+			return cw.n, err
+		}
+	}
+	return cw.n, iter.Close()
+}
+
+func (e *exporter) writeBlock(w io.Writer, block []byte) error {
+	if e.compress {
+		var buf bytes.Buffer
+		fw, err := flate.NewWriter(&buf, e.level)
+		if err != nil {
+			return err
+		}
+		if _, err = fw.Write(block); err != nil {
+			return err
+		}
+		if err = fw.Close(); err != nil {
+			return err
+		}
+		block = buf.Bytes()
+	}
+	if err := writeUvarint(w, uint64(len(block))); err != nil {
+		return err
+	}
+	_, err := w.Write(block)
+	return err
+}
+
+func appendRecord(block, prev, key, value []byte) []byte {
+	shared := 0
+	for shared < len(prev) && shared < len(key) && prev[shared] == key[shared] {
+		shared++
+	}
+	block = appendUvarint(block, uint64(shared))
+	block = appendUvarint(block, uint64(len(key)-shared))
+	block = appendUvarint(block, uint64(len(value)))
+	block = append(block, key[shared:]...)
+	return append(block, value...)
+}
+
+// Import reads an export created by Export from r and stores all
+// key/value pairs in db. Every block is written in its own transaction.
+func Import(db DB, r io.Reader) error {
+	er, err := newExportReader(r)
+	if err != nil {
+		return err
+	}
+
+	for {
+		records, err := er.nextBlock()
+		if err != nil {
+			return err
+		}
+		if records == nil {
+			return nil
+		}
+
+		txn, err := db.Writable()
+		if err != nil {
+			return err
+		}
+		if err = records.each(txn.Put); err != nil {
+			txn.Rollback()
+			return err
+		}
+		if err = txn.Commit(); err != nil {
+			return err
+		}
+	}
+}
+
+var errRekeyOrder = errors.New("rekeyed keys out of order")
+
+// PublishSnapshot writes a signed export of the pairs of db selected by
+// pred, e.g. a dataset for a partner, to w. A nil pred selects all
+// pairs. If rekey is not nil, each key is replaced by rekey(key) before
+// it is written, e.g. to strip an internal tenant prefix; rekey must
+// preserve the key order and may return nil to skip a pair. Consumers
+// load the snapshot with ImportVerified using the public key of key.
+func PublishSnapshot(w io.Writer, db DB, key ed25519.PrivateKey, pred func(k, v []byte) bool, rekey func(k []byte) []byte, opts ...ExportOption) (int64, error) {
+	opts = append(opts[:len(opts):len(opts)], SignExport(key), func(e *exporter) error {
+		e.rekey = rekey
+		return nil
+	})
+	return ExportFunc(w, db, pred, opts...)
+}
+
+// ImportVerified is like Import but only imports exports signed with
+// the private key of pub, and returns ErrInvalidSignature for any other.
+// The export is spooled to a temporary file and verified before any
+// pair is written.
+func ImportVerified(db DB, r io.Reader, pub ed25519.PublicKey) error {
+	if len(pub) != ed25519.PublicKeySize {
+		return errors.New("invalid export verification key")
+	}
+	f, err := os.CreateTemp("", "backend-import-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, hash), r)
+	if err != nil {
+		return err
+	}
+	hdrSize := int64(len(exportMagic) + 2)
+	if size < hdrSize+ed25519.SignatureSize {
+		return ErrInvalidSignature
+	}
+
+	// hash the signed part again, the signature is the trailer
+	hash.Reset()
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err = io.CopyN(hash, f, size-ed25519.SignatureSize); err != nil {
+		return err
+	}
+	sig := make([]byte, ed25519.SignatureSize)
+	if _, err = io.ReadFull(f, sig); err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, hash.Sum(nil), sig) {
+		return ErrInvalidSignature
+	}
+
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	hdr := make([]byte, hdrSize)
+	if _, err = io.ReadFull(f, hdr); err != nil {
+		return err
+	}
+	if hdr[4]&flagSigned == 0 {
+		return ErrInvalidSignature
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return Import(db, io.LimitReader(f, size-ed25519.SignatureSize))
+}
+
+type exportReader struct {
+	r          *bufio.Reader
+	compressed bool
+	buf        []byte
+}
+
+func newExportReader(r io.Reader) (*exportReader, error) {
+	br := bufio.NewReader(r)
+	hdr := make([]byte, len(exportMagic)+2)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrInvalidExport
+		}
+		return nil, err
+	}
+	if string(hdr[:len(exportMagic)]) != exportMagic || hdr[3] != exportVersion {
+		return nil, ErrInvalidExport
+	}
+	return &exportReader{r: br, compressed: hdr[4]&flagCompressed != 0}, nil
+}
+
+// nextBlock returns the decoded records of the next block, or nil at
+// the end of the export.
+func (er *exportReader) nextBlock() (exportBlock, error) {
+	n, err := binary.ReadUvarint(er.r)
+	if err != nil {
+		return nil, ErrInvalidExport
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	if n > maxExportBlockSize {
+		return nil, ErrInvalidExport
+	}
+
+	var block []byte
+	if er.compressed {
+		fr := flate.NewReader(io.LimitReader(er.r, int64(n)))
+		var buf bytes.Buffer
+		if _, err = buf.ReadFrom(io.LimitReader(fr, maxExportBlockSize)); err != nil {
+			return nil, ErrInvalidExport
+		}
+		fr.Close()
+		block = buf.Bytes()
+	} else {
+		if cap(er.buf) < int(n) {
+			er.buf = make([]byte, n)
+		}
+		block = er.buf[:n]
+		if _, err = io.ReadFull(er.r, block); err != nil {
+			return nil, ErrInvalidExport
+		}
+	}
+	if len(block) == 0 {
+		return nil, ErrInvalidExport
+	}
+	return exportBlock(block), nil
+}
+
+type exportBlock []byte
+
+// each calls fn for every record in the block. The value passed to fn
+// is only valid until the next block is read.
+func (b exportBlock) each(fn func(key, value []byte) error) error {
+	var key []byte
+	for len(b) > 0 {
+		shared, n1 := binary.Uvarint(b)
+		if n1 <= 0 {
+			return ErrInvalidExport
+		}
+		unshared, n2 := binary.Uvarint(b[n1:])
+		if n2 <= 0 {
+			return ErrInvalidExport
+		}
+		vlen, n3 := binary.Uvarint(b[n1+n2:])
+		if n3 <= 0 {
+			return ErrInvalidExport
+		}
+		b = b[n1+n2+n3:]
+		if shared > uint64(len(key)) || unshared > uint64(len(b)) ||
+			vlen > uint64(len(b))-unshared || (key != nil && shared+unshared == 0) {
+			return ErrInvalidExport
+		}
+		k := make([]byte, shared+unshared)
+		copy(k, key[:shared])
+		copy(k[shared:], b[:unshared])
+		key = k
+		value := b[unshared : unshared+vlen]
+		b = b[unshared+vlen:]
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func appendUvarint(b []byte, x uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], x)]...)
+}
+
+func writeUvarint(w io.Writer, x uint64) error {
+	var buf [binary.MaxVarintLen64]byte
+	_, err := w.Write(buf[:binary.PutUvarint(buf[:], x)])
+	return err
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,160 @@
+package backend
+
+import (
+	"errors"
+	"time"
+)
+
+// FallbackOption configures a FallbackDB.
+type FallbackOption func(*FallbackDB) error
+
+// FallbackEvent records a read that failed on a source and is retried on
+// the next source of a FallbackDB.
+type FallbackEvent struct {
+	Op     string // "get", "readonly" or "iterator"
+	Source int    // index of the failing source, 0 is the primary
+	Name   string // Name of the failing source
+	Time   time.Time
+	Err    error
+}
+
+// OnFallback calls fn whenever a read falls back to the next source, e.g.
+// to alert and start a repair of the failing store. fn is called
+// synchronously by the reading goroutine and must be safe for concurrent
+// use.
+func OnFallback(fn func(FallbackEvent)) FallbackOption {
+	return func(f *FallbackDB) error {
+		f.events = fn
+		return nil
+	}
+}
+
+// FallbackOn sets the errors that make reads fall back to the next
+// source. By default every error but ErrNotFound and ErrDBClosed does.
+func FallbackOn(fn func(err error) bool) FallbackOption {
+	return func(f *FallbackDB) error {
+		f.failed = fn
+		return nil
+	}
+}
+
+// FallbackDB wraps a primary DB and serves reads the primary fails, e.g.
+// with a corruption or I/O error, from an ordered list of fallback
+// sources such as a replica and an opened backup snapshot. User-facing
+// reads thereby survive a partially corrupted store while it is repaired.
+//
+// Writes, WriteTo and Close go to the primary only; the fallback sources
+// are owned by the caller. A key that fails on the primary is read from
+// the first source that does not fail, so a transaction may mix values of
+// different sources, which may lag behind the primary. Iterators fall
+// back only if they cannot be opened.
+type FallbackDB struct {
+	DB
+
+	sources []DB // primary first
+	events  func(FallbackEvent)
+	failed  func(err error) bool
+}
+
+// NewFallbackDB returns a FallbackDB reading from primary and, if it
+// fails, from the fallbacks in order.
+func NewFallbackDB(primary DB, fallbacks []DB, opts ...FallbackOption) (*FallbackDB, error) {
+	if len(fallbacks) == 0 {
+		return nil, errors.New("fallback chain without fallbacks")
+	}
+	f := &FallbackDB{
+		DB:      primary,
+		sources: append([]DB{primary}, fallbacks...),
+		failed:  func(err error) bool { return err != ErrNotFound && err != ErrDBClosed },
+	}
+	for _, opt := range opts {
+		if err := opt(f); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// fallback reports whether a read failing with err on source i is retried
+// on the next source and emits the event if it is.
+func (f *FallbackDB) fallback(op string, i int, err error) bool {
+	if err == nil || i+1 >= len(f.sources) || !f.failed(err) {
+		return false
+	}
+	if f.events != nil {
+		f.events(FallbackEvent{Op: op, Source: i, Name: f.sources[i].Name(), Time: time.Now(), Err: err})
+	}
+	return true
+}
+
+func (f *FallbackDB) Iterator() (Iterator, error) {
+	for i := 0; ; i++ {
+		it, err := f.sources[i].Iterator()
+		if !f.fallback("iterator", i, err) {
+			return it, err
+		}
+	}
+}
+
+// Readonly starts a read-only transaction on the first source that does
+// not fail. Keys failing on it are read from the following sources.
+func (f *FallbackDB) Readonly() (Txn, error) {
+	for i := 0; ; i++ {
+		txn, err := f.sources[i].Readonly()
+		if err == nil {
+			return &fallbackTxn{db: f, srcs: []fallbackSource{{txn: txn}}, first: i}, nil
+		}
+		if !f.fallback("readonly", i, err) {
+			return nil, err
+		}
+	}
+}
+
+// fallbackTxn holds a transaction on the source it was started on and, as
+// keys fail, on the following sources. srcs[j] belongs to source first+j.
+type fallbackTxn struct {
+	db    *FallbackDB
+	srcs  []fallbackSource
+	first int
+}
+
+type fallbackSource struct {
+	txn Txn
+	err error // starting the transaction failed
+}
+
+func (t *fallbackTxn) Get(key []byte) ([]byte, error) {
+	if t == nil || t.srcs == nil {
+		return nil, errors.New("get from unopened transaction")
+	}
+	value, err := t.srcs[0].txn.Get(key)
+	for j := 1; t.db.fallback("get", t.first+j-1, err); j++ {
+		if j == len(t.srcs) {
+			txn, err := t.db.sources[t.first+j].Readonly()
+			t.srcs = append(t.srcs, fallbackSource{txn, err})
+		}
+		if src := t.srcs[j]; src.err != nil {
+			value, err = nil, src.err
+		} else {
+			value, err = src.txn.Get(key)
+		}
+	}
+	return value, err
+}
+
+func (t *fallbackTxn) Rollback() error {
+	if t == nil || t.srcs == nil {
+		return nil
+	}
+	var err error
+	for _, src := range t.srcs {
+		if src.err != nil {
+			continue
+		}
+		if rerr := src.txn.Rollback(); err == nil {
+			err = rerr
+		}
+	}
+	t.srcs = nil
+	return err
+}
@@ -0,0 +1,126 @@
+package backend
+
+import (
+	"errors"
+	"testing"
+)
+
+var errCorrupt = errors.New("corruption: bad block")
+
+// corruptDB fails reads of the keys in bad, or all reads if broken.
+type corruptDB struct {
+	DB
+	bad    map[string]bool
+	broken bool
+}
+
+func (c *corruptDB) Iterator() (Iterator, error) {
+	if c.broken {
+		return nil, errCorrupt
+	}
+	return c.DB.Iterator()
+}
+
+func (c *corruptDB) Readonly() (Txn, error) {
+	if c.broken {
+		return nil, errCorrupt
+	}
+	txn, err := c.DB.Readonly()
+	if err != nil {
+		return nil, err
+	}
+	return &corruptTxn{Txn: txn, bad: c.bad}, nil
+}
+
+type corruptTxn struct {
+	Txn
+	bad map[string]bool
+}
+
+func (t *corruptTxn) Get(key []byte) ([]byte, error) {
+	if t.bad[string(key)] {
+		return nil, errCorrupt
+	}
+	return t.Txn.Get(key)
+}
+
+func TestFallbackDB(t *testing.T) {
+	fill := func(kv ...string) DB {
+		db := NewMemDB()
+		if err := Update(db, func(txn RWTxn) error {
+			for i := 0; i < len(kv); i += 2 {
+				if err := txn.Put([]byte(kv[i]), []byte(kv[i+1])); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("fill: %v", err)
+		}
+		return db
+	}
+	primary := &corruptDB{DB: fill("a", "primary", "b", "primary", "c", "primary"), bad: map[string]bool{"b": true, "c": true}}
+	replica := &corruptDB{DB: fill("b", "replica"), bad: map[string]bool{"c": true}}
+	backup := fill("c", "backup")
+	defer replica.Close()
+	defer backup.Close()
+
+	var events []FallbackEvent
+	db, err := NewFallbackDB(primary, []DB{replica, backup}, OnFallback(func(ev FallbackEvent) {
+		events = append(events, ev)
+	}))
+	if err != nil {
+		t.Fatalf("new fallback db: %v", err)
+	}
+	defer db.Close()
+
+	get := func(key string) (string, error) {
+		var v []byte
+		err := View(db, func(txn Txn) (err error) {
+			v, err = txn.Get([]byte(key))
+			return err
+		})
+		return string(v), err
+	}
+	for _, kv := range [][2]string{{"a", "primary"}, {"b", "replica"}, {"c", "backup"}} {
+		if v, err := get(kv[0]); err != nil || v != kv[1] {
+			t.Fatalf("get %s: expected %s, got %q, %v", kv[0], kv[1], v, err)
+		}
+	}
+	if _, err := get("d"); err != ErrNotFound {
+		t.Fatalf("get missing key: expected ErrNotFound, got %v", err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("events: expected 3, got %+v", events)
+	}
+	if ev := events[2]; ev.Op != "get" || ev.Source != 1 || ev.Err != errCorrupt {
+		t.Fatalf("event: got %+v", ev)
+	}
+
+	// a broken primary serves everything from the replica
+	primary.broken = true
+	events = nil
+	if v, err := get("b"); err != nil || v != "replica" {
+		t.Fatalf("get from broken primary: got %q, %v", v, err)
+	}
+	iter, err := db.Iterator()
+	if err != nil {
+		t.Fatalf("iterator: %v", err)
+	}
+	if k, _ := iter.First(); string(k) != "b" {
+		t.Fatalf("iterator: expected replica key b, got %q", k)
+	}
+	iter.Close()
+	if len(events) != 2 || events[0].Op != "readonly" || events[1].Op != "iterator" {
+		t.Fatalf("events: got %+v", events)
+	}
+
+	// the last source's errors are returned
+	replica.broken = true
+	if _, err := get("a"); err != ErrNotFound {
+		t.Fatalf("get from backup: expected ErrNotFound, got %v", err)
+	}
+	if _, err = NewFallbackDB(primary, nil); err == nil {
+		t.Fatal("new fallback db without fallbacks: expected error")
+	}
+}
@@ -0,0 +1,81 @@
+package backend
+
+import (
+	"testing"
+)
+
+func TestFencedDB(t *testing.T) {
+	const path = "fenced_boltdb.db"
+	db := openBoltDB(t, path)
+	defer closeBoltDB(t, path, db)
+
+	put := func(f *FencedDB, key string) error {
+		txn, err := f.Writable()
+		if err != nil {
+			t.Fatalf("begin writable transaction: %v", err)
+		}
+		if err = txn.Put([]byte(key), []byte(key)); err != nil {
+			txn.Rollback()
+			return err
+		}
+		return txn.Commit()
+	}
+
+	epoch := []byte("#epoch")
+	old, err := NewFencedDB(db, epoch)
+	if err != nil {
+		t.Fatalf("new fenced db: %v", err)
+	}
+	if old.Epoch() != 1 {
+		t.Fatalf("epoch: expected 1, got %d", old.Epoch())
+	}
+	if err = put(old, "a"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if err = put(old, string(epoch)); err != ErrFenced {
+		t.Fatalf("put epoch: expected ErrFenced, got %v", err)
+	}
+
+	// the takeover waits for a running transaction
+	txn, err := old.Writable()
+	if err != nil {
+		t.Fatalf("begin writable transaction: %v", err)
+	}
+	if err = txn.Put([]byte("b"), []byte("b")); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	done := make(chan *FencedDB)
+	go func() {
+		f, err := NewFencedDB(db, epoch)
+		if err != nil {
+			t.Errorf("new fenced db: %v", err)
+		}
+		done <- f
+	}()
+	if err = txn.Commit(); err != nil {
+		t.Fatalf("commit before takeover: %v", err)
+	}
+	leader := <-done
+	if leader.Epoch() != 2 {
+		t.Fatalf("epoch: expected 2, got %d", leader.Epoch())
+	}
+
+	if err = put(old, "c"); err != ErrFenced {
+		t.Fatalf("put after takeover: expected ErrFenced, got %v", err)
+	}
+	if fenced, err := old.Fenced(); err != nil || !fenced {
+		t.Fatalf("fenced: expected true, got %v (%v)", fenced, err)
+	}
+	if fenced, err := leader.Fenced(); err != nil || fenced {
+		t.Fatalf("fenced: expected false, got %v (%v)", fenced, err)
+	}
+	if err = put(leader, "c"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if err = View(db, func(txn Txn) error {
+		_, err := txn.Get([]byte("c"))
+		return err
+	}); err != nil {
+		t.Fatalf("get c: %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package backend
+
+import "sync"
+
+// fifoMutex is a mutual exclusion lock granted in the order Lock was
+// called. Unlike sync.Mutex a waiting goroutine cannot be overtaken by
+// later callers, so no writer starves under load.
+type fifoMutex struct {
+	mu      sync.Mutex
+	locked  bool
+	waiters []chan struct{}
+}
+
+func (m *fifoMutex) Lock() {
+	m.mu.Lock()
+	if !m.locked {
+		m.locked = true
+		m.mu.Unlock()
+		return
+	}
+	ch := make(chan struct{})
+	m.waiters = append(m.waiters, ch)
+	m.mu.Unlock()
+	<-ch // the lock is handed over without being released
+}
+
+func (m *fifoMutex) Unlock() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.locked {
+		panic("backend: unlock of unlocked fifoMutex")
+	}
+	if len(m.waiters) == 0 {
+		m.locked = false
+		return
+	}
+	ch := m.waiters[0]
+	m.waiters[0] = nil
+	m.waiters = m.waiters[1:]
+	close(ch)
+}
+
+// waiting returns the number of goroutines blocked in Lock.
+func (m *fifoMutex) waiting() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.waiters)
+}
@@ -0,0 +1,34 @@
+package backend
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestFIFOMutex(t *testing.T) {
+	var m fifoMutex
+	m.Lock()
+
+	order := make(chan int, 10)
+	for i := 0; i < 10; i++ {
+		go func(i int) {
+			m.Lock()
+			order <- i
+			m.Unlock()
+		}(i)
+		// wait until the goroutine queued before starting the next one
+		for m.waiting() != i+1 {
+			runtime.Gosched()
+		}
+	}
+	m.Unlock()
+
+	for i := 0; i < 10; i++ {
+		if n := <-order; n != i {
+			t.Fatalf("lock order: expected waiter %d, got %d", i, n)
+		}
+	}
+	if m.waiting() != 0 {
+		t.Fatalf("waiting: expected 0, got %d", m.waiting())
+	}
+}
@@ -0,0 +1,269 @@
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mars9/backend/keys"
+)
+
+var _ DB = (*ForeignBoltDB)(nil)
+
+// ForeignBoltDB is a read-only view of a Bolt database not created by
+// this package. Its top-level buckets are mapped to namespaces: the key
+// k in bucket b is visible as keys.AppendBytes(nil, b) followed by k, so
+// the keys of a bucket form a prefix range ordered by bucket and key.
+// Nested buckets are not visible.
+//
+// Combined with Export and Import, or a copy loop, a ForeignBoltDB
+// migrates other Bolt layouts into this package's conventions.
+type ForeignBoltDB struct {
+	tree *bolt.DB
+	refs refCount
+}
+
+// OpenForeignBolt opens the existing Bolt database at path read-only.
+// Timeout is the amount of time to wait to obtain a file lock, see
+// OpenBoltDB.
+func OpenForeignBolt(path string, timeout time.Duration) (*ForeignBoltDB, error) {
+	tree, err := bolt.Open(path, defaultOpenMode, &bolt.Options{
+		Timeout:  timeout,
+		ReadOnly: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &ForeignBoltDB{tree: tree}, nil
+}
+
+func (db *ForeignBoltDB) begin() (*bolt.Tx, error) {
+	if err := db.refs.acquire(); err != nil {
+		return nil, err
+	}
+	tx, err := db.tree.Begin(false)
+	if err != nil {
+		db.refs.release()
+		return nil, err
+	}
+	return tx, nil
+}
+
+func (db *ForeignBoltDB) Iterator() (Iterator, error) {
+	tx, err := db.begin()
+	if err != nil {
+		return nil, err
+	}
+	i := &foreignBoltIterator{db: db}
+	i.open(tx)
+	return i, nil
+}
+
+func (db *ForeignBoltDB) Readonly() (Txn, error) {
+	tx, err := db.begin()
+	if err != nil {
+		return nil, err
+	}
+	return &foreignBoltTxn{tx: tx, refs: &db.refs}, nil
+}
+
+// Writable returns ErrReadOnlyTxn, foreign databases are read-only.
+func (db *ForeignBoltDB) Writable() (RWTxn, error) { return nil, ErrReadOnlyTxn }
+
+// WriteTo writes the database to w using the export format.
+func (db *ForeignBoltDB) WriteTo(w io.Writer) (int64, error) { return Export(w, db) }
+
+func (db *ForeignBoltDB) Name() string { return "ForeignBoltDB" }
+
+func (db *ForeignBoltDB) Close() error {
+	if db == nil || db.tree == nil {
+		return errors.New("closing unopened ForeignBoltDB instance")
+	}
+	if err := db.refs.close(); err != nil {
+		return err
+	}
+	err := db.tree.Close()
+	db.tree = nil
+	return err
+}
+
+// splitForeignKey splits key into a bucket name and the key within the
+// bucket.
+func splitForeignKey(key []byte) (bucket, k []byte, ok bool) {
+	p := keys.NewParser(key)
+	bucket = p.Bytes()
+	if p.Err() != nil {
+		return nil, nil, false
+	}
+	return bucket, p.Rest(), true
+}
+
+type foreignBoltTxn struct {
+	tx   *bolt.Tx
+	refs *refCount
+}
+
+func (t *foreignBoltTxn) Get(key []byte) ([]byte, error) {
+	if t == nil || t.tx == nil {
+		return nil, nil
+	}
+	bucket, k, ok := splitForeignKey(key)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	b := t.tx.Bucket(bucket)
+	if b == nil {
+		return nil, ErrNotFound
+	}
+	value := b.Get(k)
+	if value == nil {
+		return nil, ErrNotFound
+	}
+	return value, nil
+}
+
+func (t *foreignBoltTxn) Put(key, value []byte) error { return ErrReadOnlyTxn }
+func (t *foreignBoltTxn) Delete(key []byte) error     { return ErrReadOnlyTxn }
+func (t *foreignBoltTxn) Commit() error               { return ErrReadOnlyTxn }
+
+func (t *foreignBoltTxn) Rollback() error {
+	if t == nil || t.tx == nil {
+		return nil
+	}
+	err := t.tx.Rollback()
+	t.tx = nil
+	t.refs.release()
+	return err
+}
+
+// foreignBoltIterator walks the buckets in name order and the keys of
+// each bucket in key order, which is the order of the mapped keys.
+type foreignBoltIterator struct {
+	db     *ForeignBoltDB
+	tx     *bolt.Tx
+	names  [][]byte // top-level bucket names in ascending order
+	bucket int      // index of the current bucket in names
+	c      *bolt.Cursor
+}
+
+func (i *foreignBoltIterator) open(tx *bolt.Tx) {
+	i.tx, i.names, i.c = tx, nil, nil
+	tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+		i.names = append(i.names, name)
+		return nil
+	})
+}
+
+// at positions the iterator on bucket n and returns the mapped pair at
+// k, v, moving on in direction dir past nested buckets and exhausted
+// buckets.
+func (i *foreignBoltIterator) at(n int, k, v []byte, dir int) ([]byte, []byte) {
+	for {
+		for k != nil && v == nil {
+			// nested bucket
+			if dir > 0 {
+				k, v = i.c.Next()
+			} else {
+				k, v = i.c.Prev()
+			}
+		}
+		if k != nil {
+			i.bucket = n
+			key := keys.AppendBytes(make([]byte, 0, len(i.names[n])+len(k)+2), i.names[n])
+			return append(key, k...), v
+		}
+		if n += dir; n < 0 || n >= len(i.names) {
+			i.c = nil
+			return nil, nil
+		}
+		i.c = i.tx.Bucket(i.names[n]).Cursor()
+		if dir > 0 {
+			k, v = i.c.First()
+		} else {
+			k, v = i.c.Last()
+		}
+	}
+}
+
+func (i *foreignBoltIterator) Seek(key []byte) ([]byte, []byte) {
+	if i == nil || i.tx == nil {
+		return nil, nil
+	}
+	bucket, k, ok := splitForeignKey(key)
+	for n, name := range i.names {
+		if ok && bytes.Equal(name, bucket) {
+			i.c = i.tx.Bucket(name).Cursor()
+			k, v := i.c.Seek(k)
+			return i.at(n, k, v, 1)
+		}
+		if bytes.Compare(keys.AppendBytes(nil, name), key) >= 0 {
+			i.c = i.tx.Bucket(name).Cursor()
+			k, v := i.c.First()
+			return i.at(n, k, v, 1)
+		}
+	}
+	i.c = nil
+	return nil, nil
+}
+
+func (i *foreignBoltIterator) First() ([]byte, []byte) {
+	if i == nil || i.tx == nil {
+		return nil, nil
+	}
+	return i.at(-1, nil, nil, 1)
+}
+
+func (i *foreignBoltIterator) Last() ([]byte, []byte) {
+	if i == nil || i.tx == nil {
+		return nil, nil
+	}
+	return i.at(len(i.names), nil, nil, -1)
+}
+
+func (i *foreignBoltIterator) Next() ([]byte, []byte) {
+	if i == nil || i.tx == nil || i.c == nil {
+		return nil, nil
+	}
+	k, v := i.c.Next()
+	return i.at(i.bucket, k, v, 1)
+}
+
+func (i *foreignBoltIterator) Prev() ([]byte, []byte) {
+	if i == nil || i.tx == nil || i.c == nil {
+		return nil, nil
+	}
+	k, v := i.c.Prev()
+	return i.at(i.bucket, k, v, -1)
+}
+
+func (i *foreignBoltIterator) SeekToIndex(n int64) ([]byte, []byte) {
+	if i == nil || i.tx == nil {
+		return nil, nil
+	}
+	return walkToIndex(i, n)
+}
+
+func (i *foreignBoltIterator) Reset() error {
+	if i == nil || i.tx == nil {
+		return errors.New("reset closed iterator")
+	}
+	tx, err := i.db.tree.Begin(false)
+	if err != nil {
+		return err
+	}
+	err = i.tx.Rollback()
+	i.open(tx)
+	return err
+}
+
+func (i *foreignBoltIterator) Close() error {
+	if i == nil || i.tx == nil {
+		return nil
+	}
+	err := i.tx.Rollback()
+	i.tx = nil
+	i.db.refs.release()
+	return err
+}
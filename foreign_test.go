@@ -0,0 +1,130 @@
+package backend
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/mars9/backend/keys"
+)
+
+func TestForeignBolt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "foreign.db")
+	tree, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatalf("open bolt: %v", err)
+	}
+	err = tree.Update(func(tx *bolt.Tx) error {
+		for bucket, pairs := range map[string][]string{
+			"users":  {"1", "alice", "2", "bob"},
+			"empty":  nil,
+			"orders": {"x", "y"},
+		} {
+			b, err := tx.CreateBucket([]byte(bucket))
+			if err != nil {
+				return err
+			}
+			for i := 0; i < len(pairs); i += 2 {
+				if err = b.Put([]byte(pairs[i]), []byte(pairs[i+1])); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("fill bolt: %v", err)
+	}
+	if err = tree.Close(); err != nil {
+		t.Fatalf("close bolt: %v", err)
+	}
+
+	db, err := OpenForeignBolt(path, time.Second)
+	if err != nil {
+		t.Fatalf("open foreign bolt: %v", err)
+	}
+	defer db.Close()
+
+	key := func(bucket, k string) string {
+		return string(keys.AppendBytes(nil, []byte(bucket))) + k
+	}
+	expected := []string{key("orders", "x"), "y", key("users", "1"), "alice", key("users", "2"), "bob"}
+
+	iter, err := db.Iterator()
+	if err != nil {
+		t.Fatalf("iterator: %v", err)
+	}
+	defer iter.Close()
+	i := 0
+	for k, v := iter.First(); k != nil; k, v = iter.Next() {
+		if i == len(expected) || string(k) != expected[i] || string(v) != expected[i+1] {
+			t.Fatalf("iterate: unexpected pair %q => %q at %d", k, v, i/2)
+		}
+		i += 2
+	}
+	if i != len(expected) {
+		t.Fatalf("iterate: expected %d pairs, got %d", len(expected)/2, i/2)
+	}
+	for k, _ := iter.Last(); k != nil; k, _ = iter.Prev() {
+		if i -= 2; i < 0 || string(k) != expected[i] {
+			t.Fatalf("iterate backwards: unexpected key %q", k)
+		}
+	}
+
+	for _, test := range []struct{ seek, expected string }{
+		{key("users", ""), key("users", "1")},
+		{key("users", "15"), key("users", "2")},
+		{"p", key("users", "1")},
+		{key("empty", ""), key("orders", "x")},
+		{key("users", "3"), ""},
+	} {
+		if k, _ := iter.Seek([]byte(test.seek)); string(k) != test.expected {
+			t.Fatalf("seek %q: expected %q, got %q", test.seek, test.expected, k)
+		}
+	}
+
+	txn, err := db.Readonly()
+	if err != nil {
+		t.Fatalf("begin read-only transaction: %v", err)
+	}
+	if v, err := txn.Get([]byte(key("orders", "x"))); err != nil || string(v) != "y" {
+		t.Fatalf("get: expected %q, got %q, %v", "y", v, err)
+	}
+	if _, err = txn.Get([]byte(key("none", "x"))); err != ErrNotFound {
+		t.Fatalf("get in missing bucket: expected ErrNotFound, got %v", err)
+	}
+	if err = txn.Rollback(); err != nil {
+		t.Fatalf("rollback read-only transaction: %v", err)
+	}
+	if _, err = db.Writable(); err != ErrReadOnlyTxn {
+		t.Fatalf("writable: expected ErrReadOnlyTxn, got %v", err)
+	}
+	if err = iter.Close(); err != nil {
+		t.Fatalf("close iterator: %v", err)
+	}
+}
+
+func TestForeignLevelDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "foreign_leveldb")
+	if _, err := OpenForeignLevelDB(path); err == nil {
+		t.Fatalf("open missing database: expected error")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("open missing database created it: %v", err)
+	}
+	db, err := OpenLevelDB(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if db, err = OpenForeignLevelDB(path); err != nil {
+		t.Fatalf("open foreign database: %v", err)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package backend
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"time"
+)
+
+// NewFS returns a read-only file system that exposes the keys of db
+// starting with prefix as slash-separated paths. The remainder of a key
+// after the prefix is the file name and the value is the file content.
+// Directories are implicit: a directory exists whenever at least one key
+// lies below it. A key shadows a directory of the same name, and keys
+// that are not valid fs paths are not visible.
+//
+// The returned file system implements fs.ReadFileFS and fs.StatFS.
+func NewFS(db DB, prefix []byte) fs.FS {
+	return &dbFS{db: db, prefix: append([]byte{}, prefix...)}
+}
+
+type dbFS struct {
+	db     DB
+	prefix []byte
+}
+
+func (f *dbFS) key(name string) []byte {
+	key := make([]byte, 0, len(f.prefix)+len(name))
+	return append(append(key, f.prefix...), name...)
+}
+
+// dirKey returns the key prefix of all entries in the directory name.
+func (f *dbFS) dirKey(name string) []byte {
+	if name == "." {
+		return f.key("")
+	}
+	return f.key(name + "/")
+}
+
+func (f *dbFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if name != "." {
+		data, err := f.readFile(name)
+		if err == nil {
+			return &fsFile{Reader: bytes.NewReader(data), info: fsFileInfo{name: name, size: int64(len(data))}}, nil
+		} else if err != ErrNotFound {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+	}
+
+	entries, err := f.readDir(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if entries == nil && name != "." {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return &fsDir{info: fsFileInfo{name: name, dir: true}, entries: entries}, nil
+}
+
+func (f *dbFS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: fs.ErrInvalid}
+	}
+	data, err := f.readFile(name)
+	if err == ErrNotFound {
+		err = fs.ErrNotExist
+		if entries, derr := f.readDir(name); derr == nil && entries != nil {
+			err = fs.ErrInvalid
+		}
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+	return data, nil
+}
+
+func (f *dbFS) Stat(name string) (fs.FileInfo, error) {
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err.(*fs.PathError).Err}
+	}
+	return file.Stat()
+}
+
+// readFile returns a copy of the value stored for name.
+func (f *dbFS) readFile(name string) ([]byte, error) {
+	txn, err := f.db.Readonly()
+	if err != nil {
+		return nil, err
+	}
+	defer txn.Rollback()
+
+	v, err := txn.Get(f.key(name))
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{}, v...), nil
+}
+
+// readDir returns the sorted entries of the directory name. It returns
+// nil if the directory does not exist.
+func (f *dbFS) readDir(name string) ([]fs.DirEntry, error) {
+	iter, err := f.db.Iterator()
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	prefix := f.dirKey(name)
+	seen := make(map[string]bool)
+	var entries []fs.DirEntry
+	for k, v := iter.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); {
+		rest := string(k[len(prefix):])
+		child, isDir := rest, false
+		if i := strings.IndexByte(rest, '/'); i >= 0 {
+			child, isDir = rest[:i], true
+		}
+
+		full := rest
+		if name != "." {
+			full = name + "/" + rest
+		}
+		if !fs.ValidPath(full) {
+			k, v = iter.Next()
+			continue
+		}
+
+		if !seen[child] {
+			seen[child] = true
+			info := fsFileInfo{name: child, dir: isDir}
+			if !isDir {
+				info.size = int64(len(v))
+			}
+			entries = append(entries, info)
+		}
+
+		if isDir {
+			// skip all keys below the child directory
+			next := append([]byte{}, prefix...)
+			next = append(append(next, child...), '/'+1)
+			k, v = iter.Seek(next)
+		} else {
+			k, v = iter.Next()
+		}
+	}
+	if err = iter.Close(); err != nil {
+		return nil, err
+	}
+
+	if entries == nil {
+		entries = []fs.DirEntry{}
+		if name != "." {
+			return nil, nil
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+type fsFileInfo struct {
+	name string
+	size int64
+	dir  bool
+}
+
+func (i fsFileInfo) Name() string {
+	if j := strings.LastIndexByte(i.name, '/'); j >= 0 {
+		return i.name[j+1:]
+	}
+	return i.name
+}
+
+func (i fsFileInfo) Size() int64 { return i.size }
+
+func (i fsFileInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (i fsFileInfo) ModTime() time.Time         { return time.Time{} }
+func (i fsFileInfo) IsDir() bool                { return i.dir }
+func (i fsFileInfo) Sys() interface{}           { return nil }
+func (i fsFileInfo) Type() fs.FileMode          { return i.Mode().Type() }
+func (i fsFileInfo) Info() (fs.FileInfo, error) { return i, nil }
+
+type fsFile struct {
+	*bytes.Reader
+	info fsFileInfo
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *fsFile) Close() error               { return nil }
+
+type fsDir struct {
+	info    fsFileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *fsDir) Close() error               { return nil }
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.offset += n
+	return rest[:n], nil
+}
@@ -0,0 +1,43 @@
+package backend
+
+import (
+	"os"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFS(t *testing.T) {
+	const path = "fs_boltdb.db"
+	db := openBoltDB(t, path)
+	defer closeBoltDB(t, path, db)
+
+	txn, err := db.Writable()
+	if err != nil {
+		t.Fatalf("begin writable transaction: %v", err)
+	}
+	for _, key := range []string{
+		"fs/a.txt",
+		"fs/dir/b.txt",
+		"fs/dir/sub/c.txt",
+		"fs/dir/sub/d.txt",
+		"fs/dir-x",
+		"fs//invalid",
+		"other/e.txt",
+	} {
+		if err = txn.Put([]byte(key), []byte("content of "+key)); err != nil {
+			t.Fatalf("put key %q: %v", key, err)
+		}
+	}
+	if err = txn.Commit(); err != nil {
+		t.Fatalf("commit writable transaction: %v", err)
+	}
+
+	fsys := NewFS(db, []byte("fs/"))
+	if err = fstest.TestFS(fsys, "a.txt", "dir/b.txt", "dir/sub/c.txt", "dir/sub/d.txt", "dir-x"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = fsys.Open("other/e.txt"); !os.IsNotExist(err) {
+		t.Fatalf("open: expected not exist error, got %v", err)
+	}
+}
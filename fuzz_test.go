@@ -0,0 +1,59 @@
+package backend_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/mars9/backend"
+	"github.com/mars9/backend/backendtest"
+)
+
+func openBoltDB(t testing.TB) backend.DB {
+	db, err := backend.OpenBoltDB(filepath.Join(t.TempDir(), "fuzz.db"), 0)
+	if err != nil {
+		t.Fatalf("opening BoltDB: %v", err)
+	}
+	return db
+}
+
+func openLevelDB(t testing.TB) backend.DB {
+	db, err := backend.OpenLevelDB(filepath.Join(t.TempDir(), "fuzz"))
+	if err != nil {
+		t.Fatalf("opening LevelDB: %v", err)
+	}
+	return db
+}
+
+func openMemDB(t testing.TB) backend.DB { return backend.NewMemDB() }
+
+func openBitcask(t testing.TB) backend.DB {
+	db, err := backend.OpenBitcask(filepath.Join(t.TempDir(), "fuzz"), backend.MaxFileSize(4096))
+	if err != nil {
+		t.Fatalf("opening Bitcask: %v", err)
+	}
+	return db
+}
+
+func openSharded(t testing.TB) backend.DB {
+	db, err := backend.NewSharded([]backend.DB{backend.NewMemDB(), backend.NewMemDB(), backend.NewMemDB()})
+	if err != nil {
+		t.Fatalf("opening Sharded: %v", err)
+	}
+	return db
+}
+
+func FuzzBoltTxnOps(f *testing.F)           { backendtest.FuzzTxnOps(f, openBoltDB) }
+func FuzzBoltIteratorSeeks(f *testing.F)    { backendtest.FuzzIteratorSeeks(f, openBoltDB) }
+func FuzzBoltRestore(f *testing.F)          { backendtest.FuzzRestore(f, openBoltDB) }
+func FuzzLevelTxnOps(f *testing.F)          { backendtest.FuzzTxnOps(f, openLevelDB) }
+func FuzzLevelIteratorSeeks(f *testing.F)   { backendtest.FuzzIteratorSeeks(f, openLevelDB) }
+func FuzzLevelRestore(f *testing.F)         { backendtest.FuzzRestore(f, openLevelDB) }
+func FuzzMemTxnOps(f *testing.F)            { backendtest.FuzzTxnOps(f, openMemDB) }
+func FuzzMemIteratorSeeks(f *testing.F)     { backendtest.FuzzIteratorSeeks(f, openMemDB) }
+func FuzzMemRestore(f *testing.F)           { backendtest.FuzzRestore(f, openMemDB) }
+func FuzzBitcaskTxnOps(f *testing.F)        { backendtest.FuzzTxnOps(f, openBitcask) }
+func FuzzBitcaskIteratorSeeks(f *testing.F) { backendtest.FuzzIteratorSeeks(f, openBitcask) }
+func FuzzBitcaskRestore(f *testing.F)       { backendtest.FuzzRestore(f, openBitcask) }
+func FuzzShardedTxnOps(f *testing.F)        { backendtest.FuzzTxnOps(f, openSharded) }
+func FuzzShardedIteratorSeeks(f *testing.F) { backendtest.FuzzIteratorSeeks(f, openSharded) }
+func FuzzShardedRestore(f *testing.F)       { backendtest.FuzzRestore(f, openSharded) }
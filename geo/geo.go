@@ -0,0 +1,240 @@
+// Package geo stores locations under geohash ordered keys and answers
+// radius queries with range scans.
+//
+// A location is encoded as a 52 bit cell, the interleaved bits of its
+// longitude and latitude at 26 bits each, which is precise to about half
+// a metre. Nearby locations share long cell prefixes, so a radius query
+// scans the few coarse cells covering the circle and filters the result
+// by distance:
+//
+//	key := geo.Key([]byte("shops/"), 52.52, 13.405, []byte("id-1"))
+//	txn.Put(key, shop)
+//	...
+//	places, err := geo.Nearby(db, []byte("shops/"), 52.52, 13.4, 500)
+package geo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+
+	"github.com/mars9/backend"
+	"github.com/mars9/backend/keys"
+)
+
+// EarthRadius is the mean earth radius in metres.
+const EarthRadius = 6371008.8
+
+const (
+	axisBits = 26 // bits per coordinate
+	cellSize = 8  // encoded size of a cell
+)
+
+var (
+	errInvalidPoint  = errors.New("geo: invalid coordinate")
+	errInvalidRadius = errors.New("geo: invalid radius")
+	errInvalidKey    = errors.New("geo: invalid key")
+)
+
+// Encode returns the cell of the location lat, lng.
+func Encode(lat, lng float64) uint64 {
+	return interleave(index(lng, -180, 360, axisBits), index(lat, -90, 180, axisBits))
+}
+
+// Decode returns the centre of cell.
+func Decode(cell uint64) (lat, lng float64) {
+	x, y := deinterleave(cell)
+	const n = 1 << axisBits
+	return (float64(y)+0.5)/n*180 - 90, (float64(x)+0.5)/n*360 - 180
+}
+
+// Key returns the key of id at the location lat, lng below prefix.
+func Key(prefix []byte, lat, lng float64, id []byte) []byte {
+	key := keys.AppendUint64(append([]byte{}, prefix...), Encode(lat, lng))
+	return append(key, id...)
+}
+
+// ParseKey returns the location and id of a key built by Key. The
+// location is the centre of the stored cell.
+func ParseKey(prefix, key []byte) (lat, lng float64, id []byte, err error) {
+	if !bytes.HasPrefix(key, prefix) || len(key) < len(prefix)+cellSize {
+		return 0, 0, nil, errInvalidKey
+	}
+	key = key[len(prefix):]
+	lat, lng = Decode(binary.BigEndian.Uint64(key))
+	return lat, lng, key[cellSize:], nil
+}
+
+// Put stores value for id at the location lat, lng.
+func Put(txn backend.RWTxn, prefix []byte, lat, lng float64, id, value []byte) error {
+	if !valid(lat, lng) {
+		return errInvalidPoint
+	}
+	return txn.Put(Key(prefix, lat, lng, id), value)
+}
+
+// Delete removes id stored at the location lat, lng.
+func Delete(txn backend.RWTxn, prefix []byte, lat, lng float64, id []byte) error {
+	if !valid(lat, lng) {
+		return errInvalidPoint
+	}
+	return txn.Delete(Key(prefix, lat, lng, id))
+}
+
+// Distance returns the great circle distance between two locations in
+// metres.
+func Distance(lat1, lng1, lat2, lng2 float64) float64 {
+	p1, p2 := lat1*math.Pi/180, lat2*math.Pi/180
+	dp, dl := p2-p1, (lng2-lng1)*math.Pi/180
+	a := math.Sin(dp/2)*math.Sin(dp/2) + math.Cos(p1)*math.Cos(p2)*math.Sin(dl/2)*math.Sin(dl/2)
+	return 2 * EarthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// Place is a location returned by Nearby.
+type Place struct {
+	ID       []byte
+	Lat, Lng float64 // centre of the stored cell
+	Distance float64 // metres from the query location
+	Value    []byte
+}
+
+// Nearby returns the places below prefix within radius metres of lat,
+// lng, ordered by distance.
+func Nearby(db backend.DB, prefix []byte, lat, lng, radius float64) ([]Place, error) {
+	if !valid(lat, lng) {
+		return nil, errInvalidPoint
+	}
+	if radius < 0 || math.IsNaN(radius) || math.IsInf(radius, 0) {
+		return nil, errInvalidRadius
+	}
+
+	iter, err := db.Iterator()
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var places []Place
+	for _, r := range cover(lat, lng, radius) {
+		start := keys.AppendUint64(append([]byte{}, prefix...), r.start)
+		for k, v := iter.Seek(start); k != nil && bytes.HasPrefix(k, prefix); k, v = iter.Next() {
+			if len(k) < len(prefix)+cellSize {
+				continue
+			}
+			cell := binary.BigEndian.Uint64(k[len(prefix):])
+			if cell >= r.end {
+				break
+			}
+			plat, plng := Decode(cell)
+			d := Distance(lat, lng, plat, plng)
+			if d > radius {
+				continue
+			}
+			places = append(places, Place{
+				ID:       append([]byte{}, k[len(prefix)+cellSize:]...),
+				Lat:      plat,
+				Lng:      plng,
+				Distance: d,
+				Value:    append([]byte{}, v...),
+			})
+		}
+	}
+	sort.SliceStable(places, func(i, j int) bool { return places[i].Distance < places[j].Distance })
+	return places, iter.Close()
+}
+
+// cellRange is the range [start, end) of cells below a coarse cell.
+type cellRange struct{ start, end uint64 }
+
+// maxCover bounds the number of coarse cells scanned by Nearby.
+const maxCover = 9
+
+// cover returns the sorted ranges of the coarsest cells covering the
+// bounding box of the circle at lat, lng, using the finest level that
+// needs at most maxCover cells.
+func cover(lat, lng, radius float64) []cellRange {
+	dLat := radius / EarthRadius * 180 / math.Pi
+	latLo, latHi := math.Max(lat-dLat, -90), math.Min(lat+dLat, 90)
+	dLng := 180.0
+	if latLo > -90 && latHi < 90 {
+		// the box is widest at the latitude nearest to a pole
+		c := math.Cos(math.Max(math.Abs(latLo), math.Abs(latHi)) * math.Pi / 180)
+		dLng = math.Min(dLat/c, 180)
+	}
+
+	for level := uint(axisBits); ; level-- {
+		n := int64(1) << level
+		ylo, yhi := index(latLo, -90, 180, level), index(latHi, -90, 180, level)
+		xlo := int64(math.Floor((lng - dLng + 180) / 360 * float64(n)))
+		xhi := int64(math.Floor((lng + dLng + 180) / 360 * float64(n)))
+		if xhi-xlo+1 > n || dLng >= 180 {
+			xlo, xhi = 0, n-1
+		}
+		if level > 0 && (int64(yhi-ylo)+1)*(xhi-xlo+1) > maxCover {
+			continue
+		}
+
+		shift := 2 * (axisBits - level)
+		var ranges []cellRange
+		for x := xlo; x <= xhi; x++ {
+			wx := uint32(((x % n) + n) % n)
+			for y := ylo; y <= yhi; y++ {
+				start := interleave(wx<<(axisBits-level), y<<(axisBits-level))
+				ranges = append(ranges, cellRange{start, start + 1<<shift})
+			}
+		}
+		sort.Slice(ranges, func(i, j int) bool { return ranges[i].start < ranges[j].start })
+		return ranges
+	}
+}
+
+func valid(lat, lng float64) bool {
+	return lat >= -90 && lat <= 90 && lng >= -180 && lng <= 180
+}
+
+// index returns the cell index of v in [min, min+span) divided into
+// 2^level cells.
+func index(v, min, span float64, level uint) uint32 {
+	n := float64(uint64(1) << level)
+	i := math.Floor((v - min) / span * n)
+	if i < 0 {
+		return 0
+	} else if i >= n {
+		return uint32(n - 1)
+	}
+	return uint32(i)
+}
+
+// interleave returns the bits of x and y interleaved, starting with the
+// most significant bit of x.
+func interleave(x, y uint32) uint64 {
+	return spread(x)<<1 | spread(y)
+}
+
+func deinterleave(cell uint64) (x, y uint32) {
+	return squash(cell >> 1), squash(cell)
+}
+
+// spread moves bit i of v to bit 2i.
+func spread(v uint32) uint64 {
+	x := uint64(v)
+	x = (x | x<<16) & 0x0000ffff0000ffff
+	x = (x | x<<8) & 0x00ff00ff00ff00ff
+	x = (x | x<<4) & 0x0f0f0f0f0f0f0f0f
+	x = (x | x<<2) & 0x3333333333333333
+	x = (x | x<<1) & 0x5555555555555555
+	return x
+}
+
+// squash moves bit 2i of v to bit i.
+func squash(v uint64) uint32 {
+	x := v & 0x5555555555555555
+	x = (x | x>>1) & 0x3333333333333333
+	x = (x | x>>2) & 0x0f0f0f0f0f0f0f0f
+	x = (x | x>>4) & 0x00ff00ff00ff00ff
+	x = (x | x>>8) & 0x0000ffff0000ffff
+	x = (x | x>>16) & 0x00000000ffffffff
+	return uint32(x)
+}
@@ -0,0 +1,102 @@
+package geo
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mars9/backend"
+)
+
+func TestEncode(t *testing.T) {
+	for _, p := range [][2]float64{{0, 0}, {52.52, 13.405}, {-33.8688, 151.2093}, {90, 180}, {-90, -180}} {
+		lat, lng := Decode(Encode(p[0], p[1]))
+		if d := Distance(p[0], p[1], lat, lng); d > 1 {
+			t.Fatalf("decode %v: off by %.2fm", p, d)
+		}
+	}
+	// nearby locations share a long prefix
+	a, b := Encode(52.52, 13.405), Encode(52.5201, 13.4051)
+	if a>>20 != b>>20 {
+		t.Fatalf("encode: expected shared prefix, got %x and %x", a, b)
+	}
+}
+
+func TestDistance(t *testing.T) {
+	// Berlin to Paris is about 878km
+	if d := Distance(52.52, 13.405, 48.8566, 2.3522); math.Abs(d-877.5e3) > 2e3 {
+		t.Fatalf("distance: got %.0fm", d)
+	}
+}
+
+func TestNearby(t *testing.T) {
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("geo-%d.db", os.Getpid()))
+	db, err := backend.OpenBoltDB(path, 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer os.Remove(path)
+	defer db.Close()
+
+	prefix := []byte("p/")
+	places := map[string][2]float64{
+		"alex":   {52.5219, 13.4132},
+		"gate":   {52.5163, 13.3777},
+		"tegel":  {52.5597, 13.2877},
+		"paris":  {48.8566, 2.3522},
+		"fiji-w": {-17.7134, 179.9},
+		"fiji-e": {-17.7134, -179.9},
+	}
+	txn, err := db.Writable()
+	if err != nil {
+		t.Fatalf("writable: %v", err)
+	}
+	for id, p := range places {
+		if err = Put(txn, prefix, p[0], p[1], []byte(id), []byte(id)); err != nil {
+			t.Fatalf("put %s: %v", id, err)
+		}
+	}
+	if err = txn.Put([]byte("q"), []byte("outside")); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if err = txn.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	for _, tc := range []struct {
+		lat, lng, radius float64
+		want             string
+	}{
+		{52.52, 13.405, 1000, "[alex]"},
+		{52.52, 13.405, 3000, "[alex gate]"},
+		{52.52, 13.405, 15000, "[alex gate tegel]"},
+		{52.52, 13.405, 0, "[]"},
+		{-17.7134, -179.99, 30000, "[fiji-e fiji-w]"},
+		{48.85, 2.35, 1e6, "[paris tegel gate alex]"},
+		{90, 0, 1e5, "[]"},
+	} {
+		found, err := Nearby(db, prefix, tc.lat, tc.lng, tc.radius)
+		if err != nil {
+			t.Fatalf("nearby: %v", err)
+		}
+		ids := make([]string, len(found))
+		for i, p := range found {
+			ids[i] = string(p.ID)
+			if string(p.Value) != ids[i] {
+				t.Fatalf("nearby: value %q for %q", p.Value, p.ID)
+			}
+		}
+		if got := fmt.Sprint(ids); got != tc.want {
+			t.Fatalf("nearby %v,%v %vm: expected %s, got %s", tc.lat, tc.lng, tc.radius, tc.want, got)
+		}
+	}
+
+	if _, err = Nearby(db, prefix, 91, 0, 1); err != errInvalidPoint {
+		t.Fatalf("nearby: expected errInvalidPoint, got %v", err)
+	}
+	if _, err = Nearby(db, prefix, 0, 0, -1); err != errInvalidRadius {
+		t.Fatalf("nearby: expected errInvalidRadius, got %v", err)
+	}
+}
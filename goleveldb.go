@@ -0,0 +1,315 @@
+package backend
+
+import (
+	"errors"
+	"io"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+var _ DB = (*GoLevelDB)(nil)
+
+// GoLevelOption configures a GoLevelDB.
+type GoLevelOption func(*opt.Options) error
+
+// GoLevelOptions replaces the goleveldb options the database is opened
+// with.
+func GoLevelOptions(o opt.Options) GoLevelOption {
+	return func(opts *opt.Options) error {
+		*opts = o
+		return nil
+	}
+}
+
+// GoLevelDB is a LevelDB database implemented in pure Go by goleveldb.
+// Unlike LevelDB it needs neither cgo nor the LevelDB C library, which
+// eases cross-compilation. The file formats of both are compatible.
+type GoLevelDB struct {
+	tree   *leveldb.DB
+	writer fifoMutex // grants write transactions in FIFO order
+	refs   refCount  // open iterators and transactions
+}
+
+// OpenGoLevelDB creates and opens a database in the directory root. If
+// the directory does not exist then it will be created automatically.
+func OpenGoLevelDB(root string, opts ...GoLevelOption) (*GoLevelDB, error) {
+	o := &opt.Options{}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	tree, err := leveldb.OpenFile(root, o)
+	if err != nil {
+		return nil, err
+	}
+	return &GoLevelDB{tree: tree}, nil
+}
+
+func (db *GoLevelDB) Name() string { return "GoLevelDB" }
+
+func (db *GoLevelDB) Close() error {
+	if db == nil {
+		return errors.New("closing unopened GoLevelDB instance")
+	}
+	if err := db.refs.close(); err != nil {
+		return err
+	}
+	err := db.tree.Close()
+	db.tree = nil
+	return err
+}
+
+// WriteTo writes the entire database to w using the export format. See
+// Export for details.
+func (db *GoLevelDB) WriteTo(w io.Writer) (int64, error) {
+	return Export(w, db)
+}
+
+// ApproximateSize returns the approximate file system space used by the
+// keys in [start, limit).
+func (db *GoLevelDB) ApproximateSize(start, limit []byte) (uint64, error) {
+	if err := db.refs.acquire(); err != nil {
+		return 0, err
+	}
+	defer db.refs.release()
+	sizes, err := db.tree.SizeOf([]util.Range{{Start: start, Limit: limit}})
+	if err != nil {
+		return 0, err
+	}
+	return uint64(sizes[0]), nil
+}
+
+// WriteQueueDepth returns the number of callers blocked in Writable.
+func (db *GoLevelDB) WriteQueueDepth() int {
+	return db.writer.waiting()
+}
+
+// snapshot returns a new snapshot holding a reference on db, which is
+// released with the snapshot.
+func (db *GoLevelDB) snapshot() (*leveldb.Snapshot, error) {
+	if err := db.refs.acquire(); err != nil {
+		return nil, err
+	}
+	snap, err := db.tree.GetSnapshot()
+	if err != nil {
+		db.refs.release()
+		return nil, err
+	}
+	return snap, nil
+}
+
+func (db *GoLevelDB) Iterator() (Iterator, error) {
+	snap, err := db.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &goLevelIterator{iter: snap.NewIterator(nil, nil), snap: snap, db: db}, nil
+}
+
+func (db *GoLevelDB) Readonly() (Txn, error) {
+	snap, err := db.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &goLevelReadTxn{snap: snap, refs: &db.refs}, nil
+}
+
+// Writable starts a new write transaction. Blocked callers are granted
+// the transaction in the order they called Writable. The transaction
+// observes its own writes.
+func (db *GoLevelDB) Writable() (RWTxn, error) {
+	db.writer.Lock()
+	if err := db.refs.acquire(); err != nil {
+		db.writer.Unlock()
+		return nil, err
+	}
+	tr, err := db.tree.OpenTransaction()
+	if err != nil {
+		db.refs.release()
+		db.writer.Unlock()
+		return nil, err
+	}
+	return &goLevelTxn{tr: tr, db: db}, nil
+}
+
+// goLevelIterator iterates over a snapshot. Keys and values are only
+// valid until the iterator moves.
+type goLevelIterator struct {
+	iter iterator.Iterator
+	snap *leveldb.Snapshot
+	db   *GoLevelDB
+}
+
+func (i *goLevelIterator) current(ok bool) ([]byte, []byte) {
+	if !ok {
+		return nil, nil
+	}
+	return i.iter.Key(), i.iter.Value()
+}
+
+func (i *goLevelIterator) Seek(key []byte) ([]byte, []byte) {
+	if i == nil || i.db == nil {
+		return nil, nil
+	}
+	return i.current(i.iter.Seek(key))
+}
+
+func (i *goLevelIterator) First() ([]byte, []byte) {
+	if i == nil || i.db == nil {
+		return nil, nil
+	}
+	return i.current(i.iter.First())
+}
+
+func (i *goLevelIterator) Last() ([]byte, []byte) {
+	if i == nil || i.db == nil {
+		return nil, nil
+	}
+	return i.current(i.iter.Last())
+}
+
+func (i *goLevelIterator) Next() ([]byte, []byte) {
+	if i == nil || i.db == nil {
+		return nil, nil
+	}
+	return i.current(i.iter.Next())
+}
+
+func (i *goLevelIterator) Prev() ([]byte, []byte) {
+	if i == nil || i.db == nil {
+		return nil, nil
+	}
+	return i.current(i.iter.Prev())
+}
+
+func (i *goLevelIterator) SeekToIndex(n int64) ([]byte, []byte) {
+	if i == nil || i.db == nil {
+		return nil, nil
+	}
+	return estimateIndex(i, i.db, n)
+}
+
+// Reset releases the iterator and snapshot and creates new ones on the
+// current state of the database.
+func (i *goLevelIterator) Reset() error {
+	if i == nil || i.db == nil {
+		return errors.New("reset closed iterator")
+	}
+	snap, err := i.db.tree.GetSnapshot()
+	if err != nil {
+		return err
+	}
+	err = i.iter.Error()
+	i.iter.Release()
+	i.snap.Release()
+	i.iter, i.snap = snap.NewIterator(nil, nil), snap
+	return err
+}
+
+func (i *goLevelIterator) Close() error {
+	if i == nil || i.db == nil {
+		return nil
+	}
+	err := i.iter.Error()
+	i.iter.Release()
+	i.snap.Release()
+	i.db.refs.release()
+	i.db = nil
+	return err
+}
+
+// goLevelReadTxn is a read-only transaction on a snapshot. Its write
+// methods only exist to reject writes after a type assertion to RWTxn.
+type goLevelReadTxn struct {
+	snap *leveldb.Snapshot
+	refs *refCount
+}
+
+func (t *goLevelReadTxn) Get(key []byte) ([]byte, error) {
+	if t == nil || t.snap == nil {
+		return nil, nil
+	}
+	v, err := t.snap.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, ErrNotFound
+	}
+	return v, err
+}
+
+func (t *goLevelReadTxn) Rollback() error {
+	if t == nil || t.snap == nil {
+		return nil
+	}
+	t.snap.Release()
+	t.snap = nil
+	t.refs.release()
+	return nil
+}
+
+func (t *goLevelReadTxn) Put(key, value []byte) error { return ErrReadOnlyTxn }
+func (t *goLevelReadTxn) Delete(key []byte) error     { return ErrReadOnlyTxn }
+func (t *goLevelReadTxn) Commit() error               { return ErrReadOnlyTxn }
+
+// goLevelTxn is a goleveldb transaction. It holds the writer lock until
+// it is committed or discarded.
+type goLevelTxn struct {
+	tr *leveldb.Transaction
+	db *GoLevelDB
+}
+
+func (t *goLevelTxn) Get(key []byte) ([]byte, error) {
+	if t == nil || t.tr == nil {
+		return nil, nil
+	}
+	v, err := t.tr.Get(key, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, ErrNotFound
+	}
+	return v, err
+}
+
+func (t *goLevelTxn) Put(key, value []byte) error {
+	if t == nil || t.tr == nil {
+		return nil
+	}
+	return t.tr.Put(key, value, nil)
+}
+
+func (t *goLevelTxn) Delete(key []byte) error {
+	if t == nil || t.tr == nil {
+		return nil
+	}
+	return t.tr.Delete(key, nil)
+}
+
+// end releases the transaction's hold on the database.
+func (t *goLevelTxn) end() {
+	t.tr = nil
+	t.db.refs.release()
+	t.db.writer.Unlock()
+}
+
+func (t *goLevelTxn) Commit() error {
+	if t == nil || t.tr == nil {
+		return nil
+	}
+	err := t.tr.Commit()
+	if err != nil {
+		t.tr.Discard()
+	}
+	t.end()
+	return err
+}
+
+func (t *goLevelTxn) Rollback() error {
+	if t == nil || t.tr == nil {
+		return nil
+	}
+	t.tr.Discard()
+	t.end()
+	return nil
+}
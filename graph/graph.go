@@ -0,0 +1,143 @@
+// Package graph stores a directed graph with edge values in a
+// backend.DB. Every edge is stored twice, keyed by its source and by its
+// target, so the neighbors of a node are found by a prefix scan in either
+// direction. Keys are built with package keys.
+package graph
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+
+	"github.com/mars9/backend"
+	"github.com/mars9/backend/keys"
+)
+
+var errCorruptEdge = errors.New("graph: corrupt edge key")
+
+// Direction selects the edges of a node.
+type Direction int
+
+const (
+	Out  Direction = iota // edges starting at the node
+	In                    // edges ending at the node
+	Both                  // edges in either direction
+)
+
+// Graph keeps outgoing edges below prefix+"o" and incoming edges below
+// prefix+"i".
+type Graph struct {
+	db  backend.DB
+	out []byte
+	in  []byte
+}
+
+// New returns a Graph keeping its data below prefix in db.
+func New(db backend.DB, prefix []byte) *Graph {
+	return &Graph{
+		db:  db,
+		out: append(append([]byte{}, prefix...), 'o'),
+		in:  append(append([]byte{}, prefix...), 'i'),
+	}
+}
+
+func edgeKey(prefix, a, b []byte) []byte {
+	key := make([]byte, 0, len(prefix)+len(a)+len(b)+4)
+	return keys.AppendBytes(keys.AppendBytes(append(key, prefix...), a), b)
+}
+
+// AddEdge adds the edge from -> to with value in txn, replacing the
+// value of an existing edge.
+func (g *Graph) AddEdge(txn backend.RWTxn, from, to, value []byte) error {
+	if err := txn.Put(edgeKey(g.out, from, to), value); err != nil {
+		return err
+	}
+	return txn.Put(edgeKey(g.in, to, from), []byte{})
+}
+
+// RemoveEdge removes the edge from -> to in txn.
+func (g *Graph) RemoveEdge(txn backend.RWTxn, from, to []byte) error {
+	if err := txn.Delete(edgeKey(g.out, from, to)); err != nil {
+		return err
+	}
+	return txn.Delete(edgeKey(g.in, to, from))
+}
+
+// Edge returns the value of the edge from -> to in txn, or
+// backend.ErrNotFound.
+func (g *Graph) Edge(txn backend.Txn, from, to []byte) ([]byte, error) {
+	return txn.Get(edgeKey(g.out, from, to))
+}
+
+// Neighbors returns the nodes connected to n by an edge in direction dir
+// in ascending order.
+func (g *Graph) Neighbors(n []byte, dir Direction) ([][]byte, error) {
+	var nodes [][]byte
+	collect := func(prefix []byte) error {
+		start := keys.AppendBytes(append([]byte{}, prefix...), n)
+		return g.scan(start, func(key, value []byte) error {
+			p := keys.NewParser(key[len(start):])
+			node := p.Bytes()
+			if p.Err() != nil || !p.Done() {
+				return errCorruptEdge
+			}
+			nodes = append(nodes, node)
+			return nil
+		})
+	}
+
+	if dir == Out || dir == Both {
+		if err := collect(g.out); err != nil {
+			return nil, err
+		}
+	}
+	if dir == In || dir == Both {
+		if err := collect(g.in); err != nil {
+			return nil, err
+		}
+	}
+	if dir == Both {
+		sort.Slice(nodes, func(i, j int) bool { return bytes.Compare(nodes[i], nodes[j]) < 0 })
+		uniq := nodes[:0]
+		for i, node := range nodes {
+			if i == 0 || !bytes.Equal(node, nodes[i-1]) {
+				uniq = append(uniq, node)
+			}
+		}
+		nodes = uniq
+	}
+	return nodes, nil
+}
+
+// Edges calls fn for every edge whose source node starts with prefix,
+// ordered by source and target. The arguments are only valid during the
+// call. Edges stops and returns the error if fn returns one.
+func (g *Graph) Edges(prefix []byte, fn func(from, to, value []byte) error) error {
+	// the encoding of a node without its terminator is a prefix of the
+	// encoding of all nodes it is a prefix of
+	enc := keys.AppendBytes(append([]byte{}, g.out...), prefix)
+	start := enc[:len(enc)-2]
+	return g.scan(start, func(key, value []byte) error {
+		p := keys.NewParser(key[len(g.out):])
+		from, to := p.Bytes(), p.Bytes()
+		if p.Err() != nil || !p.Done() {
+			return errCorruptEdge
+		}
+		return fn(from, to, value)
+	})
+}
+
+func (g *Graph) scan(prefix []byte, fn func(key, value []byte) error) error {
+	iter, err := g.db.Iterator()
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	for k, v := iter.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = iter.Next() {
+		if err = fn(k, v); err != nil {
+			return err
+		}
+	}
+	return iter.Close()
+}
@@ -0,0 +1,89 @@
+package graph
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/mars9/backend"
+)
+
+func TestGraph(t *testing.T) {
+	const path = "graph_test.db"
+	db, err := backend.OpenBoltDB(path, 0)
+	if err != nil {
+		t.Fatalf("opening BoltDB %q: %v", path, err)
+	}
+	defer os.Remove(path)
+	defer db.Close()
+
+	g := New(db, []byte("deps/"))
+	err = backend.Update(db, func(txn backend.RWTxn) error {
+		for _, e := range [][3]string{
+			{"app", "lib", "v1"},
+			{"app", "log", "v2"},
+			{"lib", "log", "v2"},
+			{"lib\x00x", "app", ""},
+			{"tool", "lib", "v1"},
+		} {
+			if err := g.AddEdge(txn, []byte(e[0]), []byte(e[1]), []byte(e[2])); err != nil {
+				return err
+			}
+		}
+		return g.RemoveEdge(txn, []byte("tool"), []byte("lib"))
+	})
+	if err != nil {
+		t.Fatalf("add edges: %v", err)
+	}
+
+	neighbors := func(n string, dir Direction) []string {
+		nodes, err := g.Neighbors([]byte(n), dir)
+		if err != nil {
+			t.Fatalf("neighbors of %q: %v", n, err)
+		}
+		s := []string{}
+		for _, node := range nodes {
+			s = append(s, string(node))
+		}
+		return s
+	}
+	for _, test := range []struct {
+		node     string
+		dir      Direction
+		expected []string
+	}{
+		{"app", Out, []string{"lib", "log"}},
+		{"app", In, []string{"lib\x00x"}},
+		{"lib", Both, []string{"app", "log"}},
+		{"log", In, []string{"app", "lib"}},
+		{"tool", Out, []string{}},
+	} {
+		if nodes := neighbors(test.node, test.dir); !reflect.DeepEqual(nodes, test.expected) {
+			t.Fatalf("neighbors of %q in direction %d: expected %q, got %q", test.node, test.dir, test.expected, nodes)
+		}
+	}
+
+	var edges []string
+	err = g.Edges([]byte("li"), func(from, to, value []byte) error {
+		edges = append(edges, fmt.Sprintf("%s->%s=%s", from, to, value))
+		return nil
+	})
+	expected := []string{"lib->log=v2", "lib\x00x->app="}
+	if err != nil || !reflect.DeepEqual(edges, expected) {
+		t.Fatalf("edges: expected %q, got %q, %v", expected, edges, err)
+	}
+
+	err = backend.View(db, func(txn backend.Txn) error {
+		if v, err := g.Edge(txn, []byte("app"), []byte("log")); err != nil || string(v) != "v2" {
+			return fmt.Errorf("edge app->log: expected v2, got %q, %v", v, err)
+		}
+		if _, err := g.Edge(txn, []byte("tool"), []byte("lib")); err != backend.ErrNotFound {
+			return fmt.Errorf("removed edge: expected ErrNotFound, got %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
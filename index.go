@@ -0,0 +1,107 @@
+package backend
+
+import "bytes"
+
+// indexSample is the number of pairs read from the front of the database
+// to estimate the storage used per pair.
+const indexSample = 1024
+
+// walkToIndex moves it to the n-th key by stepping from the first key.
+// It is exact but reads all n preceding pairs.
+func walkToIndex(it Iterator, n int64) ([]byte, []byte) {
+	if n < 0 {
+		return nil, nil
+	}
+	k, v := it.First()
+	for ; k != nil && n > 0; n-- {
+		k, v = it.Next()
+	}
+	return k, v
+}
+
+// estimateIndex moves it close to the n-th key of a database whose
+// storage e can estimate. It derives the storage used per pair from the
+// first indexSample pairs and bisects the key space for the key preceded
+// by n pairs worth of storage. Small indexes, and databases reporting no
+// storage for the sample, e.g. while all data is in the memtable, are
+// walked instead.
+func estimateIndex(it Iterator, e sizeEstimator, n int64) ([]byte, []byte) {
+	if n < indexSample {
+		return walkToIndex(it, n)
+	}
+	k, _ := it.First()
+	if k == nil {
+		return nil, nil
+	}
+	first := append([]byte{}, k...)
+	for i := 0; k != nil && i < indexSample; i++ {
+		k, _ = it.Next()
+	}
+	if k == nil {
+		return nil, nil // fewer than n keys
+	}
+	lo := append([]byte{}, k...)
+	last, _ := it.Last()
+	hi := append(append([]byte{}, last...), 0)
+
+	sample, err := e.ApproximateSize(first, lo)
+	if err != nil || sample == 0 {
+		return walkToIndex(it, n)
+	}
+	total, err := e.ApproximateSize(first, hi)
+	if err != nil {
+		return walkToIndex(it, n)
+	}
+	target := float64(sample) / indexSample * float64(n)
+	if target >= float64(total) {
+		return nil, nil // estimated past the last key
+	}
+	for i := 0; i < 32; i++ {
+		mid := keyMidpoint(lo, hi)
+		if bytes.Compare(mid, lo) <= 0 || bytes.Compare(mid, hi) >= 0 {
+			break
+		}
+		size, err := e.ApproximateSize(first, mid)
+		if err != nil {
+			return walkToIndex(it, n)
+		}
+		if float64(size) < target {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return it.Seek(lo)
+}
+
+// keyMidpoint returns the key halfway between a and b, reading both as
+// base-256 fractions. The result is one byte longer than the longer key.
+func keyMidpoint(a, b []byte) []byte {
+	n := len(a)
+	if len(b) > n {
+		n = len(b)
+	}
+	n++
+	digit := func(key []byte, i int) int {
+		if i < len(key) {
+			return int(key[i])
+		}
+		return 0
+	}
+
+	sum := make([]int, n+1) // sum[0] holds the carry out of the first byte
+	carry := 0
+	for i := n - 1; i >= 0; i-- {
+		s := digit(a, i) + digit(b, i) + carry
+		sum[i+1], carry = s&0xff, s>>8
+	}
+	sum[0] = carry
+
+	mid := make([]byte, n)
+	rem := sum[0]
+	for i := 0; i < n; i++ {
+		cur := rem<<8 | sum[i+1]
+		mid[i], rem = byte(cur>>1), cur&1
+	}
+	return mid
+}
@@ -0,0 +1,106 @@
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"testing"
+)
+
+func TestKeyMidpoint(t *testing.T) {
+	for _, test := range []struct {
+		a, b, want string
+	}{
+		{"a", "c", "b\x00"},
+		{"a", "b", "a\x80"},
+		{"", "\x01", "\x00\x80"},
+		{"ab", "b", "a\xb1\x00"},
+		{"\xff", "\xff\xff", "\xff\x7f\x80"},
+	} {
+		mid := keyMidpoint([]byte(test.a), []byte(test.b))
+		if string(mid) != test.want {
+			t.Fatalf("midpoint of %q and %q: expected %q, got %q", test.a, test.b, test.want, mid)
+		}
+		if bytes.Compare(mid, []byte(test.a)) <= 0 || bytes.Compare(mid, []byte(test.b)) >= 0 {
+			t.Fatalf("midpoint of %q and %q: %q out of range", test.a, test.b, mid)
+		}
+	}
+}
+
+// keyEstimator reports 100 bytes of storage per key in a range.
+type keyEstimator []string
+
+func (e keyEstimator) ApproximateSize(start, limit []byte) (uint64, error) {
+	lo := sort.SearchStrings(e, string(start))
+	hi := sort.SearchStrings(e, string(limit))
+	return uint64(hi-lo) * 100, nil
+}
+
+func TestEstimateIndex(t *testing.T) {
+	db := NewMemDB()
+	defer db.Close()
+
+	var keys keyEstimator
+	if err := Update(db, func(txn RWTxn) error {
+		for i := 0; i < 10000; i++ {
+			k := fmt.Sprintf("key%05d", i*7)
+			keys = append(keys, k)
+			if err := txn.Put([]byte(k), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	iter, err := db.Iterator()
+	if err != nil {
+		t.Fatalf("iterator: %v", err)
+	}
+	defer iter.Close()
+	for _, n := range []int{0, 500, 1500, 5000, 9999} {
+		k, _ := estimateIndex(iter, keys, int64(n))
+		got := sort.SearchStrings(keys, string(k))
+		if got < n-2 || got > n+2 {
+			t.Fatalf("estimate index %d: landed on %q at index %d", n, k, got)
+		}
+	}
+	if k, _ := estimateIndex(iter, keys, 10000); k != nil {
+		t.Fatalf("estimate index past end: expected nil, got %q", k)
+	}
+	// no storage reported, walk instead
+	if k, _ := estimateIndex(iter, keyEstimator(nil), 4321); string(k) != keys[4321] {
+		t.Fatalf("estimate index without storage: expected %q, got %q", keys[4321], k)
+	}
+}
+
+func TestExplainScanEstimatedKeys(t *testing.T) {
+	db := NewMemDB()
+	defer db.Close()
+
+	var keys keyEstimator
+	if err := Update(db, func(txn RWTxn) error {
+		for i := 0; i < 5000; i++ {
+			k := fmt.Sprintf("key%05d", i)
+			keys = append(keys, k)
+			if err := txn.Put([]byte(k), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	plan, err := ExplainScan(struct {
+		*MemDB
+		keyEstimator
+	}{db, keys}, []byte("key0"))
+	if err != nil {
+		t.Fatalf("explain scan: %v", err)
+	}
+	if plan.EstimatedBytes != 500000 || plan.EstimatedKeys != 5000 {
+		t.Fatalf("explain scan: expected 5000 keys in 500000 bytes, got %d in %d", plan.EstimatedKeys, plan.EstimatedBytes)
+	}
+}
@@ -0,0 +1,99 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+)
+
+// Unlock releases locks acquired by KeyLocker.LockKeys. Calling it more
+// than once has no effect.
+type Unlock func()
+
+// KeyLocker provides in-process advisory locks on keys for multi-step
+// workflows that span several transactions. Locks are not enforced by
+// the database; all participants must lock through the same KeyLocker.
+type KeyLocker struct {
+	mu    sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	ch   chan struct{} // holds a token while the key is locked
+	refs int           // holders and waiters
+}
+
+// NewKeyLocker returns a KeyLocker without any locked keys.
+func NewKeyLocker() *KeyLocker {
+	return &KeyLocker{locks: make(map[string]*keyLock)}
+}
+
+// LockKeys blocks until all keys are locked or ctx is done. Keys are
+// acquired in ascending order, so concurrent callers locking overlapping
+// key sets cannot deadlock. If ctx is done first, all keys acquired so
+// far are released and ctx.Err() is returned.
+func (l *KeyLocker) LockKeys(ctx context.Context, keys ...[]byte) (Unlock, error) {
+	sorted := make([][]byte, len(keys))
+	copy(sorted, keys)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i], sorted[j]) < 0 })
+
+	var locked []string
+	for i, key := range sorted {
+		if i > 0 && bytes.Equal(key, sorted[i-1]) {
+			continue
+		}
+		if err := l.lock(ctx, string(key)); err != nil {
+			for j := len(locked) - 1; j >= 0; j-- {
+				l.unlock(locked[j])
+			}
+			return nil, err
+		}
+		locked = append(locked, string(key))
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			for j := len(locked) - 1; j >= 0; j-- {
+				l.unlock(locked[j])
+			}
+		})
+	}, nil
+}
+
+func (l *KeyLocker) lock(ctx context.Context, key string) error {
+	l.mu.Lock()
+	kl, found := l.locks[key]
+	if !found {
+		kl = &keyLock{ch: make(chan struct{}, 1)}
+		l.locks[key] = kl
+	}
+	kl.refs++
+	l.mu.Unlock()
+
+	select {
+	case kl.ch <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		l.release(key, kl)
+		return ctx.Err()
+	}
+}
+
+func (l *KeyLocker) unlock(key string) {
+	l.mu.Lock()
+	kl := l.locks[key]
+	l.mu.Unlock()
+	<-kl.ch
+	l.release(key, kl)
+}
+
+// release drops a reference and forgets the lock once it is unused.
+func (l *KeyLocker) release(key string, kl *keyLock) {
+	l.mu.Lock()
+	if kl.refs--; kl.refs == 0 {
+		delete(l.locks, key)
+	}
+	l.mu.Unlock()
+}
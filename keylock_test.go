@@ -0,0 +1,61 @@
+package backend
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeyLocker(t *testing.T) {
+	l := NewKeyLocker()
+	unlock, err := l.LockKeys(context.Background(), []byte("b"), []byte("a"), []byte("a"))
+	if err != nil {
+		t.Fatalf("lock keys: %v", err)
+	}
+
+	// overlapping keys time out while held
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err = l.LockKeys(ctx, []byte("c"), []byte("b")); err != context.DeadlineExceeded {
+		t.Fatalf("lock held key: expected deadline exceeded, got %v", err)
+	}
+	// the partially acquired key was released again
+	unlockC, err := l.LockKeys(context.Background(), []byte("c"))
+	if err != nil {
+		t.Fatalf("lock released key: %v", err)
+	}
+	unlockC()
+	unlock()
+	unlock()
+
+	// concurrent workers with overlapping keys in opposite order
+	var wg sync.WaitGroup
+	counter := 0
+	for i := 0; i < 8; i++ {
+		keys := [][]byte{[]byte("x"), []byte("y")}
+		if i%2 == 1 {
+			keys[0], keys[1] = keys[1], keys[0]
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				unlock, err := l.LockKeys(context.Background(), keys...)
+				if err != nil {
+					t.Errorf("lock keys: %v", err)
+					return
+				}
+				counter++
+				unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if counter != 800 {
+		t.Fatalf("lock keys: expected 800 increments, got %d", counter)
+	}
+	if len(l.locks) != 0 {
+		t.Fatalf("lock keys: expected no remaining locks, got %d", len(l.locks))
+	}
+}
@@ -0,0 +1,175 @@
+// Package keys builds and parses ordered composite keys.
+//
+// A composite key is a sequence of components, e.g. tenant, type, id and
+// timestamp. Encoded keys sort like their components compared one after
+// another, and a key built from the first components of another key is
+// a prefix of it, so prefix scans select e.g. all keys of a tenant.
+//
+// String and byte components are terminated by 0x00 0x01, and zero bytes
+// within them are escaped as 0x00 0xFF, so no component can collide with
+// a separator. Integers and times are fixed width big endian; the sign
+// bit of signed values is flipped so negative values sort first.
+package keys
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// ErrInvalidKey is returned when a key does not match the components it
+// is parsed as.
+var ErrInvalidKey = errors.New("keys: invalid key")
+
+const (
+	escape     = 0x00
+	terminator = 0x01
+	escapedNul = 0xFF
+)
+
+// AppendBytes appends the encoded byte component b to dst.
+func AppendBytes(dst, b []byte) []byte {
+	for _, c := range b {
+		if c == escape {
+			dst = append(dst, escape, escapedNul)
+		} else {
+			dst = append(dst, c)
+		}
+	}
+	return append(dst, escape, terminator)
+}
+
+// AppendString appends the encoded string component s to dst.
+func AppendString(dst []byte, s string) []byte {
+	return AppendBytes(dst, []byte(s))
+}
+
+// AppendUint64 appends the encoded unsigned integer component v to dst.
+func AppendUint64(dst []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(dst, buf[:]...)
+}
+
+// AppendInt64 appends the encoded signed integer component v to dst.
+func AppendInt64(dst []byte, v int64) []byte {
+	return AppendUint64(dst, uint64(v)^1<<63)
+}
+
+// AppendTime appends the encoded time component t to dst with nanosecond
+// precision. Times must lie between the years 1678 and 2262.
+func AppendTime(dst []byte, t time.Time) []byte {
+	return AppendInt64(dst, t.UnixNano())
+}
+
+// Join returns a key built from string components.
+func Join(parts ...string) []byte {
+	var key []byte
+	for _, part := range parts {
+		key = AppendString(key, part)
+	}
+	return key
+}
+
+// Split parses a key built from string components only.
+func Split(key []byte) ([]string, error) {
+	var parts []string
+	p := NewParser(key)
+	for !p.Done() {
+		parts = append(parts, p.String())
+	}
+	return parts, p.Err()
+}
+
+// Parser parses the components of a key in the order they were appended.
+// After the first error all further components are zero values and Err
+// returns the error.
+type Parser struct {
+	key []byte
+	err error
+}
+
+// NewParser returns a Parser reading the components of key.
+func NewParser(key []byte) *Parser {
+	return &Parser{key: key}
+}
+
+// Done reports whether all components have been parsed or parsing failed.
+func (p *Parser) Done() bool {
+	return len(p.key) == 0 || p.err != nil
+}
+
+// Err returns the first error encountered while parsing.
+func (p *Parser) Err() error {
+	return p.err
+}
+
+// Rest returns the unparsed remainder of the key.
+func (p *Parser) Rest() []byte {
+	return p.key
+}
+
+// Bytes parses a byte component. The returned slice is newly allocated.
+func (p *Parser) Bytes() []byte {
+	if p.err != nil {
+		return nil
+	}
+	b := []byte{}
+	for i := 0; i < len(p.key); i++ {
+		if p.key[i] != escape {
+			b = append(b, p.key[i])
+			continue
+		}
+		if i+1 == len(p.key) {
+			break
+		}
+		switch p.key[i+1] {
+		case terminator:
+			p.key = p.key[i+2:]
+			return b
+		case escapedNul:
+			b = append(b, 0)
+			i++
+		default:
+			p.err = ErrInvalidKey
+			return nil
+		}
+	}
+	p.err = ErrInvalidKey
+	return nil
+}
+
+// String parses a string component.
+func (p *Parser) String() string {
+	return string(p.Bytes())
+}
+
+// Uint64 parses an unsigned integer component.
+func (p *Parser) Uint64() uint64 {
+	if p.err != nil {
+		return 0
+	}
+	if len(p.key) < 8 {
+		p.err = ErrInvalidKey
+		return 0
+	}
+	v := binary.BigEndian.Uint64(p.key)
+	p.key = p.key[8:]
+	return v
+}
+
+// Int64 parses a signed integer component.
+func (p *Parser) Int64() int64 {
+	if v := p.Uint64(); p.err == nil {
+		return int64(v ^ 1<<63)
+	}
+	return 0
+}
+
+// Time parses a time component.
+func (p *Parser) Time() time.Time {
+	if v := p.Int64(); p.err == nil {
+		return time.Unix(0, v)
+	}
+	return time.Time{}
+}
@@ -0,0 +1,85 @@
+package keys
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	now := time.Unix(1500000000, 123456789)
+	key := AppendString(nil, "tenant\x00a")
+	key = AppendBytes(key, []byte{0, 1, 0xff, 0})
+	key = AppendUint64(key, 42)
+	key = AppendInt64(key, -7)
+	key = AppendTime(key, now)
+
+	p := NewParser(key)
+	if s := p.String(); s != "tenant\x00a" {
+		t.Fatalf("string: got %q", s)
+	}
+	if b := p.Bytes(); !bytes.Equal(b, []byte{0, 1, 0xff, 0}) {
+		t.Fatalf("bytes: got %q", b)
+	}
+	if v := p.Uint64(); v != 42 {
+		t.Fatalf("uint64: got %d", v)
+	}
+	if v := p.Int64(); v != -7 {
+		t.Fatalf("int64: got %d", v)
+	}
+	if v := p.Time(); !v.Equal(now) {
+		t.Fatalf("time: got %v", v)
+	}
+	if !p.Done() || p.Err() != nil {
+		t.Fatalf("parser: expected done without error, got %v", p.Err())
+	}
+
+	if _, err := Split(key[:5]); err != ErrInvalidKey {
+		t.Fatalf("split truncated key: expected ErrInvalidKey, got %v", err)
+	}
+	if p = NewParser([]byte{1, 2}); p.Uint64() != 0 || p.Err() != ErrInvalidKey {
+		t.Fatalf("short uint64: expected ErrInvalidKey, got %v", p.Err())
+	}
+}
+
+func TestOrder(t *testing.T) {
+	tuples := [][]string{
+		{"a"},
+		{"a", ""},
+		{"a", "b"},
+		{"a\x00"},
+		{"a\x00", "b"},
+		{"a\x01"},
+		{"ab"},
+		{"b"},
+	}
+	var encoded [][]byte
+	for _, tuple := range tuples {
+		encoded = append(encoded, Join(tuple...))
+	}
+	sorted := append([][]byte{}, encoded...)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i], sorted[j]) < 0 })
+	if !reflect.DeepEqual(sorted, encoded) {
+		t.Fatalf("order: encoded keys do not sort like their components")
+	}
+	for i, key := range encoded {
+		parts, err := Split(key)
+		if err != nil || !reflect.DeepEqual(parts, tuples[i]) {
+			t.Fatalf("split %q: got %q, %v", key, parts, err)
+		}
+	}
+
+	// a tenant prefix must not match another tenant starting with it
+	if bytes.HasPrefix(Join("ab", "x"), Join("a")) {
+		t.Fatalf("prefix: component prefix matched")
+	}
+
+	ints := []int64{-1 << 63, -2, -1, 0, 1, 1<<63 - 1}
+	for i := 1; i < len(ints); i++ {
+		if bytes.Compare(AppendInt64(nil, ints[i-1]), AppendInt64(nil, ints[i])) >= 0 {
+			t.Fatalf("order: %d does not sort before %d", ints[i-1], ints[i])
+		}
+	}
+}
@@ -0,0 +1,532 @@
+// Package keyspace analyzes the key layout of a backend.DB. Analyze
+// scans a store, clusters its keys by an inferred schema, flags layouts
+// that do not work well with ordered key/value stores and suggests how
+// to move each cluster into its own namespace:
+//
+//	r, err := keyspace.Analyze(ctx, db)
+//	...
+//	r.WriteTo(os.Stdout)
+//
+// Keys are split into segments at '/', ':' and '|' and at the string
+// terminator of package keys. Segments consisting of digits, hex
+// strings, UUIDs and binary data are replaced by the placeholders {int},
+// {hex}, {uuid} and {bin}. Literal segments with too many distinct
+// values at the same position are merged into {str}, so user/alice and
+// user/bob both end up in the schema user/{str}.
+package keyspace
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/mars9/backend"
+)
+
+// Placeholders for variable key segments.
+const (
+	Int  = "{int}"
+	Hex  = "{hex}"
+	UUID = "{uuid}"
+	Bin  = "{bin}"
+	Str  = "{str}"
+)
+
+// Kinds of findings.
+const (
+	HugeValues      = "huge values"
+	UnboundedPrefix = "unbounded prefix"
+	UnorderedInts   = "non-order-preserving integers"
+)
+
+const (
+	defaultHugeValue   = 1 << 20
+	defaultLargePrefix = 1 << 20
+	defaultMaxLiterals = 64
+
+	checkInterval = 1024 // keys between context checks
+)
+
+// terminator ends strings encoded by package keys.
+var terminator = []byte{0x00, 0x01}
+
+// Schema describes a cluster of keys sharing an inferred layout.
+type Schema struct {
+	Pattern    string // key layout with placeholders for variable segments
+	Prefix     []byte // longest prefix common to all keys
+	Sample     []byte // first key of the cluster
+	Keys       int64  // number of keys
+	KeyBytes   int64  // total size of the keys
+	ValueBytes int64  // total size of the values
+	MaxValue   int    // size of the largest value
+
+	huge   int64          // values larger than the HugeValue threshold
+	widths map[int][2]int // minimum and maximum width of {int} segments
+}
+
+// Finding is a layout problem found in a schema.
+type Finding struct {
+	Kind    string // HugeValues, UnboundedPrefix or UnorderedInts
+	Pattern string // schema the finding applies to
+	Message string
+}
+
+// Plan suggests how to move a schema into a namespace of its own.
+type Plan struct {
+	Pattern   string
+	Namespace string // suggested namespace name, empty without a common prefix
+	Prefix    []byte // key prefix of the namespace
+	Steps     []string
+}
+
+// Report is the result of Analyze.
+type Report struct {
+	Keys     int64 // number of keys scanned
+	Bytes    int64 // total size of keys and values
+	Schemas  []Schema
+	Findings []Finding
+	Plans    []Plan
+}
+
+// Option configures Analyze.
+type Option func(*analyzer) error
+
+// HugeValue sets the value size above which values are reported as
+// huge. The default is 1 MiB.
+func HugeValue(n int) Option {
+	return func(a *analyzer) error {
+		if n <= 0 {
+			return errors.New("keyspace: huge value size must be positive")
+		}
+		a.hugeValue = n
+		return nil
+	}
+}
+
+// LargePrefix sets the number of keys above which a schema is reported
+// as an unbounded prefix. The default is 1<<20.
+func LargePrefix(n int64) Option {
+	return func(a *analyzer) error {
+		if n <= 0 {
+			return errors.New("keyspace: large prefix size must be positive")
+		}
+		a.largePrefix = n
+		return nil
+	}
+}
+
+// MaxLiterals sets the number of distinct literal segments at the same
+// position before they are merged into {str}. The default is 64.
+func MaxLiterals(n int) Option {
+	return func(a *analyzer) error {
+		if n <= 0 {
+			return errors.New("keyspace: max literals must be positive")
+		}
+		a.maxLiterals = n
+		return nil
+	}
+}
+
+type analyzer struct {
+	hugeValue   int
+	largePrefix int64
+	maxLiterals int
+	root        *node
+}
+
+// node is a position in the schema trie. Children are keyed by segment
+// placeholder or literal followed by the separator after it.
+type node struct {
+	children  map[string]*node
+	literals  int  // number of literal children
+	collapsed bool // literal children are merged into {str}
+	leaf      *Schema
+}
+
+func newNode() *node { return &node{children: make(map[string]*node)} }
+
+// Analyze scans db and returns a report of its key layout. It stops
+// early and returns ctx.Err() if ctx is done.
+func Analyze(ctx context.Context, db backend.DB, opts ...Option) (*Report, error) {
+	a := &analyzer{
+		hugeValue:   defaultHugeValue,
+		largePrefix: defaultLargePrefix,
+		maxLiterals: defaultMaxLiterals,
+		root:        newNode(),
+	}
+	for _, opt := range opts {
+		if err := opt(a); err != nil {
+			return nil, err
+		}
+	}
+
+	iter, err := db.Iterator()
+	if err != nil {
+		return nil, err
+	}
+	r := &Report{}
+	for k, v := iter.First(); k != nil; k, v = iter.Next() {
+		if r.Keys%checkInterval == 0 {
+			if err = ctx.Err(); err != nil {
+				iter.Close()
+				return nil, err
+			}
+		}
+		a.add(k, v)
+		r.Keys++
+		r.Bytes += int64(len(k) + len(v))
+	}
+	if err = iter.Close(); err != nil {
+		return nil, err
+	}
+
+	a.collect(a.root, "", r)
+	sort.Slice(r.Schemas, func(i, j int) bool {
+		if r.Schemas[i].Keys != r.Schemas[j].Keys {
+			return r.Schemas[i].Keys > r.Schemas[j].Keys
+		}
+		return r.Schemas[i].Pattern < r.Schemas[j].Pattern
+	})
+	for i := range r.Schemas {
+		a.check(&r.Schemas[i], r)
+	}
+	return r, nil
+}
+
+// add records the key/value pair in the schema trie.
+func (a *analyzer) add(k, v []byte) {
+	var widths [][2]int // index and width of {int} segments
+	n := a.root
+	for rest, index := k, 0; ; index++ {
+		seg, sep, tail := split(rest)
+		class := classify(seg)
+		if class == "" && n.collapsed {
+			class = Str
+		}
+		if class == Int {
+			widths = append(widths, [2]int{index, len(seg)})
+		}
+		label := class
+		if label == "" {
+			label = string(seg)
+		}
+		label += string(sep)
+		child, ok := n.children[label]
+		if !ok {
+			child = newNode()
+			n.children[label] = child
+			if class == "" {
+				n.literals++
+				if n.literals > a.maxLiterals {
+					a.collapse(n)
+					child = n.children[Str+string(sep)]
+				}
+			}
+		}
+		n = child
+		if sep == nil {
+			break
+		}
+		rest = tail
+	}
+
+	s := n.leaf
+	if s == nil {
+		s = &Schema{Prefix: append([]byte{}, k...), Sample: append([]byte{}, k...)}
+		n.leaf = s
+	}
+	s.Prefix = s.Prefix[:commonPrefix(s.Prefix, k)]
+	s.Keys++
+	s.KeyBytes += int64(len(k))
+	s.ValueBytes += int64(len(v))
+	if len(v) > s.MaxValue {
+		s.MaxValue = len(v)
+	}
+	if len(v) > a.hugeValue {
+		s.huge++
+	}
+	for _, w := range widths {
+		s.width(w[0], w[1], w[1])
+	}
+}
+
+// width records {int} segments of min to max digits at index.
+func (s *Schema) width(index, min, max int) {
+	if s.widths == nil {
+		s.widths = make(map[int][2]int)
+	}
+	mm, ok := s.widths[index]
+	if !ok || min < mm[0] {
+		mm[0] = min
+	}
+	if max > mm[1] {
+		mm[1] = max
+	}
+	s.widths[index] = mm
+}
+
+// collapse merges the literal children of n into {str} children.
+func (a *analyzer) collapse(n *node) {
+	n.collapsed = true
+	for label, child := range n.children {
+		seg, sep := splitLabel(label)
+		if isPlaceholder(seg) {
+			continue
+		}
+		delete(n.children, label)
+		a.insert(n, Str+sep, child)
+	}
+	n.literals = 0
+}
+
+// insert adds the subtree child to n under label, merging it with an
+// existing child.
+func (a *analyzer) insert(n *node, label string, child *node) {
+	if dst, ok := n.children[label]; ok {
+		a.merge(dst, child)
+		return
+	}
+	n.children[label] = child
+}
+
+// merge adds the subtree src to dst.
+func (a *analyzer) merge(dst, src *node) {
+	dst.leaf = mergeSchema(dst.leaf, src.leaf)
+	for label, child := range src.children {
+		seg, sep := splitLabel(label)
+		switch {
+		case isPlaceholder(seg):
+			a.insert(dst, label, child)
+		case dst.collapsed:
+			a.insert(dst, Str+sep, child)
+		default:
+			if _, ok := dst.children[label]; !ok {
+				dst.literals++
+			}
+			a.insert(dst, label, child)
+		}
+	}
+	if !dst.collapsed && dst.literals > a.maxLiterals {
+		a.collapse(dst)
+	}
+}
+
+// splitLabel splits a trie label into segment and separator.
+func splitLabel(label string) (seg, sep string) {
+	seg = strings.TrimRight(label, "/:|\x00\x01")
+	return seg, label[len(seg):]
+}
+
+func mergeSchema(dst, src *Schema) *Schema {
+	if src == nil {
+		return dst
+	}
+	if dst == nil {
+		return src
+	}
+	dst.Prefix = dst.Prefix[:commonPrefix(dst.Prefix, src.Prefix)]
+	if bytes.Compare(src.Sample, dst.Sample) < 0 {
+		dst.Sample = src.Sample
+	}
+	dst.Keys += src.Keys
+	dst.KeyBytes += src.KeyBytes
+	dst.ValueBytes += src.ValueBytes
+	if src.MaxValue > dst.MaxValue {
+		dst.MaxValue = src.MaxValue
+	}
+	dst.huge += src.huge
+	for i, mm := range src.widths {
+		dst.width(i, mm[0], mm[1])
+	}
+	return dst
+}
+
+// collect appends the schemas below n to r.
+func (a *analyzer) collect(n *node, pattern string, r *Report) {
+	labels := make([]string, 0, len(n.children))
+	for label := range n.children {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		a.collect(n.children[label], pattern+label, r)
+	}
+	if n.leaf != nil {
+		s := *n.leaf
+		s.Pattern = pattern
+		r.Schemas = append(r.Schemas, s)
+	}
+}
+
+// check appends the findings and the plan for s to r.
+func (a *analyzer) check(s *Schema, r *Report) {
+	p := Plan{Pattern: s.Pattern, Prefix: s.Prefix, Namespace: namespace(s.Prefix)}
+	if p.Namespace != "" {
+		p.Steps = append(p.Steps, fmt.Sprintf("hand out backend.Scope(db, %q) as namespace %q", s.Prefix, p.Namespace))
+	} else {
+		p.Steps = append(p.Steps, "keys share no literal prefix; move them below a namespace prefix with backend.Move")
+	}
+
+	if s.huge > 0 {
+		r.Findings = append(r.Findings, Finding{
+			Kind:    HugeValues,
+			Pattern: s.Pattern,
+			Message: fmt.Sprintf("%d values larger than %d bytes, largest %d bytes", s.huge, a.hugeValue, s.MaxValue),
+		})
+		p.Steps = append(p.Steps, "store large values in a cas.Store and keep their hash under the key")
+	}
+	if s.Keys > a.largePrefix {
+		r.Findings = append(r.Findings, Finding{
+			Kind:    UnboundedPrefix,
+			Pattern: s.Pattern,
+			Message: fmt.Sprintf("%d keys below prefix %q", s.Keys, s.Prefix),
+		})
+		p.Steps = append(p.Steps, "partition the keys by a bounded segment such as a tenant or time bucket")
+	}
+	indexes := make([]int, 0, len(s.widths))
+	for i, mm := range s.widths {
+		if mm[0] != mm[1] {
+			indexes = append(indexes, i)
+		}
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		mm := s.widths[i]
+		r.Findings = append(r.Findings, Finding{
+			Kind:    UnorderedInts,
+			Pattern: s.Pattern,
+			Message: fmt.Sprintf("segment %d holds decimal integers of %d to %d digits, which do not sort numerically", i, mm[0], mm[1]),
+		})
+		p.Steps = append(p.Steps, fmt.Sprintf("re-encode segment %d with keys.AppendUint64 so keys sort numerically", i))
+	}
+	r.Plans = append(r.Plans, p)
+}
+
+// WriteTo writes a human readable form of the report to w.
+func (r *Report) WriteTo(w io.Writer) (int64, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%d keys, %d bytes, %d schemas\n", r.Keys, r.Bytes, len(r.Schemas))
+	for _, s := range r.Schemas {
+		fmt.Fprintf(&buf, "\n%q\n", s.Pattern)
+		fmt.Fprintf(&buf, "\tkeys %d, key bytes %d, value bytes %d, largest value %d\n",
+			s.Keys, s.KeyBytes, s.ValueBytes, s.MaxValue)
+		fmt.Fprintf(&buf, "\tprefix %q, sample %q\n", s.Prefix, s.Sample)
+	}
+	if len(r.Findings) > 0 {
+		fmt.Fprintf(&buf, "\nfindings\n")
+		for _, f := range r.Findings {
+			fmt.Fprintf(&buf, "\t%q: %s: %s\n", f.Pattern, f.Kind, f.Message)
+		}
+	}
+	if len(r.Plans) > 0 {
+		fmt.Fprintf(&buf, "\nplans\n")
+		for _, p := range r.Plans {
+			fmt.Fprintf(&buf, "\t%q\n", p.Pattern)
+			for _, step := range p.Steps {
+				fmt.Fprintf(&buf, "\t\t%s\n", step)
+			}
+		}
+	}
+	return buf.WriteTo(w)
+}
+
+// split returns the first segment of key, the separator after it and the
+// remaining key. sep is nil if seg is the last segment.
+func split(key []byte) (seg, sep, rest []byte) {
+	for i := 0; i < len(key); i++ {
+		switch key[i] {
+		case '/', ':', '|':
+			return key[:i], key[i : i+1], key[i+1:]
+		case terminator[0]:
+			if i+1 < len(key) && key[i+1] == terminator[1] {
+				return key[:i], key[i : i+2], key[i+2:]
+			}
+		}
+	}
+	return key, nil, nil
+}
+
+// classify returns the placeholder for a variable segment, or "" if seg
+// is a literal.
+func classify(seg []byte) string {
+	if len(seg) == 0 {
+		return ""
+	}
+	digits, hex, printable := true, true, true
+	for _, c := range seg {
+		switch {
+		case c >= '0' && c <= '9':
+		case c >= 'a' && c <= 'f', c >= 'A' && c <= 'F':
+			digits = false
+		default:
+			digits, hex = false, false
+			if c < 0x20 || c >= 0x7f {
+				printable = false
+			}
+		}
+	}
+	switch {
+	case digits:
+		return Int
+	case !printable:
+		return Bin
+	case isUUID(seg):
+		return UUID
+	case hex && len(seg) >= 16:
+		return Hex
+	}
+	return ""
+}
+
+func isUUID(seg []byte) bool {
+	if len(seg) != 36 {
+		return false
+	}
+	for i, c := range seg {
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func isPlaceholder(seg string) bool {
+	switch seg {
+	case Int, Hex, UUID, Bin, Str:
+		return true
+	}
+	return false
+}
+
+// namespace derives a namespace name from a key prefix.
+func namespace(prefix []byte) string {
+	var b strings.Builder
+	for _, c := range prefix {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+			b.WriteByte(c)
+		case b.Len() > 0:
+			b.WriteByte('_')
+		}
+	}
+	return strings.Trim(b.String(), "_")
+}
+
+func commonPrefix(a, b []byte) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
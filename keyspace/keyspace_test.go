@@ -0,0 +1,101 @@
+package keyspace
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mars9/backend"
+	"github.com/mars9/backend/keys"
+)
+
+func TestAnalyze(t *testing.T) {
+	const path = "keyspace_test.db"
+	db, err := backend.OpenBoltDB(path, 0)
+	if err != nil {
+		t.Fatalf("opening BoltDB %q: %v", path, err)
+	}
+	defer os.Remove(path)
+	defer db.Close()
+
+	if err = backend.Update(db, func(txn backend.RWTxn) error {
+		for i := 0; i < 20; i++ {
+			k := fmt.Sprintf("user/name%02d/profile", i)
+			if err := txn.Put([]byte(k), []byte("{}")); err != nil {
+				return err
+			}
+		}
+		for i := 1; i <= 12; i++ {
+			k := fmt.Sprintf("order:%d", i)
+			if err := txn.Put([]byte(k), []byte("x")); err != nil {
+				return err
+			}
+		}
+		if err := txn.Put([]byte("blob/0123456789abcdef0123"), bytes.Repeat([]byte("b"), 100)); err != nil {
+			return err
+		}
+		if err := txn.Put(keys.AppendUint64(keys.AppendString(nil, "seq"), 7), nil); err != nil {
+			return err
+		}
+		return txn.Put([]byte("config"), []byte("on"))
+	}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = Analyze(ctx, db); err != context.Canceled {
+		t.Fatalf("analyze: expected context.Canceled, got %v", err)
+	}
+
+	r, err := Analyze(context.Background(), db, MaxLiterals(8), HugeValue(50), LargePrefix(15))
+	if err != nil {
+		t.Fatalf("analyze: %v", err)
+	}
+	if r.Keys != 35 {
+		t.Fatalf("analyze: expected 35 keys, got %d", r.Keys)
+	}
+	var patterns []string
+	for _, s := range r.Schemas {
+		patterns = append(patterns, s.Pattern)
+	}
+	want := "user/{str}/profile,order:{int},blob/{hex},config,seq\x00\x01{bin}"
+	if got := strings.Join(patterns, ","); got != want {
+		t.Fatalf("analyze: expected schemas %q, got %q", want, got)
+	}
+	if s := r.Schemas[0]; s.Keys != 20 || string(s.Prefix) != "user/name" || string(s.Sample) != "user/name00/profile" {
+		t.Fatalf("analyze: unexpected user schema %+v", s)
+	}
+
+	found := make(map[string]string)
+	for _, f := range r.Findings {
+		found[f.Kind] = f.Pattern
+	}
+	for kind, pattern := range map[string]string{
+		HugeValues:      "blob/{hex}",
+		UnboundedPrefix: "user/{str}/profile",
+		UnorderedInts:   "order:{int}",
+	} {
+		if found[kind] != pattern {
+			t.Fatalf("analyze: expected %s finding for %q, got %q", kind, pattern, found[kind])
+		}
+	}
+	if len(r.Findings) != 3 {
+		t.Fatalf("analyze: expected 3 findings, got %+v", r.Findings)
+	}
+
+	if p := r.Plans[1]; p.Namespace != "order" || string(p.Prefix) != "order:" || len(p.Steps) != 2 {
+		t.Fatalf("analyze: unexpected order plan %+v", p)
+	}
+
+	var buf bytes.Buffer
+	if _, err = r.WriteTo(&buf); err != nil {
+		t.Fatalf("write report: %v", err)
+	}
+	if !strings.HasPrefix(buf.String(), "35 keys, ") || !strings.Contains(buf.String(), "keys.AppendUint64") {
+		t.Fatalf("write report: unexpected output\n%s", buf.String())
+	}
+}
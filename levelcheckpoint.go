@@ -0,0 +1,196 @@
+//go:build cgo
+
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// checkpointAttempts is the number of times Checkpoint retries when a
+// background compaction changes the database files during the copy.
+const checkpointAttempts = 5
+
+var errCheckpointChanged = errors.New("database files changed during checkpoint")
+
+// Checkpoint creates the directory dir holding a consistent physical
+// copy of the database, which can be opened with OpenLevelDB. Table
+// files are immutable and hard linked when dir is on the same file
+// system, so a checkpoint takes little time and space; the MANIFEST and
+// the write-ahead logs are copied. Writes through db are blocked while
+// the checkpoint is taken.
+func (db *LevelDB) Checkpoint(dir string) error {
+	if err := db.refs.acquire(); err != nil {
+		return err
+	}
+	defer db.refs.release()
+	db.writer.Lock()
+	defer db.writer.Unlock()
+
+	var err error
+	for i := 0; i < checkpointAttempts; i++ {
+		if err = checkpoint(db.root, dir); err != errCheckpointChanged {
+			return err
+		}
+	}
+	return err
+}
+
+// checkpoint copies the LevelDB directory root to the new directory dir.
+// It returns errCheckpointChanged if a file disappeared or CURRENT or the
+// MANIFEST changed while copying, dir is removed then.
+func checkpoint(root, dir string) (err error) {
+	if err = os.Mkdir(dir, 0755); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	current, err := os.ReadFile(filepath.Join(root, "CURRENT"))
+	if err != nil {
+		return err
+	}
+	manifest := strings.TrimSuffix(string(current), "\n")
+	if !strings.HasPrefix(manifest, "MANIFEST-") || strings.ContainsRune(manifest, '/') {
+		return errInvalidManifest
+	}
+	data, err := os.ReadFile(filepath.Join(root, manifest))
+	if os.IsNotExist(err) {
+		return errCheckpointChanged
+	} else if err != nil {
+		return err
+	}
+	// a record may be appended concurrently, the copy must not end in
+	// a partial one
+	v, err := readManifest(bytes.NewReader(data))
+	if err == errInvalidManifest {
+		return errCheckpointChanged
+	} else if err != nil {
+		return err
+	}
+
+	for n, levels := range v.tables {
+		if levels == 0 {
+			continue
+		}
+		if err = linkTable(root, dir, n); err != nil {
+			return err
+		}
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasSuffix(name, ".log") {
+			continue
+		}
+		n, perr := strconv.ParseUint(strings.TrimSuffix(name, ".log"), 10, 64)
+		if perr != nil || (n < v.logNumber && n != v.prevLogNumber) {
+			continue
+		}
+		if err = copyFile(filepath.Join(root, name), filepath.Join(dir, name)); os.IsNotExist(err) {
+			return errCheckpointChanged
+		} else if err != nil {
+			return err
+		}
+	}
+
+	// a compaction finished meanwhile may have removed a log before it
+	// was listed
+	if changed, err := manifestChanged(root, current, data); err != nil {
+		return err
+	} else if changed {
+		return errCheckpointChanged
+	}
+
+	if err = writeFileSync(filepath.Join(dir, manifest), data); err != nil {
+		return err
+	}
+	return writeFileSync(filepath.Join(dir, "CURRENT"), current)
+}
+
+// manifestChanged reports whether CURRENT or the MANIFEST in root no
+// longer hold current and data.
+func manifestChanged(root string, current, data []byte) (bool, error) {
+	cur, err := os.ReadFile(filepath.Join(root, "CURRENT"))
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(cur, current) {
+		return true, nil
+	}
+	manifest := strings.TrimSuffix(string(current), "\n")
+	d, err := os.ReadFile(filepath.Join(root, manifest))
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	return !bytes.Equal(d, data), err
+}
+
+// linkTable hard links table file n from root to dir, copying it if
+// linking fails. LevelDB names tables .ldb, older versions .sst.
+func linkTable(root, dir string, n uint64) error {
+	for _, ext := range []string{".ldb", ".sst"} {
+		name := fmt.Sprintf("%06d%s", n, ext)
+		src, dst := filepath.Join(root, name), filepath.Join(dir, name)
+		if _, err := os.Stat(src); os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		err := os.Link(src, dst)
+		if err != nil {
+			err = copyFile(src, dst)
+		}
+		if os.IsNotExist(err) {
+			return errCheckpointChanged
+		}
+		return err
+	}
+	return errCheckpointChanged
+}
+
+func copyFile(src, dst string) error {
+	r, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(w, r); err == nil {
+		err = w.Sync()
+	}
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func writeFileSync(name string, data []byte) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
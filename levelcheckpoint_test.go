@@ -0,0 +1,101 @@
+package backend
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestLevelCheckpoint(t *testing.T) {
+	var edit []byte
+	edit = binary.AppendUvarint(edit, tagLogNumber)
+	edit = binary.AppendUvarint(edit, 7)
+	for _, n := range []uint64{5, 6} {
+		for _, v := range []uint64{tagNewFile, 0, n, 1} {
+			edit = binary.AppendUvarint(edit, v)
+		}
+		edit = append(edit, 1, 'a', 1, 'z')
+	}
+
+	root, dir := t.TempDir(), filepath.Join(t.TempDir(), "checkpoint")
+	files := map[string][]byte{
+		"CURRENT":         []byte("MANIFEST-000002\n"),
+		"MANIFEST-000002": writeLevelLog(edit),
+		"LOCK":            nil,
+		"LOG":             nil,
+		"000003.log":      []byte("flushed"),
+		"000005.ldb":      []byte("table"),
+		"000006.sst":      []byte("old table"),
+		"000007.log":      []byte("log"),
+		"000009.ldb":      []byte("unfinished"),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(root, name), data, 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	if err := checkpoint(root, dir); err != nil {
+		t.Fatalf("checkpoint: %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read checkpoint: %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil || string(data) != string(files[e.Name()]) {
+			t.Fatalf("checkpoint %s: expected %q, got %q, %v", e.Name(), files[e.Name()], data, err)
+		}
+	}
+	sort.Strings(names)
+	expected := []string{"000005.ldb", "000006.sst", "000007.log", "CURRENT", "MANIFEST-000002"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("checkpoint: expected %v, got %v", expected, names)
+	}
+	src, _ := os.Stat(filepath.Join(root, "000005.ldb"))
+	dst, _ := os.Stat(filepath.Join(dir, "000005.ldb"))
+	if !os.SameFile(src, dst) {
+		t.Fatalf("checkpoint: table file not linked")
+	}
+
+	if err = checkpoint(root, dir); !os.IsExist(err) {
+		t.Fatalf("checkpoint to existing directory: expected exist error, got %v", err)
+	}
+
+	// a table removed by a compaction requires another attempt
+	if err = os.Remove(filepath.Join(root, "000005.ldb")); err != nil {
+		t.Fatalf("remove table: %v", err)
+	}
+	dir = filepath.Join(t.TempDir(), "checkpoint")
+	if err = checkpoint(root, dir); err != errCheckpointChanged {
+		t.Fatalf("checkpoint with missing table: expected errCheckpointChanged, got %v", err)
+	}
+	if _, err = os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("failed checkpoint left directory: %v", err)
+	}
+
+	// a compaction finishing during the copy appends to the MANIFEST
+	current, manifest := files["CURRENT"], files["MANIFEST-000002"]
+	if changed, err := manifestChanged(root, current, manifest); err != nil || changed {
+		t.Fatalf("unchanged manifest: got %v, %v", changed, err)
+	}
+	appended := append(append([]byte{}, manifest...), writeLevelLog(edit)...)
+	if err = os.WriteFile(filepath.Join(root, "MANIFEST-000002"), appended, 0644); err != nil {
+		t.Fatalf("append manifest: %v", err)
+	}
+	if changed, err := manifestChanged(root, current, manifest); err != nil || !changed {
+		t.Fatalf("appended manifest: got %v, %v", changed, err)
+	}
+	if err = os.WriteFile(filepath.Join(root, "CURRENT"), []byte("MANIFEST-000010\n"), 0644); err != nil {
+		t.Fatalf("write current: %v", err)
+	}
+	if changed, err := manifestChanged(root, current, appended); err != nil || !changed {
+		t.Fatalf("new manifest: got %v, %v", changed, err)
+	}
+}
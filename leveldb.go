@@ -1,9 +1,42 @@
+//go:build cgo
+
 package backend
 
 /*
 #cgo LDFLAGS:-lleveldb
 #include <stdlib.h>
 #include "leveldb/c.h"
+
+// backend_write applies n buffered operations in a single write batch.
+// The keys and values of all operations are stored back to back in data,
+// lens holds the key and value length of each operation and kinds is 0
+// for a put and 1 for a delete.
+static void backend_write(leveldb_t* db, const leveldb_writeoptions_t* wopts,
+		const char* data, const size_t* lens, const unsigned char* kinds,
+		size_t n, char** errptr) {
+	leveldb_writebatch_t* batch = leveldb_writebatch_create();
+	size_t i;
+	for (i = 0; i < n; i++) {
+		size_t klen = lens[2*i], vlen = lens[2*i+1];
+		if (kinds[i] == 0) {
+			leveldb_writebatch_put(batch, data, klen, data+klen, vlen);
+		} else {
+			leveldb_writebatch_delete(batch, data, klen);
+		}
+		data += klen + vlen;
+	}
+	leveldb_write(db, wopts, batch, errptr);
+	leveldb_writebatch_destroy(batch);
+}
+
+// backend_approximate_size returns the approximate size of a single key
+// range, avoiding arrays of pointers on the Go side.
+static uint64_t backend_approximate_size(leveldb_t* db,
+		const char* start, size_t slen, const char* limit, size_t llen) {
+	uint64_t size;
+	leveldb_approximate_sizes(db, 1, &start, &slen, &limit, &llen, &size);
+	return size;
+}
 */
 import "C"
 
@@ -11,7 +44,7 @@ import (
 	"bytes"
 	"errors"
 	"io"
-	"sync"
+	"time"
 	"unsafe"
 )
 
@@ -53,6 +86,14 @@ func unsafeGoBytes(data *C.char, size C.size_t) []byte {
 	return (*[maxSlice]byte)(unsafe.Pointer(data))[:dlen:dlen]
 }
 
+// cbytes returns a pointer to the first byte of b, or nil if b is empty.
+func cbytes(b []byte) *C.char {
+	if len(b) == 0 {
+		return nil
+	}
+	return (*C.char)(unsafe.Pointer(&b[0]))
+}
+
 func checkDatabaseError(errptr *C.char) error {
 	if errptr == nil {
 		return nil
@@ -65,18 +106,44 @@ func checkDatabaseError(errptr *C.char) error {
 var _ DB = (*LevelDB)(nil)
 
 type LevelDB struct {
+	root   string
 	wopts  *C.leveldb_writeoptions_t // default txn write options
 	opts   *C.leveldb_options_t      // default LevelDB options
 	tree   *C.leveldb_t
-	writer sync.Mutex // excluisve writer lock
+	writer fifoMutex // excluisve writer lock
+	refs   refCount  // open iterators and transactions
+	stall  levelStall
 }
 
 func OpenLevelDB(root string, opts ...LevelOption) (*LevelDB, error) {
+	return openLevel(root, true, opts...)
+}
+
+// OpenForeignLevelDB opens an existing LevelDB database not created by
+// this package. LevelDB stores keys unchanged, so the raw keyspace is
+// visible as is. The database must use the default bytewise comparator;
+// OpenForeignLevelDB fails if it does not exist.
+func OpenForeignLevelDB(root string, opts ...LevelOption) (*LevelDB, error) {
+	return openLevel(root, false, opts...)
+}
+
+// LevelStores returns a StoreOpener opening each store as a LevelDB
+// directory with the given options.
+func LevelStores(opts ...LevelOption) StoreOpener {
+	return func(path string) (DB, error) {
+		return OpenLevelDB(path, opts...)
+	}
+}
+
+func openLevel(root string, create bool, opts ...LevelOption) (*LevelDB, error) {
 	db := &LevelDB{
+		root:  root,
 		wopts: C.leveldb_writeoptions_create(),
 		opts:  C.leveldb_options_create(),
 	}
-	C.leveldb_options_set_create_if_missing(db.opts, ctrue)
+	if create {
+		C.leveldb_options_set_create_if_missing(db.opts, ctrue)
+	}
 
 	for _, opt := range opts {
 		if err := opt(db); err != nil {
@@ -96,9 +163,12 @@ func OpenLevelDB(root string, opts ...LevelOption) (*LevelDB, error) {
 }
 
 func (db *LevelDB) Close() error {
-	if db == nil || db.tree == nil {
+	if db == nil {
 		return errors.New("closing unopened LevelDB instance")
 	}
+	if err := db.refs.close(); err != nil {
+		return err
+	}
 	C.leveldb_writeoptions_destroy(db.wopts)
 	C.leveldb_options_destroy(db.opts)
 	C.leveldb_close(db.tree)
@@ -110,21 +180,92 @@ func (db *LevelDB) Close() error {
 
 func (db *LevelDB) Name() string { return "LevelDB" }
 
+// WriteTo writes the entire database to w using the export format. See
+// Export for details.
 func (db *LevelDB) WriteTo(w io.Writer) (int64, error) {
-	panic("LevelDB: WriteTo not implemented")
+	return Export(w, db)
 }
 
 func (db *LevelDB) Iterator() (Iterator, error) {
-	return newLevelIterator(db, true), nil
+	return newLevelIterator(db, true)
 }
 
 func (db *LevelDB) Readonly() (Txn, error) {
-	return newLevelTxn(db, false), nil
+	iter, err := newLevelIterator(db, true)
+	if err != nil {
+		return nil, err
+	}
+	return &levelReadTxn{iter: iter}, nil
 }
 
 func (db *LevelDB) Writable() (RWTxn, error) {
-	db.writer.Lock()
-	return newLevelTxn(db, true), nil
+	return newLevelTxn(db)
+}
+
+// ApproximateSize returns the approximate file system space used by the
+// keys in [start, limit). Data not yet flushed from the memtable is not
+// included.
+func (db *LevelDB) ApproximateSize(start, limit []byte) (uint64, error) {
+	if db == nil {
+		return 0, errors.New("approximate size of unopened LevelDB instance")
+	}
+	if err := db.refs.acquire(); err != nil {
+		return 0, err
+	}
+	defer db.refs.release()
+	size := C.backend_approximate_size(db.tree, cbytes(start), C.size_t(len(start)),
+		cbytes(limit), C.size_t(len(limit)))
+	return uint64(size), nil
+}
+
+// Compact compacts the whole key range, dropping overwritten and deleted
+// values from the table files. It blocks until the compaction finished.
+func (db *LevelDB) Compact() error {
+	if db == nil {
+		return errors.New("compacting unopened LevelDB instance")
+	}
+	if err := db.refs.acquire(); err != nil {
+		return err
+	}
+	defer db.refs.release()
+	C.leveldb_compact_range(db.tree, nil, 0, nil, 0)
+	return nil
+}
+
+// WriteQueueDepth returns the number of Writable callers waiting for the
+// writer lock.
+func (db *LevelDB) WriteQueueDepth() int {
+	return db.writer.waiting()
+}
+
+// LevelTxnOption configures a LevelDB write transaction.
+type LevelTxnOption func(*levelTxn) error
+
+// BlindWrite disables read-your-writes tracking for pure write workloads
+// such as bulk ingest, saving a map entry and key allocation per Put and
+// Delete. Get on a blind transaction only returns committed values, it
+// does not observe the transaction's own writes.
+func BlindWrite() LevelTxnOption {
+	return func(t *levelTxn) error {
+		t.modified = nil
+		return nil
+	}
+}
+
+// WritableTxn starts a new write transaction like Writable configured by
+// opts.
+func (db *LevelDB) WritableTxn(opts ...LevelTxnOption) (RWTxn, error) {
+	txn, err := newLevelTxn(db)
+	if err != nil {
+		return nil, err
+	}
+	for _, opt := range opts {
+		if err := opt(txn); err != nil {
+			txn.close()
+			return nil, err
+		}
+	}
+	return txn, nil
 }
 
 type levelIterator struct {
@@ -134,20 +275,48 @@ type levelIterator struct {
 	db    *LevelDB
 }
 
-func newLevelIterator(db *LevelDB, snapshot bool) *levelIterator {
-	ropts := C.leveldb_readoptions_create()
-	var snap *C.leveldb_snapshot_t
-	if snapshot {
-		snap := C.leveldb_create_snapshot(db.tree)
-		C.leveldb_readoptions_set_snapshot(ropts, snap)
+// newLevelIterator returns an iterator holding a reference on db until
+// it is closed.
+func newLevelIterator(db *LevelDB, snapshot bool) (*levelIterator, error) {
+	if err := db.refs.acquire(); err != nil {
+		return nil, err
 	}
-	iter := C.leveldb_create_iterator(db.tree, ropts)
-	return &levelIterator{
-		ropts: ropts,
-		snap:  snap,
-		iter:  iter,
+	i := &levelIterator{
+		ropts: C.leveldb_readoptions_create(),
 		db:    db,
 	}
+	i.open(snapshot)
+	return i, nil
+}
+
+// open creates the leveldb iterator, optionally reading from a new
+// explicit snapshot.
+func (i *levelIterator) open(snapshot bool) {
+	if snapshot {
+		i.snap = C.leveldb_create_snapshot(i.db.tree)
+		C.leveldb_readoptions_set_snapshot(i.ropts, i.snap)
+	}
+	i.iter = C.leveldb_create_iterator(i.db.tree, i.ropts)
+}
+
+// Reset releases the leveldb iterator and snapshot and creates new ones
+// on the current state of the database. The read options are reused.
+func (i *levelIterator) Reset() error {
+	if i == nil || i.db == nil {
+		return errors.New("reset unopened iterator")
+	}
+
+	var errptr *C.char
+	C.leveldb_iter_get_error(i.iter, &errptr)
+	C.leveldb_iter_destroy(i.iter)
+	snapshot := i.snap != nil
+	if snapshot {
+		C.leveldb_readoptions_set_snapshot(i.ropts, nil)
+		C.leveldb_release_snapshot(i.db.tree, i.snap)
+		i.snap = nil
+	}
+	i.open(snapshot)
+	return checkDatabaseError(errptr)
 }
 
 func (i *levelIterator) Close() error {
@@ -165,6 +334,7 @@ func (i *levelIterator) Close() error {
 		i.snap = nil
 	}
 
+	i.db.refs.release()
 	i.iter = nil
 	i.ropts = nil
 	i.db = nil
@@ -182,7 +352,7 @@ func (i levelIterator) isValid() bool {
 // get retrieves the key/value pair in the database. get simulates the
 // leveldb Get method to avoid additional key/value copy.
 func (i levelIterator) get(key []byte) ([]byte, error) {
-	k := (*C.char)(unsafe.Pointer(&key[0]))
+	k := cbytes(key)
 	klen := C.size_t(len(key))
 	C.leveldb_iter_seek(i.iter, k, klen)
 	if !i.isValid() {
@@ -214,7 +384,7 @@ func (i levelIterator) current() ([]byte, []byte) {
 }
 
 func (i levelIterator) Seek(key []byte) ([]byte, []byte) {
-	k := (*C.char)(unsafe.Pointer(&key[0]))
+	k := cbytes(key)
 	klen := C.size_t(len(key))
 	C.leveldb_iter_seek(i.iter, k, klen)
 	if !i.isValid() {
@@ -261,70 +431,114 @@ func (i levelIterator) Prev() ([]byte, []byte) {
 	return i.current()
 }
 
+func (i *levelIterator) SeekToIndex(n int64) ([]byte, []byte) {
+	return estimateIndex(i, i.db, n)
+}
+
+const (
+	opPut    = C.uchar(0)
+	opDelete = C.uchar(1)
+)
+
+// levelTxn buffers all writes in Go memory. On commit the buffered
+// operations are handed to LevelDB in a single cgo call instead of
+// crossing the cgo boundary for every Put and Delete.
 type levelTxn struct {
 	wopts    *C.leveldb_writeoptions_t
-	batch    *C.leveldb_writebatch_t
-	modified map[string][]byte
-	iter     *levelIterator
+	data     []byte         // keys and values of all buffered operations
+	lens     []C.size_t     // key and value length of each operation
+	kinds    []C.uchar      // opPut or opDelete
+	modified *overlay       // writes by key, nil for blind writes
+	iter     *levelIterator // reads committed data without a snapshot
 	db       *LevelDB
-	writable bool
 }
 
-func newLevelTxn(db *LevelDB, writable bool) *levelTxn {
-	txn := &levelTxn{
-		wopts:    C.leveldb_writeoptions_create(),
-		batch:    C.leveldb_writebatch_create(),
-		modified: make(map[string][]byte),
-		db:       db,
+// newLevelTxn takes the writer lock, which the transaction holds until it
+// is closed.
+func newLevelTxn(db *LevelDB) (*levelTxn, error) {
+	db.writer.Lock()
+	iter, err := newLevelIterator(db, false)
+	if err != nil {
+		db.writer.Unlock()
+		return nil, err
 	}
-	if writable {
-		txn.iter = newLevelIterator(db, false)
-	} else {
-		txn.iter = newLevelIterator(db, true)
+	txn := &levelTxn{
+		wopts: C.leveldb_writeoptions_create(),
+		iter:  iter,
+		db:    db,
 	}
-	return txn
+	txn.modified = newOverlay(&txn.data)
+	return txn, nil
 }
 
 // TODO: document internal iterator behaviour
-func (t levelTxn) Get(key []byte) ([]byte, error) {
-	v, found := t.modified[string(key)]
+func (t *levelTxn) Get(key []byte) ([]byte, error) {
+	if t.modified == nil {
+		return t.iter.get(key)
+	}
+	v, deleted, found := t.modified.get(key)
 	if !found {
 		return t.iter.get(key)
 	}
-
-	if v == nil { // deleted in transaction
+	if deleted {
 		return nil, ErrNotFound
 	}
 	return v, nil
 }
 
 func (t *levelTxn) Put(key, value []byte) error {
-	k := (*C.char)(unsafe.Pointer(&key[0]))
-	v := (*C.char)(unsafe.Pointer(&value[0]))
-	klen := C.size_t(len(key))
-	vlen := C.size_t(len(value))
+	off := len(t.data)
+	if uint64(off)+uint64(len(key))+uint64(len(value)) > maxOverlaySlab {
+		return errTxnTooLarge
+	}
+	t.data = append(t.data, key...)
+	t.data = append(t.data, value...)
+	t.lens = append(t.lens, C.size_t(len(key)), C.size_t(len(value)))
+	t.kinds = append(t.kinds, opPut)
+
+	// The overlay references the buffered copy, so the caller may reuse
+	// key and value.
+	if t.modified != nil {
+		t.modified.set(off, len(key), off+len(key), len(value), false)
+	}
+	return nil
+}
 
-	C.leveldb_writebatch_put(t.batch, k, klen, v, vlen)
-	t.modified[string(key)] = value
+func (t *levelTxn) Delete(key []byte) error {
+	off := len(t.data)
+	if uint64(off)+uint64(len(key)) > maxOverlaySlab {
+		return errTxnTooLarge
+	}
+	t.data = append(t.data, key...)
+	t.lens = append(t.lens, C.size_t(len(key)), 0)
+	t.kinds = append(t.kinds, opDelete)
+	if t.modified != nil {
+		t.modified.set(off, len(key), off+len(key), 0, true)
+	}
 	return nil
 }
 
-func (t levelTxn) Delete(key []byte) error {
-	k := (*C.char)(unsafe.Pointer(&key[0]))
-	klen := C.size_t(len(key))
+// write applies all buffered operations to the database.
+func (t *levelTxn) write() error {
+	if len(t.kinds) == 0 {
+		return nil
+	}
 
-	C.leveldb_writebatch_delete(t.batch, k, klen)
-	t.modified[string(key)] = nil
-	return nil
+	var errptr *C.char
+	C.backend_write(t.db.tree, t.wopts, cbytes(t.data), &t.lens[0], &t.kinds[0],
+		C.size_t(len(t.kinds)), &errptr)
+	return checkDatabaseError(errptr)
 }
 
 func (t *levelTxn) close() error {
-	C.leveldb_writebatch_destroy(t.batch)
 	C.leveldb_writeoptions_destroy(t.wopts)
 	err := t.iter.Close()
 	t.wopts = nil
-	t.batch = nil
+	t.data = nil
+	t.lens = nil
+	t.kinds = nil
 	t.modified = nil
+	t.db.writer.Unlock()
 	if err != nil {
 		return Error(err.Error())
 	}
@@ -332,24 +546,52 @@ func (t *levelTxn) close() error {
 }
 
 func (t *levelTxn) Rollback() error {
-	if t == nil || t.batch == nil {
+	if t == nil || t.wopts == nil {
 		return errors.New("rollback unopened transaction")
 	}
-
-	if t.writable {
-		t.db.writer.Unlock()
-	}
 	return t.close()
 }
 
 func (t *levelTxn) Commit() error {
-	if t == nil || t.batch == nil {
+	if t == nil || t.wopts == nil {
 		return errors.New("commit unopened transaction")
 	}
 
-	var errptr *C.char
-	C.leveldb_write(t.db.tree, t.wopts, t.batch, &errptr)
-	t.db.writer.Unlock()
+	start := time.Now()
+	err := t.write()
+	if err == nil {
+		t.db.observeWrite(time.Since(start))
+	}
 	t.close() // TODO: error handling
-	return checkDatabaseError(errptr)
+	return err
 }
+
+// levelReadTxn is a read-only transaction reading from a snapshot. It
+// holds no write state; its write methods only exist to reject writes
+// after a type assertion to RWTxn.
+type levelReadTxn struct {
+	iter *levelIterator
+}
+
+func (t *levelReadTxn) Get(key []byte) ([]byte, error) {
+	if t == nil || t.iter == nil {
+		return nil, errors.New("get from unopened transaction")
+	}
+	return t.iter.get(key)
+}
+
+func (t *levelReadTxn) Rollback() error {
+	if t == nil || t.iter == nil {
+		return errors.New("rollback unopened transaction")
+	}
+	err := t.iter.Close()
+	t.iter = nil
+	if err != nil {
+		return Error(err.Error())
+	}
+	return nil
+}
+
+func (t *levelReadTxn) Put(key, value []byte) error { return ErrReadOnlyTxn }
+func (t *levelReadTxn) Delete(key []byte) error     { return ErrReadOnlyTxn }
+func (t *levelReadTxn) Commit() error               { return ErrReadOnlyTxn }
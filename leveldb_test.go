@@ -0,0 +1,154 @@
+package backend
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLevelBlindWrite(t *testing.T) {
+	const path = "blindwrite_leveldb"
+	db := openLevelDB(t, path)
+	defer closeLevelDB(t, path, db)
+
+	txn, err := db.WritableTxn(BlindWrite())
+	if err != nil {
+		t.Fatalf("begin blind transaction: %v", err)
+	}
+	if err = txn.Put([]byte("a"), []byte("1")); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if err = txn.Put([]byte("b"), []byte("2")); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if err = txn.Delete([]byte("b")); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	// own writes are not tracked
+	if _, err = txn.Get([]byte("a")); err != ErrNotFound {
+		t.Fatalf("get uncommitted key: expected ErrNotFound, got %v", err)
+	}
+	if err = txn.Commit(); err != nil {
+		t.Fatalf("commit blind transaction: %v", err)
+	}
+
+	rtxn, err := db.Readonly()
+	if err != nil {
+		t.Fatalf("begin read-only transaction: %v", err)
+	}
+	defer rtxn.Rollback()
+	if v, err := rtxn.Get([]byte("a")); err != nil || !bytes.Equal(v, []byte("1")) {
+		t.Fatalf("get: expected %q, got %q, %v", "1", v, err)
+	}
+	if _, err = rtxn.Get([]byte("b")); err != ErrNotFound {
+		t.Fatalf("get deleted key: expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestLevelStats(t *testing.T) {
+	const path = "stats_leveldb"
+	var events []WriteStall
+	db := openLevelDB(t, path, OnWriteStall(func(ev WriteStall) { events = append(events, ev) }))
+	defer closeLevelDB(t, path, db)
+
+	if err := Update(db, func(txn RWTxn) error { return txn.Put([]byte("a"), []byte("1")) }); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	stats, err := db.Stats()
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if stats.Stalled || stats.Stalls != 0 || len(events) != 0 {
+		t.Fatalf("stats: unexpected stall %+v, events %+v", stats, events)
+	}
+	for _, l := range stats.Levels {
+		if l.Files < 0 || l.Size < 0 || l.CompactionTime < 0 {
+			t.Fatalf("stats: invalid level %+v", l)
+		}
+	}
+
+	// level 0 grows past the slowdown and stop limits and is compacted
+	start := time.Now()
+	db.stall.update(levelSlowdownFiles, start)
+	db.stall.update(levelStopFiles, start.Add(time.Second))
+	db.stall.update(2, start.Add(3*time.Second))
+	want := []WriteStall{
+		{Stalled: true, L0Files: levelSlowdownFiles},
+		{L0Files: 2, Duration: 3 * time.Second},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("stall events: expected %+v, got %+v", want, events)
+	}
+	if stats, err = db.Stats(); err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if stats.Stalled || stats.Stalls != 1 || stats.StallTime != 3*time.Second {
+		t.Fatalf("stats after stall: got %+v", stats)
+	}
+}
+
+func TestParseLevelStats(t *testing.T) {
+	const v = `                               Compactions
+Level  Files Size(MB) Time(sec) Read(MB) Write(MB)
+--------------------------------------------------
+  0        3        1         0        0         1
+  2       17       40        12       81        79
+`
+	levels, err := parseLevelStats(v)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	want := []LevelSize{
+		{Level: 0, Files: 3, Size: 1 << 20, Written: 1 << 20},
+		{Level: 2, Files: 17, Size: 40 << 20, CompactionTime: 12 * time.Second, Read: 81 << 20, Written: 79 << 20},
+	}
+	if !reflect.DeepEqual(levels, want) {
+		t.Fatalf("parse: expected %+v, got %+v", want, levels)
+	}
+	if _, err = parseLevelStats("Level Files\n  0 1\n"); err != errInvalidLevelStats {
+		t.Fatalf("parse without table: expected errInvalidLevelStats, got %v", err)
+	}
+}
+
+func TestConvertToBolt(t *testing.T) {
+	const path = "convert_leveldb"
+	db := openLevelDB(t, path)
+	defer closeLevelDB(t, path, db)
+
+	txn, err := db.Writable()
+	if err != nil {
+		t.Fatalf("begin writable transaction: %v", err)
+	}
+	for i, key := range compatKeys {
+		if err = txn.Put(key, compatValues[i]); err != nil {
+			t.Fatalf("put: %v", err)
+		}
+	}
+	if err = txn.Commit(); err != nil {
+		t.Fatalf("commit writable transaction: %v", err)
+	}
+
+	boltPath := filepath.Join(t.TempDir(), "converted.db")
+	n, err := ConvertToBolt(db, boltPath)
+	if err != nil || n != int64(len(compatKeys)) {
+		t.Fatalf("convert: expected %d pairs, got %d, %v", len(compatKeys), n, err)
+	}
+	if _, err = ConvertToBolt(db, boltPath); err == nil {
+		t.Fatalf("convert to existing file: expected error")
+	}
+
+	converted, err := OpenBoltDB(boltPath, time.Second)
+	if err != nil {
+		t.Fatalf("open converted database: %v", err)
+	}
+	defer converted.Close()
+	sum, err := Checksum(db, nil)
+	if err != nil {
+		t.Fatalf("checksum: %v", err)
+	}
+	if convertedSum, err := Checksum(converted, nil); err != nil || convertedSum != sum {
+		t.Fatalf("converted database differs: %v", err)
+	}
+}
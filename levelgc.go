@@ -0,0 +1,300 @@
+package backend
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var errInvalidManifest = errors.New("invalid LevelDB manifest")
+
+// LevelGarbage lists files of a LevelDB directory that are not needed
+// to open the database.
+type LevelGarbage struct {
+	Files []string // file names relative to the database directory
+	Bytes int64    // total size of Files
+}
+
+// CollectLevelGarbage finds files in the LevelDB directory root that the
+// current MANIFEST does not reference: table files left behind by
+// interrupted compactions, logs already flushed to tables, old MANIFESTs,
+// temporary files and LOG.old. Unless dryRun is true the files are
+// removed.
+//
+// The database must not be open, by this or any other process, while
+// CollectLevelGarbage runs: files of an open database may be in use
+// before they are recorded in the MANIFEST.
+func CollectLevelGarbage(root string, dryRun bool) (*LevelGarbage, error) {
+	current, err := os.ReadFile(filepath.Join(root, "CURRENT"))
+	if err != nil {
+		return nil, err
+	}
+	manifest := strings.TrimSuffix(string(current), "\n")
+	if !strings.HasPrefix(manifest, "MANIFEST-") || strings.ContainsRune(manifest, '/') {
+		return nil, errInvalidManifest
+	}
+	f, err := os.Open(filepath.Join(root, manifest))
+	if err != nil {
+		return nil, err
+	}
+	v, err := readManifest(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	g := &LevelGarbage{}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !v.obsolete(name, manifest) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		g.Files = append(g.Files, name)
+		g.Bytes += info.Size()
+	}
+	sort.Strings(g.Files)
+
+	if dryRun {
+		return g, nil
+	}
+	for _, name := range g.Files {
+		if err = os.Remove(filepath.Join(root, name)); err != nil && !os.IsNotExist(err) {
+			return g, err
+		}
+	}
+	return g, nil
+}
+
+// levelVersion is the file state recorded in a MANIFEST.
+type levelVersion struct {
+	logNumber     uint64
+	prevLogNumber uint64
+	tables        map[uint64]int // number of levels listing a table file
+}
+
+// obsolete reports whether the file name is not needed by v, the state
+// of the MANIFEST file named manifest.
+func (v *levelVersion) obsolete(name, manifest string) bool {
+	switch {
+	case name == "LOG.old":
+		return true
+	case strings.HasPrefix(name, "MANIFEST-"):
+		return name != manifest
+	}
+
+	i := strings.IndexByte(name, '.')
+	if i < 0 {
+		return false
+	}
+	n, err := strconv.ParseUint(name[:i], 10, 64)
+	if err != nil {
+		return false
+	}
+	switch name[i:] {
+	case ".ldb", ".sst":
+		return v.tables[n] == 0
+	case ".log":
+		return n < v.logNumber && n != v.prevLogNumber
+	case ".dbtmp":
+		return true
+	}
+	return false
+}
+
+// LevelDB version edit tags.
+const (
+	tagComparator     = 1
+	tagLogNumber      = 2
+	tagNextFileNumber = 3
+	tagLastSequence   = 4
+	tagCompactPointer = 5
+	tagDeletedFile    = 6
+	tagNewFile        = 7
+	tagPrevLogNumber  = 9
+)
+
+// readManifest applies all version edits of a MANIFEST.
+func readManifest(r io.Reader) (*levelVersion, error) {
+	v := &levelVersion{tables: make(map[uint64]int)}
+	live := make(map[[2]uint64]bool) // level, file number
+	lr := &levelLogReader{r: r}
+	for {
+		rec, err := lr.next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		var deleted, added [][2]uint64
+		for len(rec) > 0 {
+			var tag uint64
+			if tag, rec, err = manifestUvarint(rec); err != nil {
+				return nil, err
+			}
+			switch tag {
+			case tagComparator:
+				_, rec, err = manifestBytes(rec)
+			case tagLogNumber:
+				v.logNumber, rec, err = manifestUvarint(rec)
+			case tagPrevLogNumber:
+				v.prevLogNumber, rec, err = manifestUvarint(rec)
+			case tagNextFileNumber, tagLastSequence:
+				_, rec, err = manifestUvarint(rec)
+			case tagCompactPointer:
+				if _, rec, err = manifestUvarint(rec); err == nil {
+					_, rec, err = manifestBytes(rec)
+				}
+			case tagDeletedFile:
+				var f [2]uint64
+				if f[0], rec, err = manifestUvarint(rec); err == nil {
+					f[1], rec, err = manifestUvarint(rec)
+				}
+				deleted = append(deleted, f)
+			case tagNewFile:
+				var f [2]uint64
+				if f[0], rec, err = manifestUvarint(rec); err == nil {
+					f[1], rec, err = manifestUvarint(rec)
+				}
+				if err == nil {
+					_, rec, err = manifestUvarint(rec) // file size
+				}
+				if err == nil {
+					_, rec, err = manifestBytes(rec) // smallest key
+				}
+				if err == nil {
+					_, rec, err = manifestBytes(rec) // largest key
+				}
+				added = append(added, f)
+			default:
+				return nil, errInvalidManifest
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// an edit removes files before adding them, moving a file
+		// between levels deletes and adds it in the same edit
+		for _, f := range deleted {
+			if live[f] {
+				delete(live, f)
+				v.tables[f[1]]--
+			}
+		}
+		for _, f := range added {
+			if !live[f] {
+				live[f] = true
+				v.tables[f[1]]++
+			}
+		}
+	}
+	return v, nil
+}
+
+func manifestUvarint(b []byte) (uint64, []byte, error) {
+	v, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, nil, errInvalidManifest
+	}
+	return v, b[n:], nil
+}
+
+func manifestBytes(b []byte) ([]byte, []byte, error) {
+	n, b, err := manifestUvarint(b)
+	if err != nil || uint64(len(b)) < n {
+		return nil, nil, errInvalidManifest
+	}
+	return b[:n], b[n:], nil
+}
+
+// LevelDB log format: 32KiB blocks of records with a 7 byte header of a
+// masked CRC-32C, a little endian length and a fragment type.
+const (
+	levelLogBlockSize  = 32 * 1024
+	levelLogHeaderSize = 7
+
+	levelLogFull   = 1
+	levelLogFirst  = 2
+	levelLogMiddle = 3
+	levelLogLast   = 4
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+func levelLogChecksum(typ byte, data []byte) uint32 {
+	c := crc32.Update(0, castagnoli, []byte{typ})
+	c = crc32.Update(c, castagnoli, data)
+	return (c>>15 | c<<17) + 0xa282ead8
+}
+
+type levelLogReader struct {
+	r     io.Reader
+	block []byte // unread rest of the current block
+	buf   [levelLogBlockSize]byte
+}
+
+// next returns the next complete record, or io.EOF.
+func (lr *levelLogReader) next() ([]byte, error) {
+	var rec []byte
+	inRecord := false
+	for {
+		if len(lr.block) < levelLogHeaderSize {
+			// the rest of a block too small for a header is padding
+			n, err := io.ReadFull(lr.r, lr.buf[:])
+			if err == io.EOF || (err == io.ErrUnexpectedEOF && n < levelLogHeaderSize) {
+				if inRecord {
+					return nil, errInvalidManifest
+				}
+				return nil, io.EOF
+			} else if err != nil && err != io.ErrUnexpectedEOF {
+				return nil, err
+			}
+			lr.block = lr.buf[:n]
+		}
+
+		sum := binary.LittleEndian.Uint32(lr.block)
+		length := int(binary.LittleEndian.Uint16(lr.block[4:]))
+		typ := lr.block[6]
+		if typ == 0 && length == 0 {
+			// preallocated space, skip the rest of the block
+			lr.block = nil
+			continue
+		}
+		if levelLogHeaderSize+length > len(lr.block) {
+			return nil, errInvalidManifest
+		}
+		data := lr.block[levelLogHeaderSize : levelLogHeaderSize+length]
+		lr.block = lr.block[levelLogHeaderSize+length:]
+		if levelLogChecksum(typ, data) != sum {
+			return nil, errInvalidManifest
+		}
+
+		switch {
+		case typ == levelLogFull && !inRecord:
+			return append([]byte{}, data...), nil
+		case typ == levelLogFirst && !inRecord:
+			rec, inRecord = append(rec[:0], data...), true
+		case typ == levelLogMiddle && inRecord:
+			rec = append(rec, data...)
+		case typ == levelLogLast && inRecord:
+			return append(rec, data...), nil
+		default:
+			return nil, errInvalidManifest
+		}
+	}
+}
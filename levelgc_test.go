@@ -0,0 +1,139 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeLevelLog encodes records in the LevelDB log format.
+func writeLevelLog(records ...[]byte) []byte {
+	var buf bytes.Buffer
+	left := levelLogBlockSize
+	for _, rec := range records {
+		first := true
+		for {
+			if left < levelLogHeaderSize {
+				buf.Write(make([]byte, left))
+				left = levelLogBlockSize
+			}
+			n := len(rec)
+			if n > left-levelLogHeaderSize {
+				n = left - levelLogHeaderSize
+			}
+			last := n == len(rec)
+			typ := byte(levelLogMiddle)
+			switch {
+			case first && last:
+				typ = levelLogFull
+			case first:
+				typ = levelLogFirst
+			case last:
+				typ = levelLogLast
+			}
+
+			var hdr [levelLogHeaderSize]byte
+			binary.LittleEndian.PutUint32(hdr[:], levelLogChecksum(typ, rec[:n]))
+			binary.LittleEndian.PutUint16(hdr[4:], uint16(n))
+			hdr[6] = typ
+			buf.Write(hdr[:])
+			buf.Write(rec[:n])
+			left -= levelLogHeaderSize + n
+			rec, first = rec[n:], false
+			if last {
+				break
+			}
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestCollectLevelGarbage(t *testing.T) {
+	u := func(b []byte, v ...uint64) []byte {
+		for _, x := range v {
+			b = binary.AppendUvarint(b, x)
+		}
+		return b
+	}
+	newFile := func(b []byte, level, n uint64) []byte {
+		return append(u(b, tagNewFile, level, n, 100, 1), 'a', 1, 'z')
+	}
+
+	var edit1, edit2 []byte
+	edit1 = append(u(edit1, tagComparator, 26), "leveldb.BytewiseComparator"...)
+	edit1 = u(edit1, tagLogNumber, 3, tagNextFileNumber, 4, tagLastSequence, 10)
+	edit1 = newFile(edit1, 0, 5)
+	edit1 = newFile(edit1, 0, 6)
+	edit1 = append(edit1, bytes.Repeat(newFile(nil, 1, 1000), 4000)...) // spans blocks
+	// compaction moves 6 to level 1, replaces 5 with 8 and
+	// leaves the unfinished 9 behind
+	edit2 = u(edit2, tagLogNumber, 7, tagPrevLogNumber, 4)
+	edit2 = u(edit2, tagDeletedFile, 0, 5, tagDeletedFile, 0, 6)
+	edit2 = newFile(edit2, 1, 6)
+	edit2 = newFile(edit2, 1, 8)
+
+	root := t.TempDir()
+	files := map[string][]byte{
+		"CURRENT":         []byte("MANIFEST-000002\n"),
+		"MANIFEST-000002": writeLevelLog(edit1, edit2),
+		"MANIFEST-000001": []byte("old"),
+		"LOCK":            nil,
+		"LOG":             nil,
+		"LOG.old":         []byte("old log"),
+		"000001000.ldb":   nil,
+		"000003.log":      []byte("flushed"),
+		"000004.log":      nil,
+		"000005.ldb":      []byte("compacted"),
+		"000006.ldb":      nil,
+		"000007.log":      nil,
+		"000008.sst":      nil,
+		"000009.ldb":      []byte("unfinished"),
+		"000010.dbtmp":    []byte("tmp"),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(root, name), data, 0644); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+
+	expected := &LevelGarbage{
+		Files: []string{"000003.log", "000005.ldb", "000009.ldb", "000010.dbtmp", "LOG.old", "MANIFEST-000001"},
+		Bytes: 7 + 9 + 10 + 3 + 7 + 3,
+	}
+	g, err := CollectLevelGarbage(root, true)
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if !reflect.DeepEqual(g, expected) {
+		t.Fatalf("dry run: expected %v, got %v", expected, g)
+	}
+	for _, name := range g.Files {
+		if _, err = os.Stat(filepath.Join(root, name)); err != nil {
+			t.Fatalf("dry run removed %s: %v", name, err)
+		}
+	}
+
+	if g, err = CollectLevelGarbage(root, false); err != nil || !reflect.DeepEqual(g, expected) {
+		t.Fatalf("collect: expected %v, got %v, %v", expected, g, err)
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatalf("read dir: %v", err)
+	}
+	if len(entries) != len(files)-len(expected.Files) {
+		t.Fatalf("collect: expected %d files left, got %d", len(files)-len(expected.Files), len(entries))
+	}
+
+	// a corrupt MANIFEST must not remove anything
+	manifest := writeLevelLog(edit1, edit2)
+	manifest[len(manifest)-1] ^= 1
+	if err = os.WriteFile(filepath.Join(root, "MANIFEST-000002"), manifest, 0644); err != nil {
+		t.Fatalf("write manifest: %v", err)
+	}
+	if _, err = CollectLevelGarbage(root, false); err != errInvalidManifest {
+		t.Fatalf("corrupt manifest: expected errInvalidManifest, got %v", err)
+	}
+}
@@ -0,0 +1,256 @@
+//go:build cgo
+
+package backend
+
+/*
+#include <stdlib.h>
+#include "leveldb/c.h"
+*/
+import "C"
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+var errInvalidLevelStats = errors.New("invalid leveldb.stats property")
+
+// LevelDB delays writes while level 0 holds levelSlowdownFiles tables and
+// stops them at levelStopFiles until compaction catches up, see
+// kL0_SlowdownWritesTrigger and kL0_StopWritesTrigger in db/dbformat.h.
+const (
+	levelSlowdownFiles = 8
+	levelStopFiles     = 12
+)
+
+// levelSlowWrite is the shortest write that may have been stalled.
+// LevelDB delays every write by a millisecond during a slowdown.
+const levelSlowWrite = time.Millisecond
+
+// LevelStats describes the state of a LevelDB database. Levels are
+// parsed from the leveldb.stats property; LevelDB does not count write
+// stalls, the stall counters are kept by the LevelDB handle from the
+// number of level-0 tables after slow writes and on each call to Stats.
+type LevelStats struct {
+	Levels      []LevelSize // levels holding tables or compacted before
+	MemoryUsage uint64      // approximate memory used by memtables and caches
+
+	Stalled    bool          // writes are delayed or stopped
+	Stalls     uint64        // number of stalls since the database was opened
+	StallTime  time.Duration // total duration of all stalls
+	SlowWrites uint64        // commits writing for a millisecond or more
+}
+
+// LevelSize holds the size and compaction statistics of a level.
+type LevelSize struct {
+	Level          int
+	Files          int
+	Size           int64         // bytes, with megabyte precision
+	CompactionTime time.Duration // time spent compacting into the level
+	Read, Written  int64         // bytes read and written by compactions
+}
+
+// WriteStall is reported when writes start or stop being throttled.
+type WriteStall struct {
+	Stalled  bool          // the stall began
+	L0Files  int           // number of level-0 tables
+	Stopped  bool          // writes are stopped rather than delayed
+	Duration time.Duration // duration of the stall that ended
+}
+
+// OnWriteStall calls fn when a write stall begins or ends, e.g. to shed
+// write load while compaction catches up. Stalls are detected after slow
+// commits and on calls to Stats; a service that stops writing should poll
+// Stats to learn that the stall ended. fn is called synchronously and
+// must not call Stats.
+func OnWriteStall(fn func(WriteStall)) LevelOption {
+	return func(db *LevelDB) error {
+		db.stall.notify = fn
+		return nil
+	}
+}
+
+// levelStall tracks write stalls of a LevelDB.
+type levelStall struct {
+	mu         sync.Mutex // also held while notify runs, ordering reports
+	notify     func(WriteStall)
+	since      time.Time // start of the current stall, zero if none
+	stalls     uint64
+	slowWrites uint64
+	total      time.Duration // duration of ended stalls
+}
+
+// update sets the stall state from the number of level-0 tables and
+// reports a change to notify.
+func (s *levelStall) update(l0 int, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ev := WriteStall{L0Files: l0, Stopped: l0 >= levelStopFiles}
+	switch stalled := l0 >= levelSlowdownFiles; {
+	case stalled && s.since.IsZero():
+		s.since = now
+		s.stalls++
+		ev.Stalled = true
+	case !stalled && !s.since.IsZero():
+		ev.Duration = now.Sub(s.since)
+		s.total += ev.Duration
+		s.since = time.Time{}
+	default:
+		return
+	}
+	if s.notify != nil {
+		s.notify(ev)
+	}
+}
+
+// observeWrite records a commit that wrote for d and checks for a stall
+// if the write was slow or a stall is in progress. The caller holds a
+// reference on db.
+func (db *LevelDB) observeWrite(d time.Duration) {
+	s := &db.stall
+	s.mu.Lock()
+	slow := d >= levelSlowWrite
+	if slow {
+		s.slowWrites++
+	}
+	check := slow || !s.since.IsZero()
+	s.mu.Unlock()
+	if check {
+		db.checkStall()
+	}
+}
+
+func (db *LevelDB) checkStall() {
+	v, _ := db.property("leveldb.num-files-at-level0")
+	if l0, err := strconv.Atoi(v); err == nil {
+		db.stall.update(l0, time.Now())
+	}
+}
+
+// property returns the value of a LevelDB property and whether it is
+// known. The caller holds a reference on db.
+func (db *LevelDB) property(name string) (string, bool) {
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	v := C.leveldb_property_value(db.tree, cname)
+	if v == nil {
+		return "", false
+	}
+	defer C.leveldb_free(unsafe.Pointer(v))
+	return C.GoString(v), true
+}
+
+// Stats returns the level sizes, compaction statistics and write stalls
+// of the database.
+func (db *LevelDB) Stats() (*LevelStats, error) {
+	if db == nil {
+		return nil, errors.New("stats of unopened LevelDB instance")
+	}
+	if err := db.refs.acquire(); err != nil {
+		return nil, err
+	}
+	defer db.refs.release()
+
+	stats := &LevelStats{}
+	if v, ok := db.property("leveldb.stats"); ok {
+		levels, err := parseLevelStats(v)
+		if err != nil {
+			return nil, err
+		}
+		stats.Levels = levels
+	}
+	if v, ok := db.property("leveldb.approximate-memory-usage"); ok {
+		stats.MemoryUsage, _ = strconv.ParseUint(v, 10, 64)
+	}
+
+	db.checkStall()
+	s := &db.stall
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats.Stalls, stats.SlowWrites, stats.StallTime = s.stalls, s.slowWrites, s.total
+	if !s.since.IsZero() {
+		stats.Stalled = true
+		stats.StallTime += time.Since(s.since)
+	}
+	return stats, nil
+}
+
+// levelLogTail is the number of bytes of the LevelDB LOG added to a
+// diagnostics bundle.
+const levelLogTail = 64 << 10
+
+// diagnostics adds the statistics and the tail of the LOG file.
+func (db *LevelDB) diagnostics(b *bundle) error {
+	stats, err := db.Stats()
+	if err != nil {
+		return err
+	}
+	if err = b.writeJSON("stats.json", struct {
+		Root  string
+		Stats *LevelStats
+	}{db.root, stats}); err != nil {
+		return err
+	}
+	err = b.writeTail("LOG", filepath.Join(db.root, "LOG"), levelLogTail)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// parseLevelStats parses the table of the leveldb.stats property:
+//
+//	                               Compactions
+//	Level  Files Size(MB) Time(sec) Read(MB) Write(MB)
+//	--------------------------------------------------
+//	  0        2        0         0        0         0
+func parseLevelStats(v string) ([]LevelSize, error) {
+	const mb = 1 << 20
+	var levels []LevelSize
+	table := false
+	sc := bufio.NewScanner(strings.NewReader(v))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if !table {
+			table = strings.HasPrefix(line, "---")
+			continue
+		}
+		f := strings.Fields(line)
+		if len(f) == 0 {
+			continue
+		}
+		if len(f) != 6 {
+			return nil, errInvalidLevelStats
+		}
+		var l LevelSize
+		var num [4]float64
+		var err error
+		if l.Level, err = strconv.Atoi(f[0]); err != nil {
+			return nil, errInvalidLevelStats
+		}
+		if l.Files, err = strconv.Atoi(f[1]); err != nil {
+			return nil, errInvalidLevelStats
+		}
+		for i := range num {
+			if num[i], err = strconv.ParseFloat(f[i+2], 64); err != nil {
+				return nil, errInvalidLevelStats
+			}
+		}
+		l.Size = int64(num[0] * mb)
+		l.CompactionTime = time.Duration(num[1] * float64(time.Second))
+		l.Read, l.Written = int64(num[2]*mb), int64(num[3]*mb)
+		levels = append(levels, l)
+	}
+	if !table {
+		return nil, errInvalidLevelStats
+	}
+	return levels, nil
+}
@@ -0,0 +1,149 @@
+package backend
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// managedStore is a store handle of a Manager. It opens the store on
+// use and pins it while transactions or iterators are open.
+type managedStore struct {
+	m          *Manager
+	name, path string
+
+	// guarded by m.mu
+	db        DB     // nil while closed
+	used      uint64 // m.clock at the last use
+	dropped   bool
+	archiving bool // pinned by Archive, no new uses
+
+	refs int64 // open transactions and iterators, accessed atomically
+}
+
+func (s *managedStore) idle() bool { return atomic.LoadInt64(&s.refs) == 0 }
+
+// acquire returns the open store and pins it until release is called.
+func (s *managedStore) acquire() (DB, error) {
+	m := s.m
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, ErrDBClosed
+	} else if s.dropped {
+		return nil, ErrStoreNotFound
+	} else if s.archiving {
+		return nil, ErrStoreInUse
+	}
+	if err := m.openStore(s); err != nil {
+		return nil, err
+	}
+	atomic.AddInt64(&s.refs, 1)
+	m.clock++
+	s.used = m.clock
+	return s.db, nil
+}
+
+// release unpins the store. It does not lock the Manager, so stores can
+// be closed by the Manager while it waits for open transactions.
+func (s *managedStore) release() { atomic.AddInt64(&s.refs, -1) }
+
+func (s *managedStore) Iterator() (Iterator, error) {
+	db, err := s.acquire()
+	if err != nil {
+		return nil, err
+	}
+	iter, err := db.Iterator()
+	if err != nil {
+		s.release()
+		return nil, err
+	}
+	return &managedIterator{Iterator: iter, s: s}, nil
+}
+
+func (s *managedStore) Readonly() (Txn, error) {
+	db, err := s.acquire()
+	if err != nil {
+		return nil, err
+	}
+	txn, err := db.Readonly()
+	if err != nil {
+		s.release()
+		return nil, err
+	}
+	return &managedReadTxn{Txn: txn, s: s}, nil
+}
+
+func (s *managedStore) Writable() (RWTxn, error) {
+	db, err := s.acquire()
+	if err != nil {
+		return nil, err
+	}
+	txn, err := db.Writable()
+	if err != nil {
+		s.release()
+		return nil, err
+	}
+	return &managedTxn{RWTxn: txn, s: s}, nil
+}
+
+func (s *managedStore) WriteTo(w io.Writer) (int64, error) {
+	db, err := s.acquire()
+	if err != nil {
+		return 0, err
+	}
+	defer s.release()
+	return db.WriteTo(w)
+}
+
+// Name returns the name of the store in the Manager without opening it.
+func (s *managedStore) Name() string { return s.name }
+
+// Close does nothing, the Manager closes its stores.
+func (s *managedStore) Close() error { return nil }
+
+type managedIterator struct {
+	Iterator
+	s    *managedStore
+	once sync.Once
+}
+
+func (i *managedIterator) Close() error {
+	err := i.Iterator.Close()
+	i.once.Do(i.s.release)
+	return err
+}
+
+type managedReadTxn struct {
+	Txn
+	s    *managedStore
+	once sync.Once
+}
+
+func (t *managedReadTxn) Put(key, value []byte) error { return ErrReadOnlyTxn }
+func (t *managedReadTxn) Delete(key []byte) error     { return ErrReadOnlyTxn }
+func (t *managedReadTxn) Commit() error               { return ErrReadOnlyTxn }
+
+func (t *managedReadTxn) Rollback() error {
+	err := t.Txn.Rollback()
+	t.once.Do(t.s.release)
+	return err
+}
+
+type managedTxn struct {
+	RWTxn
+	s    *managedStore
+	once sync.Once
+}
+
+func (t *managedTxn) Commit() error {
+	err := t.RWTxn.Commit()
+	t.once.Do(t.s.release)
+	return err
+}
+
+func (t *managedTxn) Rollback() error {
+	err := t.RWTxn.Rollback()
+	t.once.Do(t.s.release)
+	return err
+}
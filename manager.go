@@ -0,0 +1,537 @@
+package backend
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// ErrStoreExists is returned by Manager.Create for existing stores.
+	ErrStoreExists Error = Error("store exists")
+
+	// ErrStoreNotFound is returned by the Manager for unknown stores.
+	ErrStoreNotFound Error = Error("store not found")
+
+	// ErrStoreProtected is returned when dropping or archiving a
+	// protected store.
+	ErrStoreProtected Error = Error("store protected")
+
+	// ErrStoreInUse is returned when dropping or archiving a store with
+	// open transactions or iterators.
+	ErrStoreInUse Error = Error("store in use")
+)
+
+var errInvalidStoreName = errors.New("invalid store name")
+
+// StoreOpener opens the store at path, creating it if it does not exist.
+type StoreOpener func(path string) (DB, error)
+
+// BoltStores returns a StoreOpener opening each store as a BoltDB file
+// with the given options.
+func BoltStores(timeout time.Duration, opts ...BoltOption) StoreOpener {
+	return func(path string) (DB, error) {
+		return OpenBoltDB(path, timeout, opts...)
+	}
+}
+
+// ManagerOption configures a Manager.
+type ManagerOption func(*Manager) error
+
+// StoreEvent records a lifecycle operation on a store.
+type StoreEvent struct {
+	Op   string // "create", "clone", "drop" or "archive"
+	Name string
+	Time time.Time
+	Err  error // nil if the operation succeeded
+}
+
+// StoreEvents calls fn after every create, clone, drop and archive attempt,
+// e.g. to write an audit log. fn is called with the Manager locked and
+// must not call it.
+func StoreEvents(fn func(StoreEvent)) ManagerOption {
+	return func(m *Manager) error {
+		m.events = fn
+		return nil
+	}
+}
+
+// MaxOpenStores limits the number of stores open at the same time to n.
+// Opening another store closes the least recently used store without
+// open transactions and iterators; if all open stores are in use, the
+// limit is exceeded until they are released.
+func MaxOpenStores(n int) ManagerOption {
+	return func(m *Manager) error {
+		if n <= 0 {
+			return errors.New("max open stores must be positive")
+		}
+		m.maxOpen = n
+		return nil
+	}
+}
+
+// ProtectStores makes Drop and Archive refuse to remove the named
+// stores.
+func ProtectStores(names ...string) ManagerOption {
+	return func(m *Manager) error {
+		for _, name := range names {
+			m.protected[name] = true
+		}
+		return nil
+	}
+}
+
+// Manager owns a directory of named stores, one file or subdirectory per
+// store, all opened by the same StoreOpener. Names starting with a dot
+// are reserved.
+//
+// The Manager hands out store handles that open the store on first use
+// and may be closed by the Manager again while unused, see
+// MaxOpenStores. Handles stay valid until the store is dropped or the
+// Manager is closed; closing a handle has no effect.
+type Manager struct {
+	root      string
+	open      StoreOpener
+	events    func(StoreEvent)
+	protected map[string]bool
+	maxOpen   int // 0 for no limit
+
+	mu      sync.Mutex
+	stores  map[string]*managedStore
+	numOpen int
+	clock   uint64 // incremented on every store use
+	closed  bool
+}
+
+// NewManager returns a Manager for the stores in root, creating the
+// directory if needed.
+func NewManager(root string, open StoreOpener, opts ...ManagerOption) (*Manager, error) {
+	m := &Manager{
+		root:      root,
+		open:      open,
+		protected: make(map[string]bool),
+		stores:    make(map[string]*managedStore),
+	}
+	for _, opt := range opts {
+		if err := opt(m); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *Manager) event(op, name string, err error) {
+	if m.events != nil {
+		m.events(StoreEvent{Op: op, Name: name, Time: time.Now(), Err: err})
+	}
+}
+
+func (m *Manager) path(name string) (string, error) {
+	if name == "" || name[0] == '.' || strings.ContainsAny(name, `/\`) {
+		return "", errInvalidStoreName
+	}
+	return filepath.Join(m.root, name), nil
+}
+
+func (m *Manager) exists(path string) (bool, error) {
+	_, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// handle returns the handle of the store name, creating it if needed.
+// If create is true, a missing store is created, otherwise
+// ErrStoreNotFound is returned for it.
+func (m *Manager) handle(name string, create bool) (*managedStore, error) {
+	if m.closed {
+		return nil, ErrDBClosed
+	}
+	path, err := m.path(name)
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := m.stores[name]; ok {
+		return s, nil
+	}
+	ok, err := m.exists(path)
+	if err != nil {
+		return nil, err
+	} else if !ok && !create {
+		return nil, ErrStoreNotFound
+	}
+
+	s := &managedStore{m: m, name: name, path: path}
+	if !ok {
+		if err = m.openStore(s); err != nil {
+			return nil, err
+		}
+	}
+	m.stores[name] = s
+	return s, nil
+}
+
+// openStore opens the store of s unless it is open already, closing
+// the least recently used stores beyond the limit first.
+func (m *Manager) openStore(s *managedStore) error {
+	if s.db != nil {
+		return nil
+	}
+	for m.maxOpen > 0 && m.numOpen >= m.maxOpen {
+		var lru *managedStore
+		for _, o := range m.stores {
+			if o.db != nil && o.idle() && (lru == nil || o.used < lru.used) {
+				lru = o
+			}
+		}
+		if lru == nil {
+			break
+		}
+		if err := m.closeStore(lru); err != nil {
+			return err
+		}
+	}
+
+	db, err := m.open(s.path)
+	if err != nil {
+		return err
+	}
+	s.db = db
+	m.numOpen++
+	m.clock++
+	s.used = m.clock
+	return nil
+}
+
+func (m *Manager) closeStore(s *managedStore) error {
+	if s.db == nil {
+		return nil
+	}
+	db := s.db
+	s.db = nil
+	m.numOpen--
+	return db.Close()
+}
+
+// Open returns the store name, creating it if it does not exist.
+func (m *Manager) Open(name string) (DB, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.handle(name, true)
+}
+
+// Get returns the existing store name, or ErrStoreNotFound.
+func (m *Manager) Get(name string) (DB, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.handle(name, false)
+}
+
+// Create creates and returns the new store name. It returns
+// ErrStoreExists if the store exists.
+func (m *Manager) Create(name string) (db DB, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer func() { m.event("create", name, err) }()
+	path, err := m.path(name)
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := m.exists(path); err != nil {
+		return nil, err
+	} else if ok {
+		return nil, ErrStoreExists
+	}
+	return m.handle(name, true)
+}
+
+// Clone creates the store dst as a copy of the store src. BoltDB stores
+// are copied as a file image in a read-only transaction, LevelDB stores
+// as a checkpoint and other stores by exporting src into a new store.
+// The copy is a consistent snapshot of src.
+func (m *Manager) Clone(src, dst string) (err error) {
+	defer func() {
+		m.mu.Lock()
+		m.event("clone", dst, err)
+		m.mu.Unlock()
+	}()
+	path, err := m.path(dst)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	s, err := m.handle(src, false)
+	if err == nil {
+		var ok bool
+		if ok, err = m.exists(path); err == nil && ok {
+			err = ErrStoreExists
+		}
+	}
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	db, err := s.acquire()
+	if err != nil {
+		return err
+	}
+	defer s.release()
+
+	// copy to a reserved name, so the clone only appears once complete
+	tmp, err := os.MkdirTemp(m.root, ".clone-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err = m.copyStore(db, filepath.Join(tmp, dst)); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ok, err := m.exists(path); err != nil {
+		return err
+	} else if ok {
+		return ErrStoreExists
+	}
+	return os.Rename(filepath.Join(tmp, dst), path)
+}
+
+// checkpointer is implemented by backends that can copy their files
+// into a new directory, such as LevelDB.
+type checkpointer interface {
+	Checkpoint(dir string) error
+}
+
+// fileWriter is implemented by single-file backends that can write a
+// copy of their file, such as BoltDB.
+type fileWriter interface {
+	WriteFileTo(w io.Writer) (int64, error)
+}
+
+func (m *Manager) copyStore(db DB, path string) error {
+	switch c := db.(type) {
+	case checkpointer:
+		return c.Checkpoint(path)
+	case fileWriter:
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			return err
+		}
+		if _, err = c.WriteFileTo(f); err == nil {
+			err = f.Sync()
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}
+
+	c, err := m.open(path)
+	if err != nil {
+		return err
+	}
+	r, w := io.Pipe()
+	go func() {
+		_, err := Export(w, db)
+		w.CloseWithError(err)
+	}()
+	err = Import(c, r)
+	r.CloseWithError(io.ErrClosedPipe)
+	if cerr := c.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Drop closes the store name and removes its files. Protected stores and
+// stores with open transactions or iterators are not dropped.
+func (m *Manager) Drop(name string) (err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer func() { m.event("drop", name, err) }()
+	s, err := m.check(name)
+	if err != nil {
+		return err
+	}
+	return m.remove(s)
+}
+
+// Archive compacts the store name where the backend supports it, exports
+// it to the writer returned by sink, then closes and removes the store.
+// The store is only removed once the export and closing the writer
+// succeeded. Protected stores and stores with open transactions or
+// iterators are not archived; while the store is archived, its handle
+// returns ErrStoreInUse. Other stores are not blocked meanwhile.
+func (m *Manager) Archive(name string, sink func(name string) (io.WriteCloser, error)) (err error) {
+	defer func() {
+		m.mu.Lock()
+		m.event("archive", name, err)
+		m.mu.Unlock()
+	}()
+	m.mu.Lock()
+	s, err := m.check(name)
+	if err == nil {
+		err = m.openStore(s)
+	}
+	if err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	// pin the store, so neither the LRU nor Drop closes it
+	atomic.AddInt64(&s.refs, 1)
+	s.archiving = true
+	db := s.db
+	m.mu.Unlock()
+
+	err = archive(db, name, sink)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s.archiving = false
+	atomic.AddInt64(&s.refs, -1)
+	if err != nil {
+		return err
+	}
+	if m.closed {
+		return ErrDBClosed
+	}
+	return m.remove(s)
+}
+
+// compacter is implemented by backends that can reclaim the space of
+// overwritten and deleted values, such as LevelDB.
+type compacter interface {
+	Compact() error
+}
+
+// archive compacts db if supported and exports it to the writer
+// returned by sink.
+func archive(db DB, name string, sink func(name string) (io.WriteCloser, error)) error {
+	var err error
+	switch c := db.(type) {
+	case compacter:
+		err = c.Compact()
+	case *Bitcask:
+		err = c.Merge()
+	}
+	if err != nil {
+		return err
+	}
+	w, err := sink(name)
+	if err != nil {
+		return err
+	}
+	if _, err = Export(w, db); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// check returns the handle of the existing, unprotected and unused store
+// name.
+func (m *Manager) check(name string) (*managedStore, error) {
+	if _, err := m.path(name); err != nil {
+		return nil, err
+	} else if m.protected[name] {
+		return nil, ErrStoreProtected
+	}
+	s, err := m.handle(name, false)
+	if err != nil {
+		return nil, err
+	}
+	if !s.idle() {
+		return nil, ErrStoreInUse
+	}
+	return s, nil
+}
+
+func (m *Manager) remove(s *managedStore) error {
+	delete(m.stores, s.name)
+	s.dropped = true
+	if err := m.closeStore(s); err != nil {
+		return err
+	}
+	return os.RemoveAll(s.path)
+}
+
+// List returns the names of all stores in ascending order.
+func (m *Manager) List() ([]string, error) {
+	entries, err := os.ReadDir(m.root)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), ".") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ManagerStats summarizes the stores of a Manager.
+type ManagerStats struct {
+	Stores int   // number of stores
+	Open   int   // number of open stores
+	Bytes  int64 // disk usage of all stores
+}
+
+// Stats returns statistics aggregated over all stores.
+func (m *Manager) Stats() (ManagerStats, error) {
+	names, err := m.List()
+	if err != nil {
+		return ManagerStats{}, err
+	}
+	m.mu.Lock()
+	s := ManagerStats{Stores: len(names), Open: m.numOpen}
+	m.mu.Unlock()
+	for _, name := range names {
+		n, err := diskUsage(filepath.Join(m.root, name))
+		if err != nil && !os.IsNotExist(err) {
+			return ManagerStats{}, err
+		}
+		s.Bytes += n
+	}
+	return s, nil
+}
+
+// Close closes all open stores and returns the first error. Close waits
+// for open transactions and iterators; afterwards all handles return
+// ErrDBClosed.
+func (m *Manager) Close() error {
+	m.mu.Lock()
+	m.closed = true
+	var open []DB
+	for _, s := range m.stores {
+		if s.db != nil {
+			open = append(open, s.db)
+			s.db = nil
+		}
+	}
+	m.numOpen = 0
+	m.mu.Unlock()
+
+	// the stores wait for their transactions without the lock, which
+	// their holders may need to finish
+	var err error
+	for _, db := range open {
+		if cerr := db.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,292 @@
+package backend
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestManager(t *testing.T) {
+	m, err := NewManager(t.TempDir(), BoltStores(time.Second))
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	defer m.Close()
+
+	if _, err = m.Get("tenant1"); err != ErrStoreNotFound {
+		t.Fatalf("get missing store: expected ErrStoreNotFound, got %v", err)
+	}
+	db, err := m.Create("tenant1")
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	if _, err = m.Create("tenant1"); err != ErrStoreExists {
+		t.Fatalf("create existing store: expected ErrStoreExists, got %v", err)
+	}
+	if db1, err := m.Get("tenant1"); err != nil || db1 != db {
+		t.Fatalf("get store: expected open handle, got %v, %v", db1, err)
+	}
+	if _, err = m.Open("tenant2"); err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	for _, name := range []string{"", ".archive", "a/b"} {
+		if _, err = m.Open(name); err != errInvalidStoreName {
+			t.Fatalf("open %q: expected errInvalidStoreName, got %v", name, err)
+		}
+	}
+
+	names, err := m.List()
+	if err != nil || !reflect.DeepEqual(names, []string{"tenant1", "tenant2"}) {
+		t.Fatalf("list: expected [tenant1 tenant2], got %v, %v", names, err)
+	}
+	s, err := m.Stats()
+	if err != nil || s.Stores != 2 || s.Open != 2 {
+		t.Fatalf("stats: expected 2 open stores, got %+v, %v", s, err)
+	}
+
+	if err = m.Drop("tenant1"); err != nil {
+		t.Fatalf("drop store: %v", err)
+	}
+	if err = m.Drop("tenant1"); err != ErrStoreNotFound {
+		t.Fatalf("drop missing store: expected ErrStoreNotFound, got %v", err)
+	}
+	if names, err = m.List(); err != nil || !reflect.DeepEqual(names, []string{"tenant2"}) {
+		t.Fatalf("list: expected [tenant2], got %v, %v", names, err)
+	}
+}
+
+type archiveBuffer struct{ bytes.Buffer }
+
+func (b *archiveBuffer) Close() error { return nil }
+
+func TestManagerArchive(t *testing.T) {
+	var events []string
+	m, err := NewManager(t.TempDir(), BoltStores(time.Second),
+		ProtectStores("prod"),
+		StoreEvents(func(e StoreEvent) { events = append(events, fmt.Sprintf("%s %s %v", e.Op, e.Name, e.Err)) }))
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	defer m.Close()
+
+	if _, err = m.Create("prod"); err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	if err = m.Drop("prod"); err != ErrStoreProtected {
+		t.Fatalf("drop protected store: expected ErrStoreProtected, got %v", err)
+	}
+
+	db, err := m.Create("tenant")
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	txn, err := db.Writable()
+	if err != nil {
+		t.Fatalf("begin writable transaction: %v", err)
+	}
+	if err = txn.Put([]byte("k"), []byte("v")); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if err = txn.Commit(); err != nil {
+		t.Fatalf("commit writable transaction: %v", err)
+	}
+
+	archive := &archiveBuffer{}
+	err = m.Archive("tenant", func(name string) (io.WriteCloser, error) {
+		// the Manager is not locked during the export
+		if _, err := m.Stats(); err != nil {
+			return nil, err
+		}
+		if _, err := db.Readonly(); err != ErrStoreInUse {
+			t.Fatalf("use archived store: expected ErrStoreInUse, got %v", err)
+		}
+		return archive, nil
+	})
+	if err != nil {
+		t.Fatalf("archive store: %v", err)
+	}
+	if _, err = m.Get("tenant"); err != ErrStoreNotFound {
+		t.Fatalf("get archived store: expected ErrStoreNotFound, got %v", err)
+	}
+
+	restored, err := m.Create("restored")
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	if err = Import(restored, archive); err != nil {
+		t.Fatalf("import archive: %v", err)
+	}
+	rtxn, err := restored.Readonly()
+	if err != nil {
+		t.Fatalf("begin read-only transaction: %v", err)
+	}
+	defer rtxn.Rollback()
+	if v, err := rtxn.Get([]byte("k")); err != nil || string(v) != "v" {
+		t.Fatalf("get restored key: expected %q, got %q, %v", "v", v, err)
+	}
+
+	expected := []string{
+		"create prod <nil>",
+		"drop prod store protected",
+		"create tenant <nil>",
+		"archive tenant <nil>",
+		"create restored <nil>",
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Fatalf("events: expected %q, got %q", expected, events)
+	}
+}
+
+func TestManagerEviction(t *testing.T) {
+	m, err := NewManager(t.TempDir(), BoltStores(time.Second), MaxOpenStores(2))
+	if err != nil {
+		t.Fatalf("new manager: %v", err)
+	}
+	open := func(expected int) {
+		t.Helper()
+		if s, err := m.Stats(); err != nil || s.Open != expected {
+			t.Fatalf("stats: expected %d open stores, got %+v, %v", expected, s, err)
+		}
+	}
+
+	a, err := m.Create("a")
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	txn, err := a.Readonly()
+	if err != nil {
+		t.Fatalf("begin read-only transaction: %v", err)
+	}
+	b, err := m.Create("b")
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	wtxn, err := b.Writable()
+	if err != nil {
+		t.Fatalf("begin writable transaction: %v", err)
+	}
+	if err = wtxn.Put([]byte("k"), []byte("v")); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if err = wtxn.Commit(); err != nil {
+		t.Fatalf("commit writable transaction: %v", err)
+	}
+
+	// a is in use, so b is closed
+	if _, err = m.Create("c"); err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	open(2)
+	if err = m.Drop("a"); err != ErrStoreInUse {
+		t.Fatalf("drop store in use: expected ErrStoreInUse, got %v", err)
+	}
+	if err = txn.Rollback(); err != nil {
+		t.Fatalf("rollback read-only transaction: %v", err)
+	}
+
+	// reopening b closes a, the least recently used store
+	if txn, err = b.Readonly(); err != nil {
+		t.Fatalf("begin read-only transaction: %v", err)
+	}
+	if v, err := txn.Get([]byte("k")); err != nil || string(v) != "v" {
+		t.Fatalf("get after reopen: expected %q, got %q, %v", "v", v, err)
+	}
+	txn.Rollback()
+	open(2)
+	if name := a.Name(); name != "a" {
+		t.Fatalf("name: expected a, got %q", name)
+	}
+	open(2)
+
+	// Close waits for the transaction without locking the Manager
+	if txn, err = b.Readonly(); err != nil {
+		t.Fatalf("begin read-only transaction: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- m.Close() }()
+	for {
+		atxn, err := a.Readonly()
+		if err == ErrDBClosed {
+			break
+		} else if err != nil {
+			t.Fatalf("use closing manager: expected ErrDBClosed, got %v", err)
+		}
+		atxn.Rollback()
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err = <-done:
+		t.Fatalf("close manager: returned before the transaction ended: %v", err)
+	default:
+	}
+	txn.Rollback()
+	if err = <-done; err != nil {
+		t.Fatalf("close manager: %v", err)
+	}
+	open(0)
+	if _, err = a.Readonly(); err != ErrDBClosed {
+		t.Fatalf("use closed manager: expected ErrDBClosed, got %v", err)
+	}
+}
+
+// wrappedDB hides the engine type from the Manager.
+type wrappedDB struct{ DB }
+
+func TestManagerClone(t *testing.T) {
+	openers := map[string]StoreOpener{
+		"file":   BoltStores(time.Second),
+		"export": func(path string) (DB, error) { db, err := OpenBoltDB(path, time.Second); return wrappedDB{db}, err },
+	}
+	for kind, open := range openers {
+		m, err := NewManager(t.TempDir(), open)
+		if err != nil {
+			t.Fatalf("%s: new manager: %v", kind, err)
+		}
+		src, err := m.Create("src")
+		if err != nil {
+			t.Fatalf("%s: create store: %v", kind, err)
+		}
+		txn, err := src.Writable()
+		if err != nil {
+			t.Fatalf("%s: begin writable transaction: %v", kind, err)
+		}
+		if err = txn.Put([]byte("k"), []byte("v")); err != nil {
+			t.Fatalf("%s: put: %v", kind, err)
+		}
+		if err = txn.Commit(); err != nil {
+			t.Fatalf("%s: commit writable transaction: %v", kind, err)
+		}
+
+		if err = m.Clone("src", "dst"); err != nil {
+			t.Fatalf("%s: clone: %v", kind, err)
+		}
+		if err = m.Clone("src", "dst"); err != ErrStoreExists {
+			t.Fatalf("%s: clone to existing store: expected ErrStoreExists, got %v", kind, err)
+		}
+		if err = m.Clone("none", "dst2"); err != ErrStoreNotFound {
+			t.Fatalf("%s: clone missing store: expected ErrStoreNotFound, got %v", kind, err)
+		}
+		if names, err := m.List(); err != nil || !reflect.DeepEqual(names, []string{"dst", "src"}) {
+			t.Fatalf("%s: list: expected [dst src], got %v, %v", kind, names, err)
+		}
+
+		dst, err := m.Get("dst")
+		if err != nil {
+			t.Fatalf("%s: get clone: %v", kind, err)
+		}
+		rtxn, err := dst.Readonly()
+		if err != nil {
+			t.Fatalf("%s: begin read-only transaction: %v", kind, err)
+		}
+		if v, err := rtxn.Get([]byte("k")); err != nil || string(v) != "v" {
+			t.Fatalf("%s: get cloned key: expected %q, got %q, %v", kind, "v", v, err)
+		}
+		rtxn.Rollback()
+		if err = m.Close(); err != nil {
+			t.Fatalf("%s: close manager: %v", kind, err)
+		}
+	}
+}
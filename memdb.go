@@ -0,0 +1,490 @@
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+)
+
+var _ DB = (*MemDB)(nil)
+
+// MemDB is a key/value store held in memory, e.g. for tests and
+// ephemeral data. Keys are kept in a persistent AVL tree: writes copy
+// the path from the root instead of modifying nodes, so iterators and
+// read-only transactions read a snapshot for free and never block
+// writers. Write transactions are granted in FIFO order like BoltDB.
+type MemDB struct {
+	mu     sync.Mutex // guards root
+	root   *memNode
+	writer fifoMutex
+	refs   refCount
+}
+
+// NewMemDB returns an empty in-memory database.
+func NewMemDB() *MemDB {
+	return &MemDB{}
+}
+
+// snapshot returns the current root holding a reference on the handle,
+// which must be released when the snapshot is no longer used.
+func (db *MemDB) snapshot() (*memNode, error) {
+	if err := db.refs.acquire(); err != nil {
+		return nil, err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.root, nil
+}
+
+func (db *MemDB) Iterator() (Iterator, error) {
+	root, err := db.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &memIterator{memCursor: memCursor{root: root}, db: db}, nil
+}
+
+func (db *MemDB) Readonly() (Txn, error) {
+	root, err := db.snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &memReadTxn{root: root, db: db}, nil
+}
+
+// Writable starts a new write transaction. Blocked callers are granted
+// the transaction in the order they called Writable.
+func (db *MemDB) Writable() (RWTxn, error) {
+	db.writer.Lock()
+	root, err := db.snapshot()
+	if err != nil {
+		db.writer.Unlock()
+		return nil, err
+	}
+	return &memTxn{memReadTxn: memReadTxn{root: root, db: db}}, nil
+}
+
+// WriteQueueDepth returns the number of callers blocked in Writable.
+func (db *MemDB) WriteQueueDepth() int {
+	return db.writer.waiting()
+}
+
+// WriteTo writes the entire database to w using the export format. See
+// Export for details.
+func (db *MemDB) WriteTo(w io.Writer) (int64, error) {
+	return Export(w, db)
+}
+
+func (db *MemDB) Name() string { return "MemDB" }
+
+// Close waits for open iterators and transactions and releases the
+// data.
+func (db *MemDB) Close() error {
+	if db == nil {
+		return errors.New("closing unopened MemDB instance")
+	}
+	if err := db.refs.close(); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	db.root = nil
+	db.mu.Unlock()
+	return nil
+}
+
+// memReadTxn is a read-only transaction on a snapshot. Its write methods
+// only exist to reject writes after a type assertion to RWTxn.
+type memReadTxn struct {
+	root *memNode
+	db   *MemDB // nil after the transaction ended
+}
+
+func (t *memReadTxn) Get(key []byte) ([]byte, error) {
+	if t == nil || t.db == nil {
+		return nil, errors.New("get from unopened transaction")
+	}
+	if n := t.root.get(key); n != nil {
+		return n.value, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (t *memReadTxn) Rollback() error {
+	if t == nil || t.db == nil {
+		return nil
+	}
+	t.db.refs.release()
+	t.db, t.root = nil, nil
+	return nil
+}
+
+func (t *memReadTxn) Put(key, value []byte) error { return ErrReadOnlyTxn }
+func (t *memReadTxn) Delete(key []byte) error     { return ErrReadOnlyTxn }
+func (t *memReadTxn) Commit() error               { return ErrReadOnlyTxn }
+
+// memTxn is a write transaction. It builds a new tree from the snapshot
+// it started on and publishes its root on Commit. It holds the writer
+// lock until it is committed or rolled back.
+type memTxn struct {
+	memReadTxn
+}
+
+func (t *memTxn) Put(key, value []byte) error {
+	if t == nil || t.db == nil {
+		return errors.New("put in unopened transaction")
+	}
+	// copy key and value into one allocation, the caller may reuse both
+	buf := make([]byte, len(key)+len(value))
+	copy(buf[copy(buf, key):], value)
+	t.root = t.root.put(buf[:len(key):len(key)], buf[len(key):])
+	return nil
+}
+
+func (t *memTxn) Delete(key []byte) error {
+	if t == nil || t.db == nil {
+		return errors.New("delete in unopened transaction")
+	}
+	t.root = t.root.delete(key)
+	return nil
+}
+
+func (t *memTxn) Rollback() error {
+	if t == nil || t.db == nil {
+		return nil
+	}
+	defer t.db.writer.Unlock()
+	return t.memReadTxn.Rollback()
+}
+
+func (t *memTxn) Commit() error {
+	if t == nil || t.db == nil {
+		return nil
+	}
+	t.db.mu.Lock()
+	t.db.root = t.root
+	t.db.mu.Unlock()
+	return t.Rollback()
+}
+
+// memIterator iterates over a snapshot of a MemDB.
+type memIterator struct {
+	memCursor
+	db *MemDB // nil after the iterator was closed
+}
+
+func (i *memIterator) Seek(key []byte) ([]byte, []byte) {
+	if i == nil || i.db == nil {
+		return nil, nil
+	}
+	return i.seek(key).pair()
+}
+
+func (i *memIterator) First() ([]byte, []byte) {
+	if i == nil || i.db == nil {
+		return nil, nil
+	}
+	return i.first().pair()
+}
+
+func (i *memIterator) Last() ([]byte, []byte) {
+	if i == nil || i.db == nil {
+		return nil, nil
+	}
+	return i.last().pair()
+}
+
+func (i *memIterator) Next() ([]byte, []byte) {
+	if i == nil || i.db == nil {
+		return nil, nil
+	}
+	return i.next().pair()
+}
+
+func (i *memIterator) Prev() ([]byte, []byte) {
+	if i == nil || i.db == nil {
+		return nil, nil
+	}
+	return i.prev().pair()
+}
+
+func (i *memIterator) SeekToIndex(n int64) ([]byte, []byte) {
+	if i == nil || i.db == nil {
+		return nil, nil
+	}
+	return i.index(n).pair()
+}
+
+// Reset moves the iterator to a new snapshot of the database. The
+// iterator is unpositioned afterwards.
+func (i *memIterator) Reset() error {
+	if i == nil || i.db == nil {
+		return errors.New("reset closed iterator")
+	}
+	i.db.mu.Lock()
+	i.reset(i.db.root)
+	i.db.mu.Unlock()
+	return nil
+}
+
+func (i *memIterator) Close() error {
+	if i == nil || i.db == nil {
+		return nil
+	}
+	i.db.refs.release()
+	i.db = nil
+	i.reset(nil)
+	return nil
+}
+
+// memCursor walks a memNode tree keeping the path from the root to the
+// current node. Its methods return the new current node, or nil if the
+// cursor moved past either end.
+type memCursor struct {
+	root *memNode
+	path []*memNode
+}
+
+func (c *memCursor) reset(root *memNode) {
+	c.root, c.path = root, c.path[:0]
+}
+
+func (c *memCursor) current() *memNode {
+	if len(c.path) == 0 {
+		return nil
+	}
+	return c.path[len(c.path)-1]
+}
+
+// seek moves to the first node with a key greater than or equal to key.
+func (c *memCursor) seek(key []byte) *memNode {
+	c.path = c.path[:0]
+	for n := c.root; n != nil; {
+		c.path = append(c.path, n)
+		cmp := bytes.Compare(key, n.key)
+		if cmp == 0 {
+			return n
+		} else if cmp < 0 {
+			n = n.left
+		} else {
+			n = n.right
+		}
+	}
+	// the search ends at the predecessor or successor of key
+	if n := c.current(); n != nil && bytes.Compare(n.key, key) < 0 {
+		return c.next()
+	}
+	return c.current()
+}
+
+// index moves to the node with n smaller keys in the tree.
+func (c *memCursor) index(n int64) *memNode {
+	c.path = c.path[:0]
+	if n < 0 || n >= c.root.len() {
+		return nil
+	}
+	for node := c.root; node != nil; {
+		c.path = append(c.path, node)
+		switch left := node.left.len(); {
+		case n < left:
+			node = node.left
+		case n == left:
+			return node
+		default:
+			n -= left + 1
+			node = node.right
+		}
+	}
+	return nil
+}
+
+func (c *memCursor) first() *memNode {
+	c.path = c.path[:0]
+	for n := c.root; n != nil; n = n.left {
+		c.path = append(c.path, n)
+	}
+	return c.current()
+}
+
+func (c *memCursor) last() *memNode {
+	c.path = c.path[:0]
+	for n := c.root; n != nil; n = n.right {
+		c.path = append(c.path, n)
+	}
+	return c.current()
+}
+
+func (c *memCursor) next() *memNode {
+	n := c.current()
+	if n == nil {
+		return nil
+	}
+	if n.right != nil {
+		for n = n.right; n != nil; n = n.left {
+			c.path = append(c.path, n)
+		}
+		return c.current()
+	}
+	// climb until we leave a left subtree
+	for len(c.path) > 1 {
+		child := c.path[len(c.path)-1]
+		c.path = c.path[:len(c.path)-1]
+		if c.path[len(c.path)-1].left == child {
+			return c.current()
+		}
+	}
+	c.path = c.path[:0]
+	return nil
+}
+
+func (c *memCursor) prev() *memNode {
+	n := c.current()
+	if n == nil {
+		return nil
+	}
+	if n.left != nil {
+		for n = n.left; n != nil; n = n.right {
+			c.path = append(c.path, n)
+		}
+		return c.current()
+	}
+	// climb until we leave a right subtree
+	for len(c.path) > 1 {
+		child := c.path[len(c.path)-1]
+		c.path = c.path[:len(c.path)-1]
+		if c.path[len(c.path)-1].right == child {
+			return c.current()
+		}
+	}
+	c.path = c.path[:0]
+	return nil
+}
+
+// memNode is an immutable node of a persistent AVL tree. Modifications
+// return a new root sharing all untouched subtrees with the old one.
+// Nodes count the keys of their subtree to address keys by index.
+type memNode struct {
+	key, value  []byte
+	left, right *memNode
+	height      int
+	size        int64
+}
+
+// pair returns the key and value of n, or nil if n is nil.
+func (n *memNode) pair() ([]byte, []byte) {
+	if n == nil {
+		return nil, nil
+	}
+	return n.key, n.value
+}
+
+func (n *memNode) get(key []byte) *memNode {
+	for n != nil {
+		c := bytes.Compare(key, n.key)
+		if c == 0 {
+			return n
+		} else if c < 0 {
+			n = n.left
+		} else {
+			n = n.right
+		}
+	}
+	return nil
+}
+
+func (n *memNode) put(key, value []byte) *memNode {
+	if n == nil {
+		return &memNode{key: key, value: value, height: 1, size: 1}
+	}
+	switch c := bytes.Compare(key, n.key); {
+	case c == 0:
+		return &memNode{key: key, value: value, left: n.left, right: n.right, height: n.height, size: n.size}
+	case c < 0:
+		return balance(n.key, n.value, n.left.put(key, value), n.right)
+	default:
+		return balance(n.key, n.value, n.left, n.right.put(key, value))
+	}
+}
+
+func (n *memNode) delete(key []byte) *memNode {
+	if n == nil {
+		return nil
+	}
+	switch c := bytes.Compare(key, n.key); {
+	case c < 0:
+		left := n.left.delete(key)
+		if left == n.left {
+			return n
+		}
+		return balance(n.key, n.value, left, n.right)
+	case c > 0:
+		right := n.right.delete(key)
+		if right == n.right {
+			return n
+		}
+		return balance(n.key, n.value, n.left, right)
+	}
+	if n.left == nil {
+		return n.right
+	} else if n.right == nil {
+		return n.left
+	}
+	// replace n by its successor
+	succ := n.right
+	for succ.left != nil {
+		succ = succ.left
+	}
+	return balance(succ.key, succ.value, n.left, n.right.delete(succ.key))
+}
+
+func (n *memNode) h() int {
+	if n == nil {
+		return 0
+	}
+	return n.height
+}
+
+func (n *memNode) len() int64 {
+	if n == nil {
+		return 0
+	}
+	return n.size
+}
+
+func newMemNode(key, value []byte, left, right *memNode) *memNode {
+	h := left.h()
+	if right.h() > h {
+		h = right.h()
+	}
+	return &memNode{key: key, value: value, left: left, right: right, height: h + 1,
+		size: left.len() + right.len() + 1}
+}
+
+// balance returns a new node for key and value with the given subtrees,
+// whose heights differ by at most two, rotating to restore the AVL
+// invariant.
+func balance(key, value []byte, left, right *memNode) *memNode {
+	switch d := left.h() - right.h(); {
+	case d > 1:
+		if left.left.h() < left.right.h() {
+			left = rotateLeft(left.key, left.value, left.left, left.right)
+		}
+		return newMemNode(left.key, left.value, left.left,
+			newMemNode(key, value, left.right, right))
+	case d < -1:
+		if right.right.h() < right.left.h() {
+			right = rotateRight(right.key, right.value, right.left, right.right)
+		}
+		return newMemNode(right.key, right.value,
+			newMemNode(key, value, left, right.left), right.right)
+	}
+	return newMemNode(key, value, left, right)
+}
+
+func rotateLeft(key, value []byte, left, right *memNode) *memNode {
+	return newMemNode(right.key, right.value, newMemNode(key, value, left, right.left), right.right)
+}
+
+func rotateRight(key, value []byte, left, right *memNode) *memNode {
+	return newMemNode(left.key, left.value, left.left, newMemNode(key, value, left.right, right))
+}
@@ -0,0 +1,112 @@
+package backend
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestMemDBModel(t *testing.T) {
+	db := NewMemDB()
+	defer db.Close()
+
+	rnd := rand.New(rand.NewSource(1))
+	model := make(map[string]string)
+	for round := 0; round < 50; round++ {
+		snap, err := db.Iterator()
+		if err != nil {
+			t.Fatalf("iterator: %v", err)
+		}
+		before := len(model)
+
+		txn, err := db.Writable()
+		if err != nil {
+			t.Fatalf("begin writable transaction: %v", err)
+		}
+		for i := 0; i < 40; i++ {
+			k := fmt.Sprintf("k%03d", rnd.Intn(300))
+			if rnd.Intn(3) == 0 {
+				delete(model, k)
+				err = txn.Delete([]byte(k))
+			} else {
+				model[k] = fmt.Sprint(round, i)
+				err = txn.Put([]byte(k), []byte(model[k]))
+			}
+			if err != nil {
+				t.Fatalf("write %q: %v", k, err)
+			}
+		}
+		if err = txn.Commit(); err != nil {
+			t.Fatalf("commit: %v", err)
+		}
+
+		// the iterator still reads the state before the commit
+		n := 0
+		for k, _ := snap.First(); k != nil; k, _ = snap.Next() {
+			n++
+		}
+		if n != before {
+			t.Fatalf("round %d: snapshot has %d keys, expected %d", round, n, before)
+		}
+		snap.Close()
+	}
+
+	keys := make([]string, 0, len(model))
+	for k := range model {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	iter, err := db.Iterator()
+	if err != nil {
+		t.Fatalf("iterator: %v", err)
+	}
+	defer iter.Close()
+	i := 0
+	for k, v := iter.First(); k != nil; k, v = iter.Next() {
+		if string(k) != keys[i] || string(v) != model[keys[i]] {
+			t.Fatalf("ascending: expected %q=%q, got %q=%q", keys[i], model[keys[i]], k, v)
+		}
+		i++
+	}
+	if i != len(keys) {
+		t.Fatalf("ascending: expected %d keys, got %d", len(keys), i)
+	}
+	i = len(keys) - 1
+	for k, _ := iter.Last(); k != nil; k, _ = iter.Prev() {
+		if string(k) != keys[i] {
+			t.Fatalf("descending: expected %q, got %q", keys[i], k)
+		}
+		i--
+	}
+	for j := 0; j < 300; j++ {
+		target := fmt.Sprintf("k%03d", j)
+		want := sort.SearchStrings(keys, target)
+		k, _ := iter.Seek([]byte(target))
+		if want == len(keys) && k != nil || want < len(keys) && string(k) != keys[want] {
+			t.Fatalf("seek %q: got %q", target, k)
+		}
+	}
+
+	// the tree stays balanced
+	var check func(n *memNode) int
+	check = func(n *memNode) int {
+		if n == nil {
+			return 0
+		}
+		l, r := check(n.left), check(n.right)
+		h := l
+		if r > h {
+			h = r
+		}
+		if l-r > 1 || r-l > 1 || n.height != h+1 {
+			t.Fatalf("unbalanced node %q: left %d, right %d, height %d", n.key, l, r, n.height)
+		}
+		if n.size != n.left.len()+n.right.len()+1 {
+			t.Fatalf("node %q: wrong subtree size %d", n.key, n.size)
+		}
+		return n.height
+	}
+	check(db.root)
+}
@@ -0,0 +1,204 @@
+package backend
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ReplicaError reports a write transaction a Mirror committed on the
+// primary but failed to apply to a replica. The replica misses the write
+// and must be resynchronized, e.g. by restoring a copy of the primary.
+type ReplicaError struct {
+	Replica int // index of the replica
+	Err     error
+}
+
+func (e *ReplicaError) Error() string {
+	return fmt.Sprintf("replica %d: %v", e.Replica, e.Err)
+}
+
+// Mirror wraps a primary DB and applies every write transaction committed
+// through it to a set of replicas, in commit order, e.g. to migrate a live
+// store to another backend or keep a redundant copy. Reads go to the
+// primary only. Writes that bypass the Mirror are not mirrored.
+//
+// Close waits for queued writes and closes the primary; the replicas are
+// owned by the caller.
+type Mirror struct {
+	DB
+
+	replicas []DB
+	order    sync.Mutex // keeps replicas in primary commit order
+	closed   bool       // guarded by order
+
+	queue chan []mirrorOp // nil if synchronous
+	done  chan struct{}
+
+	mu      sync.Mutex
+	applied *sync.Cond // signaled when queued transactions were applied
+	queued  uint64
+	written uint64
+	err     error // first failed asynchronous write
+}
+
+type mirrorOp struct {
+	key, value []byte
+	delete     bool
+}
+
+// NewMirror returns a Mirror applying the writes to the replicas before
+// Commit returns. Commit then returns a *ReplicaError if a replica
+// failed, although the primary committed.
+func NewMirror(primary DB, replicas ...DB) *Mirror {
+	return &Mirror{DB: primary, replicas: replicas}
+}
+
+// NewAsyncMirror returns a Mirror applying the writes to the replicas in
+// the background. Up to queue committed transactions wait to be applied,
+// further commits block. Failed writes are reported by Flush and Err.
+func NewAsyncMirror(primary DB, queue int, replicas ...DB) *Mirror {
+	m := &Mirror{
+		DB:       primary,
+		replicas: replicas,
+		queue:    make(chan []mirrorOp, queue),
+		done:     make(chan struct{}),
+	}
+	m.applied = sync.NewCond(&m.mu)
+	go m.run()
+	return m
+}
+
+func (m *Mirror) run() {
+	defer close(m.done)
+	for ops := range m.queue {
+		err := m.apply(ops)
+		m.mu.Lock()
+		if m.err == nil {
+			m.err = err
+		}
+		m.written++
+		m.applied.Broadcast()
+		m.mu.Unlock()
+	}
+}
+
+// apply writes ops to every replica and returns the first failure.
+func (m *Mirror) apply(ops []mirrorOp) error {
+	var first error
+	for i, replica := range m.replicas {
+		err := Update(replica, func(txn RWTxn) error {
+			for _, op := range ops {
+				var err error
+				if op.delete {
+					err = txn.Delete(op.key)
+				} else {
+					err = txn.Put(op.key, op.value)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil && first == nil {
+			first = &ReplicaError{Replica: i, Err: err}
+		}
+	}
+	return first
+}
+
+// Err returns the first failure of an asynchronous replica write, nil if
+// all writes were applied so far.
+func (m *Mirror) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+// Flush waits until the queued writes were applied and returns Err.
+func (m *Mirror) Flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for n := m.queued; m.written < n; {
+		m.applied.Wait()
+	}
+	return m.err
+}
+
+// Writable starts a new write transaction whose commit is mirrored.
+func (m *Mirror) Writable() (RWTxn, error) {
+	txn, err := m.DB.Writable()
+	if err != nil {
+		return nil, err
+	}
+	return &mirrorTxn{RWTxn: txn, m: m}, nil
+}
+
+// Close closes the primary, then waits for queued writes. Later commits
+// fail with ErrDBClosed. It returns the error of closing the primary or,
+// failing that, Err.
+func (m *Mirror) Close() error {
+	err := m.DB.Close()
+	m.order.Lock()
+	closed := m.closed
+	m.closed = true
+	if m.queue != nil && !closed {
+		close(m.queue)
+	}
+	m.order.Unlock()
+	if m.queue != nil && !closed {
+		<-m.done
+	}
+	if err != nil {
+		return err
+	}
+	return m.Err()
+}
+
+type mirrorTxn struct {
+	RWTxn
+	m   *Mirror
+	ops []mirrorOp
+}
+
+func (t *mirrorTxn) Put(key, value []byte) error {
+	if err := t.RWTxn.Put(key, value); err != nil {
+		return err
+	}
+	t.ops = append(t.ops, mirrorOp{key: append([]byte{}, key...), value: append([]byte{}, value...)})
+	return nil
+}
+
+func (t *mirrorTxn) Delete(key []byte) error {
+	if err := t.RWTxn.Delete(key); err != nil {
+		return err
+	}
+	t.ops = append(t.ops, mirrorOp{key: append([]byte{}, key...), delete: true})
+	return nil
+}
+
+func (t *mirrorTxn) Commit() error {
+	ops := t.ops
+	t.ops = nil
+	if len(ops) == 0 {
+		return t.RWTxn.Commit()
+	}
+	m := t.m
+	m.order.Lock()
+	defer m.order.Unlock()
+	if m.closed {
+		t.RWTxn.Rollback()
+		return ErrDBClosed
+	}
+	if err := t.RWTxn.Commit(); err != nil {
+		return err
+	}
+	if m.queue == nil {
+		return m.apply(ops)
+	}
+	m.mu.Lock()
+	m.queued++
+	m.mu.Unlock()
+	m.queue <- ops
+	return nil
+}
@@ -0,0 +1,109 @@
+package backend
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestMirror(t *testing.T) {
+	write := func(db DB, n int) error {
+		return Update(db, func(txn RWTxn) error {
+			if err := txn.Put([]byte(fmt.Sprintf("k%d", n)), []byte(fmt.Sprint(n))); err != nil {
+				return err
+			}
+			return txn.Delete([]byte(fmt.Sprintf("k%d", n-1)))
+		})
+	}
+	same := func(name string, primary DB, replicas ...DB) {
+		want, err := Scan(primary, nil)
+		if err != nil {
+			t.Fatalf("%s: scan primary: %v", name, err)
+		}
+		for i, replica := range replicas {
+			got, err := Scan(replica, nil)
+			if err != nil {
+				t.Fatalf("%s: scan replica %d: %v", name, i, err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("%s: replica %d: expected %q, got %q", name, i, want, got)
+			}
+		}
+	}
+
+	primary, replica := NewMemDB(), NewMemDB()
+	defer replica.Close()
+	m := NewMirror(primary, replica, NewReadOnlyView(replica))
+	err := write(m, 1)
+	if rerr, ok := err.(*ReplicaError); !ok || rerr.Replica != 1 || rerr.Err != ErrReadOnly {
+		t.Fatalf("commit to read-only replica: expected ReplicaError, got %v", err)
+	}
+	same("sync", primary, replica)
+	if err = m.Close(); err != nil {
+		t.Fatalf("sync: close: %v", err)
+	}
+
+	primary, replicas := NewMemDB(), []DB{NewMemDB(), NewMemDB()}
+	m = NewAsyncMirror(primary, 2, replicas...)
+	for i := 1; i <= 20; i++ {
+		if err = write(m, i); err != nil {
+			t.Fatalf("async: write %d: %v", i, err)
+		}
+	}
+	if err = m.Flush(); err != nil {
+		t.Fatalf("async: flush: %v", err)
+	}
+	same("async", primary, replicas...)
+	replicas[1].Close()
+	if err = write(m, 21); err != nil {
+		t.Fatalf("async: write to closed replica: %v", err)
+	}
+	if err = m.Flush(); err == nil || err.(*ReplicaError).Err != ErrDBClosed {
+		t.Fatalf("async: flush: expected ReplicaError, got %v", err)
+	}
+	same("async", primary, replicas[0])
+	if err = m.Close(); err != m.Err() {
+		t.Fatalf("async: close: expected %v, got %v", m.Err(), err)
+	}
+}
+
+func TestMirrorCloseWhileWriting(t *testing.T) {
+	replica := NewMemDB()
+	defer replica.Close()
+	m := NewAsyncMirror(NewMemDB(), 1, replica)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				err := Update(m, func(txn RWTxn) error {
+					return txn.Put([]byte(fmt.Sprintf("g%d-%d", g, i)), []byte{1})
+				})
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(g)
+	}
+	if err := m.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != ErrDBClosed {
+			t.Fatalf("write after close: expected ErrDBClosed, got %v", err)
+		}
+	}
+	if err := m.Close(); err != ErrDBClosed {
+		t.Fatalf("close twice: expected ErrDBClosed, got %v", err)
+	}
+}
@@ -0,0 +1,356 @@
+// Package mockdb implements a scriptable in-memory backend.DB for unit
+// tests. Without rules it behaves like a regular database. Rules make
+// individual operations return scripted values or errors, or take a given
+// time, so error branches can be tested without disk or cgo.
+//
+//	db := mockdb.New()
+//	db.On(mockdb.OpCommit, nil).Fail(errors.New("disk full")).Times(1)
+//	db.On(mockdb.OpGet, []byte("config")).Return([]byte("{}"))
+package mockdb
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mars9/backend"
+)
+
+var _ backend.DB = (*DB)(nil)
+
+// Op identifies a DB, transaction or iterator operation.
+type Op int
+
+// Operations rules can be attached to.
+const (
+	OpIterator Op = iota
+	OpReadonly
+	OpWritable
+	OpGet
+	OpPut
+	OpDelete
+	OpCommit
+	OpRollback
+	OpWriteTo
+	OpClose
+)
+
+var opNames = [...]string{
+	OpIterator: "Iterator",
+	OpReadonly: "Readonly",
+	OpWritable: "Writable",
+	OpGet:      "Get",
+	OpPut:      "Put",
+	OpDelete:   "Delete",
+	OpCommit:   "Commit",
+	OpRollback: "Rollback",
+	OpWriteTo:  "WriteTo",
+	OpClose:    "Close",
+}
+
+func (op Op) String() string {
+	if op < 0 || int(op) >= len(opNames) {
+		return "Unknown"
+	}
+	return opNames[op]
+}
+
+// Call is an operation executed on the DB.
+type Call struct {
+	Op  Op
+	Key []byte // nil for operations without a key
+	Err error  // error returned by the operation
+}
+
+// Rule scripts the behavior of matching operations. A rule matches an
+// operation if the operations are equal and the rule key is nil or
+// equal to the operation key.
+type Rule struct {
+	op    Op
+	key   []byte
+	value []byte
+	err   error
+	delay time.Duration
+	times int // remaining matches, -1 for unlimited
+}
+
+// Return makes a matching Get return value instead of the stored value.
+func (r *Rule) Return(value []byte) *Rule {
+	r.value = value
+	return r
+}
+
+// Fail makes matching operations return err without being executed.
+func (r *Rule) Fail(err error) *Rule {
+	r.err = err
+	return r
+}
+
+// Delay makes matching operations sleep for d before they are executed.
+func (r *Rule) Delay(d time.Duration) *Rule {
+	r.delay = d
+	return r
+}
+
+// Times limits the rule to the next n matching operations.
+func (r *Rule) Times(n int) *Rule {
+	r.times = n
+	return r
+}
+
+// DB is a mock database. Write transactions do not block each other and
+// are applied on commit; iterators see the data committed when they were
+// created.
+type DB struct {
+	mu    sync.Mutex
+	data  map[string][]byte
+	rules []*Rule
+	calls []Call
+}
+
+// New returns an empty mock database.
+func New() *DB {
+	return &DB{data: make(map[string][]byte)}
+}
+
+// On adds a rule for op on key, or on all keys if key is nil. Rules are
+// matched in the order they were added.
+func (db *DB) On(op Op, key []byte) *Rule {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	r := &Rule{op: op, times: -1}
+	if key != nil {
+		r.key = append([]byte{}, key...)
+	}
+	db.rules = append(db.rules, r)
+	return r
+}
+
+// Calls returns all operations executed so far.
+func (db *DB) Calls() []Call {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return append([]Call{}, db.calls...)
+}
+
+// match returns the rule applying to op on key and consumes one of its
+// matches, or nil if no rule applies.
+func (db *DB) match(op Op, key []byte) *Rule {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, r := range db.rules {
+		if r.op != op || r.times == 0 || (r.key != nil && !bytes.Equal(r.key, key)) {
+			continue
+		}
+		if r.times > 0 {
+			r.times--
+		}
+		return r
+	}
+	return nil
+}
+
+// call applies the rules for op on key and runs fn unless a rule fails
+// the operation or scripts its result.
+func (db *DB) call(op Op, key []byte, fn func() error) (*Rule, error) {
+	r := db.match(op, key)
+	if r != nil && r.delay > 0 {
+		time.Sleep(r.delay)
+	}
+	var err error
+	if r != nil && r.err != nil {
+		err = r.err
+	} else if (r == nil || r.value == nil) && fn != nil {
+		err = fn()
+	}
+
+	db.mu.Lock()
+	db.calls = append(db.calls, Call{Op: op, Key: append([]byte(nil), key...), Err: err})
+	db.mu.Unlock()
+	return r, err
+}
+
+func (db *DB) Iterator() (backend.Iterator, error) {
+	var iter *iterator
+	_, err := db.call(OpIterator, nil, func() error {
+		iter = db.snapshot()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return iter, nil
+}
+
+func (db *DB) Readonly() (backend.Txn, error) {
+	if _, err := db.call(OpReadonly, nil, nil); err != nil {
+		return nil, err
+	}
+	return &txn{db: db}, nil
+}
+
+func (db *DB) Writable() (backend.RWTxn, error) {
+	if _, err := db.call(OpWritable, nil, nil); err != nil {
+		return nil, err
+	}
+	return &txn{db: db, writes: make(map[string][]byte)}, nil
+}
+
+// WriteTo writes the database in the backend export format.
+func (db *DB) WriteTo(w io.Writer) (int64, error) {
+	var n int64
+	_, err := db.call(OpWriteTo, nil, func() (err error) {
+		n, err = backend.Export(w, db)
+		return err
+	})
+	return n, err
+}
+
+func (db *DB) Name() string { return "MockDB" }
+
+func (db *DB) Close() error {
+	_, err := db.call(OpClose, nil, nil)
+	return err
+}
+
+// snapshot returns an iterator over the current contents.
+func (db *DB) snapshot() *iterator {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	iter := &iterator{db: db, pos: -1}
+	for k, v := range db.data {
+		iter.pairs = append(iter.pairs, backend.KeyValue{Key: []byte(k), Value: v})
+	}
+	sort.Slice(iter.pairs, func(i, j int) bool {
+		return bytes.Compare(iter.pairs[i].Key, iter.pairs[j].Key) < 0
+	})
+	return iter
+}
+
+type txn struct {
+	db     *DB
+	writes map[string][]byte // nil value marks a deletion, nil map for read-only
+	closed bool
+}
+
+func (t *txn) Get(key []byte) ([]byte, error) {
+	var v []byte
+	r, err := t.db.call(OpGet, key, func() error {
+		var found bool
+		if v, found = t.writes[string(key)]; found {
+			if v == nil {
+				return backend.ErrNotFound
+			}
+			return nil
+		}
+		t.db.mu.Lock()
+		v, found = t.db.data[string(key)]
+		t.db.mu.Unlock()
+		if !found {
+			return backend.ErrNotFound
+		}
+		return nil
+	})
+	if err == nil && r != nil && r.value != nil {
+		return r.value, nil
+	}
+	return v, err
+}
+
+func (t *txn) Put(key, value []byte) error {
+	_, err := t.db.call(OpPut, key, func() error {
+		if t.writes == nil {
+			return backend.ErrReadOnlyTxn
+		}
+		t.writes[string(key)] = append([]byte{}, value...)
+		return nil
+	})
+	return err
+}
+
+func (t *txn) Delete(key []byte) error {
+	_, err := t.db.call(OpDelete, key, func() error {
+		if t.writes == nil {
+			return backend.ErrReadOnlyTxn
+		}
+		t.writes[string(key)] = nil
+		return nil
+	})
+	return err
+}
+
+func (t *txn) Commit() error {
+	_, err := t.db.call(OpCommit, nil, func() error {
+		if t.writes == nil {
+			return backend.ErrReadOnlyTxn
+		} else if t.closed {
+			return errors.New("commit closed transaction")
+		}
+		t.db.mu.Lock()
+		for k, v := range t.writes {
+			if v == nil {
+				delete(t.db.data, k)
+			} else {
+				t.db.data[k] = v
+			}
+		}
+		t.db.mu.Unlock()
+		return nil
+	})
+	t.closed = true
+	return err
+}
+
+func (t *txn) Rollback() error {
+	_, err := t.db.call(OpRollback, nil, func() error {
+		t.closed = true
+		return nil
+	})
+	return err
+}
+
+type iterator struct {
+	db    *DB
+	pairs []backend.KeyValue
+	pos   int
+}
+
+func (i *iterator) at(pos int) ([]byte, []byte) {
+	if pos < 0 {
+		i.pos = -1
+		return nil, nil
+	}
+	if pos >= len(i.pairs) {
+		i.pos = len(i.pairs)
+		return nil, nil
+	}
+	i.pos = pos
+	return i.pairs[pos].Key, i.pairs[pos].Value
+}
+
+func (i *iterator) Seek(key []byte) ([]byte, []byte) {
+	return i.at(sort.Search(len(i.pairs), func(n int) bool {
+		return bytes.Compare(i.pairs[n].Key, key) >= 0
+	}))
+}
+
+func (i *iterator) First() ([]byte, []byte) { return i.at(0) }
+func (i *iterator) Last() ([]byte, []byte)  { return i.at(len(i.pairs) - 1) }
+func (i *iterator) Next() ([]byte, []byte)  { return i.at(i.pos + 1) }
+func (i *iterator) Prev() ([]byte, []byte)  { return i.at(i.pos - 1) }
+func (i *iterator) Close() error            { return nil }
+
+func (i *iterator) Reset() error {
+	*i = *i.db.snapshot()
+	return nil
+}
+
+func (i *iterator) SeekToIndex(n int64) ([]byte, []byte) {
+	if n < 0 || n >= int64(len(i.pairs)) {
+		return i.at(len(i.pairs))
+	}
+	return i.at(int(n))
+}
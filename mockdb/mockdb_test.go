@@ -0,0 +1,64 @@
+package mockdb
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/mars9/backend"
+	"github.com/mars9/backend/backendtest"
+)
+
+func TestRules(t *testing.T) {
+	db := New()
+	errDiskFull := errors.New("disk full")
+	db.On(OpCommit, nil).Fail(errDiskFull).Times(1)
+	db.On(OpGet, []byte("config")).Return([]byte("{}"))
+
+	commit := func() error {
+		txn, err := db.Writable()
+		if err != nil {
+			t.Fatalf("begin writable transaction: %v", err)
+		}
+		if err = txn.Put([]byte("key"), []byte("value")); err != nil {
+			t.Fatalf("put: %v", err)
+		}
+		return txn.Commit()
+	}
+	if err := commit(); err != errDiskFull {
+		t.Fatalf("commit: expected scripted error, got %v", err)
+	}
+	if err := commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	txn, err := db.Readonly()
+	if err != nil {
+		t.Fatalf("begin read-only transaction: %v", err)
+	}
+	if v, err := txn.Get([]byte("config")); err != nil || !bytes.Equal(v, []byte("{}")) {
+		t.Fatalf("get: expected scripted value, got %q, %v", v, err)
+	}
+	if v, err := txn.Get([]byte("key")); err != nil || !bytes.Equal(v, []byte("value")) {
+		t.Fatalf("get: expected stored value, got %q, %v", v, err)
+	}
+
+	var failed int
+	for _, call := range db.Calls() {
+		if call.Err != nil {
+			failed++
+			if call.Op != OpCommit {
+				t.Fatalf("calls: unexpected failed %v", call.Op)
+			}
+		}
+	}
+	if failed != 1 {
+		t.Fatalf("calls: expected 1 failed call, got %d", failed)
+	}
+}
+
+func open(t testing.TB) backend.DB { return New() }
+
+func FuzzTxnOps(f *testing.F)        { backendtest.FuzzTxnOps(f, open) }
+func FuzzIteratorSeeks(f *testing.F) { backendtest.FuzzIteratorSeeks(f, open) }
+func FuzzRestore(f *testing.F)       { backendtest.FuzzRestore(f, open) }
@@ -0,0 +1,108 @@
+package backend
+
+import "bytes"
+
+// ErrKeyExists is returned by Move and MovePrefix if the target key
+// exists and overwriting was not requested.
+const ErrKeyExists Error = Error("key exists")
+
+// Move renames oldKey to newKey within txn. It returns ErrNotFound if
+// oldKey does not exist and, unless overwrite is true, ErrKeyExists if
+// newKey exists. Since the copy and the delete happen in the same
+// transaction, a Move is either fully applied on commit or not at all.
+func Move(txn RWTxn, oldKey, newKey []byte, overwrite bool) error {
+	v, err := txn.Get(oldKey)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(oldKey, newKey) {
+		return nil
+	}
+	if !overwrite {
+		if _, err = txn.Get(newKey); err == nil {
+			return ErrKeyExists
+		} else if err != ErrNotFound {
+			return err
+		}
+	}
+	// copy, the value may reference pages modified by the writes below
+	if err = txn.Put(newKey, append([]byte{}, v...)); err != nil {
+		return err
+	}
+	return txn.Delete(oldKey)
+}
+
+// MovePrefix renames all keys starting with oldPrefix to start with
+// newPrefix instead, in a single write transaction, and returns the
+// number of moved keys. If any key cannot be moved, e.g. because its
+// target exists and overwrite is false, nothing is moved.
+func MovePrefix(db DB, oldPrefix, newPrefix []byte, overwrite bool) (int, error) {
+	txn, err := db.Writable()
+	if err != nil {
+		return 0, err
+	}
+	// The keys are listed once the write transaction holds off other
+	// writers, so the listing matches what the transaction sees.
+	keys, err := listPrefix(db, oldPrefix)
+	if err != nil {
+		txn.Rollback()
+		return 0, err
+	}
+	// With overlapping prefixes a target may be a key that is moved
+	// itself; checking all targets up front keeps the result independent
+	// of the move order.
+	moving := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		moving[string(k)] = true
+	}
+	newKeys := make([][]byte, len(keys))
+	for i, k := range keys {
+		newKeys[i] = append(append([]byte{}, newPrefix...), k[len(oldPrefix):]...)
+		if overwrite || moving[string(newKeys[i])] {
+			continue
+		}
+		if _, err = txn.Get(newKeys[i]); err == nil {
+			txn.Rollback()
+			return 0, ErrKeyExists
+		} else if err != ErrNotFound {
+			txn.Rollback()
+			return 0, err
+		}
+	}
+
+	values := make([][]byte, len(keys))
+	for i, k := range keys {
+		v, err := txn.Get(k)
+		if err != nil {
+			txn.Rollback()
+			return 0, err
+		}
+		values[i] = append([]byte{}, v...)
+	}
+	for _, k := range keys {
+		if err = txn.Delete(k); err != nil {
+			txn.Rollback()
+			return 0, err
+		}
+	}
+	for i, k := range newKeys {
+		if err = txn.Put(k, values[i]); err != nil {
+			txn.Rollback()
+			return 0, err
+		}
+	}
+	return len(keys), txn.Commit()
+}
+
+// listPrefix returns copies of all keys in db starting with prefix.
+func listPrefix(db DB, prefix []byte) ([][]byte, error) {
+	iter, err := db.Iterator()
+	if err != nil {
+		return nil, err
+	}
+	var keys [][]byte
+	for k, _ := iter.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = iter.Next() {
+		keys = append(keys, append([]byte{}, k...))
+	}
+	return keys, iter.Close()
+}
@@ -0,0 +1,58 @@
+package backend
+
+import (
+	"bytes"
+	"sort"
+)
+
+// NamespacedKey is a key within a namespace. The namespace is a key
+// prefix: the stored key is Namespace followed by Key.
+type NamespacedKey struct {
+	Namespace []byte
+	Key       []byte
+}
+
+// Result is the outcome of a single lookup of GetMulti. Err is
+// ErrNotFound if the key does not exist.
+type Result struct {
+	Value []byte
+	Err   error
+}
+
+// GetMulti looks up all keys in a single read-only transaction, so all
+// values come from the same consistent view of the database. Lookups are
+// executed grouped by namespace and in key order to keep reads local.
+// Results are returned in the order of keys and hold copies of the
+// values. The returned error is only set if the transaction failed.
+func GetMulti(db DB, keys []NamespacedKey) ([]Result, error) {
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := keys[order[i]], keys[order[j]]
+		if c := bytes.Compare(a.Namespace, b.Namespace); c != 0 {
+			return c < 0
+		}
+		return bytes.Compare(a.Key, b.Key) < 0
+	})
+
+	txn, err := db.Readonly()
+	if err != nil {
+		return nil, err
+	}
+	defer txn.Rollback()
+
+	results := make([]Result, len(keys))
+	var key []byte
+	for _, i := range order {
+		key = append(append(key[:0], keys[i].Namespace...), keys[i].Key...)
+		v, err := txn.Get(key)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		results[i].Value = append([]byte{}, v...)
+	}
+	return results, txn.Rollback()
+}
@@ -0,0 +1,161 @@
+package backend
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// opNames maps trace operation codes to the names used by OpLog.
+var opNames = [...]string{
+	traceReadonly:    "readonly",
+	traceWritable:    "writable",
+	traceGet:         "get",
+	tracePut:         "put",
+	traceDelete:      "delete",
+	traceCommit:      "commit",
+	traceRollback:    "rollback",
+	traceIterator:    "iterator",
+	traceSeek:        "seek",
+	traceFirst:       "first",
+	traceLast:        "last",
+	traceNext:        "next",
+	tracePrev:        "prev",
+	traceReset:       "reset",
+	traceClose:       "close",
+	traceSeekToIndex: "seek-index",
+}
+
+// OpRecord describes an operation kept by an OpLog.
+type OpRecord struct {
+	Time    time.Time     // start of the operation
+	Op      string        // e.g. "get", "put" or "commit"
+	ID      uint64        // transaction or iterator
+	KeyHash uint64        // FNV-1a hash of the key, 0 without key
+	Size    int           // length of key and value
+	Latency time.Duration // duration of the operation
+	Err     string        // error returned, empty on success
+}
+
+func (r OpRecord) String() string {
+	s := fmt.Sprintf("%s %-10s id=%d key=%016x size=%d latency=%s",
+		r.Time.Format(time.RFC3339Nano), r.Op, r.ID, r.KeyHash, r.Size, r.Latency)
+	if r.Err != "" {
+		s += " err=" + r.Err
+	}
+	return s
+}
+
+// OpLog wraps a DB and keeps its last operations in a ring buffer for
+// post-mortem analysis, at a fraction of the cost of a trace: keys are
+// only kept as hashes and values not at all. The buffer is read with
+// Ops, served as text by ServeHTTP, e.g. below /debug/, and written by
+// DumpOnPanic when a goroutine panics.
+//
+// An OpLog is safe for concurrent use. Operations are kept in the order
+// they complete.
+type OpLog struct {
+	DB
+
+	mu     sync.Mutex
+	ring   []OpRecord
+	n      uint64 // number of operations recorded
+	nextID uint64
+}
+
+// NewOpLog returns an OpLog keeping the last n operations on db.
+func NewOpLog(db DB, n int) (*OpLog, error) {
+	if n <= 0 {
+		return nil, errors.New("op log size must be positive")
+	}
+	return &OpLog{DB: db, ring: make([]OpRecord, n)}, nil
+}
+
+func (l *OpLog) record(op byte, id uint64, start time.Time, err error, key, value []byte) {
+	rec := OpRecord{
+		Time:    start,
+		Op:      opNames[op],
+		ID:      id,
+		Size:    len(key) + len(value),
+		Latency: time.Since(start),
+	}
+	if key != nil {
+		h := fnv.New64a()
+		h.Write(key)
+		rec.KeyHash = h.Sum64()
+	}
+	if err != nil {
+		rec.Err = err.Error()
+	}
+
+	l.mu.Lock()
+	l.ring[l.n%uint64(len(l.ring))] = rec
+	l.n++
+	l.mu.Unlock()
+}
+
+func (l *OpLog) id() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.nextID++
+	return l.nextID
+}
+
+func (l *OpLog) Iterator() (Iterator, error) { return recordIterator(l, l.DB) }
+
+func (l *OpLog) Readonly() (Txn, error) { return recordReadonly(l, l.DB) }
+
+func (l *OpLog) Writable() (RWTxn, error) { return recordWritable(l, l.DB) }
+
+// Ops returns the kept operations, oldest first.
+func (l *OpLog) Ops() []OpRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	size := uint64(len(l.ring))
+	if l.n <= size {
+		return append([]OpRecord{}, l.ring[:l.n]...)
+	}
+	i := l.n % size
+	return append(append([]OpRecord{}, l.ring[i:]...), l.ring[:i]...)
+}
+
+// Dump writes the kept operations to w, one per line, oldest first.
+func (l *OpLog) Dump(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, rec := range l.Ops() {
+		bw.WriteString(rec.String())
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+// DumpOnPanic dumps the kept operations to w if the goroutine panics and
+// continues panicking. It must be deferred directly:
+//
+//	defer oplog.DumpOnPanic(os.Stderr)
+func (l *OpLog) DumpOnPanic(w io.Writer) {
+	if r := recover(); r != nil {
+		fmt.Fprintf(w, "panic: %v\nlast %s operations:\n", r, l.DB.Name())
+		l.Dump(w)
+		panic(r)
+	}
+}
+
+func (l *OpLog) diagnostics(b *bundle) error {
+	w, err := b.create("ops.txt")
+	if err != nil {
+		return err
+	}
+	return l.Dump(w)
+}
+
+// ServeHTTP writes the kept operations as plain text.
+func (l *OpLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	l.Dump(w)
+}
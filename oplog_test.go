@@ -0,0 +1,66 @@
+package backend
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOpLog(t *testing.T) {
+	db, err := NewOpLog(NewMemDB(), 4)
+	if err != nil {
+		t.Fatalf("new op log: %v", err)
+	}
+	defer db.Close()
+
+	if err = Update(db, func(txn RWTxn) error {
+		if err := txn.Put([]byte("a"), []byte("123")); err != nil {
+			return err
+		}
+		if _, err := txn.Get([]byte("b")); err != ErrNotFound {
+			t.Fatalf("get: expected ErrNotFound, got %v", err)
+		}
+		return txn.Delete([]byte("a"))
+	}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	txn, err := db.Readonly()
+	if err != nil {
+		t.Fatalf("readonly: %v", err)
+	}
+	txn.Rollback()
+
+	ops := db.Ops()
+	var names []string
+	for _, op := range ops {
+		names = append(names, op.Op)
+	}
+	// writable, put and get were pushed out of the ring
+	if got := strings.Join(names, ","); got != "delete,commit,readonly,rollback" {
+		t.Fatalf("ops: expected delete,commit,readonly,rollback, got %s", got)
+	}
+	if ops[0].KeyHash == 0 || ops[0].Size != 1 || ops[0].ID != ops[1].ID || ops[2].ID == ops[1].ID {
+		t.Fatalf("ops: unexpected records %+v", ops)
+	}
+
+	rec := httptest.NewRecorder()
+	db.ServeHTTP(rec, httptest.NewRequest("GET", "/debug/ops", nil))
+	if lines := strings.Count(rec.Body.String(), "\n"); lines != 4 {
+		t.Fatalf("serve: expected 4 lines, got %d\n%s", lines, rec.Body.String())
+	}
+
+	var buf bytes.Buffer
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Fatalf("dump on panic: expected panic to continue, recovered %v", r)
+			}
+		}()
+		defer db.DumpOnPanic(&buf)
+		panic("boom")
+	}()
+	if !strings.HasPrefix(buf.String(), "panic: boom\nlast MemDB operations:\n") || !strings.Contains(buf.String(), " rollback ") {
+		t.Fatalf("dump on panic: unexpected output\n%s", buf.String())
+	}
+}
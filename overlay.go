@@ -0,0 +1,169 @@
+package backend
+
+import (
+	"bytes"
+	"errors"
+)
+
+const (
+	overlayMaxHeight = 12
+	maxOverlaySlab   = 1<<32 - 1 // nodes store 32 bit offsets
+)
+
+var errTxnTooLarge = errors.New("transaction too large")
+
+// overlay is an ordered set of pending writes. Keys and values are not
+// copied: they live back to back in a caller owned slab and nodes refer
+// to them by offset, so the structure holds no pointers besides the two
+// slices and inserting a key allocates nothing but amortized slab and
+// node space.
+//
+// Nodes form a skip list. nodes[0] is the head sentinel, a next index of
+// 0 ends a list.
+type overlay struct {
+	slab   *[]byte
+	nodes  []overlayNode
+	height int
+	rnd    uint32
+}
+
+type overlayNode struct {
+	keyOff, keyLen uint32
+	valOff, valLen uint32
+	deleted        bool
+	next           [overlayMaxHeight]int32
+}
+
+func newOverlay(slab *[]byte) *overlay {
+	return &overlay{
+		slab:   slab,
+		nodes:  make([]overlayNode, 1),
+		height: 1,
+		rnd:    0x9e3779b9,
+	}
+}
+
+func (o *overlay) key(n int32) []byte {
+	nd := &o.nodes[n]
+	return (*o.slab)[nd.keyOff : nd.keyOff+nd.keyLen : nd.keyOff+nd.keyLen]
+}
+
+func (o *overlay) value(n int32) []byte {
+	nd := &o.nodes[n]
+	return (*o.slab)[nd.valOff : nd.valOff+nd.valLen : nd.valOff+nd.valLen]
+}
+
+// randomHeight returns a node height with a 1/4 chance of each further
+// level.
+func (o *overlay) randomHeight() int {
+	h := 1
+	for h < overlayMaxHeight {
+		// xorshift32
+		o.rnd ^= o.rnd << 13
+		o.rnd ^= o.rnd >> 17
+		o.rnd ^= o.rnd << 5
+		if o.rnd&3 != 0 {
+			break
+		}
+		h++
+	}
+	return h
+}
+
+// find returns the first node with a key greater than or equal to key and
+// stores the last node before it on every level in prev.
+func (o *overlay) find(key []byte, prev *[overlayMaxHeight]int32) int32 {
+	x := int32(0)
+	for level := o.height - 1; level >= 0; level-- {
+		for next := o.nodes[x].next[level]; next != 0 && bytes.Compare(o.key(next), key) < 0; next = o.nodes[x].next[level] {
+			x = next
+		}
+		if prev != nil {
+			prev[level] = x
+		}
+	}
+	return o.nodes[x].next[0]
+}
+
+// set records a put, or a deletion if deleted is true, of the key stored
+// at slab[keyOff:keyOff+keyLen] with the value stored at
+// slab[valOff:valOff+valLen]. A later set of an equal key replaces the
+// earlier one.
+func (o *overlay) set(keyOff, keyLen, valOff, valLen int, deleted bool) {
+	key := (*o.slab)[keyOff : keyOff+keyLen]
+	var prev [overlayMaxHeight]int32
+	if n := o.find(key, &prev); n != 0 && bytes.Equal(o.key(n), key) {
+		nd := &o.nodes[n]
+		nd.valOff, nd.valLen, nd.deleted = uint32(valOff), uint32(valLen), deleted
+		return
+	}
+
+	h := o.randomHeight()
+	if h > o.height {
+		for level := o.height; level < h; level++ {
+			prev[level] = 0
+		}
+		o.height = h
+	}
+	n := int32(len(o.nodes))
+	o.nodes = append(o.nodes, overlayNode{
+		keyOff:  uint32(keyOff),
+		keyLen:  uint32(keyLen),
+		valOff:  uint32(valOff),
+		valLen:  uint32(valLen),
+		deleted: deleted,
+	})
+	for level := 0; level < h; level++ {
+		o.nodes[n].next[level] = o.nodes[prev[level]].next[level]
+		o.nodes[prev[level]].next[level] = n
+	}
+}
+
+// get returns the pending value of key. found is false if key was not
+// written, deleted is true if its last write was a deletion.
+func (o *overlay) get(key []byte) (value []byte, deleted, found bool) {
+	n := o.find(key, nil)
+	if n == 0 || !bytes.Equal(o.key(n), key) {
+		return nil, false, false
+	}
+	if o.nodes[n].deleted {
+		return nil, true, true
+	}
+	return o.value(n), false, true
+}
+
+// len returns the number of distinct keys written.
+func (o *overlay) len() int { return len(o.nodes) - 1 }
+
+// seek returns the first node with a key greater than or equal to key, or
+// 0 if there is none.
+func (o *overlay) seek(key []byte) int32 { return o.find(key, nil) }
+
+// first returns the node with the smallest key, or 0 if empty.
+func (o *overlay) first() int32 { return o.nodes[0].next[0] }
+
+// next returns the node following n, or 0 at the end.
+func (o *overlay) next(n int32) int32 { return o.nodes[n].next[0] }
+
+// last returns the node with the largest key, or 0 if empty.
+func (o *overlay) last() int32 {
+	x := int32(0)
+	for level := o.height - 1; level >= 0; level-- {
+		for next := o.nodes[x].next[level]; next != 0; next = o.nodes[x].next[level] {
+			x = next
+		}
+	}
+	return x
+}
+
+// prev returns the node preceding n, or 0 at the beginning.
+func (o *overlay) prev(n int32) int32 {
+	key := o.key(n)
+	x := int32(0)
+	for level := o.height - 1; level >= 0; level-- {
+		for next := o.nodes[x].next[level]; next != 0 && bytes.Compare(o.key(next), key) < 0; next = o.nodes[x].next[level] {
+			x = next
+		}
+	}
+	return x
+}
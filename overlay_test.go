@@ -0,0 +1,69 @@
+package backend
+
+import (
+	"bytes"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestOverlay(t *testing.T) {
+	var slab []byte
+	o := newOverlay(&slab)
+	model := map[string]string{} // "" marks a deletion
+
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		key := []byte{byte('a' + rnd.Intn(26)), byte('a' + rnd.Intn(26))}
+		off := len(slab)
+		slab = append(slab, key...)
+		if rnd.Intn(4) == 0 {
+			o.set(off, len(key), off+len(key), 0, true)
+			model[string(key)] = ""
+			continue
+		}
+		value := []byte{'v', byte(i), byte(i >> 8)}
+		slab = append(slab, value...)
+		o.set(off, len(key), off+len(key), len(value), false)
+		model[string(key)] = string(value)
+	}
+
+	keys := make([]string, 0, len(model))
+	for k := range model {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if o.len() != len(keys) {
+		t.Fatalf("len: expected %d, got %d", len(keys), o.len())
+	}
+
+	i := 0
+	for n := o.first(); n != 0; n = o.next(n) {
+		if string(o.key(n)) != keys[i] {
+			t.Fatalf("next: expected key %q, got %q", keys[i], o.key(n))
+		}
+		v, deleted, found := o.get(o.key(n))
+		if !found || deleted != (model[keys[i]] == "") || (!deleted && string(v) != model[keys[i]]) {
+			t.Fatalf("get %q: unexpected %q, %v, %v", keys[i], v, deleted, found)
+		}
+		i++
+	}
+	if i != len(keys) {
+		t.Fatalf("next: expected %d keys, got %d", len(keys), i)
+	}
+
+	i = len(keys) - 1
+	for n := o.last(); n != 0; n = o.prev(n) {
+		if string(o.key(n)) != keys[i] {
+			t.Fatalf("prev: expected key %q, got %q", keys[i], o.key(n))
+		}
+		i--
+	}
+
+	if n := o.seek([]byte("m")); !bytes.Equal(o.key(n), []byte(keys[sort.SearchStrings(keys, "m")])) {
+		t.Fatalf("seek: unexpected key %q", o.key(n))
+	}
+	if _, _, found := o.get([]byte("zzz")); found {
+		t.Fatalf("get: unexpected missing key")
+	}
+}
@@ -0,0 +1,73 @@
+package backend
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// ErrInvalidJSON is returned by PatchJSON if the stored value or the
+// patch is not valid JSON.
+const ErrInvalidJSON Error = Error("invalid JSON value")
+
+// PatchJSON applies the RFC 7386 JSON merge patch to the JSON value stored
+// under key and stores the result in the same transaction. A missing key
+// is patched like an empty value: the result is the patch with all null
+// members removed.
+//
+// Members of the resulting object are written in key order and
+// insignificant whitespace is removed.
+func PatchJSON(txn RWTxn, key, patch []byte) error {
+	if !json.Valid(patch) {
+		return ErrInvalidJSON
+	}
+	target, err := txn.Get(key)
+	if err == ErrNotFound {
+		target = nil
+	} else if err != nil {
+		return err
+	} else if !json.Valid(target) {
+		return ErrInvalidJSON
+	}
+
+	value, err := mergePatch(target, patch)
+	if err != nil {
+		return err
+	}
+	return txn.Put(key, value)
+}
+
+// mergePatch implements the MergePatch function of RFC 7386. Both target
+// and patch must be valid JSON, target may be nil.
+func mergePatch(target, patch []byte) ([]byte, error) {
+	if !isJSONObject(patch) {
+		return patch, nil
+	}
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &members); err != nil {
+		return nil, err
+	}
+
+	result := map[string]json.RawMessage{}
+	if isJSONObject(target) {
+		if err := json.Unmarshal(target, &result); err != nil {
+			return nil, err
+		}
+	}
+	for name, value := range members {
+		if bytes.Equal(bytes.TrimSpace(value), []byte("null")) {
+			delete(result, name)
+			continue
+		}
+		merged, err := mergePatch(result[name], value)
+		if err != nil {
+			return nil, err
+		}
+		result[name] = merged
+	}
+	return json.Marshal(result)
+}
+
+func isJSONObject(b []byte) bool {
+	b = bytes.TrimSpace(b)
+	return len(b) > 0 && b[0] == '{'
+}
@@ -0,0 +1,262 @@
+// Package raftstore implements the hashicorp/raft LogStore and
+// StableStore interfaces on top of a backend.DB.
+package raftstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/mars9/backend"
+)
+
+var (
+	_ raft.LogStore    = (*Store)(nil)
+	_ raft.StableStore = (*Store)(nil)
+)
+
+// ErrKeyNotFound is returned by Get and GetUint64 if the key does not
+// exist. raft compares the error message, so it matches the error used
+// by raft-boltdb.
+var ErrKeyNotFound = errors.New("not found")
+
+var errCorruptLog = errors.New("raftstore: corrupt log entry")
+
+// Store stores raft log entries below prefix+"l", keyed by the big endian
+// log index so entries are ordered, and stable values below prefix+"s".
+type Store struct {
+	db     backend.DB
+	logs   []byte
+	stable []byte
+}
+
+// New returns a Store keeping its data below prefix in db.
+func New(db backend.DB, prefix []byte) *Store {
+	return &Store{
+		db:     db,
+		logs:   append(append([]byte{}, prefix...), 'l'),
+		stable: append(append([]byte{}, prefix...), 's'),
+	}
+}
+
+func (s *Store) logKey(index uint64) []byte {
+	key := make([]byte, len(s.logs)+8)
+	copy(key, s.logs)
+	binary.BigEndian.PutUint64(key[len(s.logs):], index)
+	return key
+}
+
+func (s *Store) stableKey(key []byte) []byte {
+	k := make([]byte, 0, len(s.stable)+len(key))
+	return append(append(k, s.stable...), key...)
+}
+
+// index returns the log index of key, or false if key is not a log key.
+func (s *Store) index(key []byte) (uint64, bool) {
+	if key == nil || len(key) != len(s.logs)+8 || !bytes.HasPrefix(key, s.logs) {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(key[len(s.logs):]), true
+}
+
+// FirstIndex returns the first index written, or 0 for no entries.
+func (s *Store) FirstIndex() (uint64, error) {
+	iter, err := s.db.Iterator()
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+
+	k, _ := iter.Seek(s.logs)
+	index, _ := s.index(k)
+	return index, iter.Close()
+}
+
+// LastIndex returns the last index written, or 0 for no entries.
+func (s *Store) LastIndex() (uint64, error) {
+	iter, err := s.db.Iterator()
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+
+	// position on the first key after all log keys and step back
+	limit := append([]byte{}, s.logs...)
+	limit[len(limit)-1]++
+	k, _ := iter.Seek(limit)
+	if k == nil {
+		k, _ = iter.Last()
+	} else {
+		k, _ = iter.Prev()
+	}
+	index, _ := s.index(k)
+	return index, iter.Close()
+}
+
+// GetLog gets the log entry at the given index. It returns
+// raft.ErrLogNotFound if the entry does not exist.
+func (s *Store) GetLog(index uint64, log *raft.Log) error {
+	txn, err := s.db.Readonly()
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+
+	v, err := txn.Get(s.logKey(index))
+	if err == backend.ErrNotFound {
+		return raft.ErrLogNotFound
+	} else if err != nil {
+		return err
+	}
+	return decodeLog(v, index, log)
+}
+
+// StoreLog stores a single log entry.
+func (s *Store) StoreLog(log *raft.Log) error {
+	return s.StoreLogs([]*raft.Log{log})
+}
+
+// StoreLogs stores multiple log entries in a single transaction.
+func (s *Store) StoreLogs(logs []*raft.Log) error {
+	txn, err := s.db.Writable()
+	if err != nil {
+		return err
+	}
+	for _, log := range logs {
+		if err = txn.Put(s.logKey(log.Index), encodeLog(log)); err != nil {
+			txn.Rollback()
+			return err
+		}
+	}
+	return txn.Commit()
+}
+
+// DeleteRange deletes all log entries from min to max, inclusive.
+func (s *Store) DeleteRange(min, max uint64) error {
+	iter, err := s.db.Iterator()
+	if err != nil {
+		return err
+	}
+	var keys [][]byte
+	for k, _ := iter.Seek(s.logKey(min)); k != nil; k, _ = iter.Next() {
+		index, ok := s.index(k)
+		if !ok || index > max {
+			break
+		}
+		keys = append(keys, append([]byte{}, k...))
+	}
+	if err = iter.Close(); err != nil {
+		return err
+	}
+
+	txn, err := s.db.Writable()
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err = txn.Delete(k); err != nil {
+			txn.Rollback()
+			return err
+		}
+	}
+	return txn.Commit()
+}
+
+// Set stores a stable key/value pair.
+func (s *Store) Set(key []byte, val []byte) error {
+	txn, err := s.db.Writable()
+	if err != nil {
+		return err
+	}
+	if err = txn.Put(s.stableKey(key), append([]byte{}, val...)); err != nil {
+		txn.Rollback()
+		return err
+	}
+	return txn.Commit()
+}
+
+// Get returns the value of a stable key, or ErrKeyNotFound.
+func (s *Store) Get(key []byte) ([]byte, error) {
+	txn, err := s.db.Readonly()
+	if err != nil {
+		return nil, err
+	}
+	defer txn.Rollback()
+
+	v, err := txn.Get(s.stableKey(key))
+	if err == backend.ErrNotFound {
+		return nil, ErrKeyNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return append([]byte{}, v...), nil
+}
+
+// SetUint64 stores a stable uint64 value.
+func (s *Store) SetUint64(key []byte, val uint64) error {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], val)
+	return s.Set(key, buf[:])
+}
+
+// GetUint64 returns a stable uint64 value, or ErrKeyNotFound.
+func (s *Store) GetUint64(key []byte) (uint64, error) {
+	v, err := s.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	if len(v) != 8 {
+		return 0, errors.New("raftstore: invalid uint64 value")
+	}
+	return binary.BigEndian.Uint64(v), nil
+}
+
+// Log entries are encoded as
+//
+//	term       uint64
+//	type       uint8
+//	appendedAt int64 (unix nanoseconds, 0 if unset)
+//	dlen       uvarint
+//	data       [dlen]byte
+//	extensions []byte (remainder)
+func encodeLog(log *raft.Log) []byte {
+	buf := make([]byte, 17, 17+binary.MaxVarintLen64+len(log.Data)+len(log.Extensions))
+	binary.BigEndian.PutUint64(buf[0:], log.Term)
+	buf[8] = byte(log.Type)
+	if !log.AppendedAt.IsZero() {
+		binary.BigEndian.PutUint64(buf[9:], uint64(log.AppendedAt.UnixNano()))
+	}
+	var n [binary.MaxVarintLen64]byte
+	buf = append(buf, n[:binary.PutUvarint(n[:], uint64(len(log.Data)))]...)
+	buf = append(buf, log.Data...)
+	return append(buf, log.Extensions...)
+}
+
+func decodeLog(buf []byte, index uint64, log *raft.Log) error {
+	if len(buf) < 17 {
+		return errCorruptLog
+	}
+	log.Index = index
+	log.Term = binary.BigEndian.Uint64(buf[0:])
+	log.Type = raft.LogType(buf[8])
+	log.AppendedAt = time.Time{}
+	if ns := int64(binary.BigEndian.Uint64(buf[9:])); ns != 0 {
+		log.AppendedAt = time.Unix(0, ns)
+	}
+	dlen, n := binary.Uvarint(buf[17:])
+	if n <= 0 || dlen > uint64(len(buf)-17-n) {
+		return errCorruptLog
+	}
+	buf = buf[17+n:]
+	log.Data = nil
+	if dlen > 0 {
+		log.Data = append([]byte{}, buf[:dlen]...)
+	}
+	log.Extensions = nil
+	if len(buf) > int(dlen) {
+		log.Extensions = append([]byte{}, buf[dlen:]...)
+	}
+	return nil
+}
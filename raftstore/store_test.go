@@ -0,0 +1,83 @@
+package raftstore
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/mars9/backend"
+)
+
+func TestStore(t *testing.T) {
+	const path = "raftstore_test.db"
+	db, err := backend.OpenBoltDB(path, 0)
+	if err != nil {
+		t.Fatalf("opening BoltDB %q: %v", path, err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(path)
+	}()
+
+	// surrounding keys must not be mistaken for log entries
+	txn, err := db.Writable()
+	if err != nil {
+		t.Fatalf("begin writable transaction: %v", err)
+	}
+	txn.Put([]byte("a"), []byte("before"))
+	txn.Put([]byte("z"), []byte("after"))
+	if err = txn.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	s := New(db, []byte("raft/"))
+	if idx, err := s.LastIndex(); err != nil || idx != 0 {
+		t.Fatalf("last index: expected 0, got %d (%v)", idx, err)
+	}
+
+	now := time.Unix(0, time.Now().UnixNano())
+	var logs []*raft.Log
+	for i := uint64(1); i <= 10; i++ {
+		logs = append(logs, &raft.Log{Index: i, Term: 1, Type: raft.LogCommand, Data: []byte{byte(i)}, AppendedAt: now})
+	}
+	if err = s.StoreLogs(logs); err != nil {
+		t.Fatalf("store logs: %v", err)
+	}
+
+	if idx, err := s.FirstIndex(); err != nil || idx != 1 {
+		t.Fatalf("first index: expected 1, got %d (%v)", idx, err)
+	}
+	if idx, err := s.LastIndex(); err != nil || idx != 10 {
+		t.Fatalf("last index: expected 10, got %d (%v)", idx, err)
+	}
+
+	var log raft.Log
+	if err = s.GetLog(5, &log); err != nil {
+		t.Fatalf("get log: %v", err)
+	}
+	if !reflect.DeepEqual(&log, logs[4]) {
+		t.Fatalf("get log: expected %+v, got %+v", logs[4], &log)
+	}
+
+	if err = s.DeleteRange(1, 3); err != nil {
+		t.Fatalf("delete range: %v", err)
+	}
+	if idx, err := s.FirstIndex(); err != nil || idx != 4 {
+		t.Fatalf("first index: expected 4, got %d (%v)", idx, err)
+	}
+	if err = s.GetLog(2, &log); err != raft.ErrLogNotFound {
+		t.Fatalf("get log: expected ErrLogNotFound, got %v", err)
+	}
+
+	if _, err = s.GetUint64([]byte("CurrentTerm")); err != ErrKeyNotFound {
+		t.Fatalf("get uint64: expected ErrKeyNotFound, got %v", err)
+	}
+	if err = s.SetUint64([]byte("CurrentTerm"), 42); err != nil {
+		t.Fatalf("set uint64: %v", err)
+	}
+	if v, err := s.GetUint64([]byte("CurrentTerm")); err != nil || v != 42 {
+		t.Fatalf("get uint64: expected 42, got %d (%v)", v, err)
+	}
+}
@@ -0,0 +1,157 @@
+package backend
+
+import (
+	"bytes"
+	"container/list"
+	"sync"
+)
+
+// KeyValue is a key/value pair.
+type KeyValue struct {
+	Key   []byte
+	Value []byte
+}
+
+// RangeCache wraps a DB and caches the results of prefix scans in
+// memory. Cached ranges are invalidated when a write transaction started
+// through the RangeCache commits a key inside the range. Writes that
+// bypass the RangeCache are not observed.
+type RangeCache struct {
+	DB
+
+	mu        sync.Mutex
+	ranges    map[string]*list.Element
+	lru       *list.List // front is most recently used
+	maxRanges int
+	gen       uint64 // incremented on every commit
+}
+
+type cachedRange struct {
+	prefix string
+	pairs  []KeyValue
+}
+
+// NewRangeCache returns a RangeCache that keeps at most maxRanges
+// prefixes in memory, evicting the least recently used one first.
+func NewRangeCache(db DB, maxRanges int) *RangeCache {
+	return &RangeCache{
+		DB:        db,
+		ranges:    make(map[string]*list.Element),
+		lru:       list.New(),
+		maxRanges: maxRanges,
+	}
+}
+
+// ScanPrefix returns all key/value pairs whose key starts with prefix in
+// ascending key order. The returned slices are shared with the cache and
+// must not be modified.
+func (c *RangeCache) ScanPrefix(prefix []byte) ([]KeyValue, error) {
+	c.mu.Lock()
+	if e, found := c.ranges[string(prefix)]; found {
+		c.lru.MoveToFront(e)
+		pairs := e.Value.(*cachedRange).pairs
+		c.mu.Unlock()
+		return pairs, nil
+	}
+	gen := c.gen
+	c.mu.Unlock()
+
+	iter, err := c.DB.Iterator()
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	pairs := []KeyValue{}
+	for k, v := iter.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = iter.Next() {
+		pairs = append(pairs, KeyValue{
+			Key:   append([]byte{}, k...),
+			Value: append([]byte{}, v...),
+		})
+	}
+	if err = iter.Close(); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// A commit during the scan may have invalidated the range already, do
+	// not cache what might be stale.
+	if gen == c.gen && c.maxRanges > 0 {
+		if _, found := c.ranges[string(prefix)]; !found {
+			c.ranges[string(prefix)] = c.lru.PushFront(&cachedRange{prefix: string(prefix), pairs: pairs})
+			if c.lru.Len() > c.maxRanges {
+				e := c.lru.Back()
+				c.lru.Remove(e)
+				delete(c.ranges, e.Value.(*cachedRange).prefix)
+			}
+		}
+	}
+	return pairs, nil
+}
+
+// Purge drops all cached ranges.
+func (c *RangeCache) Purge() {
+	c.mu.Lock()
+	c.gen++
+	c.ranges = make(map[string]*list.Element)
+	c.lru.Init()
+	c.mu.Unlock()
+}
+
+// Writable starts a new write transaction whose commit invalidates all
+// cached ranges containing a modified key.
+func (c *RangeCache) Writable() (RWTxn, error) {
+	txn, err := c.DB.Writable()
+	if err != nil {
+		return nil, err
+	}
+	return &rangeCacheTxn{RWTxn: txn, c: c}, nil
+}
+
+// invalidate drops all cached ranges containing one of keys.
+func (c *RangeCache) invalidate(keys [][]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	for prefix, e := range c.ranges {
+		for _, key := range keys {
+			if bytes.HasPrefix(key, []byte(prefix)) {
+				c.lru.Remove(e)
+				delete(c.ranges, prefix)
+				break
+			}
+		}
+	}
+}
+
+type rangeCacheTxn struct {
+	RWTxn
+	c    *RangeCache
+	keys [][]byte
+}
+
+func (t *rangeCacheTxn) Put(key, value []byte) error {
+	if err := t.RWTxn.Put(key, value); err != nil {
+		return err
+	}
+	t.keys = append(t.keys, append([]byte{}, key...))
+	return nil
+}
+
+func (t *rangeCacheTxn) Delete(key []byte) error {
+	if err := t.RWTxn.Delete(key); err != nil {
+		return err
+	}
+	t.keys = append(t.keys, append([]byte{}, key...))
+	return nil
+}
+
+func (t *rangeCacheTxn) Commit() error {
+	err := t.RWTxn.Commit()
+	if len(t.keys) > 0 {
+		t.c.invalidate(t.keys)
+		t.keys = nil
+	}
+	return err
+}
@@ -0,0 +1,100 @@
+package backend
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRangeCache(t *testing.T) {
+	const path = "rangecache_boltdb.db"
+	db := openBoltDB(t, path)
+	defer closeBoltDB(t, path, db)
+
+	c := NewRangeCache(db, 2)
+	put := func(key, value string) {
+		txn, err := c.Writable()
+		if err != nil {
+			t.Fatalf("begin writable transaction: %v", err)
+		}
+		if err = txn.Put([]byte(key), []byte(value)); err != nil {
+			t.Fatalf("put key %q: %v", key, err)
+		}
+		if err = txn.Commit(); err != nil {
+			t.Fatalf("commit writable transaction: %v", err)
+		}
+	}
+	scan := func(prefix string) []KeyValue {
+		pairs, err := c.ScanPrefix([]byte(prefix))
+		if err != nil {
+			t.Fatalf("scan prefix %q: %v", prefix, err)
+		}
+		return pairs
+	}
+
+	put("a/1", "x")
+	put("b/1", "y")
+	if pairs := scan("a/"); len(pairs) != 1 {
+		t.Fatalf("scan: expected 1 pair, got %d", len(pairs))
+	}
+	first := scan("b/")
+
+	// unrelated commit keeps the cached range
+	put("a/2", "z")
+	if pairs := scan("b/"); &pairs[0] != &first[0] {
+		t.Fatalf("scan: expected cached range for %q", "b/")
+	}
+	// commit inside the range invalidates it
+	if pairs := scan("a/"); len(pairs) != 2 {
+		t.Fatalf("scan: expected 2 pairs after commit, got %d", len(pairs))
+	}
+	put("b/2", "w")
+	if pairs := scan("b/"); len(pairs) != 2 {
+		t.Fatalf("scan: expected 2 pairs after commit, got %d", len(pairs))
+	}
+}
+
+func TestWarm(t *testing.T) {
+	const path = "warm_boltdb.db"
+	db := openBoltDB(t, path)
+	defer closeBoltDB(t, path, db)
+
+	txn, err := db.Writable()
+	if err != nil {
+		t.Fatalf("begin writable transaction: %v", err)
+	}
+	for i, key := range compatKeys {
+		if err = txn.Put(key, compatValues[i]); err != nil {
+			t.Fatalf("put key %q: %v", key, err)
+		}
+	}
+	if err = txn.Commit(); err != nil {
+		t.Fatalf("commit writable transaction: %v", err)
+	}
+
+	for _, warmed := range []DB{db, NewRangeCache(db, 4)} {
+		var reports []WarmProgress
+		prefixes := [][]byte{[]byte("key0"), []byte("key05"), []byte("none")}
+		if err = Warm(context.Background(), warmed, prefixes, func(p WarmProgress) {
+			reports = append(reports, p)
+		}); err != nil {
+			t.Fatalf("warm: %v", err)
+		}
+		if len(reports) != 3 {
+			t.Fatalf("warm: expected 3 reports, got %d", len(reports))
+		}
+		for i, keys := range []int64{100, 10, 0} {
+			if !reports[i].Done || reports[i].Keys != keys || reports[i].Bytes != 12*keys {
+				t.Fatalf("warm: unexpected report %+v", reports[i])
+			}
+		}
+		if c, ok := warmed.(*RangeCache); ok && c.lru.Len() != 3 {
+			t.Fatalf("warm: expected 3 cached ranges, got %d", c.lru.Len())
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = Warm(ctx, db, [][]byte{nil}, nil); err != context.Canceled {
+		t.Fatalf("warm: expected context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,560 @@
+// Package rdb imports Redis RDB dumps into a backend.DB.
+//
+// Strings and hashes are imported, other value types make Import fail.
+// A string key k of Redis database n is stored as
+//
+//	prefix + keys.AppendUint64(nil, n) + keys.AppendBytes(nil, k)
+//
+// and the field f of a hash k as that key followed by
+// keys.AppendBytes(nil, f), so all fields of a hash share a prefix.
+// Expired keys are skipped, expiry times of the other keys are dropped.
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/mars9/backend"
+	"github.com/mars9/backend/keys"
+)
+
+// ErrUnsupportedType is returned for values other than strings and
+// hashes.
+var ErrUnsupportedType = errors.New("rdb: unsupported value type")
+
+var errInvalidRDB = errors.New("rdb: invalid dump")
+
+// batchSize is the number of keys and hash fields written per
+// transaction.
+const batchSize = 1000
+
+// Opcodes and value types of the RDB format.
+const (
+	opModuleAux    = 0xF7
+	opIdle         = 0xF8
+	opFreq         = 0xF9
+	opAux          = 0xFA
+	opResizeDB     = 0xFB
+	opExpireTimeMS = 0xFC
+	opExpireTime   = 0xFD
+	opSelectDB     = 0xFE
+	opEOF          = 0xFF
+
+	typeString      = 0
+	typeHash        = 4
+	typeHashZiplist = 13
+	typeHashListpck = 16
+)
+
+// Import reads the RDB dump r and writes its strings and hashes below
+// prefix in dst. It returns the number of imported keys. Pairs are
+// written in batches of separate transactions; if an error occurs, the
+// batches written so far stay in dst.
+func Import(dst backend.DB, r io.Reader, prefix []byte) (int, error) {
+	d := &decoder{r: bufio.NewReader(r), now: time.Now()}
+	w := &writer{db: dst}
+	n, err := d.run(w, prefix)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = errInvalidRDB
+	}
+	if err != nil {
+		w.abort()
+		return n, err
+	}
+	return n, w.flush()
+}
+
+// writer writes pairs in batches.
+type writer struct {
+	db      backend.DB
+	txn     backend.RWTxn
+	pending int
+}
+
+func (w *writer) put(key, value []byte) error {
+	if w.txn == nil {
+		txn, err := w.db.Writable()
+		if err != nil {
+			return err
+		}
+		w.txn = txn
+	}
+	if err := w.txn.Put(key, value); err != nil {
+		return err
+	}
+	if w.pending++; w.pending == batchSize {
+		return w.flush()
+	}
+	return nil
+}
+
+func (w *writer) flush() error {
+	if w.txn == nil {
+		return nil
+	}
+	txn := w.txn
+	w.txn, w.pending = nil, 0
+	return txn.Commit()
+}
+
+func (w *writer) abort() {
+	if w.txn != nil {
+		w.txn.Rollback()
+		w.txn = nil
+	}
+}
+
+type decoder struct {
+	r   *bufio.Reader
+	now time.Time
+}
+
+func (d *decoder) run(w *writer, prefix []byte) (int, error) {
+	var magic [9]byte
+	if _, err := io.ReadFull(d.r, magic[:]); err != nil {
+		return 0, errInvalidRDB
+	}
+	if !bytes.HasPrefix(magic[:], []byte("REDIS")) {
+		return 0, errInvalidRDB
+	}
+	if _, err := strconv.Atoi(string(magic[5:])); err != nil {
+		return 0, errInvalidRDB
+	}
+
+	n := 0
+	db := uint64(0)
+	var expires time.Time
+	for {
+		op, err := d.r.ReadByte()
+		if err != nil {
+			return n, errInvalidRDB
+		}
+		switch op {
+		case opEOF:
+			// the trailing checksum is not verified
+			return n, nil
+		case opSelectDB:
+			if db, _, err = d.length(); err != nil {
+				return n, err
+			}
+		case opResizeDB:
+			if _, _, err = d.length(); err == nil {
+				_, _, err = d.length()
+			}
+		case opAux:
+			if _, err = d.string(); err == nil {
+				_, err = d.string()
+			}
+		case opExpireTime:
+			var b [4]byte
+			if _, err = io.ReadFull(d.r, b[:]); err == nil {
+				expires = time.Unix(int64(binary.LittleEndian.Uint32(b[:])), 0)
+			}
+		case opExpireTimeMS:
+			var b [8]byte
+			if _, err = io.ReadFull(d.r, b[:]); err == nil {
+				expires = time.UnixMilli(int64(binary.LittleEndian.Uint64(b[:])))
+			}
+		case opIdle:
+			_, _, err = d.length()
+		case opFreq:
+			_, err = d.r.ReadByte()
+		case opModuleAux:
+			return n, ErrUnsupportedType
+		default:
+			key, err := d.string()
+			if err != nil {
+				return n, err
+			}
+			base := keys.AppendBytes(keys.AppendUint64(append([]byte{}, prefix...), db), key)
+			live := expires.IsZero() || expires.After(d.now)
+			expires = time.Time{}
+			if err = d.value(op, func(field, value []byte) error {
+				if !live {
+					return nil
+				}
+				if field == nil {
+					return w.put(base, value)
+				}
+				return w.put(keys.AppendBytes(append([]byte{}, base...), field), value)
+			}); err != nil {
+				return n, err
+			}
+			if live {
+				n++
+			}
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// value decodes a value of type typ and calls put with a nil field for
+// strings and once per field for hashes.
+func (d *decoder) value(typ byte, put func(field, value []byte) error) error {
+	switch typ {
+	case typeString:
+		v, err := d.string()
+		if err != nil {
+			return err
+		}
+		return put(nil, v)
+	case typeHash:
+		n, _, err := d.length()
+		if err != nil {
+			return err
+		}
+		for ; n > 0; n-- {
+			field, err := d.string()
+			if err != nil {
+				return err
+			}
+			v, err := d.string()
+			if err != nil {
+				return err
+			}
+			if err = put(field, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	case typeHashZiplist, typeHashListpck:
+		b, err := d.string()
+		if err != nil {
+			return err
+		}
+		var entries [][]byte
+		if typ == typeHashZiplist {
+			entries, err = ziplist(b)
+		} else {
+			entries, err = listpack(b)
+		}
+		if err != nil {
+			return err
+		}
+		if len(entries)%2 != 0 {
+			return errInvalidRDB
+		}
+		for i := 0; i < len(entries); i += 2 {
+			if err = put(entries[i], entries[i+1]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return ErrUnsupportedType
+}
+
+// length decodes a length. If encoded is true, the low bits of n select
+// a special string encoding instead.
+func (d *decoder) length() (n uint64, encoded bool, err error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3F), false, nil
+	case 1:
+		b2, err := d.r.ReadByte()
+		return uint64(b&0x3F)<<8 | uint64(b2), false, err
+	case 3:
+		return uint64(b & 0x3F), true, nil
+	}
+	switch b {
+	case 0x80:
+		var buf [4]byte
+		_, err = io.ReadFull(d.r, buf[:])
+		return uint64(binary.BigEndian.Uint32(buf[:])), false, err
+	case 0x81:
+		var buf [8]byte
+		_, err = io.ReadFull(d.r, buf[:])
+		return binary.BigEndian.Uint64(buf[:]), false, err
+	}
+	return 0, false, errInvalidRDB
+}
+
+// string decodes a string, which may be stored as an integer or LZF
+// compressed.
+func (d *decoder) string() ([]byte, error) {
+	n, encoded, err := d.length()
+	if err != nil {
+		return nil, err
+	}
+	if !encoded {
+		return d.bytes(n)
+	}
+	switch n {
+	case 0, 1, 2:
+		b, err := d.bytes(1 << n)
+		if err != nil {
+			return nil, err
+		}
+		var v int64
+		switch n {
+		case 0:
+			v = int64(int8(b[0]))
+		case 1:
+			v = int64(int16(binary.LittleEndian.Uint16(b)))
+		case 2:
+			v = int64(int32(binary.LittleEndian.Uint32(b)))
+		}
+		return strconv.AppendInt(nil, v, 10), nil
+	case 3:
+		clen, _, err := d.length()
+		if err != nil {
+			return nil, err
+		}
+		ulen, _, err := d.length()
+		if err != nil {
+			return nil, err
+		}
+		c, err := d.bytes(clen)
+		if err != nil {
+			return nil, err
+		}
+		return lzfDecompress(c, ulen)
+	}
+	return nil, errInvalidRDB
+}
+
+// maxPrealloc limits the memory allocated up front for a string whose
+// length was read from the dump.
+const maxPrealloc = 1 << 20
+
+func (d *decoder) bytes(n uint64) ([]byte, error) {
+	if n <= maxPrealloc {
+		b := make([]byte, n)
+		_, err := io.ReadFull(d.r, b)
+		return b, err
+	}
+	var buf bytes.Buffer
+	m, err := io.CopyN(&buf, d.r, int64(n))
+	if err == nil && uint64(m) != n {
+		err = io.ErrUnexpectedEOF
+	}
+	return buf.Bytes(), err
+}
+
+func lzfDecompress(in []byte, n uint64) ([]byte, error) {
+	if n > 1<<32 {
+		return nil, errInvalidRDB
+	}
+	out := make([]byte, 0, n)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 32 {
+			// literal run
+			l := ctrl + 1
+			if i+l > len(in) {
+				return nil, errInvalidRDB
+			}
+			out = append(out, in[i:i+l]...)
+			i += l
+			continue
+		}
+		// back reference
+		l := ctrl >> 5
+		if l == 7 {
+			if i >= len(in) {
+				return nil, errInvalidRDB
+			}
+			l += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errInvalidRDB
+		}
+		ref := len(out) - (ctrl&0x1F)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, errInvalidRDB
+		}
+		for l += 2; l > 0; l-- {
+			out = append(out, out[ref])
+			ref++
+		}
+	}
+	if uint64(len(out)) != n {
+		return nil, errInvalidRDB
+	}
+	return out, nil
+}
+
+// ziplist returns the entries of a ziplist encoded value.
+func ziplist(b []byte) ([][]byte, error) {
+	if len(b) < 11 {
+		return nil, errInvalidRDB
+	}
+	b = b[10:]
+	var entries [][]byte
+	for {
+		if len(b) == 0 {
+			return nil, errInvalidRDB
+		}
+		if b[0] == 0xFF {
+			return entries, nil
+		}
+		// skip the length of the previous entry
+		if b[0] < 0xFE {
+			b = b[1:]
+		} else if len(b) >= 5 {
+			b = b[5:]
+		} else {
+			return nil, errInvalidRDB
+		}
+		if len(b) == 0 {
+			return nil, errInvalidRDB
+		}
+
+		enc := b[0]
+		var size, skip int
+		var v int64
+		isInt := true
+		switch {
+		case enc>>6 == 0:
+			size, skip, isInt = int(enc&0x3F), 1, false
+		case enc>>6 == 1:
+			if len(b) < 2 {
+				return nil, errInvalidRDB
+			}
+			size, skip, isInt = int(enc&0x3F)<<8|int(b[1]), 2, false
+		case enc == 0x80:
+			if len(b) < 5 {
+				return nil, errInvalidRDB
+			}
+			size, skip, isInt = int(binary.BigEndian.Uint32(b[1:])), 5, false
+		case enc == 0xC0:
+			size, skip = 2, 1
+		case enc == 0xD0:
+			size, skip = 4, 1
+		case enc == 0xE0:
+			size, skip = 8, 1
+		case enc == 0xF0:
+			size, skip = 3, 1
+		case enc == 0xFE:
+			size, skip = 1, 1
+		case enc >= 0xF1 && enc <= 0xFD:
+			v, skip = int64(enc&0x0F)-1, 1
+		default:
+			return nil, errInvalidRDB
+		}
+		if size < 0 || len(b) < skip+size {
+			return nil, errInvalidRDB
+		}
+		data := b[skip : skip+size]
+		b = b[skip+size:]
+		if !isInt {
+			entries = append(entries, data)
+			continue
+		}
+		switch size {
+		case 1:
+			v = int64(int8(data[0]))
+		case 2:
+			v = int64(int16(binary.LittleEndian.Uint16(data)))
+		case 3:
+			v = int64(int32(uint32(data[0])<<8|uint32(data[1])<<16|uint32(data[2])<<24) >> 8)
+		case 4:
+			v = int64(int32(binary.LittleEndian.Uint32(data)))
+		case 8:
+			v = int64(binary.LittleEndian.Uint64(data))
+		}
+		entries = append(entries, strconv.AppendInt(nil, v, 10))
+	}
+}
+
+// listpack returns the entries of a listpack encoded value.
+func listpack(b []byte) ([][]byte, error) {
+	if len(b) < 7 {
+		return nil, errInvalidRDB
+	}
+	b = b[6:]
+	var entries [][]byte
+	for {
+		if len(b) == 0 {
+			return nil, errInvalidRDB
+		}
+		enc := b[0]
+		if enc == 0xFF {
+			return entries, nil
+		}
+
+		var size, skip int
+		var v int64
+		isInt := true
+		switch {
+		case enc>>7 == 0:
+			v, skip = int64(enc&0x7F), 1
+		case enc>>6 == 2:
+			size, skip, isInt = int(enc&0x3F), 1, false
+		case enc>>5 == 6:
+			if len(b) < 2 {
+				return nil, errInvalidRDB
+			}
+			v, skip = int64(uint16(enc&0x1F)<<8|uint16(b[1])), 2
+			if v >= 1<<12 {
+				v -= 1 << 13
+			}
+		case enc>>4 == 0xE:
+			if len(b) < 2 {
+				return nil, errInvalidRDB
+			}
+			size, skip, isInt = int(enc&0x0F)<<8|int(b[1]), 2, false
+		case enc == 0xF0:
+			if len(b) < 5 {
+				return nil, errInvalidRDB
+			}
+			size, skip, isInt = int(binary.LittleEndian.Uint32(b[1:])), 5, false
+		case enc >= 0xF1 && enc <= 0xF4:
+			size = [...]int{2, 3, 4, 8}[enc-0xF1]
+			skip = 1
+		default:
+			return nil, errInvalidRDB
+		}
+		if size < 0 || len(b) < skip+size {
+			return nil, errInvalidRDB
+		}
+		data := b[skip : skip+size]
+		if isInt && size > 0 {
+			var u uint64
+			for i := size - 1; i >= 0; i-- {
+				u = u<<8 | uint64(data[i])
+			}
+			// sign extend
+			shift := 64 - 8*uint(size)
+			v = int64(u<<shift) >> shift
+		}
+		if isInt {
+			entries = append(entries, strconv.AppendInt(nil, v, 10))
+		} else {
+			entries = append(entries, data)
+		}
+
+		// skip the entry and its back length
+		l := skip + size
+		b = b[l:]
+		back := 5
+		switch {
+		case l <= 127:
+			back = 1
+		case l < 16383:
+			back = 2
+		case l < 2097151:
+			back = 3
+		case l < 268435455:
+			back = 4
+		}
+		if len(b) < back {
+			return nil, errInvalidRDB
+		}
+		b = b[back:]
+	}
+}
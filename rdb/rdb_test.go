@@ -0,0 +1,133 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mars9/backend"
+	"github.com/mars9/backend/keys"
+)
+
+// rdbString encodes a plain RDB string of less than 64 bytes.
+func rdbString(s string) []byte { return append([]byte{byte(len(s))}, s...) }
+
+func dump() []byte {
+	var b bytes.Buffer
+	b.WriteString("REDIS0011")
+	b.WriteByte(opAux)
+	b.Write(rdbString("redis-ver"))
+	b.Write(rdbString("7.2.0"))
+	b.Write([]byte{opSelectDB, 0, opResizeDB, 6, 1})
+
+	b.WriteByte(typeString)
+	b.Write(rdbString("name"))
+	b.Write(rdbString("redis"))
+
+	// int16 encoded string
+	b.WriteByte(typeString)
+	b.Write(rdbString("count"))
+	b.Write([]byte{0xC1, 0x39, 0x30})
+
+	// LZF compressed "aaaaaaaaaa"
+	b.WriteByte(typeString)
+	b.Write(rdbString("lzf"))
+	b.Write([]byte{0xC3, 5, 10, 0x00, 'a', 0xE0, 0x00, 0x00})
+
+	// expired key
+	b.WriteByte(opExpireTimeMS)
+	binary.Write(&b, binary.LittleEndian, uint64(time.Now().Add(-time.Hour).UnixMilli()))
+	b.WriteByte(typeString)
+	b.Write(rdbString("expired"))
+	b.Write(rdbString("x"))
+
+	b.WriteByte(typeHash)
+	b.Write(rdbString("user:1"))
+	b.WriteByte(2)
+	for _, s := range []string{"name", "alice", "age", "30"} {
+		b.Write(rdbString(s))
+	}
+
+	// listpack: "city" => "berlin", "zip" => 10115
+	lp := []byte{0, 0, 0, 0, 4, 0}
+	lp = append(lp, 0x84, 'c', 'i', 't', 'y', 5)
+	lp = append(lp, 0x86, 'b', 'e', 'r', 'l', 'i', 'n', 7)
+	lp = append(lp, 0x83, 'z', 'i', 'p', 4)
+	lp = append(lp, 0xF1, 0x83, 0x27, 3)
+	lp = append(lp, 0xFF)
+	b.WriteByte(typeHashListpck)
+	b.Write(rdbString("user:2"))
+	b.Write(rdbString(string(lp)))
+
+	// ziplist: "n" => -2 (int8), "m" => 5 (immediate)
+	zl := make([]byte, 10)
+	zl = append(zl, 0, 0x01, 'n', 3, 0xFE, 0xFE, 2, 0x01, 'm', 3, 0xF6, 0xFF)
+	b.Write([]byte{opSelectDB, 1})
+	b.WriteByte(typeHashZiplist)
+	b.Write(rdbString("user:3"))
+	b.Write(rdbString(string(zl)))
+
+	b.WriteByte(opEOF)
+	b.Write(make([]byte, 8))
+	return b.Bytes()
+}
+
+func TestImport(t *testing.T) {
+	const path = "rdb_test.db"
+	db, err := backend.OpenBoltDB(path, 0)
+	if err != nil {
+		t.Fatalf("opening BoltDB %q: %v", path, err)
+	}
+	defer os.Remove(path)
+	defer db.Close()
+
+	n, err := Import(db, bytes.NewReader(dump()), []byte("redis/"))
+	if err != nil || n != 6 {
+		t.Fatalf("import: expected 6 keys, got %d, %v", n, err)
+	}
+
+	key := func(db uint64, k string, field ...string) []byte {
+		key := keys.AppendBytes(keys.AppendUint64([]byte("redis/"), db), []byte(k))
+		for _, f := range field {
+			key = keys.AppendBytes(key, []byte(f))
+		}
+		return key
+	}
+	expected := []backend.KeyValue{
+		{Key: key(0, "count"), Value: []byte("12345")},
+		{Key: key(0, "lzf"), Value: []byte("aaaaaaaaaa")},
+		{Key: key(0, "name"), Value: []byte("redis")},
+		{Key: key(0, "user:1", "age"), Value: []byte("30")},
+		{Key: key(0, "user:1", "name"), Value: []byte("alice")},
+		{Key: key(0, "user:2", "city"), Value: []byte("berlin")},
+		{Key: key(0, "user:2", "zip"), Value: []byte("10115")},
+		{Key: key(1, "user:3", "m"), Value: []byte("5")},
+		{Key: key(1, "user:3", "n"), Value: []byte("-2")},
+	}
+	pairs, err := backend.Scan(db, []byte("redis/"))
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if len(pairs) != len(expected) {
+		t.Fatalf("import: expected %d pairs, got %d", len(expected), len(pairs))
+	}
+	for i, p := range pairs {
+		if !bytes.Equal(p.Key, expected[i].Key) || !bytes.Equal(p.Value, expected[i].Value) {
+			t.Fatalf("import: expected %q => %q, got %q => %q", expected[i].Key, expected[i].Value, p.Key, p.Value)
+		}
+	}
+
+	d := dump()
+	for _, invalid := range [][]byte{nil, []byte("REDIS"), d[:len(d)-20]} {
+		if _, err = Import(db, bytes.NewReader(invalid), nil); err != errInvalidRDB {
+			t.Fatalf("import invalid dump: expected errInvalidRDB, got %v", err)
+		}
+	}
+	set := append([]byte("REDIS0011"), 2)
+	set = append(set, rdbString("set")...)
+	if _, err = Import(db, bytes.NewReader(set), nil); err != ErrUnsupportedType {
+		t.Fatalf("import set: expected ErrUnsupportedType, got %v", err)
+	}
+}
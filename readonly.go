@@ -0,0 +1,46 @@
+package backend
+
+import "io"
+
+// NewReadOnlyView returns a view of db that can only be read. Writable
+// returns ErrReadOnly and read transactions reject writes with
+// ErrReadOnlyTxn, even after a type assertion to RWTxn. Closing the view
+// does not close db.
+func NewReadOnlyView(db DB) DB {
+	return readOnlyView{db: db}
+}
+
+// NewReadonly is NewReadOnlyView, named after the Readonly method that
+// starts read transactions.
+func NewReadonly(db DB) DB { return NewReadOnlyView(db) }
+
+type readOnlyView struct {
+	db DB
+}
+
+func (v readOnlyView) Iterator() (Iterator, error) { return v.db.Iterator() }
+
+func (v readOnlyView) Readonly() (Txn, error) {
+	txn, err := v.db.Readonly()
+	if err != nil {
+		return nil, err
+	}
+	return readOnly{txn}, nil
+}
+
+// Writable returns ErrReadOnly.
+func (v readOnlyView) Writable() (RWTxn, error) { return nil, ErrReadOnly }
+
+func (v readOnlyView) WriteTo(w io.Writer) (int64, error) { return v.db.WriteTo(w) }
+
+func (v readOnlyView) Name() string { return v.db.Name() }
+
+// Close is a no-op, the view does not own the database.
+func (v readOnlyView) Close() error { return nil }
+
+// readOnly adds write methods rejecting writes to a read-only Txn.
+type readOnly struct{ Txn }
+
+func (readOnly) Put(key, value []byte) error { return ErrReadOnlyTxn }
+func (readOnly) Delete(key []byte) error     { return ErrReadOnlyTxn }
+func (readOnly) Commit() error               { return ErrReadOnlyTxn }
@@ -0,0 +1,61 @@
+package backend
+
+import "sync"
+
+// refCount tracks the open iterators and transactions of a database
+// handle, so Close can wait for them instead of freeing resources still
+// in use. After close, acquire fails with ErrDBClosed.
+type refCount struct {
+	mu     sync.Mutex
+	idle   *sync.Cond // signaled when n drops to zero
+	n      int
+	closed bool
+}
+
+func (r *refCount) acquire() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return ErrDBClosed
+	}
+	r.n++
+	return nil
+}
+
+// acquireIdle acquires a reference only if no other one is held and
+// reports whether it did.
+func (r *refCount) acquireIdle() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed || r.n > 0 {
+		return false
+	}
+	r.n++
+	return true
+}
+
+func (r *refCount) release() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.n--; r.n == 0 && r.idle != nil {
+		r.idle.Broadcast()
+	}
+}
+
+// close rejects all further acquires and blocks until all references are
+// released. It returns ErrDBClosed if close was called before.
+func (r *refCount) close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return ErrDBClosed
+	}
+	r.closed = true
+	if r.idle == nil {
+		r.idle = sync.NewCond(&r.mu)
+	}
+	for r.n > 0 {
+		r.idle.Wait()
+	}
+	return nil
+}
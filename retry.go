@@ -0,0 +1,92 @@
+package backend
+
+import "time"
+
+// RetryPolicy retries transactions that fail with a transient error. The
+// zero value runs a transaction once.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times a transaction is run,
+	// values below 1 mean a single attempt.
+	MaxAttempts int
+
+	// MinBackoff is the delay before the first retry. It doubles for every
+	// further retry up to MaxBackoff, if MaxBackoff is not zero.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Retryable reports whether err is transient. If nil, errors with a
+	// Temporary method returning true are retried.
+	Retryable func(err error) bool
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	t, ok := err.(interface{ Temporary() bool })
+	return ok && t.Temporary()
+}
+
+// run calls fn until it succeeds, fails with a permanent error or the
+// maximum number of attempts is reached.
+func (p *RetryPolicy) run(fn func() error) error {
+	backoff := p.MinBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.MaxAttempts || !p.retryable(err) {
+			return err
+		}
+		time.Sleep(backoff)
+		if backoff *= 2; p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
+
+// Update runs fn in a write transaction and commits it if fn returns nil,
+// otherwise the transaction is rolled back. If fn or the commit fails
+// with a retryable error, the whole transaction is run again, so fn must
+// not have side effects outside the transaction. A nil policy runs the
+// transaction once.
+func (p *RetryPolicy) Update(db DB, fn func(txn RWTxn) error) error {
+	if p == nil {
+		p = &RetryPolicy{}
+	}
+	return p.run(func() error {
+		txn, err := db.Writable()
+		if err != nil {
+			return err
+		}
+		if err = fn(txn); err != nil {
+			txn.Rollback()
+			return err
+		}
+		return txn.Commit()
+	})
+}
+
+// View runs fn in a read-only transaction, retrying like Update.
+func (p *RetryPolicy) View(db DB, fn func(txn Txn) error) error {
+	if p == nil {
+		p = &RetryPolicy{}
+	}
+	return p.run(func() error {
+		txn, err := db.Readonly()
+		if err != nil {
+			return err
+		}
+		defer txn.Rollback()
+		return fn(txn)
+	})
+}
+
+// Update runs fn in a write transaction without retries. See
+// RetryPolicy.Update.
+func Update(db DB, fn func(txn RWTxn) error) error {
+	return (*RetryPolicy)(nil).Update(db, fn)
+}
+
+// View runs fn in a read-only transaction without retries.
+func View(db DB, fn func(txn Txn) error) error {
+	return (*RetryPolicy)(nil).View(db, fn)
+}
@@ -0,0 +1,60 @@
+package backend_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mars9/backend"
+	"github.com/mars9/backend/mockdb"
+)
+
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "temporary" }
+func (temporaryError) Temporary() bool { return true }
+
+func TestRetryPolicy(t *testing.T) {
+	db := mockdb.New()
+	db.On(mockdb.OpCommit, nil).Fail(temporaryError{}).Times(2)
+
+	p := &backend.RetryPolicy{MaxAttempts: 3}
+	runs := 0
+	err := p.Update(db, func(txn backend.RWTxn) error {
+		runs++
+		return txn.Put([]byte("key"), []byte("value"))
+	})
+	if err != nil || runs != 3 {
+		t.Fatalf("update: expected success after 3 runs, got %d runs, %v", runs, err)
+	}
+
+	// permanent errors are not retried
+	errPermanent := errors.New("permanent")
+	runs = 0
+	err = p.View(db, func(txn backend.Txn) error {
+		runs++
+		return errPermanent
+	})
+	if err != errPermanent || runs != 1 {
+		t.Fatalf("view: expected permanent error after 1 run, got %d runs, %v", runs, err)
+	}
+
+	// attempts are limited
+	db.On(mockdb.OpReadonly, nil).Fail(temporaryError{})
+	err = p.View(db, func(txn backend.Txn) error { return nil })
+	if _, ok := err.(temporaryError); !ok {
+		t.Fatalf("view: expected temporary error, got %v", err)
+	}
+	var readonly int
+	for _, call := range db.Calls() {
+		if call.Op == mockdb.OpReadonly && call.Err != nil {
+			readonly++
+		}
+	}
+	if readonly != 3 {
+		t.Fatalf("view: expected 3 attempts, got %d", readonly)
+	}
+
+	if err = backend.Update(db, func(txn backend.RWTxn) error { return nil }); err != nil {
+		t.Fatalf("update without policy: %v", err)
+	}
+}
@@ -0,0 +1,646 @@
+//go:build cgo && rocksdb
+
+package backend
+
+/*
+#cgo LDFLAGS:-lrocksdb
+#include <stdlib.h>
+#include "rocksdb/c.h"
+
+// backend_rocks_write applies n buffered operations to the column family
+// cf in a single write batch. The layout of data, lens and kinds matches
+// backend_write.
+static void backend_rocks_write(rocksdb_t* db, const rocksdb_writeoptions_t* wopts,
+		rocksdb_column_family_handle_t* cf, const char* data, const size_t* lens,
+		const unsigned char* kinds, size_t n, char** errptr) {
+	rocksdb_writebatch_t* batch = rocksdb_writebatch_create();
+	size_t i;
+	for (i = 0; i < n; i++) {
+		size_t klen = lens[2*i], vlen = lens[2*i+1];
+		if (kinds[i] == 0) {
+			rocksdb_writebatch_put_cf(batch, cf, data, klen, data+klen, vlen);
+		} else {
+			rocksdb_writebatch_delete_cf(batch, cf, data, klen);
+		}
+		data += klen + vlen;
+	}
+	rocksdb_write(db, wopts, batch, errptr);
+	rocksdb_writebatch_destroy(batch);
+}
+*/
+import "C"
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"unsafe"
+)
+
+// defaultFamily is the column family every RocksDB database has.
+const defaultFamily = "default"
+
+type RocksOption func(*RocksDB) error
+
+func RocksWriteBufferSize(size int) RocksOption {
+	return func(db *RocksDB) error {
+		C.rocksdb_options_set_write_buffer_size(db.opts, C.size_t(size))
+		return nil
+	}
+}
+
+func RocksBlockSize(size int) RocksOption {
+	return func(db *RocksDB) error {
+		C.rocksdb_block_based_options_set_block_size(db.topts, C.size_t(size))
+		return nil
+	}
+}
+
+func RocksBlockRestartInterval(n int) RocksOption {
+	return func(db *RocksDB) error {
+		C.rocksdb_block_based_options_set_block_restart_interval(db.topts, C.int(n))
+		return nil
+	}
+}
+
+// ColumnFamilies opens the given column families along with the default
+// one, creating them if they do not exist. Column families already in
+// the database are always opened. Use Namespace to access them.
+func ColumnFamilies(names ...string) RocksOption {
+	return func(db *RocksDB) error {
+		for _, name := range names {
+			if name == "" {
+				return errors.New("empty column family name")
+			}
+			db.names = append(db.names, name)
+		}
+		return nil
+	}
+}
+
+// RocksStores returns a StoreOpener opening each store as a RocksDB
+// directory with the given options.
+func RocksStores(opts ...RocksOption) StoreOpener {
+	return func(path string) (DB, error) {
+		return OpenRocksDB(path, opts...)
+	}
+}
+
+func checkRocksError(errptr *C.char) error {
+	if errptr == nil {
+		return nil
+	}
+	err := Error(C.GoString(errptr))
+	C.rocksdb_free(unsafe.Pointer(errptr))
+	return err
+}
+
+var _ DB = (*RocksDB)(nil)
+
+// RocksDB is a key/value store on top of RocksDB. The DB methods operate
+// on the default column family, Namespace returns views of the others.
+// RocksDB requires cgo and librocksdb and is only built with the rocksdb
+// build tag.
+type RocksDB struct {
+	root  string
+	wopts *C.rocksdb_writeoptions_t              // default txn write options
+	opts  *C.rocksdb_options_t                   // default RocksDB options
+	topts *C.rocksdb_block_based_table_options_t // block based table options
+	tree  *C.rocksdb_t
+	names []string // column families to open
+	refs  refCount
+
+	mu       sync.Mutex // guards families
+	families map[string]*rocksFamily
+}
+
+// OpenRocksDB opens the RocksDB database at root, creating it if it does
+// not exist.
+func OpenRocksDB(root string, opts ...RocksOption) (*RocksDB, error) {
+	db := &RocksDB{
+		root:     root,
+		wopts:    C.rocksdb_writeoptions_create(),
+		opts:     C.rocksdb_options_create(),
+		topts:    C.rocksdb_block_based_options_create(),
+		families: make(map[string]*rocksFamily),
+	}
+	C.rocksdb_options_set_create_if_missing(db.opts, ctrue)
+	C.rocksdb_options_set_create_missing_column_families(db.opts, ctrue)
+
+	for _, opt := range opts {
+		if err := opt(db); err != nil {
+			db.destroyOptions()
+			return nil, err
+		}
+	}
+	C.rocksdb_options_set_block_based_table_factory(db.opts, db.topts)
+
+	path := C.CString(root)
+	defer C.free(unsafe.Pointer(path))
+
+	names := append([]string{defaultFamily}, db.names...)
+	names = append(names, listColumnFamilies(db.opts, path)...)
+	names = uniqueNames(names)
+
+	cnames := make([]*C.char, len(names))
+	copts := make([]*C.rocksdb_options_t, len(names))
+	handles := make([]*C.rocksdb_column_family_handle_t, len(names))
+	for i, name := range names {
+		cnames[i] = C.CString(name)
+		copts[i] = db.opts
+	}
+	defer func() {
+		for _, name := range cnames {
+			C.free(unsafe.Pointer(name))
+		}
+	}()
+
+	var errptr *C.char
+	db.tree = C.rocksdb_open_column_families(db.opts, path, C.int(len(names)),
+		&cnames[0], &copts[0], &handles[0], &errptr)
+	if err := checkRocksError(errptr); err != nil {
+		db.destroyOptions()
+		return nil, err
+	}
+	for i, name := range names {
+		db.families[name] = &rocksFamily{cf: handles[i]}
+	}
+	return db, nil
+}
+
+// listColumnFamilies returns the column families of an existing database
+// or nil if it cannot be listed, e.g. because it does not exist yet.
+func listColumnFamilies(opts *C.rocksdb_options_t, path *C.char) []string {
+	var n C.size_t
+	var errptr *C.char
+	list := C.rocksdb_list_column_families(opts, path, &n, &errptr)
+	if checkRocksError(errptr) != nil || list == nil {
+		return nil
+	}
+	defer C.rocksdb_list_column_families_destroy(list, n)
+
+	cnames := (*[1 << 20]*C.char)(unsafe.Pointer(list))[:n:n]
+	names := make([]string, len(cnames))
+	for i, name := range cnames {
+		names[i] = C.GoString(name)
+	}
+	return names
+}
+
+func uniqueNames(names []string) []string {
+	seen := make(map[string]bool, len(names))
+	unique := names[:0]
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			unique = append(unique, name)
+		}
+	}
+	return unique
+}
+
+func (db *RocksDB) destroyOptions() {
+	C.rocksdb_writeoptions_destroy(db.wopts)
+	C.rocksdb_block_based_options_destroy(db.topts)
+	C.rocksdb_options_destroy(db.opts)
+	db.wopts = nil
+	db.topts = nil
+	db.opts = nil
+}
+
+func (db *RocksDB) Close() error {
+	if db == nil {
+		return errors.New("closing unopened RocksDB instance")
+	}
+	if err := db.refs.close(); err != nil {
+		return err
+	}
+	db.mu.Lock()
+	for name, f := range db.families {
+		C.rocksdb_column_family_handle_destroy(f.cf)
+		delete(db.families, name)
+	}
+	db.mu.Unlock()
+	C.rocksdb_close(db.tree)
+	db.destroyOptions()
+	db.tree = nil
+	return nil
+}
+
+func (db *RocksDB) Name() string { return "RocksDB" }
+
+// WriteTo writes the default column family to w using the export format.
+// See Export for details.
+func (db *RocksDB) WriteTo(w io.Writer) (int64, error) {
+	return Export(w, db)
+}
+
+func (db *RocksDB) Iterator() (Iterator, error) {
+	return newRocksIterator(db, db.family(defaultFamily).handle(), true)
+}
+
+func (db *RocksDB) Readonly() (Txn, error) {
+	return newRocksReadTxn(db, db.family(defaultFamily).handle())
+}
+
+// Writable starts a new write transaction. Blocked callers are granted
+// the transaction in the order they called Writable. Writes are buffered
+// and applied in a single batch on Commit.
+func (db *RocksDB) Writable() (RWTxn, error) {
+	return newRocksTxn(db, db.family(defaultFamily))
+}
+
+// WriteQueueDepth returns the number of callers blocked in Writable.
+func (db *RocksDB) WriteQueueDepth() int {
+	f := db.family(defaultFamily)
+	if f == nil {
+		return 0
+	}
+	return f.writer.waiting()
+}
+
+// rocksFamily is an open column family. Write transactions of different
+// column families touch disjoint keys and do not block each other.
+type rocksFamily struct {
+	cf     *C.rocksdb_column_family_handle_t
+	writer fifoMutex
+}
+
+// handle returns the column family handle, nil if f is nil.
+func (f *rocksFamily) handle() *C.rocksdb_column_family_handle_t {
+	if f == nil {
+		return nil
+	}
+	return f.cf
+}
+
+// family returns the open column family name, nil if the database is
+// closed.
+func (db *RocksDB) family(name string) *rocksFamily {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.families[name]
+}
+
+// Namespace returns a view of the column family name, creating it if it
+// does not exist. Keys of different namespaces never collide, each
+// namespace has its own keyspace. Closing the view does not close db,
+// column families are released by RocksDB.Close.
+func (db *RocksDB) Namespace(name string) (*RocksNamespace, error) {
+	if name == "" {
+		return nil, errors.New("empty column family name")
+	}
+	if err := db.refs.acquire(); err != nil {
+		return nil, err
+	}
+	defer db.refs.release()
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	f, ok := db.families[name]
+	if !ok {
+		cname := C.CString(name)
+		defer C.free(unsafe.Pointer(cname))
+		var errptr *C.char
+		cf := C.rocksdb_create_column_family(db.tree, db.opts, cname, &errptr)
+		if err := checkRocksError(errptr); err != nil {
+			return nil, err
+		}
+		f = &rocksFamily{cf: cf}
+		db.families[name] = f
+	}
+	return &RocksNamespace{db: db, f: f, name: name}, nil
+}
+
+var _ DB = (*RocksNamespace)(nil)
+
+// RocksNamespace is a RocksDB column family returned by RocksDB.Namespace.
+type RocksNamespace struct {
+	db   *RocksDB
+	f    *rocksFamily
+	name string
+}
+
+func (ns *RocksNamespace) Iterator() (Iterator, error) {
+	return newRocksIterator(ns.db, ns.f.cf, true)
+}
+
+func (ns *RocksNamespace) Readonly() (Txn, error) {
+	return newRocksReadTxn(ns.db, ns.f.cf)
+}
+
+func (ns *RocksNamespace) Writable() (RWTxn, error) {
+	return newRocksTxn(ns.db, ns.f)
+}
+
+// WriteTo writes the column family to w using the export format.
+func (ns *RocksNamespace) WriteTo(w io.Writer) (int64, error) {
+	return Export(w, ns)
+}
+
+func (ns *RocksNamespace) Name() string { return "RocksDB/" + ns.name }
+
+// Close is a no-op, the column family is released by RocksDB.Close.
+func (ns *RocksNamespace) Close() error { return nil }
+
+type rocksIterator struct {
+	ropts *C.rocksdb_readoptions_t
+	snap  *C.rocksdb_snapshot_t
+	iter  *C.rocksdb_iterator_t
+	cf    *C.rocksdb_column_family_handle_t
+	db    *RocksDB
+}
+
+// newRocksIterator returns an iterator over the column family cf holding
+// a reference on db until it is closed.
+func newRocksIterator(db *RocksDB, cf *C.rocksdb_column_family_handle_t, snapshot bool) (*rocksIterator, error) {
+	if err := db.refs.acquire(); err != nil {
+		return nil, err
+	}
+	i := &rocksIterator{
+		ropts: C.rocksdb_readoptions_create(),
+		cf:    cf,
+		db:    db,
+	}
+	i.open(snapshot)
+	return i, nil
+}
+
+func (i *rocksIterator) open(snapshot bool) {
+	if snapshot {
+		i.snap = C.rocksdb_create_snapshot(i.db.tree)
+		C.rocksdb_readoptions_set_snapshot(i.ropts, i.snap)
+	}
+	i.iter = C.rocksdb_create_iterator_cf(i.db.tree, i.ropts, i.cf)
+}
+
+// Reset releases the rocksdb iterator and snapshot and creates new ones
+// on the current state of the database.
+func (i *rocksIterator) Reset() error {
+	if i == nil || i.db == nil {
+		return errors.New("reset unopened iterator")
+	}
+
+	var errptr *C.char
+	C.rocksdb_iter_get_error(i.iter, &errptr)
+	C.rocksdb_iter_destroy(i.iter)
+	snapshot := i.snap != nil
+	if snapshot {
+		C.rocksdb_readoptions_set_snapshot(i.ropts, nil)
+		C.rocksdb_release_snapshot(i.db.tree, i.snap)
+		i.snap = nil
+	}
+	i.open(snapshot)
+	return checkRocksError(errptr)
+}
+
+func (i *rocksIterator) Close() error {
+	if i == nil || i.db == nil {
+		return errors.New("closing unopened iterator")
+	}
+
+	var errptr *C.char
+	C.rocksdb_iter_get_error(i.iter, &errptr)
+
+	C.rocksdb_iter_destroy(i.iter)
+	C.rocksdb_readoptions_destroy(i.ropts)
+	if i.snap != nil {
+		C.rocksdb_release_snapshot(i.db.tree, i.snap)
+		i.snap = nil
+	}
+
+	i.db.refs.release()
+	i.iter = nil
+	i.ropts = nil
+	i.db = nil
+	return checkRocksError(errptr)
+}
+
+func (i rocksIterator) isValid() bool {
+	return C.rocksdb_iter_valid(i.iter) != cfalse
+}
+
+// get retrieves the value of key by seeking the iterator, which avoids
+// copying the value like rocksdb_get.
+func (i rocksIterator) get(key []byte) ([]byte, error) {
+	klen := C.size_t(len(key))
+	C.rocksdb_iter_seek(i.iter, cbytes(key), klen)
+	if !i.isValid() {
+		var errptr *C.char
+		C.rocksdb_iter_get_error(i.iter, &errptr)
+		if errptr == nil {
+			return nil, ErrNotFound
+		}
+		return nil, checkRocksError(errptr)
+	}
+
+	k := C.rocksdb_iter_key(i.iter, &klen)
+	if !bytes.Equal(unsafeGoBytes(k, klen), key) {
+		return nil, ErrNotFound
+	}
+
+	var vlen C.size_t
+	v := C.rocksdb_iter_value(i.iter, &vlen)
+	return unsafeGoBytes(v, vlen), nil
+}
+
+func (i rocksIterator) current() ([]byte, []byte) {
+	var klen, vlen C.size_t
+	k := C.rocksdb_iter_key(i.iter, &klen)
+	v := C.rocksdb_iter_value(i.iter, &vlen)
+	return unsafeGoBytes(k, klen), unsafeGoBytes(v, vlen)
+}
+
+func (i rocksIterator) Seek(key []byte) ([]byte, []byte) {
+	C.rocksdb_iter_seek(i.iter, cbytes(key), C.size_t(len(key)))
+	if !i.isValid() {
+		return nil, nil
+	}
+	return i.current()
+}
+
+func (i rocksIterator) First() ([]byte, []byte) {
+	C.rocksdb_iter_seek_to_first(i.iter)
+	if !i.isValid() {
+		return nil, nil
+	}
+	return i.current()
+}
+
+func (i rocksIterator) Last() ([]byte, []byte) {
+	C.rocksdb_iter_seek_to_last(i.iter)
+	if !i.isValid() {
+		return nil, nil
+	}
+	return i.current()
+}
+
+func (i rocksIterator) Next() ([]byte, []byte) {
+	if !i.isValid() {
+		return nil, nil
+	}
+	C.rocksdb_iter_next(i.iter)
+	if !i.isValid() {
+		return nil, nil
+	}
+	return i.current()
+}
+
+func (i rocksIterator) Prev() ([]byte, []byte) {
+	if !i.isValid() {
+		return nil, nil
+	}
+	C.rocksdb_iter_prev(i.iter)
+	if !i.isValid() {
+		return nil, nil
+	}
+	return i.current()
+}
+
+func (i *rocksIterator) SeekToIndex(n int64) ([]byte, []byte) {
+	return walkToIndex(i, n)
+}
+
+// rocksTxn buffers all writes in Go memory like levelTxn and applies
+// them to its column family in a single cgo call on commit.
+type rocksTxn struct {
+	data     []byte
+	lens     []C.size_t
+	kinds    []C.uchar
+	modified *overlay
+	iter     *rocksIterator // reads committed data without a snapshot
+	cf       *C.rocksdb_column_family_handle_t
+	writer   *fifoMutex // writer lock of the column family
+	db       *RocksDB
+}
+
+// newRocksTxn takes the writer lock of the column family f, which the
+// transaction holds until it is closed.
+func newRocksTxn(db *RocksDB, f *rocksFamily) (*rocksTxn, error) {
+	if f == nil {
+		return nil, ErrDBClosed
+	}
+	f.writer.Lock()
+	iter, err := newRocksIterator(db, f.cf, false)
+	if err != nil {
+		f.writer.Unlock()
+		return nil, err
+	}
+	txn := &rocksTxn{iter: iter, cf: f.cf, writer: &f.writer, db: db}
+	txn.modified = newOverlay(&txn.data)
+	return txn, nil
+}
+
+func (t *rocksTxn) Get(key []byte) ([]byte, error) {
+	if t == nil || t.iter == nil {
+		return nil, errors.New("get from unopened transaction")
+	}
+	v, deleted, found := t.modified.get(key)
+	if !found {
+		return t.iter.get(key)
+	}
+	if deleted {
+		return nil, ErrNotFound
+	}
+	return v, nil
+}
+
+func (t *rocksTxn) Put(key, value []byte) error {
+	if t == nil || t.iter == nil {
+		return errors.New("put in unopened transaction")
+	}
+	off := len(t.data)
+	if uint64(off)+uint64(len(key))+uint64(len(value)) > maxOverlaySlab {
+		return errTxnTooLarge
+	}
+	t.data = append(t.data, key...)
+	t.data = append(t.data, value...)
+	t.lens = append(t.lens, C.size_t(len(key)), C.size_t(len(value)))
+	t.kinds = append(t.kinds, opPut)
+	t.modified.set(off, len(key), off+len(key), len(value), false)
+	return nil
+}
+
+func (t *rocksTxn) Delete(key []byte) error {
+	if t == nil || t.iter == nil {
+		return errors.New("delete in unopened transaction")
+	}
+	off := len(t.data)
+	if uint64(off)+uint64(len(key)) > maxOverlaySlab {
+		return errTxnTooLarge
+	}
+	t.data = append(t.data, key...)
+	t.lens = append(t.lens, C.size_t(len(key)), 0)
+	t.kinds = append(t.kinds, opDelete)
+	t.modified.set(off, len(key), off+len(key), 0, true)
+	return nil
+}
+
+func (t *rocksTxn) close() error {
+	err := t.iter.Close()
+	t.iter = nil
+	t.data = nil
+	t.lens = nil
+	t.kinds = nil
+	t.modified = nil
+	t.writer.Unlock()
+	return err
+}
+
+func (t *rocksTxn) Rollback() error {
+	if t == nil || t.iter == nil {
+		return errors.New("rollback unopened transaction")
+	}
+	return t.close()
+}
+
+func (t *rocksTxn) Commit() error {
+	if t == nil || t.iter == nil {
+		return errors.New("commit unopened transaction")
+	}
+	var err error
+	if len(t.kinds) > 0 {
+		var errptr *C.char
+		C.backend_rocks_write(t.db.tree, t.db.wopts, t.cf, cbytes(t.data), &t.lens[0],
+			&t.kinds[0], C.size_t(len(t.kinds)), &errptr)
+		err = checkRocksError(errptr)
+	}
+	if cerr := t.close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// rocksReadTxn is a read-only transaction reading from a snapshot.
+type rocksReadTxn struct {
+	iter *rocksIterator
+}
+
+func newRocksReadTxn(db *RocksDB, cf *C.rocksdb_column_family_handle_t) (*rocksReadTxn, error) {
+	iter, err := newRocksIterator(db, cf, true)
+	if err != nil {
+		return nil, err
+	}
+	return &rocksReadTxn{iter: iter}, nil
+}
+
+func (t *rocksReadTxn) Get(key []byte) ([]byte, error) {
+	if t == nil || t.iter == nil {
+		return nil, errors.New("get from unopened transaction")
+	}
+	return t.iter.get(key)
+}
+
+func (t *rocksReadTxn) Rollback() error {
+	if t == nil || t.iter == nil {
+		return errors.New("rollback unopened transaction")
+	}
+	err := t.iter.Close()
+	t.iter = nil
+	return err
+}
+
+func (t *rocksReadTxn) Put(key, value []byte) error { return ErrReadOnlyTxn }
+func (t *rocksReadTxn) Delete(key []byte) error     { return ErrReadOnlyTxn }
+func (t *rocksReadTxn) Commit() error               { return ErrReadOnlyTxn }
@@ -0,0 +1,78 @@
+//go:build cgo && rocksdb
+
+package backend
+
+import (
+	"os"
+	"testing"
+)
+
+func TestRocksCompatibility(t *testing.T) {
+	const path = "compatibility_rocksdb"
+	db, err := OpenRocksDB(path, RocksWriteBufferSize(1<<20), RocksBlockSize(4096), ColumnFamilies("users"))
+	if err != nil {
+		t.Fatalf("opening RocksDB %q: %v", path, err)
+	}
+	defer os.RemoveAll(path)
+	users, err := db.Namespace("users")
+	if err != nil {
+		t.Fatalf("namespace users: %v", err)
+	}
+	orders, err := db.Namespace("orders")
+	if err != nil {
+		t.Fatalf("namespace orders: %v", err)
+	}
+
+	testBasic(t, db, users)
+	testBasicTransaction(t, db, users)
+	testBasicIterator(t, db, users)
+	testExport(t, db, users)
+	testTextDump(t, db, users)
+	testIteratorReset(t, db, users)
+	testScan(t, db, users)
+	testPatchJSON(t, db, users)
+	testChecksum(t, db, users)
+	testReadOnlyTxn(t, db, users)
+	testReadOnlyView(t, db, users)
+	testGetMulti(t, db, users)
+	testMove(t, db, users)
+	testDeleteFunc(t, db, users)
+	testScope(t, db, users)
+
+	if err = Update(orders, func(txn RWTxn) error {
+		return txn.Put([]byte("order/1"), []byte("order"))
+	}); err != nil {
+		t.Fatalf("put in namespace orders: %v", err)
+	}
+	if err = View(db, func(txn Txn) error {
+		_, err := txn.Get([]byte("order/1"))
+		return err
+	}); err != ErrNotFound {
+		t.Fatalf("namespace orders: expected ErrNotFound in default column family, got %v", err)
+	}
+
+	if err = db.Close(); err != nil {
+		t.Fatalf("closing RocksDB %q: %v", path, err)
+	}
+	if _, err = orders.Writable(); err != ErrDBClosed {
+		t.Fatalf("namespace of closed RocksDB: expected ErrDBClosed, got %v", err)
+	}
+
+	// column families created by Namespace are opened again
+	if db, err = OpenRocksDB(path); err != nil {
+		t.Fatalf("reopening RocksDB %q: %v", path, err)
+	}
+	defer db.Close()
+	if db.family("orders") == nil {
+		t.Fatalf("reopen: column family orders not opened")
+	}
+	if orders, err = db.Namespace("orders"); err != nil {
+		t.Fatalf("namespace orders: %v", err)
+	}
+	if err = View(orders, func(txn Txn) error {
+		_, err := txn.Get([]byte("order/1"))
+		return err
+	}); err != nil {
+		t.Fatalf("reopen: get from namespace orders: %v", err)
+	}
+}
@@ -0,0 +1,208 @@
+package backend
+
+import (
+	"bytes"
+	"errors"
+)
+
+// ScanOption configures a Scan.
+type ScanOption func(*scanner) error
+
+type scanner struct {
+	limit    int // maximum number of pairs, 0 for no limit
+	maxBytes int // maximum number of key and value bytes, 0 for no limit
+	project  ValueProjector
+}
+
+// ValueProjector extracts the parts of a stored value a scan is
+// interested in, e.g. a few fields of a wide JSON or protobuf record. The
+// value is only valid during the call; the projector may return a
+// subslice of it, which Scan copies. Returning a nil slice skips the pair.
+type ValueProjector func(key, value []byte) ([]byte, error)
+
+// Project applies p to every value before it is copied out of the
+// database. Byte limits are accounted on the projected values.
+func Project(p ValueProjector) ScanOption {
+	return func(s *scanner) error {
+		if p == nil {
+			return errors.New("nil value projector")
+		}
+		s.project = p
+		return nil
+	}
+}
+
+// Limit stops a scan after n key/value pairs.
+func Limit(n int) ScanOption {
+	return func(s *scanner) error {
+		if n <= 0 {
+			return errors.New("scan limit must be positive")
+		}
+		s.limit = n
+		return nil
+	}
+}
+
+// StopAfterBytes stops a scan once the returned keys and values would
+// exceed n bytes. The first pair is always returned, even if it is larger
+// than n, so paging through a range always makes progress.
+func StopAfterBytes(n int) ScanOption {
+	return func(s *scanner) error {
+		if n <= 0 {
+			return errors.New("scan byte limit must be positive")
+		}
+		s.maxBytes = n
+		return nil
+	}
+}
+
+// Scan returns the key/value pairs whose key starts with prefix in
+// ascending key order. The iterator is not advanced past the last pair
+// returned, so a limited scan only reads and copies what it returns.
+func Scan(db DB, prefix []byte, opts ...ScanOption) ([]KeyValue, error) {
+	s := &scanner{}
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+
+	iter, err := db.Iterator()
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var pairs []KeyValue
+	size := 0
+	for k, v := iter.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = iter.Next() {
+		if s.project != nil {
+			if v, err = s.project(k, v); err != nil {
+				return nil, err
+			} else if v == nil {
+				continue
+			}
+		}
+
+		n := len(k) + len(v)
+		if s.maxBytes > 0 && len(pairs) > 0 && size+n > s.maxBytes {
+			break
+		}
+		size += n
+
+		// keep key and value in a single allocation
+		buf := make([]byte, n)
+		copy(buf[copy(buf, k):], v)
+		pairs = append(pairs, KeyValue{Key: buf[:len(k):len(k)], Value: buf[len(k):]})
+
+		if len(pairs) == s.limit || (s.maxBytes > 0 && size >= s.maxBytes) {
+			break
+		}
+	}
+	return pairs, iter.Close()
+}
+
+// ScanPlan describes how Scan would execute.
+type ScanPlan struct {
+	// Start and Limit bound the scanned key range [Start, Limit). Limit is
+	// nil if the range extends to the last key.
+	Start, Limit []byte
+
+	MaxPairs  int  // Limit option, 0 if unlimited
+	MaxBytes  int  // StopAfterBytes option, 0 if unlimited
+	Projected bool // values pass through a ValueProjector
+
+	// EstimatedBytes is the approximate storage used by the range, or -1
+	// if the backend cannot estimate it without reading the range.
+	EstimatedBytes int64
+
+	// EstimatedKeys is the approximate number of keys in the range, or -1
+	// if the backend cannot estimate it. Ranges of up to indexSample keys
+	// are counted exactly, larger ones are estimated from the storage used
+	// by their first indexSample pairs.
+	EstimatedKeys int64
+}
+
+// sizeEstimator is implemented by backends that can estimate the size of
+// a key range without reading it.
+type sizeEstimator interface {
+	ApproximateSize(start, limit []byte) (uint64, error)
+}
+
+// ExplainScan reports how Scan would execute with the same arguments
+// reading at most the first indexSample pairs of the range. A scan always
+// reads a single iterator view of the database; bloom filters only answer
+// point lookups and never shortcut a scan.
+func ExplainScan(db DB, prefix []byte, opts ...ScanOption) (*ScanPlan, error) {
+	s := &scanner{}
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+
+	plan := &ScanPlan{
+		Start:          append([]byte{}, prefix...),
+		Limit:          prefixEnd(prefix),
+		MaxPairs:       s.limit,
+		MaxBytes:       s.maxBytes,
+		Projected:      s.project != nil,
+		EstimatedBytes: -1,
+		EstimatedKeys:  -1,
+	}
+	iter, err := db.Iterator()
+	if err != nil {
+		return nil, err
+	}
+	// count the range up to the sample size, end is the key after the
+	// sample
+	n := int64(0)
+	k, _ := iter.Seek(prefix)
+	for ; k != nil && bytes.HasPrefix(k, prefix) && n < indexSample; k, _ = iter.Next() {
+		n++
+	}
+	if k == nil || !bytes.HasPrefix(k, prefix) {
+		plan.EstimatedKeys = n
+	}
+	end := append([]byte{}, k...)
+	limit := plan.Limit
+	if limit == nil {
+		// estimators need an upper bound, use the key after the last
+		last, _ := iter.Last()
+		limit = append(append([]byte{}, last...), 0)
+	}
+	if err = iter.Close(); err != nil {
+		return nil, err
+	}
+
+	if e, ok := db.(sizeEstimator); ok {
+		size, err := e.ApproximateSize(plan.Start, limit)
+		if err != nil {
+			return nil, err
+		}
+		plan.EstimatedBytes = int64(size)
+		if plan.EstimatedKeys < 0 {
+			sample, err := e.ApproximateSize(plan.Start, end)
+			if err != nil {
+				return nil, err
+			}
+			if sample > 0 {
+				plan.EstimatedKeys = int64(float64(size) / float64(sample) * indexSample)
+			}
+		}
+	}
+	return plan, nil
+}
+
+// prefixEnd returns the smallest key greater than all keys starting with
+// prefix, or nil if there is none.
+func prefixEnd(prefix []byte) []byte {
+	end := append([]byte{}, prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
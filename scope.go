@@ -0,0 +1,107 @@
+package backend
+
+import (
+	"bytes"
+	"io"
+)
+
+// Scope returns a view of the keys of db starting with prefix. Keys are
+// passed to and returned from the view without the prefix, so code
+// handed the view cannot read or write any key outside of it. Closing
+// the view does not close db.
+func Scope(db DB, prefix []byte) DB {
+	return &scopedDB{db: db, prefix: append([]byte{}, prefix...)}
+}
+
+type scopedDB struct {
+	db     DB
+	prefix []byte
+}
+
+// key returns the key k in the underlying database.
+func (s *scopedDB) key(k []byte) []byte {
+	key := make([]byte, 0, len(s.prefix)+len(k))
+	return append(append(key, s.prefix...), k...)
+}
+
+func (s *scopedDB) Iterator() (Iterator, error) {
+	iter, err := s.db.Iterator()
+	if err != nil {
+		return nil, err
+	}
+	return &scopedIterator{Iterator: iter, s: s}, nil
+}
+
+func (s *scopedDB) Readonly() (Txn, error) {
+	txn, err := s.db.Readonly()
+	if err != nil {
+		return nil, err
+	}
+	return &scopedTxn{RWTxn: readOnly{txn}, s: s}, nil
+}
+
+func (s *scopedDB) Writable() (RWTxn, error) {
+	txn, err := s.db.Writable()
+	if err != nil {
+		return nil, err
+	}
+	return &scopedTxn{RWTxn: txn, s: s}, nil
+}
+
+// WriteTo writes the keys of the view to w using the export format.
+func (s *scopedDB) WriteTo(w io.Writer) (int64, error) { return Export(w, s) }
+
+func (s *scopedDB) Name() string { return s.db.Name() }
+
+// Close is a no-op, the view does not own the database.
+func (s *scopedDB) Close() error { return nil }
+
+type scopedTxn struct {
+	RWTxn
+	s *scopedDB
+}
+
+func (t *scopedTxn) Get(key []byte) ([]byte, error) { return t.RWTxn.Get(t.s.key(key)) }
+
+func (t *scopedTxn) Put(key, value []byte) error { return t.RWTxn.Put(t.s.key(key), value) }
+
+func (t *scopedTxn) Delete(key []byte) error { return t.RWTxn.Delete(t.s.key(key)) }
+
+// scopedIterator confines an iterator to the keys of the view.
+type scopedIterator struct {
+	Iterator
+	s *scopedDB
+}
+
+// strip returns k without the prefix, or nil if k is outside the view.
+func (i *scopedIterator) strip(k, v []byte) ([]byte, []byte) {
+	if k == nil || !bytes.HasPrefix(k, i.s.prefix) {
+		return nil, nil
+	}
+	return k[len(i.s.prefix):], v
+}
+
+func (i *scopedIterator) Seek(key []byte) ([]byte, []byte) {
+	return i.strip(i.Iterator.Seek(i.s.key(key)))
+}
+
+func (i *scopedIterator) First() ([]byte, []byte) {
+	return i.strip(i.Iterator.Seek(i.s.prefix))
+}
+
+func (i *scopedIterator) Last() ([]byte, []byte) {
+	end := prefixEnd(i.s.prefix)
+	if end == nil {
+		return i.strip(i.Iterator.Last())
+	}
+	if k, _ := i.Iterator.Seek(end); k == nil {
+		return i.strip(i.Iterator.Last())
+	}
+	return i.strip(i.Iterator.Prev())
+}
+
+func (i *scopedIterator) Next() ([]byte, []byte) { return i.strip(i.Iterator.Next()) }
+
+func (i *scopedIterator) Prev() ([]byte, []byte) { return i.strip(i.Iterator.Prev()) }
+
+func (i *scopedIterator) SeekToIndex(n int64) ([]byte, []byte) { return walkToIndex(i, n) }
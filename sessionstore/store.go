@@ -0,0 +1,163 @@
+// Package sessionstore implements a gorilla/sessions store that keeps
+// session data in a backend.DB.
+package sessionstore
+
+import (
+	"encoding/base32"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/mars9/backend"
+)
+
+var _ sessions.Store = (*Store)(nil)
+
+const defaultMaxAge = 86400 * 30
+
+// Store stores sessions in a backend.DB. The cookie only holds the
+// authenticated session ID, the encoded session values are stored under
+// the key prefix + ID.
+type Store struct {
+	Codecs  []securecookie.Codec
+	Options *sessions.Options // default configuration
+
+	db     backend.DB
+	prefix []byte
+}
+
+// New returns a new Store. Keys are defined in pairs to allow key
+// rotation, see securecookie.CodecsFromPairs for details.
+func New(db backend.DB, prefix []byte, keyPairs ...[]byte) *Store {
+	s := &Store{
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:   "/",
+			MaxAge: defaultMaxAge,
+		},
+		db:     db,
+		prefix: append([]byte{}, prefix...),
+	}
+	s.MaxAge(s.Options.MaxAge)
+	return s
+}
+
+// MaxAge sets the maximum age of the store and its codecs. Stored values
+// older than age are rejected by the codecs.
+func (s *Store) MaxAge(age int) {
+	s.Options.MaxAge = age
+	for _, codec := range s.Codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(age)
+		}
+	}
+}
+
+// Get returns a cached session for the request or creates a new one.
+func (s *Store) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns a session for the given name without adding it to the
+// registry. If the request carries a valid session cookie the stored
+// session is loaded.
+func (s *Store) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+
+	c, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	if err = securecookie.DecodeMulti(name, c.Value, &session.ID, s.Codecs...); err != nil {
+		return session, err
+	}
+	if err = s.load(session); err == backend.ErrNotFound {
+		return session, nil
+	} else if err != nil {
+		return session, err
+	}
+	session.IsNew = false
+	return session, nil
+}
+
+// Save stores the session and writes the session cookie. A session with
+// a MaxAge <= 0 is deleted.
+func (s *Store) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge <= 0 {
+		if err := s.erase(session); err != nil {
+			return err
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if session.ID == "" {
+		session.ID = strings.TrimRight(
+			base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), "=")
+	}
+	if err := s.save(session); err != nil {
+		return err
+	}
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+func (s *Store) key(session *sessions.Session) []byte {
+	key := make([]byte, 0, len(s.prefix)+len(session.ID))
+	return append(append(key, s.prefix...), session.ID...)
+}
+
+func (s *Store) save(session *sessions.Session) error {
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.Values, s.Codecs...)
+	if err != nil {
+		return err
+	}
+
+	txn, err := s.db.Writable()
+	if err != nil {
+		return err
+	}
+	if err = txn.Put(s.key(session), []byte(encoded)); err != nil {
+		txn.Rollback()
+		return err
+	}
+	return txn.Commit()
+}
+
+func (s *Store) load(session *sessions.Session) error {
+	txn, err := s.db.Readonly()
+	if err != nil {
+		return err
+	}
+	defer txn.Rollback()
+
+	v, err := txn.Get(s.key(session))
+	if err != nil {
+		return err
+	}
+	return securecookie.DecodeMulti(session.Name(), string(v), &session.Values, s.Codecs...)
+}
+
+func (s *Store) erase(session *sessions.Session) error {
+	if session.ID == "" {
+		return nil
+	}
+
+	txn, err := s.db.Writable()
+	if err != nil {
+		return err
+	}
+	if err = txn.Delete(s.key(session)); err != nil {
+		txn.Rollback()
+		return err
+	}
+	return txn.Commit()
+}
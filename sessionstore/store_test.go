@@ -0,0 +1,66 @@
+package sessionstore
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/mars9/backend"
+)
+
+func TestStore(t *testing.T) {
+	const path = "sessionstore_test.db"
+	db, err := backend.OpenBoltDB(path, 0)
+	if err != nil {
+		t.Fatalf("opening BoltDB %q: %v", path, err)
+	}
+	defer func() {
+		db.Close()
+		os.RemoveAll(path)
+	}()
+
+	store := New(db, []byte("session/"), []byte("secret-hash-key"))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	session, err := store.New(req, "sid")
+	if err != nil {
+		t.Fatalf("new session: %v", err)
+	}
+	if !session.IsNew {
+		t.Fatalf("new session: expected IsNew")
+	}
+	session.Values["user"] = "alice"
+
+	rec := httptest.NewRecorder()
+	if err = store.Save(req, rec, session); err != nil {
+		t.Fatalf("save session: %v", err)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("save session: expected 1 cookie, got %d", len(cookies))
+	}
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookies[0])
+	loaded, err := store.New(req, "sid")
+	if err != nil {
+		t.Fatalf("load session: %v", err)
+	}
+	if loaded.IsNew || loaded.ID != session.ID {
+		t.Fatalf("load session: expected stored session %q, got %q", session.ID, loaded.ID)
+	}
+	if user := loaded.Values["user"]; user != "alice" {
+		t.Fatalf("load session: expected user %q, got %v", "alice", user)
+	}
+
+	loaded.Options.MaxAge = -1
+	if err = store.Save(req, httptest.NewRecorder(), loaded); err != nil {
+		t.Fatalf("delete session: %v", err)
+	}
+	req = httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "sid", Value: cookies[0].Value})
+	if session, err = store.New(req, "sid"); err != nil || !session.IsNew {
+		t.Fatalf("deleted session: expected new session, got %v (%v)", session.IsNew, err)
+	}
+}
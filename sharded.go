@@ -0,0 +1,358 @@
+package backend
+
+import (
+	"bytes"
+	"errors"
+	"hash/fnv"
+	"io"
+	"sort"
+)
+
+var _ DB = (*Sharded)(nil)
+
+// ShardOption configures a Sharded.
+type ShardOption func(*Sharded) error
+
+// ShardByRange partitions the keys by range instead of by hash: shard i
+// holds the keys from splits[i-1] up to but excluding splits[i]. There
+// must be one split less than shards, in ascending order.
+func ShardByRange(splits ...[]byte) ShardOption {
+	return func(s *Sharded) error {
+		if len(splits) != len(s.shards)-1 {
+			return errors.New("number of splits does not match shards")
+		}
+		for i := 1; i < len(splits); i++ {
+			if bytes.Compare(splits[i-1], splits[i]) >= 0 {
+				return errors.New("splits not in ascending order")
+			}
+		}
+		s.splits = make([][]byte, len(splits))
+		for i, split := range splits {
+			s.splits[i] = append([]byte{}, split...)
+		}
+		return nil
+	}
+}
+
+// Sharded spreads keys across several databases, by default by the hash
+// of the key. The shards are compacted independently and each holds a
+// fraction of the data, which helps single-file stores like BoltDB scale
+// to larger datasets. Iterators merge the shards in global key order.
+//
+// A transaction holds a transaction on every shard; write transactions
+// are serialized. Commit is atomic per shard only: if committing a shard
+// fails, the shards committed before keep the writes and the remaining
+// ones are rolled back. Read-only transactions do not see a common
+// snapshot of all shards.
+//
+// The shards are owned by the Sharded and must always be given in the
+// same order.
+type Sharded struct {
+	shards []DB
+	splits [][]byte // nil for hash partitioning
+}
+
+// NewSharded returns a Sharded partitioning keys across shards.
+func NewSharded(shards []DB, opts ...ShardOption) (*Sharded, error) {
+	if len(shards) == 0 {
+		return nil, errors.New("no shards")
+	}
+	s := &Sharded{shards: append([]DB{}, shards...)}
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// shard returns the index of the shard holding key.
+func (s *Sharded) shard(key []byte) int {
+	if s.splits != nil {
+		return sort.Search(len(s.splits), func(i int) bool {
+			return bytes.Compare(key, s.splits[i]) < 0
+		})
+	}
+	h := fnv.New32a()
+	h.Write(key)
+	return int(h.Sum32() % uint32(len(s.shards)))
+}
+
+// Shard returns the database holding key.
+func (s *Sharded) Shard(key []byte) DB { return s.shards[s.shard(key)] }
+
+func (s *Sharded) Iterator() (Iterator, error) {
+	iters := make([]Iterator, 0, len(s.shards))
+	for _, db := range s.shards {
+		iter, err := db.Iterator()
+		if err != nil {
+			for _, iter := range iters {
+				iter.Close()
+			}
+			return nil, err
+		}
+		iters = append(iters, iter)
+	}
+	return &shardedIterator{
+		iters:  iters,
+		keys:   make([][]byte, len(iters)),
+		values: make([][]byte, len(iters)),
+		cur:    -1,
+	}, nil
+}
+
+func (s *Sharded) Readonly() (Txn, error) {
+	txns := make([]RWTxn, 0, len(s.shards))
+	for _, db := range s.shards {
+		txn, err := db.Readonly()
+		if err != nil {
+			rollbackAll(txns)
+			return nil, err
+		}
+		txns = append(txns, readOnly{txn})
+	}
+	return &shardedTxn{s: s, txns: txns}, nil
+}
+
+// Writable starts a write transaction on every shard, in shard order so
+// concurrent callers cannot deadlock.
+func (s *Sharded) Writable() (RWTxn, error) {
+	txns := make([]RWTxn, 0, len(s.shards))
+	for _, db := range s.shards {
+		txn, err := db.Writable()
+		if err != nil {
+			rollbackAll(txns)
+			return nil, err
+		}
+		txns = append(txns, txn)
+	}
+	return &shardedTxn{s: s, txns: txns, dirty: make([]bool, len(txns)), writable: true}, nil
+}
+
+// WriteTo writes the keys of all shards to w using the export format.
+func (s *Sharded) WriteTo(w io.Writer) (int64, error) { return Export(w, s) }
+
+func (s *Sharded) Name() string { return "Sharded" }
+
+// Close closes all shards.
+func (s *Sharded) Close() error {
+	var err error
+	for _, db := range s.shards {
+		if cerr := db.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func rollbackAll(txns []RWTxn) {
+	for _, txn := range txns {
+		txn.Rollback()
+	}
+}
+
+type shardedTxn struct {
+	s        *Sharded
+	txns     []RWTxn
+	dirty    []bool // shards written to
+	writable bool
+}
+
+func (t *shardedTxn) Get(key []byte) ([]byte, error) {
+	if t == nil || t.txns == nil {
+		return nil, errors.New("get from unopened transaction")
+	}
+	return t.txns[t.s.shard(key)].Get(key)
+}
+
+func (t *shardedTxn) Put(key, value []byte) error {
+	if t == nil || t.txns == nil {
+		return errors.New("put in unopened transaction")
+	}
+	i := t.s.shard(key)
+	if err := t.txns[i].Put(key, value); err != nil {
+		return err
+	}
+	t.dirty[i] = true
+	return nil
+}
+
+func (t *shardedTxn) Delete(key []byte) error {
+	if t == nil || t.txns == nil {
+		return errors.New("delete in unopened transaction")
+	}
+	i := t.s.shard(key)
+	if err := t.txns[i].Delete(key); err != nil {
+		return err
+	}
+	t.dirty[i] = true
+	return nil
+}
+
+func (t *shardedTxn) Rollback() error {
+	if t == nil || t.txns == nil {
+		return nil
+	}
+	var err error
+	for _, txn := range t.txns {
+		if rerr := txn.Rollback(); err == nil {
+			err = rerr
+		}
+	}
+	t.txns = nil
+	return err
+}
+
+// Commit commits the shards written to in shard order and rolls back the
+// others. After a failed commit the remaining shards are rolled back.
+func (t *shardedTxn) Commit() error {
+	if t == nil || t.txns == nil {
+		return nil
+	}
+	if !t.writable {
+		return ErrReadOnlyTxn
+	}
+	var err error
+	for i, txn := range t.txns {
+		if err != nil || !t.dirty[i] {
+			txn.Rollback()
+			continue
+		}
+		err = txn.Commit()
+	}
+	t.txns = nil
+	return err
+}
+
+// shardedIterator merges the iterators of all shards. Moving forward,
+// each iterator is positioned at its first key after the current key,
+// moving backward at its last key before it; keys[cur] is the current
+// key.
+type shardedIterator struct {
+	iters   []Iterator
+	keys    [][]byte // nil if the iterator is exhausted
+	values  [][]byte
+	cur     int // -1 if not positioned
+	forward bool
+}
+
+// pick makes the smallest key, or the largest if moving backward, the
+// current key.
+func (i *shardedIterator) pick(forward bool) ([]byte, []byte) {
+	i.forward, i.cur = forward, -1
+	for j, k := range i.keys {
+		if k == nil {
+			continue
+		}
+		if i.cur < 0 || forward == (bytes.Compare(k, i.keys[i.cur]) < 0) {
+			i.cur = j
+		}
+	}
+	if i.cur < 0 {
+		return nil, nil
+	}
+	return i.keys[i.cur], i.values[i.cur]
+}
+
+func (i *shardedIterator) Seek(key []byte) ([]byte, []byte) {
+	if i == nil || i.iters == nil {
+		return nil, nil
+	}
+	for j, iter := range i.iters {
+		i.keys[j], i.values[j] = iter.Seek(key)
+	}
+	return i.pick(true)
+}
+
+func (i *shardedIterator) First() ([]byte, []byte) {
+	if i == nil || i.iters == nil {
+		return nil, nil
+	}
+	for j, iter := range i.iters {
+		i.keys[j], i.values[j] = iter.First()
+	}
+	return i.pick(true)
+}
+
+func (i *shardedIterator) Last() ([]byte, []byte) {
+	if i == nil || i.iters == nil {
+		return nil, nil
+	}
+	for j, iter := range i.iters {
+		i.keys[j], i.values[j] = iter.Last()
+	}
+	return i.pick(false)
+}
+
+func (i *shardedIterator) Next() ([]byte, []byte) {
+	if i == nil || i.iters == nil || i.cur < 0 {
+		return nil, nil
+	}
+	if !i.forward {
+		// the other iterators are before the current key, move them after
+		key := i.keys[i.cur]
+		for j, iter := range i.iters {
+			if j != i.cur {
+				i.keys[j], i.values[j] = iter.Seek(key)
+			}
+		}
+	}
+	i.keys[i.cur], i.values[i.cur] = i.iters[i.cur].Next()
+	return i.pick(true)
+}
+
+func (i *shardedIterator) Prev() ([]byte, []byte) {
+	if i == nil || i.iters == nil || i.cur < 0 {
+		return nil, nil
+	}
+	if i.forward {
+		// the other iterators are after the current key, move them before
+		key := i.keys[i.cur]
+		for j, iter := range i.iters {
+			if j == i.cur {
+				continue
+			}
+			if k, _ := iter.Seek(key); k == nil {
+				i.keys[j], i.values[j] = iter.Last()
+			} else {
+				i.keys[j], i.values[j] = iter.Prev()
+			}
+		}
+	}
+	i.keys[i.cur], i.values[i.cur] = i.iters[i.cur].Prev()
+	return i.pick(false)
+}
+
+func (i *shardedIterator) SeekToIndex(n int64) ([]byte, []byte) {
+	if i == nil || i.iters == nil {
+		return nil, nil
+	}
+	return walkToIndex(i, n)
+}
+
+func (i *shardedIterator) Reset() error {
+	if i == nil || i.iters == nil {
+		return errors.New("reset closed iterator")
+	}
+	for _, iter := range i.iters {
+		if err := iter.Reset(); err != nil {
+			return err
+		}
+	}
+	i.cur = -1
+	return nil
+}
+
+func (i *shardedIterator) Close() error {
+	if i == nil || i.iters == nil {
+		return nil
+	}
+	var err error
+	for _, iter := range i.iters {
+		if cerr := iter.Close(); err == nil {
+			err = cerr
+		}
+	}
+	i.iters = nil
+	return err
+}
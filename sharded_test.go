@@ -0,0 +1,75 @@
+package backend
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSharded(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		opts []ShardOption
+	}{
+		{"hash", nil},
+		{"range", []ShardOption{ShardByRange([]byte("k3"), []byte("k6"))}},
+	} {
+		db := openSharded(t, 3, c.opts...)
+		if err := Update(db, func(txn RWTxn) error {
+			for i := 0; i < 9; i++ {
+				if err := txn.Put([]byte(fmt.Sprintf("k%d", i)), []byte(fmt.Sprint(i))); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			t.Fatalf("%s: update: %v", c.name, err)
+		}
+		for i, shard := range db.shards {
+			pairs, err := Scan(shard, nil)
+			if err != nil {
+				t.Fatalf("%s: scan shard %d: %v", c.name, i, err)
+			}
+			if len(pairs) == 0 || len(pairs) == 9 {
+				t.Fatalf("%s: shard %d holds %d keys", c.name, i, len(pairs))
+			}
+		}
+		if c.name == "range" && db.Shard([]byte("k3")) != db.shards[1] {
+			t.Fatalf("%s: k3 not in the second shard", c.name)
+		}
+
+		iter, err := db.Iterator()
+		if err != nil {
+			t.Fatalf("%s: iterator: %v", c.name, err)
+		}
+		var keys []string
+		step := func(k, _ []byte) {
+			keys = append(keys, string(k))
+		}
+		step(iter.Seek([]byte("k4")))
+		step(iter.Next())
+		step(iter.Prev())
+		step(iter.Prev())
+		step(iter.Prev())
+		step(iter.Next())
+		step(iter.Next())
+		step(iter.Last())
+		step(iter.Next())
+		if got := strings.Join(keys, ","); got != "k4,k5,k4,k3,k2,k3,k4,k8," {
+			t.Fatalf("%s: iterator steps: got %s", c.name, got)
+		}
+		if err = iter.Close(); err != nil {
+			t.Fatalf("%s: close iterator: %v", c.name, err)
+		}
+		if err = db.Close(); err != nil {
+			t.Fatalf("%s: close: %v", c.name, err)
+		}
+	}
+
+	shards := []DB{NewMemDB(), NewMemDB(), NewMemDB()}
+	for _, splits := range [][][]byte{nil, {[]byte("a")}, {[]byte("b"), []byte("a")}} {
+		if _, err := NewSharded(shards, ShardByRange(splits...)); err == nil {
+			t.Fatalf("new sharded with splits %q: expected error", splits)
+		}
+	}
+}
@@ -0,0 +1,358 @@
+// Package sketch maintains approximate counters as database values: a
+// HyperLogLog estimating the number of distinct items and a count-min
+// sketch estimating the frequency of each item.
+//
+// The helpers read, update and write a sketch inside a transaction, so
+// counts can be maintained together with the data they describe:
+//
+//	err := sketch.AddHLL(txn, []byte("visitors/2024-05-01"), 14, []byte(userID))
+//	...
+//	n, err := sketch.EstimateHLL(txn, []byte("visitors/2024-05-01"))
+//
+// Sketches of the same shape are merged with MergeHLL and MergeCountMin,
+// e.g. to roll daily counts up into a month.
+package sketch
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/bits"
+
+	"github.com/mars9/backend"
+)
+
+var (
+	errInvalidSketch = errors.New("sketch: invalid encoding")
+	errPrecision     = errors.New("sketch: precision out of range")
+	errShape         = errors.New("sketch: shape mismatch")
+)
+
+const (
+	// MinPrecision and MaxPrecision bound the HyperLogLog precision. A
+	// precision p uses 2^p one byte registers and has a standard error of
+	// about 1.04/sqrt(2^p), e.g. 0.8% at p = 14.
+	MinPrecision = 4
+	MaxPrecision = 18
+)
+
+// hash returns a well mixed 64 bit hash of item.
+func hash(item []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(item)
+	x := h.Sum64()
+	// splitmix64 finalizer, fnv alone is biased in the high bits
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+// HLL is a HyperLogLog sketch.
+type HLL struct {
+	p   uint8
+	reg []uint8
+}
+
+// NewHLL returns an empty sketch with the given precision.
+func NewHLL(precision int) (*HLL, error) {
+	if precision < MinPrecision || precision > MaxPrecision {
+		return nil, errPrecision
+	}
+	return &HLL{p: uint8(precision), reg: make([]uint8, 1<<precision)}, nil
+}
+
+// Add adds item to the sketch.
+func (s *HLL) Add(item []byte) {
+	x := hash(item)
+	i := x >> (64 - s.p)
+	rank := uint8(bits.LeadingZeros64(x<<s.p|1<<(s.p-1))) + 1
+	if rank > s.reg[i] {
+		s.reg[i] = rank
+	}
+}
+
+// Estimate returns the estimated number of distinct items added.
+func (s *HLL) Estimate() uint64 {
+	m := float64(len(s.reg))
+	sum, zeros := 0.0, 0
+	for _, r := range s.reg {
+		sum += 1 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+	var alpha float64
+	switch len(s.reg) {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	default:
+		alpha = 0.7213 / (1 + 1.079/m)
+	}
+	e := alpha * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		// linear counting is more accurate for small cardinalities
+		e = m * math.Log(m/float64(zeros))
+	}
+	return uint64(e + 0.5)
+}
+
+// Merge adds all items of o to s. Both sketches must have the same
+// precision.
+func (s *HLL) Merge(o *HLL) error {
+	if s.p != o.p {
+		return errShape
+	}
+	for i, r := range o.reg {
+		if r > s.reg[i] {
+			s.reg[i] = r
+		}
+	}
+	return nil
+}
+
+// MarshalBinary encodes the sketch as its precision followed by its
+// registers.
+func (s *HLL) MarshalBinary() ([]byte, error) {
+	return append([]byte{s.p}, s.reg...), nil
+}
+
+// UnmarshalBinary decodes a sketch encoded by MarshalBinary.
+func (s *HLL) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 || data[0] < MinPrecision || data[0] > MaxPrecision || len(data) != 1+1<<data[0] {
+		return errInvalidSketch
+	}
+	s.p, s.reg = data[0], append([]uint8(nil), data[1:]...)
+	return nil
+}
+
+// CountMin is a count-min sketch. Counts are never underestimated and
+// overestimated by at most about 2N/width with probability 1-2^-depth,
+// where N is the total count added.
+type CountMin struct {
+	width, depth int
+	counts       []uint64
+}
+
+// NewCountMin returns an empty sketch of depth rows of width counters.
+func NewCountMin(width, depth int) (*CountMin, error) {
+	if width <= 0 || depth <= 0 || width > 1<<24 || depth > 64 {
+		return nil, errShape
+	}
+	return &CountMin{width: width, depth: depth, counts: make([]uint64, width*depth)}, nil
+}
+
+// cells calls fn with the counter index of item in every row.
+func (s *CountMin) cells(item []byte, fn func(i int)) {
+	x := hash(item)
+	h1, h2 := x&math.MaxUint32, x>>32|1
+	for row := 0; row < s.depth; row++ {
+		fn(row*s.width + int((h1+uint64(row)*h2)%uint64(s.width)))
+	}
+}
+
+// Add adds n to the count of item.
+func (s *CountMin) Add(item []byte, n uint64) {
+	s.cells(item, func(i int) { s.counts[i] += n })
+}
+
+// Count returns the estimated count of item.
+func (s *CountMin) Count(item []byte) uint64 {
+	min := uint64(math.MaxUint64)
+	s.cells(item, func(i int) {
+		if s.counts[i] < min {
+			min = s.counts[i]
+		}
+	})
+	return min
+}
+
+// Merge adds all counts of o to s. Both sketches must have the same
+// width and depth.
+func (s *CountMin) Merge(o *CountMin) error {
+	if s.width != o.width || s.depth != o.depth {
+		return errShape
+	}
+	for i, n := range o.counts {
+		s.counts[i] += n
+	}
+	return nil
+}
+
+// MarshalBinary encodes the sketch as its width and depth followed by
+// its counters, all big endian.
+func (s *CountMin) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 8, 8+8*len(s.counts))
+	binary.BigEndian.PutUint32(buf, uint32(s.width))
+	binary.BigEndian.PutUint32(buf[4:], uint32(s.depth))
+	for _, n := range s.counts {
+		buf = binary.BigEndian.AppendUint64(buf, n)
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary decodes a sketch encoded by MarshalBinary.
+func (s *CountMin) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 {
+		return errInvalidSketch
+	}
+	n, err := NewCountMin(int(binary.BigEndian.Uint32(data)), int(binary.BigEndian.Uint32(data[4:])))
+	if err != nil || len(data) != 8+8*len(n.counts) {
+		return errInvalidSketch
+	}
+	for i := range n.counts {
+		n.counts[i] = binary.BigEndian.Uint64(data[8+8*i:])
+	}
+	*s = *n
+	return nil
+}
+
+// GetHLL returns the sketch stored under key. It returns
+// backend.ErrNotFound if the key does not exist.
+func GetHLL(txn backend.Txn, key []byte) (*HLL, error) {
+	value, err := txn.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	s := &HLL{}
+	if err = s.UnmarshalBinary(value); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// AddHLL adds items to the sketch stored under key. A missing sketch is
+// created with the given precision.
+func AddHLL(txn backend.RWTxn, key []byte, precision int, items ...[]byte) error {
+	s, err := GetHLL(txn, key)
+	if err == backend.ErrNotFound {
+		s, err = NewHLL(precision)
+	}
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		s.Add(item)
+	}
+	return putSketch(txn, key, s)
+}
+
+// EstimateHLL returns the estimated number of distinct items in the
+// sketch stored under key, or 0 if the key does not exist.
+func EstimateHLL(txn backend.Txn, key []byte) (uint64, error) {
+	s, err := GetHLL(txn, key)
+	if err == backend.ErrNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return s.Estimate(), nil
+}
+
+// MergeHLL merges the sketches stored under srcs into the sketch stored
+// under dst. Missing sources are skipped; a missing dst is created with
+// the precision of the first source.
+func MergeHLL(txn backend.RWTxn, dst []byte, srcs ...[]byte) error {
+	s, err := GetHLL(txn, dst)
+	if err != nil && err != backend.ErrNotFound {
+		return err
+	}
+	for _, src := range srcs {
+		o, err := GetHLL(txn, src)
+		if err == backend.ErrNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if s == nil {
+			s = o
+		} else if err = s.Merge(o); err != nil {
+			return err
+		}
+	}
+	if s == nil {
+		return nil
+	}
+	return putSketch(txn, dst, s)
+}
+
+// GetCountMin returns the sketch stored under key. It returns
+// backend.ErrNotFound if the key does not exist.
+func GetCountMin(txn backend.Txn, key []byte) (*CountMin, error) {
+	value, err := txn.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	s := &CountMin{}
+	if err = s.UnmarshalBinary(value); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// AddCount adds n to the count of item in the sketch stored under key. A
+// missing sketch is created with the given width and depth.
+func AddCount(txn backend.RWTxn, key []byte, width, depth int, item []byte, n uint64) error {
+	s, err := GetCountMin(txn, key)
+	if err == backend.ErrNotFound {
+		s, err = NewCountMin(width, depth)
+	}
+	if err != nil {
+		return err
+	}
+	s.Add(item, n)
+	return putSketch(txn, key, s)
+}
+
+// Count returns the estimated count of item in the sketch stored under
+// key, or 0 if the key does not exist.
+func Count(txn backend.Txn, key, item []byte) (uint64, error) {
+	s, err := GetCountMin(txn, key)
+	if err == backend.ErrNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return s.Count(item), nil
+}
+
+// MergeCountMin merges the sketches stored under srcs into the sketch
+// stored under dst. Missing sources are skipped; a missing dst is
+// created with the shape of the first source.
+func MergeCountMin(txn backend.RWTxn, dst []byte, srcs ...[]byte) error {
+	s, err := GetCountMin(txn, dst)
+	if err != nil && err != backend.ErrNotFound {
+		return err
+	}
+	for _, src := range srcs {
+		o, err := GetCountMin(txn, src)
+		if err == backend.ErrNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if s == nil {
+			s = o
+		} else if err = s.Merge(o); err != nil {
+			return err
+		}
+	}
+	if s == nil {
+		return nil
+	}
+	return putSketch(txn, dst, s)
+}
+
+func putSketch(txn backend.RWTxn, key []byte, s interface{ MarshalBinary() ([]byte, error) }) error {
+	value, err := s.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return txn.Put(key, value)
+}
@@ -0,0 +1,134 @@
+package sketch
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mars9/backend"
+)
+
+func TestHLL(t *testing.T) {
+	for _, n := range []int{0, 10, 1000, 100000} {
+		s, err := NewHLL(14)
+		if err != nil {
+			t.Fatalf("new: %v", err)
+		}
+		for i := 0; i < n; i++ {
+			item := []byte(fmt.Sprintf("item-%d", i))
+			s.Add(item)
+			s.Add(item)
+		}
+		if e := float64(s.Estimate()); math.Abs(e-float64(n)) > 0.03*float64(n)+1 {
+			t.Fatalf("estimate %d: got %.0f", n, e)
+		}
+	}
+	if _, err := NewHLL(3); err != errPrecision {
+		t.Fatalf("new: expected errPrecision, got %v", err)
+	}
+
+	a, _ := NewHLL(10)
+	b, _ := NewHLL(10)
+	for i := 0; i < 500; i++ {
+		a.Add([]byte(fmt.Sprint(i)))
+		b.Add([]byte(fmt.Sprint(i + 250)))
+	}
+	if err := a.Merge(b); err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	if e := float64(a.Estimate()); math.Abs(e-750) > 60 {
+		t.Fatalf("merged estimate: got %.0f", e)
+	}
+	c, _ := NewHLL(12)
+	if err := a.Merge(c); err != errShape {
+		t.Fatalf("merge: expected errShape, got %v", err)
+	}
+
+	data, _ := a.MarshalBinary()
+	r := &HLL{}
+	if err := r.UnmarshalBinary(data); err != nil || r.Estimate() != a.Estimate() {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if err := r.UnmarshalBinary(data[:100]); err != errInvalidSketch {
+		t.Fatalf("unmarshal truncated: expected errInvalidSketch, got %v", err)
+	}
+}
+
+func TestCountMin(t *testing.T) {
+	s, err := NewCountMin(1024, 4)
+	if err != nil {
+		t.Fatalf("new: %v", err)
+	}
+	for i := 0; i < 1000; i++ {
+		s.Add([]byte(fmt.Sprint(i%100)), 1)
+	}
+	s.Add([]byte("hot"), 5000)
+	if n := s.Count([]byte("hot")); n < 5000 || n > 5020 {
+		t.Fatalf("count hot: got %d", n)
+	}
+	if n := s.Count([]byte("7")); n < 10 || n > 30 {
+		t.Fatalf("count 7: got %d", n)
+	}
+
+	o, _ := NewCountMin(1024, 4)
+	o.Add([]byte("hot"), 1)
+	if err = s.Merge(o); err != nil {
+		t.Fatalf("merge: %v", err)
+	}
+	if n := s.Count([]byte("hot")); n < 5001 {
+		t.Fatalf("merged count: got %d", n)
+	}
+
+	data, _ := s.MarshalBinary()
+	r := &CountMin{}
+	if err = r.UnmarshalBinary(data); err != nil || r.Count([]byte("hot")) != s.Count([]byte("hot")) {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if err = r.UnmarshalBinary(data[:20]); err != errInvalidSketch {
+		t.Fatalf("unmarshal truncated: expected errInvalidSketch, got %v", err)
+	}
+}
+
+func TestTxn(t *testing.T) {
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("sketch-%d.db", os.Getpid()))
+	db, err := backend.OpenBoltDB(path, 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer os.Remove(path)
+	defer db.Close()
+
+	err = backend.Update(db, func(txn backend.RWTxn) error {
+		for i := 0; i < 100; i++ {
+			day := []byte(fmt.Sprintf("day%d", i%2))
+			if err := AddHLL(txn, day, 12, []byte(fmt.Sprint(i%60))); err != nil {
+				return err
+			}
+			if err := AddCount(txn, []byte("pages"), 256, 4, []byte(fmt.Sprint(i%5)), 1); err != nil {
+				return err
+			}
+		}
+		return MergeHLL(txn, []byte("month"), []byte("day0"), []byte("day1"), []byte("day2"))
+	})
+	if err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	err = backend.View(db, func(txn backend.Txn) error {
+		if n, err := EstimateHLL(txn, []byte("month")); err != nil || n != 60 {
+			return fmt.Errorf("estimate month: %d %v", n, err)
+		}
+		if n, err := EstimateHLL(txn, []byte("missing")); err != nil || n != 0 {
+			return fmt.Errorf("estimate missing: %d %v", n, err)
+		}
+		if n, err := Count(txn, []byte("pages"), []byte("3")); err != nil || n != 20 {
+			return fmt.Errorf("count: %d %v", n, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
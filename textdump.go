@@ -0,0 +1,125 @@
+package backend
+
+import (
+	"bufio"
+	"encoding/hex"
+	"io"
+	"strings"
+)
+
+// ErrInvalidTextDump is returned when reading a malformed text dump.
+const ErrInvalidTextDump Error = Error("invalid text dump")
+
+const (
+	textDumpSeparator = " ==> "
+	textDumpBatch     = 1000
+)
+
+// DumpText writes all key/value pairs of db to w in the text format of
+// the LevelDB/RocksDB ldb tool with hex encoding enabled, one pair per
+// line:
+//
+//	0x6B6579 ==> 0x76616C7565
+//
+// The output can be loaded with "ldb load --hex" and read back with
+// LoadText. It returns the number of bytes written.
+func DumpText(w io.Writer, db DB) (int64, error) {
+	iter, err := db.Iterator()
+	if err != nil {
+		return 0, err
+	}
+	defer iter.Close()
+
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	var line []byte
+	for k, v := iter.First(); k != nil; k, v = iter.Next() {
+		line = appendHex(line[:0], k)
+		line = append(line, textDumpSeparator...)
+		line = appendHex(line, v)
+		line = append(line, '\n')
+		if _, err = bw.Write(line); err != nil {
+			return cw.n, err
+		}
+	}
+	if err = bw.Flush(); err != nil {
+		return cw.n, err
+	}
+	return cw.n, iter.Close()
+}
+
+// appendHex appends the upper case hex encoding of src as used by ldb.
+func appendHex(b, src []byte) []byte {
+	const digits = "0123456789ABCDEF"
+	b = append(b, "0x"...)
+	for _, c := range src {
+		b = append(b, digits[c>>4], digits[c&0x0f])
+	}
+	return b
+}
+
+// LoadText reads a hex encoded text dump as written by DumpText or
+// "ldb dump --hex" from r and stores all key/value pairs in db. Empty
+// lines are ignored. Pairs are written in batches, each in its own
+// transaction.
+func LoadText(db DB, r io.Reader) error {
+	type pair struct{ key, value []byte }
+
+	batch := make([]pair, 0, textDumpBatch)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		txn, err := db.Writable()
+		if err != nil {
+			return err
+		}
+		for _, p := range batch {
+			if err = txn.Put(p.key, p.value); err != nil {
+				txn.Rollback()
+				return err
+			}
+		}
+		batch = batch[:0]
+		return txn.Commit()
+	}
+
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, maxExportBlockSize)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		i := strings.Index(line, textDumpSeparator)
+		if i < 0 {
+			return ErrInvalidTextDump
+		}
+		key, err := decodeHex(line[:i])
+		if err != nil || len(key) == 0 {
+			return ErrInvalidTextDump
+		}
+		value, err := decodeHex(line[i+len(textDumpSeparator):])
+		if err != nil {
+			return ErrInvalidTextDump
+		}
+
+		batch = append(batch, pair{key, value})
+		if len(batch) == textDumpBatch {
+			if err = flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	return flush()
+}
+
+func decodeHex(s string) ([]byte, error) {
+	if !strings.HasPrefix(s, "0x") && !strings.HasPrefix(s, "0X") {
+		return nil, ErrInvalidTextDump
+	}
+	return hex.DecodeString(s[2:])
+}
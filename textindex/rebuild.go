@@ -0,0 +1,243 @@
+package textindex
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/mars9/backend"
+	"github.com/mars9/backend/keys"
+)
+
+const defaultRebuildBatch = 1000
+
+var errIndexMismatch = errors.New("textindex: index does not match values")
+
+// Rebuild phases stored in the progress record.
+const (
+	phaseIndex = 'i' // adding entries for all keys
+	phasePrune = 'p' // removing entries not derived from current values
+)
+
+// RebuildOption configures a Rebuild.
+type RebuildOption func(*rebuilder) error
+
+type rebuilder struct {
+	batch    int
+	progress func(keys int64)
+}
+
+// RebuildBatch sets the number of keys written per transaction.
+func RebuildBatch(n int) RebuildOption {
+	return func(r *rebuilder) error {
+		if n <= 0 {
+			return errors.New("textindex: rebuild batch must be positive")
+		}
+		r.batch = n
+		return nil
+	}
+}
+
+// RebuildProgress calls fn after every batch with the number of keys
+// processed so far.
+func RebuildProgress(fn func(keys int64)) RebuildOption {
+	return func(r *rebuilder) error {
+		r.progress = fn
+		return nil
+	}
+}
+
+// progressKey returns the key of the rebuild progress record of ix. The
+// 0x00 0x02 separator sorts after every encoded index name.
+func (db *DB) progressKey(ix *index) []byte {
+	return keys.AppendString(append(append([]byte{}, db.prefix...), 0x00, 0x02), ix.name)
+}
+
+// Rebuild rewrites the index name from the current values, e.g. after
+// its extractor changed. It adds the entries of all keys and then
+// removes entries no longer derived from their values, both in batches.
+// Values are read inside the write transaction of their batch, so writes
+// through the DB may continue during the rebuild.
+//
+// The position of the rebuild is stored with every batch; calling
+// Rebuild again after a failure continues where it stopped. At the end,
+// the number of index entries is compared to the number derived from
+// the values in a single snapshot. Rebuild returns the number of keys
+// processed.
+func (db *DB) Rebuild(name string, opts ...RebuildOption) (int64, error) {
+	r := &rebuilder{batch: defaultRebuildBatch}
+	for _, opt := range opts {
+		if err := opt(r); err != nil {
+			return 0, err
+		}
+	}
+	ix, err := db.index(name)
+	if err != nil {
+		return 0, err
+	}
+
+	pkey := db.progressKey(ix)
+	phase, last := byte(phaseIndex), []byte(nil)
+	err = backend.View(db.DB, func(txn backend.Txn) error {
+		v, err := txn.Get(pkey)
+		if err == backend.ErrNotFound {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if len(v) == 0 || (v[0] != phaseIndex && v[0] != phasePrune) {
+			return errCorruptIndex
+		}
+		phase, last = v[0], append([]byte{}, v[1:]...)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var n int64
+	for {
+		var start []byte
+		if last != nil {
+			start = append(append([]byte{}, last...), 0x00)
+		} else if phase == phasePrune {
+			start = ix.prefix
+		}
+		batch, err := db.collect(ix, phase, start, r.batch)
+		if err != nil {
+			return n, err
+		}
+		if len(batch) == 0 {
+			if phase == phasePrune {
+				break
+			}
+			phase, last = phasePrune, nil
+			continue
+		}
+		last = batch[len(batch)-1]
+
+		err = backend.Update(db.DB, func(txn backend.RWTxn) error {
+			if phase == phaseIndex {
+				err = indexKeys(txn, ix, batch)
+			} else {
+				err = pruneEntries(txn, ix, batch)
+			}
+			if err != nil {
+				return err
+			}
+			return txn.Put(pkey, append([]byte{phase}, last...))
+		})
+		if err != nil {
+			return n, err
+		}
+		n += int64(len(batch))
+		if r.progress != nil {
+			r.progress(n)
+		}
+	}
+
+	if err = backend.Update(db.DB, func(txn backend.RWTxn) error { return txn.Delete(pkey) }); err != nil {
+		return n, err
+	}
+	return n, db.verify(ix)
+}
+
+// collect returns up to n keys starting at start: primary keys in the
+// index phase, entries of ix in the prune phase.
+func (db *DB) collect(ix *index, phase byte, start []byte, n int) ([][]byte, error) {
+	iter, err := db.DB.Iterator()
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var batch [][]byte
+	for k, _ := iter.Seek(start); k != nil && len(batch) < n; k, _ = iter.Next() {
+		if phase == phasePrune {
+			if !bytes.HasPrefix(k, ix.prefix) {
+				break
+			}
+		} else if bytes.HasPrefix(k, db.prefix) {
+			continue
+		}
+		batch = append(batch, append([]byte{}, k...))
+	}
+	return batch, iter.Close()
+}
+
+// indexKeys adds the entries of the current values of keys.
+func indexKeys(txn backend.RWTxn, ix *index, keys [][]byte) error {
+	for _, key := range keys {
+		v, err := txn.Get(key)
+		if err == backend.ErrNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+		for _, term := range Terms(ix.extract(key, v)) {
+			if err = txn.Put(ix.entry(term, key), []byte{}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// pruneEntries deletes the entries whose term is not derived from the
+// current value of their key.
+func pruneEntries(txn backend.RWTxn, ix *index, entries [][]byte) error {
+	for _, e := range entries {
+		p := keys.NewParser(e[len(ix.prefix):])
+		term := p.String()
+		if p.Err() != nil {
+			return errCorruptIndex
+		}
+		key := p.Rest()
+
+		v, err := txn.Get(key)
+		if err != nil && err != backend.ErrNotFound {
+			return err
+		}
+		stale := err == backend.ErrNotFound
+		if !stale {
+			stale = true
+			for _, t := range Terms(ix.extract(key, v)) {
+				if t == term {
+					stale = false
+					break
+				}
+			}
+		}
+		if stale {
+			if err = txn.Delete(e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// verify compares the number of entries of ix with the number derived
+// from the values, both read from one iterator snapshot.
+func (db *DB) verify(ix *index) error {
+	iter, err := db.DB.Iterator()
+	if err != nil {
+		return err
+	}
+	defer iter.Close()
+
+	var expected, actual int
+	for k, v := iter.First(); k != nil; k, v = iter.Next() {
+		if bytes.HasPrefix(k, ix.prefix) {
+			actual++
+		} else if !bytes.HasPrefix(k, db.prefix) {
+			expected += len(Terms(ix.extract(k, v)))
+		}
+	}
+	if err = iter.Close(); err != nil {
+		return err
+	}
+	if expected != actual {
+		return errIndexMismatch
+	}
+	return nil
+}
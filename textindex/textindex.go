@@ -0,0 +1,288 @@
+// Package textindex maintains full-text inverted indexes over the values
+// of a backend.DB. Indexes are updated in the write transaction that
+// changes a key, so search results never disagree with committed data:
+//
+//	db := textindex.New(bolt, []byte("fts/"))
+//	db.Register("title", func(key, value []byte) string { return titleOf(value) })
+//	...
+//	keys, err := db.Search("title", textindex.And(textindex.Term("go"), textindex.Prefix("data")))
+//
+// Text is split into terms at every rune that is neither a letter nor a
+// digit, and terms are lower cased.
+package textindex
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/mars9/backend"
+	"github.com/mars9/backend/keys"
+)
+
+var (
+	errIndexExists  = errors.New("textindex: index exists")
+	errNoIndex      = errors.New("textindex: unknown index")
+	errCorruptIndex = errors.New("textindex: corrupt index key")
+)
+
+// Extractor returns the text of value stored under key to index, or ""
+// if the pair is not indexed.
+type Extractor func(key, value []byte) string
+
+type index struct {
+	name    string
+	prefix  []byte // index prefix + encoded name
+	extract Extractor
+}
+
+// DB wraps a backend.DB and maintains the registered text indexes on
+// writes through Writable. Index entries are stored below prefix, keys
+// below prefix are never indexed. Writes that bypass the DB are not
+// indexed.
+type DB struct {
+	backend.DB
+
+	prefix  []byte
+	mu      sync.RWMutex
+	indexes []*index
+}
+
+// New returns a DB storing its indexes below prefix in db.
+func New(db backend.DB, prefix []byte) *DB {
+	return &DB{DB: db, prefix: append([]byte{}, prefix...)}
+}
+
+// Register adds the index name fed by extract. Keys written before the
+// index was registered are indexed by Rebuild.
+func (db *DB) Register(name string, extract Extractor) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, ix := range db.indexes {
+		if ix.name == name {
+			return errIndexExists
+		}
+	}
+	db.indexes = append(db.indexes, &index{
+		name:    name,
+		prefix:  keys.AppendString(append([]byte{}, db.prefix...), name),
+		extract: extract,
+	})
+	return nil
+}
+
+func (db *DB) index(name string) (*index, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	for _, ix := range db.indexes {
+		if ix.name == name {
+			return ix, nil
+		}
+	}
+	return nil, errNoIndex
+}
+
+// Terms splits text into lower cased, deduplicated terms.
+func Terms(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	sort.Strings(fields)
+	terms := fields[:0]
+	for i, f := range fields {
+		if i == 0 || f != fields[i-1] {
+			terms = append(terms, f)
+		}
+	}
+	return terms
+}
+
+// entry returns the index key of key for term.
+func (ix *index) entry(term string, key []byte) []byte {
+	return append(keys.AppendString(append([]byte{}, ix.prefix...), term), key...)
+}
+
+// Writable starts a new write transaction maintaining all registered
+// indexes.
+func (db *DB) Writable() (backend.RWTxn, error) {
+	txn, err := db.DB.Writable()
+	if err != nil {
+		return nil, err
+	}
+	db.mu.RLock()
+	indexes := append([]*index{}, db.indexes...)
+	db.mu.RUnlock()
+	return &indexTxn{RWTxn: txn, db: db, indexes: indexes}, nil
+}
+
+type indexTxn struct {
+	backend.RWTxn
+	db      *DB
+	indexes []*index
+}
+
+// update replaces the index entries of key for its old value, if
+// exists, with those for value, if not nil.
+func (t *indexTxn) update(key, value []byte) error {
+	if bytes.HasPrefix(key, t.db.prefix) || len(t.indexes) == 0 {
+		return nil
+	}
+	old, err := t.RWTxn.Get(key)
+	if err == backend.ErrNotFound {
+		old = nil
+	} else if err != nil {
+		return err
+	}
+	for _, ix := range t.indexes {
+		var oldTerms, newTerms []string
+		if old != nil {
+			oldTerms = Terms(ix.extract(key, old))
+		}
+		if value != nil {
+			newTerms = Terms(ix.extract(key, value))
+		}
+		for _, term := range oldTerms {
+			if err = t.RWTxn.Delete(ix.entry(term, key)); err != nil {
+				return err
+			}
+		}
+		for _, term := range newTerms {
+			if err = t.RWTxn.Put(ix.entry(term, key), []byte{}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (t *indexTxn) Put(key, value []byte) error {
+	if value == nil {
+		value = []byte{}
+	}
+	if err := t.update(key, value); err != nil {
+		return err
+	}
+	return t.RWTxn.Put(key, value)
+}
+
+func (t *indexTxn) Delete(key []byte) error {
+	if err := t.update(key, nil); err != nil {
+		return err
+	}
+	return t.RWTxn.Delete(key)
+}
+
+// Query selects keys of an index.
+type Query func(ix *index, db backend.DB) ([][]byte, error)
+
+// Term matches keys whose text contains the term. term is lower cased.
+func Term(term string) Query {
+	return func(ix *index, db backend.DB) ([][]byte, error) {
+		return ix.scan(db, keys.AppendString(append([]byte{}, ix.prefix...), strings.ToLower(term)))
+	}
+}
+
+// Prefix matches keys whose text contains a term starting with prefix.
+// prefix is lower cased.
+func Prefix(prefix string) Query {
+	return func(ix *index, db backend.DB) ([][]byte, error) {
+		// the encoding without terminator prefixes all longer terms
+		p := keys.AppendString(append([]byte{}, ix.prefix...), strings.ToLower(prefix))
+		return ix.scan(db, p[:len(p)-2])
+	}
+}
+
+// And matches keys matched by all queries.
+func And(queries ...Query) Query {
+	return func(ix *index, db backend.DB) ([][]byte, error) {
+		var result [][]byte
+		for i, q := range queries {
+			keys, err := q(ix, db)
+			if err != nil {
+				return nil, err
+			}
+			if i == 0 {
+				result = keys
+				continue
+			}
+			result = intersect(result, keys)
+		}
+		return result, nil
+	}
+}
+
+// Or matches keys matched by any of the queries.
+func Or(queries ...Query) Query {
+	return func(ix *index, db backend.DB) ([][]byte, error) {
+		var all [][]byte
+		for _, q := range queries {
+			keys, err := q(ix, db)
+			if err != nil {
+				return nil, err
+			}
+			all = append(all, keys...)
+		}
+		return unique(all), nil
+	}
+}
+
+// Search returns the keys matching q in the index name in ascending
+// order.
+func (db *DB) Search(name string, q Query) ([][]byte, error) {
+	ix, err := db.index(name)
+	if err != nil {
+		return nil, err
+	}
+	return q(ix, db.DB)
+}
+
+// scan returns the sorted, deduplicated keys of all index entries below
+// prefix.
+func (ix *index) scan(db backend.DB, prefix []byte) ([][]byte, error) {
+	iter, err := db.Iterator()
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+
+	var result [][]byte
+	for k, _ := iter.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = iter.Next() {
+		p := keys.NewParser(k[len(ix.prefix):])
+		if term := p.String(); term == "" || p.Err() != nil {
+			return nil, errCorruptIndex
+		}
+		result = append(result, append([]byte{}, p.Rest()...))
+	}
+	return unique(result), iter.Close()
+}
+
+func unique(keys [][]byte) [][]byte {
+	sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) < 0 })
+	result := keys[:0]
+	for i, k := range keys {
+		if i == 0 || !bytes.Equal(k, keys[i-1]) {
+			result = append(result, k)
+		}
+	}
+	return result
+}
+
+// intersect returns the keys contained in both sorted slices.
+func intersect(a, b [][]byte) [][]byte {
+	var result [][]byte
+	for len(a) > 0 && len(b) > 0 {
+		switch c := bytes.Compare(a[0], b[0]); {
+		case c < 0:
+			a = a[1:]
+		case c > 0:
+			b = b[1:]
+		default:
+			result = append(result, a[0])
+			a, b = a[1:], b[1:]
+		}
+	}
+	return result
+}
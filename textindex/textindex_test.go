@@ -0,0 +1,130 @@
+package textindex
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mars9/backend"
+)
+
+func TestTextIndex(t *testing.T) {
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("textindex-%d.db", os.Getpid()))
+	bolt, err := backend.OpenBoltDB(path, 0)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer os.Remove(path)
+	defer bolt.Close()
+
+	db := New(bolt, []byte("fts/"))
+	if err = db.Register("body", func(key, value []byte) string { return string(value) }); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if err = db.Register("body", nil); err != errIndexExists {
+		t.Fatalf("register twice: expected errIndexExists, got %v", err)
+	}
+
+	put := func(key, value string) {
+		txn, err := db.Writable()
+		if err != nil {
+			t.Fatalf("writable: %v", err)
+		}
+		if value == "" {
+			err = txn.Delete([]byte(key))
+		} else {
+			err = txn.Put([]byte(key), []byte(value))
+		}
+		if err != nil {
+			t.Fatalf("write %q: %v", key, err)
+		}
+		if err = txn.Commit(); err != nil {
+			t.Fatalf("commit: %v", err)
+		}
+	}
+	search := func(q Query, want ...string) {
+		t.Helper()
+		keys, err := db.Search("body", q)
+		if err != nil {
+			t.Fatalf("search: %v", err)
+		}
+		got := make([]string, len(keys))
+		for i, k := range keys {
+			got[i] = string(k)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("search: expected %v, got %v", want, got)
+		}
+	}
+
+	put("a", "The quick brown Fox")
+	put("b", "a lazy dog, quick!")
+	put("c", "foxes and dogs")
+
+	search(Term("quick"), "a", "b")
+	search(Term("QUICK"), "a", "b")
+	search(Prefix("fox"), "a", "c")
+	search(And(Term("quick"), Prefix("do")), "b")
+	search(Or(Term("brown"), Term("lazy")), "a", "b")
+	search(And(Term("quick"), Term("foxes")))
+
+	put("a", "slow brown bear")
+	search(Term("quick"), "b")
+	search(Term("bear"), "a")
+	put("b", "")
+	search(Term("quick"))
+
+	if _, err = db.Search("title", Term("x")); err != errNoIndex {
+		t.Fatalf("search unknown: expected errNoIndex, got %v", err)
+	}
+
+	// keys written before registration are found after a rebuild
+	if err = db.Register("key", func(key, value []byte) string { return string(key) }); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	var progress []int64
+	n, err := db.Rebuild("key", RebuildBatch(1), RebuildProgress(func(n int64) { progress = append(progress, n) }))
+	if err != nil {
+		t.Fatalf("rebuild: %v", err)
+	}
+	// two keys are indexed, then their two entries checked
+	if n != 4 || fmt.Sprint(progress) != "[1 2 3 4]" {
+		t.Fatalf("rebuild: expected 4 keys, got %d %v", n, progress)
+	}
+	searchIn := func(name string, q Query, want ...string) {
+		t.Helper()
+		keys, err := db.Search(name, q)
+		if err != nil {
+			t.Fatalf("search: %v", err)
+		}
+		got := make([]string, len(keys))
+		for i, k := range keys {
+			got[i] = string(k)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("search: expected %v, got %v", want, got)
+		}
+	}
+	searchIn("key", Or(Term("a"), Term("c")), "a", "c")
+
+	// a stale entry is pruned, unless a resumed rebuild already passed it
+	ix, _ := db.index("key")
+	stale := ix.entry("0", []byte("c"))
+	write := func(key, value []byte) {
+		if err := backend.Update(bolt, func(txn backend.RWTxn) error { return txn.Put(key, value) }); err != nil {
+			t.Fatalf("put: %v", err)
+		}
+	}
+	write(stale, []byte{})
+	write(db.progressKey(ix), append([]byte{phasePrune}, ix.entry("a", []byte("a"))...))
+	if _, err = db.Rebuild("key"); err != errIndexMismatch {
+		t.Fatalf("resumed rebuild: expected errIndexMismatch, got %v", err)
+	}
+	searchIn("key", Term("0"), "c")
+	if _, err = db.Rebuild("key"); err != nil {
+		t.Fatalf("rebuild: %v", err)
+	}
+	searchIn("key", Term("0"))
+	searchIn("key", Prefix(""), "a", "c")
+}
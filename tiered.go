@@ -0,0 +1,264 @@
+package backend
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+)
+
+// Eviction selects the entry a Tiered cache drops when it is full.
+type Eviction int
+
+const (
+	// EvictLRU drops the least recently used entry.
+	EvictLRU Eviction = iota
+	// EvictFIFO drops the oldest entry; hits do not keep an entry cached,
+	// which suits scans touching every key once.
+	EvictFIFO
+)
+
+// TieredOption configures a Tiered.
+type TieredOption func(*Tiered) error
+
+// CacheSize limits the cached keys and values to n bytes. The default is
+// 64 MiB.
+func CacheSize(n int64) TieredOption {
+	return func(t *Tiered) error {
+		if n < 0 {
+			return errors.New("negative cache size")
+		}
+		t.maxSize = n
+		return nil
+	}
+}
+
+// CacheEviction sets the eviction policy, EvictLRU by default.
+func CacheEviction(e Eviction) TieredOption {
+	return func(t *Tiered) error {
+		if e != EvictLRU && e != EvictFIFO {
+			return errors.New("unknown eviction policy")
+		}
+		t.evict = e
+		return nil
+	}
+}
+
+// Tiered wraps a persistent DB with an in-memory read cache. Get in a
+// read-only transaction is served from the cache if possible, misses and
+// absent keys are cached. Writes go through to the DB: a commit drops the
+// written keys from the cache before it is applied and caches the new
+// values once it succeeded, so no transaction sees a value its snapshot
+// does not hold. Writes that bypass the Tiered are not observed.
+//
+// Iterators and write transactions are not cached. Values returned from
+// the cache are shared and must not be modified.
+type Tiered struct {
+	DB
+
+	maxSize int64
+	evict   Eviction
+
+	mu         sync.Mutex
+	entries    map[string]*list.Element
+	order      *list.List // front is evicted last
+	size       int64
+	gen        uint64 // incremented when a commit starts and ends
+	committing int
+}
+
+type tieredEntry struct {
+	key   string
+	value []byte
+	found bool
+	gen   uint64 // generation the entry was cached at
+}
+
+// NewTiered returns a Tiered caching reads of db.
+func NewTiered(db DB, opts ...TieredOption) (*Tiered, error) {
+	t := &Tiered{
+		DB:      db,
+		maxSize: 64 << 20,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+	for _, opt := range opts {
+		if err := opt(t); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// lookup returns the cached entry of key if it is visible to a
+// transaction started at generation gen.
+func (t *Tiered) lookup(key []byte, gen uint64) *tieredEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, found := t.entries[string(key)]
+	if !found || e.Value.(*tieredEntry).gen > gen {
+		return nil
+	}
+	if t.evict == EvictLRU {
+		t.order.MoveToFront(e)
+	}
+	return e.Value.(*tieredEntry)
+}
+
+// fill caches a value read by a transaction started at generation gen,
+// unless a commit started since then and the value might be stale.
+func (t *Tiered) fill(key, value []byte, found bool, gen uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if gen == t.gen && t.committing == 0 {
+		t.store(string(key), append([]byte{}, value...), found)
+	}
+}
+
+// store caches value under key, evicting entries until the cache fits.
+// The caller holds t.mu.
+func (t *Tiered) store(key string, value []byte, found bool) {
+	t.remove(key)
+	size := int64(len(key) + len(value))
+	if size > t.maxSize {
+		return
+	}
+	t.entries[key] = t.order.PushFront(&tieredEntry{key: key, value: value, found: found, gen: t.gen})
+	t.size += size
+	for t.size > t.maxSize {
+		t.remove(t.order.Back().Value.(*tieredEntry).key)
+	}
+}
+
+// remove drops key from the cache. The caller holds t.mu.
+func (t *Tiered) remove(key string) {
+	e, found := t.entries[key]
+	if !found {
+		return
+	}
+	t.order.Remove(e)
+	delete(t.entries, key)
+	t.size -= int64(len(key) + len(e.Value.(*tieredEntry).value))
+}
+
+// Size returns the number of bytes of cached keys and values.
+func (t *Tiered) Size() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.size
+}
+
+// Purge drops all cached entries.
+func (t *Tiered) Purge() {
+	t.mu.Lock()
+	t.gen++
+	t.entries = make(map[string]*list.Element)
+	t.order.Init()
+	t.size = 0
+	t.mu.Unlock()
+}
+
+// Readonly starts a read-only transaction reading through the cache.
+func (t *Tiered) Readonly() (Txn, error) {
+	// The generation is taken first: the transaction may then see a newer
+	// snapshot than the cache entries it uses, but never an older one.
+	t.mu.Lock()
+	gen := t.gen
+	t.mu.Unlock()
+	txn, err := t.DB.Readonly()
+	if err != nil {
+		return nil, err
+	}
+	return &tieredReadTxn{Txn: txn, t: t, gen: gen}, nil
+}
+
+// Writable starts a new write transaction whose commit updates the cache.
+func (t *Tiered) Writable() (RWTxn, error) {
+	txn, err := t.DB.Writable()
+	if err != nil {
+		return nil, err
+	}
+	return &tieredTxn{RWTxn: txn, t: t, writes: make(map[string]tieredWrite)}, nil
+}
+
+// Close drops the cache and closes the DB.
+func (t *Tiered) Close() error {
+	t.Purge()
+	return t.DB.Close()
+}
+
+type tieredReadTxn struct {
+	Txn
+	t   *Tiered
+	gen uint64
+}
+
+func (r *tieredReadTxn) Get(key []byte) ([]byte, error) {
+	if e := r.t.lookup(key, r.gen); e != nil {
+		if !e.found {
+			return nil, ErrNotFound
+		}
+		return e.value, nil
+	}
+	value, err := r.Txn.Get(key)
+	if err == nil || err == ErrNotFound {
+		r.t.fill(key, value, err == nil, r.gen)
+	}
+	return value, err
+}
+
+type tieredWrite struct {
+	value []byte
+	found bool // false for deletes
+}
+
+type tieredTxn struct {
+	RWTxn
+	t      *Tiered
+	writes map[string]tieredWrite // last write of each key
+}
+
+func (w *tieredTxn) Put(key, value []byte) error {
+	if err := w.RWTxn.Put(key, value); err != nil {
+		return err
+	}
+	w.writes[string(key)] = tieredWrite{value: append([]byte{}, value...), found: true}
+	return nil
+}
+
+func (w *tieredTxn) Delete(key []byte) error {
+	if err := w.RWTxn.Delete(key); err != nil {
+		return err
+	}
+	w.writes[string(key)] = tieredWrite{}
+	return nil
+}
+
+func (w *tieredTxn) Commit() error {
+	if len(w.writes) == 0 {
+		return w.RWTxn.Commit()
+	}
+	t := w.t
+	t.mu.Lock()
+	t.gen++
+	t.committing++
+	for key := range w.writes {
+		t.remove(key)
+	}
+	t.mu.Unlock()
+
+	err := w.RWTxn.Commit()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.gen++
+	t.committing--
+	// A commit that started meanwhile may have overwritten the keys in
+	// the DB already; they stay uncached until read again.
+	if err == nil && t.committing == 0 {
+		for key, wr := range w.writes {
+			t.store(key, wr.value, wr.found)
+		}
+	}
+	w.writes = nil
+	return err
+}
@@ -0,0 +1,105 @@
+package backend
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+// getCounter counts the Get calls of read-only transactions.
+type getCounter struct {
+	DB
+	gets int64
+}
+
+func (c *getCounter) Readonly() (Txn, error) {
+	txn, err := c.DB.Readonly()
+	if err != nil {
+		return nil, err
+	}
+	return &countingTxn{Txn: txn, gets: &c.gets}, nil
+}
+
+type countingTxn struct {
+	Txn
+	gets *int64
+}
+
+func (t *countingTxn) Get(key []byte) ([]byte, error) {
+	atomic.AddInt64(t.gets, 1)
+	return t.Txn.Get(key)
+}
+
+func TestTiered(t *testing.T) {
+	store := &getCounter{DB: NewMemDB()}
+	db, err := NewTiered(store, CacheSize(8))
+	if err != nil {
+		t.Fatalf("new tiered: %v", err)
+	}
+	defer db.Close()
+
+	get := func(key string) (string, error) {
+		var v []byte
+		err := View(db, func(txn Txn) (err error) {
+			v, err = txn.Get([]byte(key))
+			return err
+		})
+		return string(v), err
+	}
+	put := func(key, value string) {
+		if err := Update(db, func(txn RWTxn) error { return txn.Put([]byte(key), []byte(value)) }); err != nil {
+			t.Fatalf("put %s: %v", key, err)
+		}
+	}
+	expect := func(key, value string, gets int64) {
+		t.Helper()
+		v, err := get(key)
+		if value == "" && err != ErrNotFound || value != "" && (err != nil || v != value) {
+			t.Fatalf("get %s: expected %q, got %q, %v", key, value, v, err)
+		}
+		if n := atomic.LoadInt64(&store.gets); n != gets {
+			t.Fatalf("get %s: expected %d store reads, got %d", key, gets, n)
+		}
+	}
+
+	put("a", "1")
+	expect("a", "1", 0) // cached by the commit
+	expect("b", "", 1)
+	expect("b", "", 1) // absent keys are cached too
+
+	// a transaction keeps its snapshot while a commit updates the cache
+	txn, err := db.Readonly()
+	if err != nil {
+		t.Fatalf("readonly: %v", err)
+	}
+	put("a", "2")
+	if v, err := txn.Get([]byte("a")); err != nil || string(v) != "1" {
+		t.Fatalf("get from older snapshot: expected 1, got %q, %v", v, err)
+	}
+	txn.Rollback()
+	expect("a", "2", 2)
+
+	// a failed commit leaves the keys uncached
+	wtxn, err := db.Writable()
+	if err != nil {
+		t.Fatalf("writable: %v", err)
+	}
+	wtxn.Delete([]byte("a"))
+	wtxn.Rollback()
+	expect("a", "2", 2)
+
+	// the least recently used entries make room for new ones
+	put("c", "333")
+	expect("a", "2", 2)
+	put("d", "4")
+	expect("b", "", 3)
+	expect("c", "333", 4)
+	if size := db.Size(); size > 8 {
+		t.Fatalf("size: expected at most 8, got %d", size)
+	}
+
+	db.Purge()
+	expect("a", "2", 5)
+	if _, err = NewTiered(store, CacheEviction(Eviction(7))); err == nil {
+		t.Fatal("new tiered with unknown eviction: expected error")
+	}
+}
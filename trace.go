@@ -0,0 +1,511 @@
+package backend
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrInvalidTrace is returned by Replay if the trace is malformed.
+const ErrInvalidTrace Error = Error("invalid trace")
+
+// A trace starts with traceMagic followed by traceVersion. Each record is
+//
+//	op       uint8
+//	flags    uint8 (traceFailed if the operation returned an error)
+//	id       uvarint (transaction or iterator id)
+//	start    uvarint (nanoseconds since the recorder was created)
+//	duration uvarint (nanoseconds)
+//	key      uvarint length + bytes (Get, Put, Delete and Seek only)
+//	value    uvarint length + bytes (Put only)
+const (
+	traceMagic   = "BKT"
+	traceVersion = 1
+	traceFailed  = 1 << 0
+)
+
+const (
+	traceReadonly byte = iota + 1
+	traceWritable
+	traceGet
+	tracePut
+	traceDelete
+	traceCommit
+	traceRollback
+	traceIterator
+	traceSeek
+	traceFirst
+	traceLast
+	traceNext
+	tracePrev
+	traceReset
+	traceClose
+	traceSeekToIndex
+)
+
+// Recorder wraps a DB and writes every operation on it, with its start
+// time and duration, to a trace. Replay re-executes a trace against any
+// backend. Keys and values are recorded verbatim; a trace holds all data
+// written through the Recorder.
+//
+// A Recorder is safe for concurrent use. Operations are recorded in the
+// order they complete.
+type Recorder struct {
+	DB
+
+	mu     sync.Mutex
+	w      *bufio.Writer
+	start  time.Time
+	nextID uint64
+	err    error // first write error
+}
+
+// NewRecorder returns a Recorder writing the trace of all operations on
+// db to w. Call Flush or Close to write buffered records.
+func NewRecorder(db DB, w io.Writer) (*Recorder, error) {
+	r := &Recorder{DB: db, w: bufio.NewWriter(w), start: time.Now()}
+	if _, err := r.w.WriteString(traceMagic); err != nil {
+		return nil, err
+	}
+	if err := r.w.WriteByte(traceVersion); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// record writes a single trace record.
+func (r *Recorder) record(op byte, id uint64, start time.Time, err error, key, value []byte) {
+	var buf [3*binary.MaxVarintLen64 + 2]byte
+	buf[0], buf[1] = op, 0
+	if err != nil {
+		buf[1] = traceFailed
+	}
+	n := 2
+	n += binary.PutUvarint(buf[n:], id)
+	n += binary.PutUvarint(buf[n:], uint64(start.Sub(r.start)))
+	n += binary.PutUvarint(buf[n:], uint64(time.Since(start)))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	_, r.err = r.w.Write(buf[:n])
+	switch op {
+	case traceGet, traceDelete, traceSeek, traceSeekToIndex:
+		r.writeBytes(key)
+	case tracePut:
+		r.writeBytes(key)
+		r.writeBytes(value)
+	}
+}
+
+func (r *Recorder) writeBytes(b []byte) {
+	if r.err != nil {
+		return
+	}
+	if r.err = writeUvarint(r.w, uint64(len(b))); r.err == nil {
+		_, r.err = r.w.Write(b)
+	}
+}
+
+func (r *Recorder) id() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextID++
+	return r.nextID
+}
+
+// Flush writes all buffered records and returns the first error
+// encountered while recording.
+func (r *Recorder) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.err = r.w.Flush()
+	return r.err
+}
+
+// Close flushes the trace and closes the underlying DB.
+func (r *Recorder) Close() error {
+	err := r.Flush()
+	if cerr := r.DB.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (r *Recorder) Iterator() (Iterator, error) { return recordIterator(r, r.DB) }
+
+func (r *Recorder) Readonly() (Txn, error) { return recordReadonly(r, r.DB) }
+
+func (r *Recorder) Writable() (RWTxn, error) { return recordWritable(r, r.DB) }
+
+// opRecorder receives the operations on the transactions and iterators
+// of a DB.
+type opRecorder interface {
+	record(op byte, id uint64, start time.Time, err error, key, value []byte)
+	id() uint64
+}
+
+func recordIterator(r opRecorder, db DB) (Iterator, error) {
+	start := time.Now()
+	iter, err := db.Iterator()
+	id := r.id()
+	r.record(traceIterator, id, start, err, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &recordedIterator{Iterator: iter, r: r, id: id}, nil
+}
+
+func recordReadonly(r opRecorder, db DB) (Txn, error) {
+	start := time.Now()
+	txn, err := db.Readonly()
+	id := r.id()
+	r.record(traceReadonly, id, start, err, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &recordedTxn{Txn: txn, r: r, id: id}, nil
+}
+
+func recordWritable(r opRecorder, db DB) (RWTxn, error) {
+	start := time.Now()
+	txn, err := db.Writable()
+	id := r.id()
+	r.record(traceWritable, id, start, err, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &recordedRWTxn{recordedTxn{Txn: txn, r: r, id: id}, txn}, nil
+}
+
+type recordedTxn struct {
+	Txn
+	r  opRecorder
+	id uint64
+}
+
+func (t *recordedTxn) Get(key []byte) ([]byte, error) {
+	start := time.Now()
+	v, err := t.Txn.Get(key)
+	if err == ErrNotFound {
+		// a miss is a regular result, not a failure worth flagging
+		t.r.record(traceGet, t.id, start, nil, key, nil)
+	} else {
+		t.r.record(traceGet, t.id, start, err, key, nil)
+	}
+	return v, err
+}
+
+func (t *recordedTxn) Rollback() error {
+	start := time.Now()
+	err := t.Txn.Rollback()
+	t.r.record(traceRollback, t.id, start, err, nil, nil)
+	return err
+}
+
+type recordedRWTxn struct {
+	recordedTxn
+	rw RWTxn
+}
+
+func (t *recordedRWTxn) Put(key, value []byte) error {
+	start := time.Now()
+	err := t.rw.Put(key, value)
+	t.r.record(tracePut, t.id, start, err, key, value)
+	return err
+}
+
+func (t *recordedRWTxn) Delete(key []byte) error {
+	start := time.Now()
+	err := t.rw.Delete(key)
+	t.r.record(traceDelete, t.id, start, err, key, nil)
+	return err
+}
+
+func (t *recordedRWTxn) Commit() error {
+	start := time.Now()
+	err := t.rw.Commit()
+	t.r.record(traceCommit, t.id, start, err, nil, nil)
+	return err
+}
+
+type recordedIterator struct {
+	Iterator
+	r  opRecorder
+	id uint64
+}
+
+func (i *recordedIterator) move(op byte, key []byte, move func() ([]byte, []byte)) ([]byte, []byte) {
+	start := time.Now()
+	k, v := move()
+	i.r.record(op, i.id, start, nil, key, nil)
+	return k, v
+}
+
+func (i *recordedIterator) Seek(key []byte) ([]byte, []byte) {
+	return i.move(traceSeek, key, func() ([]byte, []byte) { return i.Iterator.Seek(key) })
+}
+
+func (i *recordedIterator) First() ([]byte, []byte) {
+	return i.move(traceFirst, nil, i.Iterator.First)
+}
+
+func (i *recordedIterator) Last() ([]byte, []byte) {
+	return i.move(traceLast, nil, i.Iterator.Last)
+}
+
+func (i *recordedIterator) Next() ([]byte, []byte) {
+	return i.move(traceNext, nil, i.Iterator.Next)
+}
+
+func (i *recordedIterator) Prev() ([]byte, []byte) {
+	return i.move(tracePrev, nil, i.Iterator.Prev)
+}
+
+// SeekToIndex records n as an 8 byte big-endian key.
+func (i *recordedIterator) SeekToIndex(n int64) ([]byte, []byte) {
+	var key [8]byte
+	binary.BigEndian.PutUint64(key[:], uint64(n))
+	return i.move(traceSeekToIndex, key[:], func() ([]byte, []byte) { return i.Iterator.SeekToIndex(n) })
+}
+
+func (i *recordedIterator) Reset() error {
+	start := time.Now()
+	err := i.Iterator.Reset()
+	i.r.record(traceReset, i.id, start, err, nil, nil)
+	return err
+}
+
+func (i *recordedIterator) Close() error {
+	start := time.Now()
+	err := i.Iterator.Close()
+	i.r.record(traceClose, i.id, start, err, nil, nil)
+	return err
+}
+
+// ReplayStats summarizes a replayed trace.
+type ReplayStats struct {
+	Ops      int           // number of replayed operations
+	Errors   int           // operations that failed during replay
+	Recorded time.Duration // total duration of all recorded operations
+	Replayed time.Duration // total duration of all replayed operations
+}
+
+// ReplayOption configures Replay.
+type ReplayOption func(*replayer) error
+
+type replayer struct {
+	db      DB
+	timing  bool
+	txns    map[uint64]Txn
+	iters   map[uint64]Iterator
+	started time.Time
+	stats   ReplayStats
+}
+
+// ReplayTiming delays every operation until its recorded start time
+// relative to the start of the replay, reproducing the original pacing
+// instead of replaying as fast as possible.
+func ReplayTiming() ReplayOption {
+	return func(r *replayer) error {
+		r.timing = true
+		return nil
+	}
+}
+
+// Replay executes the operations of a trace written by a Recorder
+// against db, one after another in recorded order. Operations that failed
+// while recording are replayed as well. Transactions and iterators left
+// open by the trace are closed when Replay returns.
+//
+// Since operations are not replayed concurrently, a trace of concurrent
+// write transactions only replays on backends that allow more than one
+// open write transaction per goroutine.
+func Replay(db DB, r io.Reader, opts ...ReplayOption) (ReplayStats, error) {
+	rp := &replayer{
+		db:    db,
+		txns:  make(map[uint64]Txn),
+		iters: make(map[uint64]Iterator),
+	}
+	for _, opt := range opts {
+		if err := opt(rp); err != nil {
+			return rp.stats, err
+		}
+	}
+	defer rp.close()
+
+	br := bufio.NewReader(r)
+	header := make([]byte, len(traceMagic)+1)
+	if _, err := io.ReadFull(br, header); err != nil || string(header[:len(traceMagic)]) != traceMagic {
+		return rp.stats, ErrInvalidTrace
+	} else if header[len(traceMagic)] != traceVersion {
+		return rp.stats, errors.New("unsupported trace version")
+	}
+
+	rp.started = time.Now()
+	for {
+		op, err := br.ReadByte()
+		if err == io.EOF {
+			return rp.stats, nil
+		} else if err != nil {
+			return rp.stats, err
+		}
+		if err = rp.replay(op, br); err != nil {
+			return rp.stats, err
+		}
+	}
+}
+
+func (rp *replayer) replay(op byte, br *bufio.Reader) error {
+	if _, err := br.ReadByte(); err != nil { // flags
+		return ErrInvalidTrace
+	}
+	var fields [3]uint64 // id, start, duration
+	for i := range fields {
+		v, err := binary.ReadUvarint(br)
+		if err != nil {
+			return ErrInvalidTrace
+		}
+		fields[i] = v
+	}
+	id := fields[0]
+	var key, value []byte
+	var err error
+	switch op {
+	case traceGet, traceDelete, traceSeek, traceSeekToIndex:
+		key, err = readTraceBytes(br)
+	case tracePut:
+		if key, err = readTraceBytes(br); err == nil {
+			value, err = readTraceBytes(br)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if rp.timing {
+		if d := time.Duration(fields[1]) - time.Since(rp.started); d > 0 {
+			time.Sleep(d)
+		}
+	}
+	rp.stats.Ops++
+	rp.stats.Recorded += time.Duration(fields[2])
+	start := time.Now()
+	defer func() { rp.stats.Replayed += time.Since(start) }()
+
+	switch op {
+	case traceReadonly:
+		txn, err := rp.db.Readonly()
+		rp.result(err)
+		if err == nil {
+			rp.txns[id] = txn
+		}
+	case traceWritable:
+		txn, err := rp.db.Writable()
+		rp.result(err)
+		if err == nil {
+			rp.txns[id] = txn
+		}
+	case traceIterator:
+		iter, err := rp.db.Iterator()
+		rp.result(err)
+		if err == nil {
+			rp.iters[id] = iter
+		}
+	case traceGet, traceRollback:
+		txn, found := rp.txns[id]
+		if !found {
+			return ErrInvalidTrace
+		}
+		if op == traceGet {
+			if _, err := txn.Get(key); err != ErrNotFound {
+				rp.result(err)
+			}
+		} else {
+			rp.result(txn.Rollback())
+			delete(rp.txns, id)
+		}
+	case tracePut, traceDelete, traceCommit:
+		txn, ok := rp.txns[id].(RWTxn)
+		if !ok {
+			return ErrInvalidTrace
+		}
+		switch op {
+		case tracePut:
+			rp.result(txn.Put(key, value))
+		case traceDelete:
+			rp.result(txn.Delete(key))
+		case traceCommit:
+			rp.result(txn.Commit())
+			delete(rp.txns, id)
+		}
+	case traceSeek, traceFirst, traceLast, traceNext, tracePrev, traceSeekToIndex, traceReset, traceClose:
+		iter, found := rp.iters[id]
+		if !found {
+			return ErrInvalidTrace
+		}
+		switch op {
+		case traceSeek:
+			iter.Seek(key)
+		case traceFirst:
+			iter.First()
+		case traceLast:
+			iter.Last()
+		case traceNext:
+			iter.Next()
+		case tracePrev:
+			iter.Prev()
+		case traceSeekToIndex:
+			if len(key) != 8 {
+				return ErrInvalidTrace
+			}
+			iter.SeekToIndex(int64(binary.BigEndian.Uint64(key)))
+		case traceReset:
+			rp.result(iter.Reset())
+		case traceClose:
+			rp.result(iter.Close())
+			delete(rp.iters, id)
+		}
+	default:
+		return ErrInvalidTrace
+	}
+	return nil
+}
+
+func (rp *replayer) result(err error) {
+	if err != nil {
+		rp.stats.Errors++
+	}
+}
+
+// close releases all transactions and iterators left open by the trace.
+func (rp *replayer) close() {
+	for id, iter := range rp.iters {
+		iter.Close()
+		delete(rp.iters, id)
+	}
+	for id, txn := range rp.txns {
+		txn.Rollback()
+		delete(rp.txns, id)
+	}
+}
+
+func readTraceBytes(br *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadUvarint(br)
+	if err != nil || n > maxExportBlockSize {
+		return nil, ErrInvalidTrace
+	}
+	b := make([]byte, n)
+	if _, err = io.ReadFull(br, b); err != nil {
+		return nil, ErrInvalidTrace
+	}
+	return b, nil
+}
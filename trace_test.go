@@ -0,0 +1,130 @@
+package backend
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	const path, replayPath = "record_boltdb.db", "replay_leveldb"
+	db := openBoltDB(t, path)
+	defer closeBoltDB(t, path, db)
+	replayDB := openLevelDB(t, replayPath)
+	defer closeLevelDB(t, replayPath, replayDB)
+
+	var trace bytes.Buffer
+	r, err := NewRecorder(db, &trace)
+	if err != nil {
+		t.Fatalf("new recorder: %v", err)
+	}
+
+	txn, err := r.Writable()
+	if err != nil {
+		t.Fatalf("begin writable transaction: %v", err)
+	}
+	for i, key := range compatKeys[:10] {
+		if err = txn.Put(key, compatValues[i]); err != nil {
+			t.Fatalf("put key %q: %v", key, err)
+		}
+	}
+	if err = txn.Delete(compatKeys[3]); err != nil {
+		t.Fatalf("delete key %q: %v", compatKeys[3], err)
+	}
+	if err = txn.Commit(); err != nil {
+		t.Fatalf("commit writable transaction: %v", err)
+	}
+
+	rtxn, err := r.Readonly()
+	if err != nil {
+		t.Fatalf("begin read-only transaction: %v", err)
+	}
+	if _, err = rtxn.Get(compatKeys[3]); err != ErrNotFound {
+		t.Fatalf("get deleted key: expected ErrNotFound, got %v", err)
+	}
+	if err = rtxn.Rollback(); err != nil {
+		t.Fatalf("rollback read-only transaction: %v", err)
+	}
+
+	iter, err := r.Iterator()
+	if err != nil {
+		t.Fatalf("iterator: %v", err)
+	}
+	for k, _ := iter.Seek(compatKeys[5]); k != nil; k, _ = iter.Next() {
+	}
+	if err = iter.Close(); err != nil {
+		t.Fatalf("close iterator: %v", err)
+	}
+	if err = r.Flush(); err != nil {
+		t.Fatalf("flush trace: %v", err)
+	}
+
+	stats, err := Replay(replayDB, bytes.NewReader(trace.Bytes()))
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	// 13 transaction operations, 3 for the read-only transaction and
+	// iterator creation, seek, 5 moves and close
+	if stats.Ops != 24 || stats.Errors != 0 {
+		t.Fatalf("replay: expected 24 operations without errors, got %+v", stats)
+	}
+
+	sum, err := Checksum(db, nil)
+	if err != nil {
+		t.Fatalf("checksum: %v", err)
+	}
+	replayed, err := Checksum(replayDB, nil)
+	if err != nil {
+		t.Fatalf("checksum: %v", err)
+	}
+	if sum != replayed {
+		t.Fatalf("replay: database contents differ")
+	}
+
+	if _, err = Replay(replayDB, bytes.NewReader(trace.Bytes()[:trace.Len()-1])); err != ErrInvalidTrace {
+		t.Fatalf("replay truncated trace: expected ErrInvalidTrace, got %v", err)
+	}
+}
+
+func TestRecordReplaySeekToIndex(t *testing.T) {
+	var trace bytes.Buffer
+	r, err := NewRecorder(NewMemDB(), &trace)
+	if err != nil {
+		t.Fatalf("new recorder: %v", err)
+	}
+	defer r.Close()
+	if err = Update(r, func(txn RWTxn) error {
+		for i, key := range compatKeys[:10] {
+			if err := txn.Put(key, compatValues[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	iter, err := r.Iterator()
+	if err != nil {
+		t.Fatalf("iterator: %v", err)
+	}
+	if k, _ := iter.SeekToIndex(3); !bytes.Equal(k, compatKeys[3]) {
+		t.Fatalf("seek to index 3: expected %q, got %q", compatKeys[3], k)
+	}
+	iter.SeekToIndex(1 << 40)
+	if err = iter.Close(); err != nil {
+		t.Fatalf("close iterator: %v", err)
+	}
+	if err = r.Flush(); err != nil {
+		t.Fatalf("flush trace: %v", err)
+	}
+
+	replayDB := NewMemDB()
+	defer replayDB.Close()
+	stats, err := Replay(replayDB, bytes.NewReader(trace.Bytes()))
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	// writable, 10 puts and commit, iterator creation, 2 seeks and close
+	if stats.Ops != 16 || stats.Errors != 0 {
+		t.Fatalf("replay: expected 16 operations without errors, got %+v", stats)
+	}
+}
@@ -0,0 +1,70 @@
+package backend
+
+import "sync"
+
+const minPooledSize = 64
+
+// Value is a copy of a stored value whose memory may be recycled. Call
+// Release once the value is no longer used; neither Bytes nor the slice
+// it returned may be used after Release.
+type Value struct {
+	b     []byte
+	buf   []byte     // pooled backing buffer
+	class *sync.Pool // nil if not pooled
+}
+
+// Bytes returns the value.
+func (v *Value) Bytes() []byte {
+	if v == nil {
+		return nil
+	}
+	return v.b
+}
+
+// Release returns the value memory to its pool. Calling Release again
+// before the Value is handed out anew has no effect.
+func (v *Value) Release() {
+	if v == nil || v.b == nil {
+		return
+	}
+	v.b = nil
+	if v.class != nil {
+		v.class.Put(v)
+	}
+}
+
+// valuePool hands out Values from power of two size classes between
+// minPooledSize and maxSize. Larger values are allocated directly.
+type valuePool struct {
+	classes []sync.Pool
+}
+
+func newValuePool(maxSize int) *valuePool {
+	n := 0
+	for size := minPooledSize; size <= maxSize; size <<= 1 {
+		n++
+	}
+
+	p := &valuePool{classes: make([]sync.Pool, n)}
+	for i := range p.classes {
+		size, class := minPooledSize<<i, &p.classes[i]
+		class.New = func() interface{} {
+			return &Value{buf: make([]byte, size), class: class}
+		}
+	}
+	return p
+}
+
+// clone returns a Value holding a copy of b.
+func (p *valuePool) clone(b []byte) *Value {
+	if p != nil {
+		for i := range p.classes {
+			if len(b) <= minPooledSize<<i {
+				v := p.classes[i].Get().(*Value)
+				v.b = v.buf[:copy(v.buf, b)]
+				return v
+			}
+		}
+	}
+	return &Value{b: append([]byte{}, b...)}
+}
@@ -0,0 +1,75 @@
+package backend
+
+import (
+	"bytes"
+	"context"
+)
+
+// warmReportInterval is the number of keys between progress reports.
+const warmReportInterval = 1024
+
+// WarmProgress reports the state of a Warm call.
+type WarmProgress struct {
+	Prefix []byte // prefix currently scanned
+	Done   bool   // the scan of Prefix finished
+	Keys   int64  // keys read below Prefix so far
+	Bytes  int64  // key and value bytes read below Prefix so far
+}
+
+// Warm reads all keys and values below each of prefixes to load them
+// into the operating system page cache and the engine's block cache. If
+// db is a RangeCache, the prefixes are loaded into the cache as well.
+//
+// progress, if not nil, is called regularly during the scan of a prefix
+// and once after it. Warm stops early and returns ctx.Err() if ctx is
+// done.
+func Warm(ctx context.Context, db DB, prefixes [][]byte, progress func(WarmProgress)) error {
+	report := func(p WarmProgress) {
+		if progress != nil {
+			progress(p)
+		}
+	}
+
+	for _, prefix := range prefixes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		p := WarmProgress{Prefix: prefix}
+
+		if c, ok := db.(*RangeCache); ok {
+			pairs, err := c.ScanPrefix(prefix)
+			if err != nil {
+				return err
+			}
+			for _, kv := range pairs {
+				p.Keys++
+				p.Bytes += int64(len(kv.Key) + len(kv.Value))
+			}
+			p.Done = true
+			report(p)
+			continue
+		}
+
+		iter, err := db.Iterator()
+		if err != nil {
+			return err
+		}
+		for k, v := iter.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = iter.Next() {
+			p.Keys++
+			p.Bytes += int64(len(k) + len(v))
+			if p.Keys%warmReportInterval == 0 {
+				if err = ctx.Err(); err != nil {
+					iter.Close()
+					return err
+				}
+				report(p)
+			}
+		}
+		if err = iter.Close(); err != nil {
+			return err
+		}
+		p.Done = true
+		report(p)
+	}
+	return nil
+}